@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+// shortHash is how much of a hash is shown, enough to refer to an entry
+const shortHash = 12
+
+func NewTagCommand() *cobra.Command {
+	var remove bool
+
+	tagCmd := &cobra.Command{
+		Use:   "tag <history-id|hash|last> <tag>...",
+		Short: "Tag a command so it can be found again",
+		Long: `This command attaches tags to a history entry. Tags are signed by this
+machine and synced to every peer the entry is shared with, and
+"syncsh search tag:<tag>" finds the entry again. "last" is the newest command
+run on this machine other than syncsh itself.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			tags := make([]string, 0, len(args)-1)
+			for _, tag := range args[1:] {
+				tags = append(tags, strings.ToLower(tag))
+			}
+			res, err := annotate(cmd.Context(), daemon.AnnotateParams{Ref: args[0], Tags: tags, Remove: remove})
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Tags of %q: %s\n", res.Command, orDash(strings.Join(res.Annotation.Tags, " ")))
+			reportSync(out, res.Peers)
+			return nil
+		},
+	}
+
+	tagCmd.Flags().BoolVar(&remove, "remove", false, "Remove the tags instead of adding them")
+
+	return tagCmd
+}
+
+func NewNoteCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "note <history-id|hash|last> <note>",
+		Short: "Attach a note to a command",
+		Long: `This command attaches a free-text note to a history entry, replacing this
+machine's previous note on it. An empty note removes it. Notes are synced like
+tags and searched along with the commands.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			note := strings.TrimSpace(args[1])
+			res, err := annotate(cmd.Context(), daemon.AnnotateParams{Ref: args[0], Note: &note})
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if note == "" {
+				fmt.Fprintf(out, "Removed the note on %q\n", res.Command)
+			} else {
+				fmt.Fprintf(out, "Noted %q\n", res.Command)
+			}
+			reportSync(out, res.Peers)
+			return nil
+		},
+	}
+}
+
+// annotate changes this machine's annotation of an entry through the
+// daemon, or through the history store when no daemon is running
+func annotate(ctx context.Context, params daemon.AnnotateParams) (daemon.AnnotateResult, error) {
+	var res daemon.AnnotateResult
+	err := callDaemonWith(ctx, daemon.MethodAnnotate, params, &res)
+	if daemonRunning(err) {
+		return res, err
+	}
+
+	cfg, ks, err := loadMachine()
+	if err != nil {
+		return daemon.AnnotateResult{}, err
+	}
+	signingKey, err := machine.SigningKey(cfg, ks)
+	if err != nil {
+		return daemon.AnnotateResult{}, err
+	}
+	st, err := store.Open(ctx, cfg.SQLitePath)
+	if err != nil {
+		return daemon.AnnotateResult{}, fmt.Errorf("failed to open history store: %w", err)
+	}
+	defer st.Close()
+	res, err = daemon.Annotate(ctx, st, cfg.MachineID, signingKey, params)
+	res.Peers = -1
+	return res, err
+}
+
+func NewSearchCommand() *cobra.Command {
+	var limit int
+
+	searchCmd := &cobra.Command{
+		Use:   "search [tag:<tag>]... [text]...",
+		Short: "Search the history of every machine",
+		Long: `This command lists history entries, newest first. Each text must appear in
+the command or in a note on it, ignoring case; quote text with spaces to
+search for it as a whole. tag:<tag> only lists entries with that tag.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := parseQuery(args)
+			q.Limit = limit
+			return listEntries(cmd, q, printSearch)
+		},
+	}
+
+	searchCmd.Flags().IntVarP(&limit, "limit", "n", 20, "Maximum number of entries to list, 0 for all")
+
+	return searchCmd
+}
+
+func NewBookmarksCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "bookmarks [tag]...",
+		Short: "List tagged and noted commands",
+		Long: `This command lists every history entry that has tags or a note, with them,
+newest first. Given tags, only entries with all of them are listed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			q := daemon.SearchParams{Annotated: true}
+			for _, tag := range args {
+				q.Tags = append(q.Tags, strings.ToLower(tag))
+			}
+			return listEntries(cmd, q, printBookmarks)
+		},
+	}
+}
+
+// parseQuery turns search arguments into a query: tag:<tag> selects a tag,
+// anything else is text to look for
+func parseQuery(args []string) daemon.SearchParams {
+	var q daemon.SearchParams
+	for _, arg := range args {
+		if tag, ok := strings.CutPrefix(arg, "tag:"); ok {
+			q.Tags = append(q.Tags, strings.ToLower(tag))
+		} else if arg != "" {
+			q.Words = append(q.Words, arg)
+		}
+	}
+	return q
+}
+
+// listEntries runs q through the daemon, or against the history store when
+// no daemon is running, and prints the entries found with their annotations
+func listEntries(cmd *cobra.Command, q daemon.SearchParams, print func(io.Writer, []parser.HistoryEntry, map[string][]parser.Annotation, func(string) string)) error {
+	ctx := cmd.Context()
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	var res daemon.SearchResult
+	if err := callDaemonWith(ctx, daemon.MethodSearch, q, &res); daemonRunning(err) {
+		if err != nil {
+			return err
+		}
+	} else {
+		st, err := store.Open(ctx, cfg.SQLitePath)
+		if err != nil {
+			return fmt.Errorf("failed to open history store: %w", err)
+		}
+		defer st.Close()
+		if res, err = daemon.Search(ctx, st, q); err != nil {
+			return err
+		}
+	}
+
+	byHash := make(map[string][]parser.Annotation)
+	for _, a := range res.Annotations {
+		byHash[a.Hash] = append(byHash[a.Hash], a)
+	}
+	print(cmd.OutOrStdout(), historyEntries(res.Entries), byHash, machineNamer(cfg))
+	return nil
+}
+
+func printSearch(w io.Writer, entries []parser.HistoryEntry, annotations map[string][]parser.Annotation, name func(string) string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tHASH\tTIME\tMACHINE\tTAGS\tCOMMAND")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Hash[:min(len(e.Hash), shortHash)],
+			time.Unix(e.Timestamp, 0).Format("2006-01-02 15:04"), name(e.MachineID),
+			orDash(strings.Join(mergedTags(annotations[e.Hash]), ",")), e.Command)
+	}
+	tw.Flush()
+}
+
+func printBookmarks(w io.Writer, entries []parser.HistoryEntry, annotations map[string][]parser.Annotation, name func(string) string) {
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, e.Command)
+		fmt.Fprintf(w, "  #%d %s, run on %s at %s\n", e.ID, e.Hash[:min(len(e.Hash), shortHash)],
+			name(e.MachineID), time.Unix(e.Timestamp, 0).Format("2006-01-02 15:04"))
+		if tags := mergedTags(annotations[e.Hash]); len(tags) > 0 {
+			fmt.Fprintf(w, "  tags: %s\n", strings.Join(tags, " "))
+		}
+		for _, a := range annotations[e.Hash] {
+			if a.Note != "" {
+				fmt.Fprintf(w, "  note by %s: %s\n", name(a.MachineID), a.Note)
+			}
+		}
+	}
+}
+
+// machineNamer returns a function naming machines after the peers, with
+// machines that are not peers by ID
+func machineNamer(cfg *config.Config) func(string) string {
+	names := map[string]string{cfg.MachineID: "this machine"}
+	for _, p := range cfg.Peers {
+		if p.MachineID != "" {
+			names[p.MachineID] = p.Name
+		}
+	}
+	return func(machineID string) string {
+		if name, ok := names[machineID]; ok {
+			return name
+		}
+		return machineID
+	}
+}
+
+// mergedTags returns the tags every machine attached, sorted
+func mergedTags(annotations []parser.Annotation) []string {
+	var tags []string
+	for _, a := range annotations {
+		tags = append(tags, a.Tags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+func TestParseQuery(t *testing.T) {
+	q := parseQuery([]string{"tag:Deploy", "git push", "", "tag:k8s:prod"})
+	if !slices.Equal(q.Tags, []string{"deploy", "k8s:prod"}) || !slices.Equal(q.Words, []string{"git push"}) {
+		t.Errorf("parseQuery() = %+v", q)
+	}
+}
+
+func TestPrintBookmarks(t *testing.T) {
+	entries := []parser.HistoryEntry{{ID: 7, Hash: "0123456789abcdef", MachineID: "m1", Command: "make deploy"}}
+	annotations := map[string][]parser.Annotation{"0123456789abcdef": {
+		{MachineID: "m1", Tags: []string{"prod", "deploy"}},
+		{MachineID: "m2", Tags: []string{"deploy"}, Note: "Needs VPN"},
+	}}
+
+	var out bytes.Buffer
+	name := machineNamer(&config.Config{MachineID: "m1", Peers: []config.Peer{{Name: "server", MachineID: "m2"}}})
+	printBookmarks(&out, entries, annotations, name)
+	for _, want := range []string{"make deploy\n", "#7 0123456789ab, run on this machine", "tags: deploy prod\n", "note by server: Needs VPN"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Bookmarks do not contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/service"
+	"golang.org/x/term"
+)
+
+// passphraseEnv lets non-interactive runs unlock an encrypted key store
+const passphraseEnv = "SYNCSH_PASSPHRASE"
+
+// configDir returns the directory syncsh keeps its configuration in
+func configDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".config", "syncsh")
+}
+
+// loadConfig reads the configuration written by syncsh init
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.NewFromFile(filepath.Join(configDir(), "config.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// loadMachine reads the configuration, opens its key store and upgrades
+// configs left by older versions: plaintext private keys are moved into the
+// key store, and a missing machine ID or signing key is created.
+func loadMachine() (*config.Config, keys.KeyStore, error) {
+	return loadMachineWith(promptPassphrase())
+}
+
+// loadMachineWith is loadMachine with a given passphrase source, so long
+// running commands ask for the passphrase only once
+func loadMachineWith(passphrase keys.PassphraseFunc) (*config.Config, keys.KeyStore, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cfg.KeyStore == "" {
+		cfg.KeyStore = keyStoreRef(keys.StoreFile)
+	}
+	ks, err := keys.Open(cfg.KeyStore, passphrase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open key store: %w", err)
+	}
+	if cfg.KeyStore == keys.StoreKeyring+":" {
+		slog.Warn("Private keys are only in the kernel keyring and are lost on reboot, run syncsh keys migrate --to keyring")
+	}
+
+	migrated, err := machine.MigratePlaintextKeys(cfg, ks)
+	if err != nil {
+		return nil, nil, err
+	}
+	assigned, err := machine.EnsureMachineID(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	generated, err := machine.EnsureSigningKey(cfg, ks)
+	if err != nil {
+		return nil, nil, err
+	}
+	if migrated || assigned || generated {
+		if err := cfg.Save(); err != nil {
+			return nil, nil, fmt.Errorf("failed to save configuration: %w", err)
+		}
+	}
+	return cfg, ks, nil
+}
+
+// keyStoreRef expands a bare key store kind into a full reference rooted in
+// the config directory. Full references are returned unchanged.
+func keyStoreRef(kind string) string {
+	switch kind {
+	case keys.StoreFile, keys.StoreEncrypted:
+		return kind + ":" + filepath.Join(configDir(), "keys")
+	case keys.StoreKeyring:
+		return kind + ":" + filepath.Join(configDir(), "keyring")
+	default:
+		return kind
+	}
+}
+
+// promptPassphrase returns a PassphraseFunc that reads the passphrase from
+// the credential the service unit loads, SYNCSH_PASSPHRASE or the terminal,
+// at most once per run.
+func promptPassphrase() keys.PassphraseFunc {
+	var (
+		once       sync.Once
+		passphrase []byte
+		err        error
+	)
+	return func() ([]byte, error) {
+		once.Do(func() {
+			var ok bool
+			if passphrase, ok, err = service.ReadCredential(service.PassphraseCredential); ok || err != nil {
+				return
+			}
+			if env := os.Getenv(passphraseEnv); env != "" {
+				passphrase = []byte(env)
+				return
+			}
+			fd := int(os.Stdin.Fd())
+			if !term.IsTerminal(fd) {
+				err = errors.New("key store is encrypted: set " + passphraseEnv + " or run from a terminal")
+				return
+			}
+			fmt.Fprint(os.Stderr, "Key store passphrase: ")
+			passphrase, err = term.ReadPassword(fd)
+			fmt.Fprintln(os.Stderr)
+			if err == nil && len(strings.TrimSpace(string(passphrase))) == 0 {
+				err = errors.New("passphrase cannot be empty")
+			}
+		})
+		return passphrase, err
+	}
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/control"
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+// callTimeout bounds a single call to the daemon
+const callTimeout = 30 * time.Second
+
+func NewDaemonCommand() *cobra.Command {
+	daemonCmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run syncsh in the background",
+		Long: `This command runs the history watcher, ingestion and the sync loop of every peer
+under one supervisor until it is interrupted. It listens on a control socket in
+$XDG_RUNTIME_DIR/syncsh, which the other commands use to talk to it. SIGHUP
+reloads the configuration.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			passphrase := promptPassphrase()
+			load := func() (*config.Config, keys.KeyStore, error) {
+				return loadMachineWith(passphrase)
+			}
+			cfg, _, err := load()
+			if err != nil {
+				return err
+			}
+
+			st, err := store.Open(cmd.Context(), cfg.SQLitePath)
+			if err != nil {
+				return fmt.Errorf("failed to open history store: %w", err)
+			}
+			defer st.Close()
+
+			ln, err := control.Listen(control.SocketPath())
+			if err != nil {
+				return err
+			}
+			defer ln.Close()
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			d := daemon.New(load, st)
+			go reloadOnHangup(ctx, d)
+			return d.Run(ctx, ln)
+		},
+	}
+
+	daemonCmd.AddCommand(
+		newDaemonPeersCommand(),
+		newDaemonPauseCommand(true),
+		newDaemonPauseCommand(false),
+		newDaemonSyncNowCommand(),
+		newDaemonReloadCommand(),
+	)
+
+	return daemonCmd
+}
+
+func reloadOnHangup(ctx context.Context, d *daemon.Daemon) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := d.Reload(); err != nil {
+				slog.Error("Failed to reload configuration.", "error", err)
+			}
+		}
+	}
+}
+
+// callDaemon calls method on the running daemon and decodes the result
+func callDaemon(ctx context.Context, method string, result any) error {
+	return callDaemonWith(ctx, method, nil, result)
+}
+
+// callDaemonWith is callDaemon with parameters, and files to pass to the
+// daemon
+func callDaemonWith(ctx context.Context, method string, params, result any, files ...*os.File) error {
+	ctx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	c, err := control.Dial(ctx, control.SocketPath())
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.CallWithFiles(ctx, method, params, result, files...)
+}
+
+// daemonRunning reports whether err from callDaemon only means no daemon
+// is listening
+func daemonRunning(err error) bool {
+	return !errors.Is(err, control.ErrNotRunning)
+}
+
+func newDaemonPeersCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "peers",
+		Short: "Show the connection state of every peer",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var peers []daemon.PeerStatus
+			if err := callDaemon(cmd.Context(), daemon.MethodPeers, &peers); err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			for _, p := range peers {
+				state := "disconnected"
+				if p.Connected {
+					state = "connected"
+				}
+				if p.State != "" {
+					state = fmt.Sprintf("%s for %s", p.State, time.Since(p.StateSince).Round(time.Second))
+				}
+				fmt.Fprintf(out, "%s: %s, %d entries pushed", p.Name, state, p.Pushed)
+				if p.Rebuilds > 0 {
+					fmt.Fprintf(out, ", %d tunnel rebuilds", p.Rebuilds)
+				}
+				if !p.LastPush.IsZero() {
+					fmt.Fprintf(out, ", last push %s", p.LastPush.Format(time.RFC3339))
+				}
+				if p.LastError != "" {
+					fmt.Fprintf(out, ", last error: %s", p.LastError)
+				}
+				fmt.Fprintln(out)
+			}
+			return nil
+		},
+	}
+}
+
+func newDaemonPauseCommand(pause bool) *cobra.Command {
+	use, method, short := "resume", daemon.MethodResume, "Resume synchronization"
+	if pause {
+		use, method, short = "pause", daemon.MethodPause, "Stop synchronizing until resumed, history is still recorded"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := callDaemon(cmd.Context(), method, nil); err != nil {
+				return err
+			}
+			if pause {
+				fmt.Fprintln(cmd.OutOrStdout(), "Synchronization paused")
+			} else {
+				fmt.Fprintln(cmd.OutOrStdout(), "Synchronization resumed")
+			}
+			return nil
+		},
+	}
+}
+
+func newDaemonSyncNowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sync-now",
+		Short: "Push pending history to every connected peer",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var result daemon.SyncNowResult
+			if err := callDaemon(cmd.Context(), daemon.MethodSyncNow, &result); err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Sync requested for %d connected peers\n", result.Peers)
+			return nil
+		},
+	}
+}
+
+func newDaemonReloadCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reload",
+		Short: "Reload the configuration and restart the sync loops",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := callDaemon(cmd.Context(), daemon.MethodReload, nil); err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), "Configuration reloaded")
+			return nil
+		},
+	}
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+// historyEntries returns entries with their IDs set
+func historyEntries(entries []daemon.Entry) []parser.HistoryEntry {
+	history := make([]parser.HistoryEntry, len(entries))
+	for i, e := range entries {
+		history[i] = e.HistoryEntry
+		history[i].ID = e.ID
+	}
+	return history
+}
+
+// reportSync tells whether peers were asked for a change, given how many
+// connected peers the daemon asked, or a negative count if it is not running
+func reportSync(out io.Writer, peers int) {
+	if peers < 0 {
+		fmt.Fprintln(out, "The daemon is not running, peers get it once it starts")
+	}
+}
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/forget"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func NewForgetCommand() *cobra.Command {
+	var (
+		match, since, machineName string
+		yes                       bool
+	)
+
+	forgetCmd := &cobra.Command{
+		Use:   "forget --match <regex>",
+		Short: "Delete commands everywhere, such as a leaked secret",
+		Long: `This command deletes every history entry whose command matches the regular
+expression, on this machine and on its peers, and removes the matching
+commands from the shell history file. It lists what would be deleted and asks
+before deleting anything.
+
+Only what the list showed is deleted, even if more commands match by the time
+you confirm. Peers that are connected delete the entries right away, the
+others when they next connect. The history file is copied to a backup next to
+the history store before it is rewritten; delete the backup once you have
+checked the result.
+
+--since only deletes commands run after a time, given as a duration back from
+now such as 24h, a date or an RFC 3339 time. Commands in the history file
+without a time are removed whenever they match. --machine only deletes
+commands of a peer, given by name or machine ID; the history file is then left
+alone unless it names this machine.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			if _, err := regexp.Compile(match); err != nil {
+				return fmt.Errorf("invalid --match: %w", err)
+			}
+			req := forget.Request{Match: match}
+			if since != "" {
+				sinceTime, err := parseSince(since, time.Now())
+				if err != nil {
+					return err
+				}
+				req.Since = sinceTime.Unix()
+			}
+
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if req.MachineID, err = forgetMachine(cfg, machineName); err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			preview, err := forgetCommands(ctx, cfg, daemon.ForgetParams{Request: req, DryRun: true})
+			if err != nil {
+				return err
+			}
+			if len(preview.Entries) == 0 && preview.Commands == 0 {
+				fmt.Fprintln(out, "No command matches")
+				return nil
+			}
+			printSearch(out, historyEntries(preview.Entries), nil, machineNamer(cfg))
+			fmt.Fprintf(out, "\n%d entries match, and %d commands in %s\n", len(preview.Entries), preview.Commands, preview.Path)
+			if !yes && !confirm(cmd.InOrStdin(), out, "Delete them everywhere?") {
+				fmt.Fprintln(out, "Nothing was deleted")
+				return nil
+			}
+
+			// Exactly what was listed is deleted, not what matches by now
+			sel := forget.Selection{Records: preview.Records}
+			for _, e := range preview.Entries {
+				sel.Hashes = append(sel.Hashes, e.Hash)
+			}
+			res, err := forgetCommands(ctx, cfg, daemon.ForgetParams{Request: req, Selection: &sel})
+			if err != nil {
+				return err
+			}
+			if res.Backup != "" {
+				fmt.Fprintf(out, "Removed %d commands from %s, the original is in %s\n", res.Commands, res.Path, res.Backup)
+			}
+			fmt.Fprintf(out, "Deleted %d entries\n", len(res.Entries))
+			switch {
+			case len(res.Entries) == 0:
+			case res.Peers < 0:
+				fmt.Fprintln(out, "The daemon is not running, peers delete them once it starts")
+			default:
+				fmt.Fprintf(out, "Told %d connected peers to delete them, the others will be told when they connect\n", res.Peers)
+			}
+			return nil
+		},
+	}
+
+	forgetCmd.Flags().StringVar(&match, "match", "", "Regular expression commands to delete match")
+	forgetCmd.Flags().StringVar(&since, "since", "", "Only delete commands run after this time")
+	forgetCmd.Flags().StringVar(&machineName, "machine", "", "Only delete commands of this peer or machine ID")
+	forgetCmd.Flags().BoolVarP(&yes, "yes", "y", false, "Delete without asking")
+	forgetCmd.MarkFlagRequired("match")
+
+	return forgetCmd
+}
+
+// parseSince reads a time given as a duration back from now, a date or an
+// RFC 3339 time
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q: use a duration such as 24h, a date or an RFC 3339 time", s)
+}
+
+// forgetMachine returns the machine ID of the peer called name, or name
+// itself if no peer is. An empty name selects every machine.
+func forgetMachine(cfg *config.Config, name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	if peer, ok := cfg.GetPeer(name); ok {
+		if peer.MachineID == "" {
+			return "", fmt.Errorf("peer %s has not connected yet, so it has no history here", name)
+		}
+		return peer.MachineID, nil
+	}
+	return name, nil
+}
+
+// forgetCommands forgets through the daemon, which holds off reading the
+// history file while it is rewritten. The daemon's sandbox may not let it
+// write the history file, so it gets the file opened here. Only if no daemon
+// is running is the store changed directly; Peers is then -1.
+func forgetCommands(ctx context.Context, cfg *config.Config, params daemon.ForgetParams) (daemon.ForgetResult, error) {
+	var files []*os.File
+	if params.Selection != nil && len(params.Selection.Records) > 0 {
+		f, err := os.OpenFile(forget.HistoryOf(cfg).Path, os.O_RDWR, 0)
+		if err != nil {
+			return daemon.ForgetResult{}, fmt.Errorf("failed to open history file: %w", err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+	var res daemon.ForgetResult
+	err := callDaemonWith(ctx, daemon.MethodForget, params, &res, files...)
+	if daemonRunning(err) {
+		return res, err
+	}
+
+	st, err := store.Open(ctx, cfg.SQLitePath)
+	if err != nil {
+		return daemon.ForgetResult{}, fmt.Errorf("failed to open history store: %w", err)
+	}
+	defer st.Close()
+	var signingKey keys.Key
+	if !params.DryRun {
+		_, ks, err := loadMachine()
+		if err != nil {
+			return daemon.ForgetResult{}, err
+		}
+		if signingKey, err = machine.SigningKey(cfg, ks); err != nil {
+			return daemon.ForgetResult{}, err
+		}
+	}
+	var history *os.File
+	if len(files) > 0 {
+		history = files[0]
+	}
+	res, err = daemon.Forget(ctx, st, forget.HistoryOf(cfg), params, signingKey, history)
+	res.Peers = -1
+	return res, err
+}
+
+// confirm asks a yes/no question, defaulting to no
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -3,7 +3,9 @@ package cmd
 import (
 	"fmt"
 	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
 	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
 	"github.com/TheRealSibasishBehera/syncsh/pkg/utils"
 	"github.com/spf13/cobra"
 	"os"
@@ -14,6 +16,8 @@ func NewInitCommand() *cobra.Command {
 	var historyPath string
 	var interfaceName string
 	var shellKind string
+	var keyStore string
+	var meshPrefix string
 
 	initCmd := &cobra.Command{
 		Use:   "init",
@@ -25,6 +29,9 @@ func NewInitCommand() *cobra.Command {
 			if err := config.ValidateShellKindAndHistoryPath(shellKind, historyPath); err != nil {
 				return fmt.Errorf("validation failed: %w", err)
 			}
+			if _, err := network.ParseMeshPrefix(meshPrefix); err != nil {
+				return fmt.Errorf("validation failed: %w", err)
+			}
 
 			shell := config.ShellKind(shellKind)
 
@@ -44,9 +51,13 @@ func NewInitCommand() *cobra.Command {
 			if historyPath != "" {
 				configOpts = append(configOpts, config.WithHistoryPath(historyPath))
 			}
+			if meshPrefix != "" {
+				configOpts = append(configOpts, config.WithMeshPrefix(meshPrefix))
+			}
 
 			dbPath := filepath.Join(configDir, "syncsh.db")
 			configOpts = append(configOpts, config.WithSQLitePath(dbPath))
+			configOpts = append(configOpts, config.WithKeyStore(keyStoreRef(keyStore)))
 
 			cfg, err := config.NewConfigWithOpts(configOpts...)
 			if err != nil {
@@ -58,7 +69,12 @@ func NewInitCommand() *cobra.Command {
 				return fmt.Errorf("failed to save configuration: %w", err)
 			}
 
-			err = machine.NewMachine(cfg)
+			ks, err := keys.Open(cfg.KeyStore, promptPassphrase())
+			if err != nil {
+				return fmt.Errorf("failed to open key store: %w", err)
+			}
+
+			err = machine.NewMachine(cfg, ks)
 			if err != nil {
 				return fmt.Errorf("failed to create machine: %w", err)
 			}
@@ -70,6 +86,8 @@ func NewInitCommand() *cobra.Command {
 	initCmd.Flags().StringVar(&shellKind, "shell", "zsh", "Shell type (bash, zsh, fish)")
 	initCmd.Flags().StringVar(&historyPath, "history-path", "", "Custom path to shell history file (default: auto-detect based on shell)")
 	initCmd.Flags().StringVar(&interfaceName, "interface", "syncsh0", "WireGuard interface name")
+	initCmd.Flags().StringVar(&keyStore, "key-store", keys.StoreFile, "Where to keep private keys (file, encrypted, keyring)")
+	initCmd.Flags().StringVar(&meshPrefix, "mesh-prefix", "", "ULA /48 of the mesh to join (default: a new random one)")
 
 	return initCmd
 }
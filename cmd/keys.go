@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/syncer"
+	"github.com/spf13/cobra"
+)
+
+// announceTimeout bounds how long rotate waits for a single peer
+const announceTimeout = 15 * time.Second
+
+func NewKeysCommand() *cobra.Command {
+	keysCmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage this machine's WireGuard keys",
+	}
+
+	keysCmd.AddCommand(
+		newKeysShowCommand(),
+		newKeysRotateCommand(),
+		newKeysMigrateCommand(),
+	)
+
+	return keysCmd
+}
+
+func newKeysShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show",
+		Short: "Print this machine's WireGuard public key",
+		Long: `This command prints this machine's WireGuard public key in the base64 encoding
+used by wg(8), so it can be handed to peers or compared with "wg show".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, _, err := loadMachine()
+			if err != nil {
+				return err
+			}
+			if cfg.PublicKey.IsZero() {
+				return machine.ErrNoKeys
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), cfg.PublicKey)
+			return nil
+		},
+	}
+}
+
+func newKeysRotateCommand() *cobra.Command {
+	var grace time.Duration
+
+	rotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Replace this machine's WireGuard key pair",
+		Long: `This command generates a new WireGuard key pair and announces it to every peer,
+through the daemon when one is running. The announcement is authenticated with the old key, which stays valid for the grace
+window so peers that are offline now can learn the new key when they next connect.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, ks, err := loadMachine()
+			if err != nil {
+				return err
+			}
+
+			rotation, err := machine.RotateKeys(cfg, ks, grace)
+			if err != nil {
+				return fmt.Errorf("failed to rotate keys: %w", err)
+			}
+			if err := cfg.Save(); err != nil {
+				return fmt.Errorf("failed to save configuration: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "New public key: %s\n", rotation.NewPublicKey)
+			fmt.Fprintf(out, "Old key valid until: %s\n", rotation.GraceUntil.Format(time.RFC3339))
+
+			// A running daemon announces the new key on its next session
+			// with every peer.
+			err = callDaemon(cmd.Context(), daemon.MethodReload, nil)
+			if daemonRunning(err) {
+				if err != nil {
+					return fmt.Errorf("failed to reload the daemon: %w", err)
+				}
+				fmt.Fprintln(out, "The daemon will announce the new key to every peer")
+				return nil
+			}
+
+			srv := syncer.NewServer(cfg, ks, nil)
+			for i := range cfg.Peers {
+				peer := &cfg.Peers[i]
+				if err := announceToPeer(cmd.Context(), srv, cfg, ks, peer); err != nil {
+					fmt.Fprintf(out, "  %s: not announced (%v), it will catch up on its next connection\n", peer.Name, err)
+					continue
+				}
+				fmt.Fprintf(out, "  %s: acknowledged\n", peer.Name)
+			}
+
+			return nil
+		},
+	}
+
+	rotateCmd.Flags().DurationVar(&grace, "grace", machine.DefaultRotationGrace, "How long the old key stays valid")
+
+	return rotateCmd
+}
+
+func announceToPeer(ctx context.Context, srv *syncer.Server, cfg *config.Config, ks keys.KeyStore, peer *config.Peer) error {
+	ctx, cancel := context.WithTimeout(ctx, announceTimeout)
+	defer cancel()
+
+	privateKey, err := machine.PrivateKeyFor(cfg, ks, peer)
+	if err != nil {
+		return err
+	}
+	conn, t, err := machine.DialPeer(ctx, peer, privateKey, machine.TunnelOptionsFor(cfg))
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	return srv.Announce(ctx, conn, peer, privateKey)
+}
+
+func newKeysMigrateCommand() *cobra.Command {
+	var to string
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move private keys to a different key store",
+		Long: `This command moves this machine's private keys into another key store and updates
+the configuration to reference it. Plaintext keys left in config.yaml by older
+versions are moved into the current key store automatically by every command.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, from, err := loadMachine()
+			if err != nil {
+				return err
+			}
+
+			ref := keyStoreRef(to)
+			if ref == cfg.KeyStore {
+				fmt.Fprintf(cmd.OutOrStdout(), "Keys are already stored in %s\n", ref)
+				return nil
+			}
+			dest, err := keys.Open(ref, promptPassphrase())
+			if err != nil {
+				return fmt.Errorf("failed to open key store: %w", err)
+			}
+
+			err = machine.MoveKeys(cfg, from, dest, func() error {
+				previous := cfg.KeyStore
+				cfg.KeyStore = ref
+				if err := cfg.Save(); err != nil {
+					cfg.KeyStore = previous
+					return fmt.Errorf("failed to save configuration, the keys are still in %s: %w", previous, err)
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to move keys: %w", err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Keys moved to %s\n", ref)
+			return nil
+		},
+	}
+
+	migrateCmd.Flags().StringVar(&to, "to", "", "Destination key store (file, encrypted, keyring or a full reference)")
+	_ = migrateCmd.MarkFlagRequired("to")
+
+	return migrateCmd
+}
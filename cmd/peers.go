@@ -0,0 +1,375 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/discovery"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func NewPeersCommand() *cobra.Command {
+	peersCmd := &cobra.Command{
+		Use:   "peers",
+		Short: "Manage the machines this machine synchronizes with",
+	}
+
+	peersCmd.AddCommand(
+		newPeersListCommand(),
+		newPeersAddCommand(),
+		newPeersRenameCommand(),
+		newPeersRemoveCommand(),
+		newPeersBlockCommand(),
+		newPeersDiscoverCommand(),
+	)
+
+	return peersCmd
+}
+
+func newPeersListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the configured peers and blocked keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			printPeers(cmd.OutOrStdout(), cfg)
+			return nil
+		},
+	}
+}
+
+func printPeers(w io.Writer, cfg *config.Config) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tFINGERPRINT\tMACHINE ID\tENDPOINT\tDIRECTION\tNAMESPACES")
+	for _, p := range cfg.Peers {
+		direction := p.Policy.Direction
+		if direction == "" {
+			direction = config.DirectionBoth
+		}
+		namespaces := make([]string, 0, len(p.Namespaces))
+		for _, ns := range p.EntryNamespaces() {
+			namespaces = append(namespaces, config.NamespaceName(ns))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", p.Name, discovery.Fingerprint(p.PublicKey),
+			orDash(p.MachineID), orDash(strings.Join(p.EndpointCandidates(), ",")), direction,
+			strings.Join(namespaces, ","))
+	}
+	tw.Flush()
+
+	if len(cfg.BlockedKeys) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nBlocked keys:")
+	for _, key := range cfg.BlockedKeys {
+		fmt.Fprintf(w, "  %s  %s\n", discovery.Fingerprint(key), key)
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func newPeersAddCommand() *cobra.Command {
+	var (
+		endpoints  []string
+		listenPort int
+		namespaces []string
+	)
+
+	addCmd := &cobra.Command{
+		Use:   "add <name> <public-key>",
+		Short: "Add a machine to synchronize with",
+		Long: `This command adds a peer with its WireGuard public key, as printed by
+"syncsh keys show" on that machine. The first --endpoint is where the tunnel
+starts, the others are tried in order when handshakes fail. --namespace
+makes the peer a member of a namespace, "personal" being this machine's own
+history; without it the peer only gets personal history. Blocked keys cannot
+be added.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := keys.ParseKey(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid public key: %w", err)
+			}
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			peer := config.Peer{Name: args[0], PublicKey: key, ListenPort: listenPort, Namespaces: namespaces}
+			if len(endpoints) > 0 {
+				peer.Endpoint, peer.Endpoints = endpoints[0], endpoints[1:]
+			}
+			if err := cfg.AddPeer(peer); err != nil {
+				return err
+			}
+			if err := cfg.Save(); err != nil {
+				return fmt.Errorf("failed to save configuration: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Added peer %s (%s)\n", peer.Name, discovery.Fingerprint(key))
+			return reloadPeers(cmd.Context(), cmd.OutOrStdout())
+		},
+	}
+
+	addCmd.Flags().StringArrayVar(&endpoints, "endpoint", nil, "Address or hostname and port the peer listens on, repeat for fallbacks")
+	addCmd.Flags().IntVar(&listenPort, "listen-port", 0, "Local UDP port of the tunnel to the peer, 0 for a random one")
+	addCmd.Flags().StringArrayVar(&namespaces, "namespace", nil, "Namespace the peer is a member of, repeat for several")
+
+	return addCmd
+}
+
+func newPeersRenameCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rename <name> <new-name>",
+		Short: "Rename a peer",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := cfg.RenamePeer(args[0], args[1]); err != nil {
+				return err
+			}
+			if err := cfg.Save(); err != nil {
+				return fmt.Errorf("failed to save configuration: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Renamed peer %s to %s\n", args[0], args[1])
+			return reloadPeers(cmd.Context(), cmd.OutOrStdout())
+		},
+	}
+}
+
+func newPeersRemoveCommand() *cobra.Command {
+	var purge bool
+
+	removeCmd := &cobra.Command{
+		Use:     "remove <name>",
+		Aliases: []string{"revoke"},
+		Short:   "Revoke a peer",
+		Long: `This command removes a peer with its keys and takes it off the WireGuard
+device, through the daemon when one is running. The history the peer
+contributed is kept unless --purge is given. The peer can be added again;
+use "syncsh peers block" to refuse it for good.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			peer, err := cfg.RemovePeer(args[0])
+			if err != nil {
+				return err
+			}
+			if err := cfg.Save(); err != nil {
+				return fmt.Errorf("failed to save configuration: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Removed peer %s\n", peer.Name)
+			return revokePeer(cmd.Context(), cmd.OutOrStdout(), cfg, peer, purge)
+		},
+	}
+
+	removeCmd.Flags().BoolVar(&purge, "purge", false, "Also delete the history the peer contributed")
+
+	return removeCmd
+}
+
+func newPeersBlockCommand() *cobra.Command {
+	var purge bool
+
+	blockCmd := &cobra.Command{
+		Use:   "block <name|public-key>",
+		Short: "Revoke a peer and refuse its keys from now on",
+		Long: `This command revokes a peer like "syncsh peers remove" and blocks its current
+and previous public keys. Blocked keys are refused at the handshake and
+cannot be added as peers again. A public key that is not a peer can be
+blocked too.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			peer, found, err := blockPeer(cfg, args[0])
+			if err != nil {
+				return err
+			}
+			if err := cfg.Save(); err != nil {
+				return fmt.Errorf("failed to save configuration: %w", err)
+			}
+			if !found {
+				fmt.Fprintf(out, "Blocked %s\n", discovery.Fingerprint(peer.PublicKey))
+				return reloadPeers(cmd.Context(), out)
+			}
+			fmt.Fprintf(out, "Blocked peer %s (%s)\n", peer.Name, discovery.Fingerprint(peer.PublicKey))
+			return revokePeer(cmd.Context(), out, cfg, peer, purge)
+		},
+	}
+
+	blockCmd.Flags().BoolVar(&purge, "purge", false, "Also delete the history the peer contributed")
+
+	return blockCmd
+}
+
+// blockPeer removes the peer ref names, or whose current or previous public
+// key it is, and blocks the peer's keys. A public key of no peer is blocked
+// alone and returned as the key of a peer that was not found.
+func blockPeer(cfg *config.Config, ref string) (peer config.Peer, found bool, err error) {
+	name := ref
+	key, keyErr := keys.ParseKey(ref)
+	if keyErr == nil {
+		if i := slices.IndexFunc(cfg.Peers, func(p config.Peer) bool {
+			return p.PublicKey == key || p.PreviousPublicKey == key
+		}); i >= 0 {
+			name = cfg.Peers[i].Name
+		}
+	}
+	peer, err = cfg.RemovePeer(name)
+	if err != nil {
+		if keyErr != nil {
+			return config.Peer{}, false, err
+		}
+		cfg.Block(key)
+		return config.Peer{PublicKey: key}, false, nil
+	}
+	cfg.Block(peer.PublicKey)
+	cfg.Block(peer.PreviousPublicKey)
+	return peer, true, nil
+}
+
+// reloadPeers has a running daemon pick up the changed peers
+func reloadPeers(ctx context.Context, out io.Writer) error {
+	err := callDaemon(ctx, daemon.MethodReload, nil)
+	if !daemonRunning(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reload the daemon: %w", err)
+	}
+	fmt.Fprintln(out, "The daemon reloaded its peers")
+	return nil
+}
+
+// revokePeer takes a peer that was removed from cfg off the WireGuard
+// device and forgets its sync state, deleting its history if purge is set.
+// A running daemon closes the peer's tunnel when it reloads and changes the
+// store itself; without one a kernel interface may still be left over.
+func revokePeer(ctx context.Context, out io.Writer, cfg *config.Config, peer config.Peer, purge bool) error {
+	if err := reloadPeers(ctx, out); err != nil {
+		return err
+	}
+	iface := network.PeerInterfaceName(cfg.InterfaceName, peer.PublicKey)
+	if err := network.RemoveKernelPeer(iface, peer.PublicKey); err != nil {
+		fmt.Fprintf(out, "Could not remove the peer from %s: %v\n", iface, err)
+	}
+
+	if peer.MachineID == "" {
+		return nil // never connected, so nothing is stored
+	}
+	params := daemon.RevokeParams{MachineID: peer.MachineID, Purge: purge}
+	var res daemon.RevokeResult
+	err := callDaemonWith(ctx, daemon.MethodRevoke, params, &res)
+	if !daemonRunning(err) {
+		res, err = revokeStored(ctx, cfg, params)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke peer %s: %w", peer.Name, err)
+	}
+	if purge {
+		fmt.Fprintf(out, "Deleted %d history entries of %s\n", res.Deleted, peer.MachineID)
+	}
+	return nil
+}
+
+// revokeStored does what the daemon's revoke does, for when no daemon is
+// running
+func revokeStored(ctx context.Context, cfg *config.Config, params daemon.RevokeParams) (daemon.RevokeResult, error) {
+	st, err := store.Open(ctx, cfg.SQLitePath)
+	if err != nil {
+		return daemon.RevokeResult{}, fmt.Errorf("failed to open history store: %w", err)
+	}
+	defer st.Close()
+	return daemon.Revoke(ctx, st, params)
+}
+
+func newPeersDiscoverCommand() *cobra.Command {
+	var timeout time.Duration
+
+	discoverCmd := &cobra.Command{
+		Use:   "discover",
+		Short: "List syncsh machines on the local network",
+		Long: `This command looks for syncsh machines on the local network with multicast DNS
+(_syncsh._udp) and lists them with the peer they are configured as. Machines
+that are not peers yet are pairing candidates: once their key is checked on
+the machine itself, add them with "syncsh peers add" to start synchronizing.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			found, err := discovery.Browse(cmd.Context(), timeout)
+			if err != nil {
+				return err
+			}
+			printDiscovered(cmd.OutOrStdout(), cfg, found)
+			return nil
+		},
+	}
+
+	discoverCmd.Flags().DurationVar(&timeout, "timeout", 3*time.Second, "How long to wait for answers")
+
+	return discoverCmd
+}
+
+func printDiscovered(w io.Writer, cfg *config.Config, found []discovery.Announcement) {
+	var candidates []discovery.Announcement
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "HOST\tFINGERPRINT\tADDRESSES\tPEER")
+	for _, a := range found {
+		if a.PublicKey == cfg.PublicKey {
+			continue
+		}
+		peer, known := cfg.PeerByPublicKey(a.PublicKey, time.Now())
+		name := "new"
+		if known {
+			name = peer.Name
+		} else {
+			candidates = append(candidates, a)
+		}
+		addrs := make([]string, len(a.Addrs))
+		for i, addr := range a.Addrs {
+			addrs[i] = addr.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Host, discovery.Fingerprint(a.PublicKey), strings.Join(addrs, ","), name)
+	}
+	tw.Flush()
+
+	if len(candidates) == 0 {
+		return
+	}
+	// Announcements are not authenticated, so the key has to be checked on
+	// the machine itself before it is trusted
+	fmt.Fprintln(w, "\nTo pair with a new machine, check that its key matches \"syncsh keys show\" there and add it:")
+	fmt.Fprintln(w)
+	for _, a := range candidates {
+		fmt.Fprintf(w, "  syncsh peers add %s %s\n", a.Host, a.PublicKey)
+	}
+}
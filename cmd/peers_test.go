@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/discovery"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+)
+
+func TestPrintDiscovered(t *testing.T) {
+	key := func() keys.Key {
+		k, _ := keys.GenerateKey()
+		pub, _ := k.PublicKey()
+		return pub
+	}
+	self, server, newcomer := key(), key(), key()
+	cfg := &config.Config{PublicKey: self, Peers: []config.Peer{{Name: "server", PublicKey: server}}}
+	lan := []netip.Addr{netip.MustParseAddr("192.168.1.20")}
+
+	var buf bytes.Buffer
+	printDiscovered(&buf, cfg, []discovery.Announcement{
+		{PublicKey: self, Host: "me", Addrs: lan},
+		{PublicKey: server, Host: "rack", Addrs: lan},
+		{PublicKey: newcomer, Host: "build-box", MachineID: "m-7", Addrs: lan},
+	})
+	out := buf.String()
+
+	rows := make(map[string][]string)
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) == 4 {
+			rows[fields[0]] = fields[1:]
+		}
+	}
+	if row := rows["rack"]; row == nil || row[0] != discovery.Fingerprint(server) || row[2] != "server" {
+		t.Errorf("Known peer row = %v", row)
+	}
+	if row := rows["build-box"]; row == nil || row[1] != "192.168.1.20" || row[2] != "new" {
+		t.Errorf("Candidate row = %v", row)
+	}
+	if _, ok := rows["me"]; ok {
+		t.Errorf("This machine must not be listed:\n%s", out)
+	}
+	if want := "  syncsh peers add build-box " + newcomer.String() + "\n"; !strings.Contains(out, want) {
+		t.Errorf("Output is missing the pairing command:\n%s", out)
+	}
+	if strings.Contains(out, "m-7") {
+		t.Errorf("Output must not suggest the announced machine ID:\n%s", out)
+	}
+}
+
+func TestBlockPeer(t *testing.T) {
+	var k [4]keys.Key
+	for i := range k {
+		k[i], _ = keys.GenerateKey()
+	}
+	cfg := &config.Config{Peers: []config.Peer{
+		{Name: "laptop", PublicKey: k[1], PreviousPublicKey: k[0]},
+		{Name: "desktop", PublicKey: k[2]},
+	}}
+
+	for ref, want := range map[string]string{k[2].String(): "desktop", k[0].String(): "laptop"} {
+		peer, found, err := blockPeer(cfg, ref)
+		if err != nil || !found || peer.Name != want {
+			t.Fatalf("blockPeer(%s) = %s, %v, %v; want %s", ref, peer.Name, found, err, want)
+		}
+		if _, ok := cfg.GetPeer(want); ok {
+			t.Errorf("Blocked peer %s is still configured", want)
+		}
+	}
+	for _, key := range k[:3] {
+		if !cfg.IsBlocked(key) {
+			t.Errorf("Key %s of a blocked peer is not blocked", key)
+		}
+	}
+
+	if peer, found, err := blockPeer(cfg, k[3].String()); err != nil || found || peer.PublicKey != k[3] || !cfg.IsBlocked(k[3]) {
+		t.Errorf("blockPeer() of a key of no peer = %+v, %v, %v", peer, found, err)
+	}
+	if _, _, err := blockPeer(cfg, "server"); err == nil {
+		t.Error("blockPeer() of an unknown name succeeded")
+	}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/relay"
+	"github.com/spf13/cobra"
+)
+
+// relayKeyName is the name of the relay's private key in its key store
+const relayKeyName = "relay"
+
+func NewRelayCommand() *cobra.Command {
+	var (
+		listen   string
+		keyStore string
+	)
+
+	relayCmd := &cobra.Command{
+		Use:   "relay",
+		Short: "Forward WireGuard packets between peers that cannot reach each other",
+		Long: `This command runs a relay for machines behind NATs that block direct
+connections, e.g. two laptops on hotel Wi-Fi. Run it on a host both machines
+can reach and add it to their config.yaml:
+
+  relay:
+    address: relay.example.com:7421
+    public_key: <printed at start>
+
+Peers register with the relay by WireGuard public key and fall back to it when
+direct handshakes fail. The relay only forwards WireGuard ciphertext. Its key
+is created on first start and kept in the key store.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ks, err := keys.Open(keyStoreRef(keyStore), promptPassphrase())
+			if err != nil {
+				return err
+			}
+			privateKey, err := relayKey(ks)
+			if err != nil {
+				return err
+			}
+			publicKey, err := privateKey.PublicKey()
+			if err != nil {
+				return err
+			}
+
+			addr, err := net.ResolveUDPAddr("udp", listen)
+			if err != nil {
+				return fmt.Errorf("invalid listen address %q: %w", listen, err)
+			}
+			pc, err := net.ListenUDP("udp", addr)
+			if err != nil {
+				return fmt.Errorf("failed to listen: %w", err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Relay listening on %s\nPublic key: %s\n", pc.LocalAddr(), publicKey)
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return relay.NewServer(privateKey).Serve(ctx, pc)
+		},
+	}
+
+	relayCmd.Flags().StringVar(&listen, "listen", ":"+strconv.Itoa(relay.DefaultPort), "UDP address to listen on")
+	relayCmd.Flags().StringVar(&keyStore, "key-store", keys.StoreFile, "Where to keep the relay key (file, encrypted, keyring or a full reference)")
+
+	return relayCmd
+}
+
+// relayKey returns the relay's private key, creating it on first use
+func relayKey(ks keys.KeyStore) (keys.Key, error) {
+	key, err := ks.Get(relayKeyName)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, keys.ErrKeyNotFound) {
+		return keys.Key{}, err
+	}
+	if key, err = keys.GenerateKey(); err != nil {
+		return keys.Key{}, err
+	}
+	if err := ks.Put(relayKeyName, key); err != nil {
+		return keys.Key{}, fmt.Errorf("failed to save relay key: %w", err)
+	}
+	return key, nil
+}
@@ -1,19 +1,61 @@
 package cmd
 
 import (
+	"io"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
 	"github.com/spf13/cobra"
 )
 
 func NewRootCommand() *cobra.Command {
+	var (
+		verbose int
+		logFile io.Closer
+	)
+
 	rootCmd := &cobra.Command{
 		Use:   "syncsh",
 		Short: "syncsh is a tool for synchronizing shells across networks",
 		Long:  `syncsh is a powerful command-line tool designed to synchronize shell sessions across multiple machines in a network.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Commands like init run before a config exists, so a missing or
+			// broken config only means default logging.
+			var cfg logging.Config
+			if c, err := loadConfig(); err == nil {
+				cfg = c.Logging
+			}
+			closer, err := logging.Setup(cfg, verbose)
+			if err != nil {
+				return err
+			}
+			logFile = closer
+			return nil
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if logFile != nil {
+				return logFile.Close()
+			}
+			return nil
+		},
 	}
+	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "Log more detail, repeat for more")
 
 	rootCmd.AddCommand(
 		NewInitCommand(),
 		NewConnectCommand(),
+		NewKeysCommand(),
+		NewVerifyCommand(),
+		NewDaemonCommand(),
+		NewServiceCommand(),
+		NewStatusCommand(),
+		NewRelayCommand(),
+		NewPeersCommand(),
+		NewShareCommand(),
+		NewTagCommand(),
+		NewNoteCommand(),
+		NewSearchCommand(),
+		NewBookmarksCommand(),
+		NewForgetCommand(),
 	)
 
 	return rootCmd
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/service"
+	"github.com/spf13/cobra"
+)
+
+func NewServiceCommand() *cobra.Command {
+	serviceCmd := &cobra.Command{
+		Use:   "service",
+		Short: "Run the daemon as a systemd user service",
+	}
+
+	serviceCmd.AddCommand(
+		newServiceInstallCommand(),
+		newServiceUninstallCommand(),
+		newServiceStatusCommand(),
+	)
+
+	return serviceCmd
+}
+
+func newServiceInstallCommand() *cobra.Command {
+	var dryRun bool
+
+	installCmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install, enable and start the syncsh user service",
+		Long: `This command writes a systemd user unit that runs "syncsh daemon", restarting it
+when it fails, then enables and starts it. The unit is hardened: the file system is
+read-only except for the syncsh configuration, the history store, the log file
+and the control socket. "syncsh forget" passes the daemon the open history file
+rather than giving it write access. With --dry-run the unit is printed instead
+of installed.
+
+An encrypted or keyring key store is unlocked with a systemd credential: the passphrase
+is asked for once and sealed with "systemd-creds encrypt --user" (systemd 256
+or later) into passphrase.cred in the configuration directory. Delete that
+file and install again after changing the passphrase.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			unit, err := daemonUnit(cmd, cfg)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				contents, err := unit.Render()
+				if err != nil {
+					return err
+				}
+				fmt.Fprint(cmd.OutOrStdout(), contents)
+				return nil
+			}
+
+			if unit.Passphrase != "" {
+				if err := sealPassphrase(cmd, unit.Passphrase); err != nil {
+					return err
+				}
+			}
+			m, err := service.NewManager()
+			if err != nil {
+				return err
+			}
+			if err := m.Install(cmd.Context(), unit); err != nil {
+				return fmt.Errorf("failed to install service: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Installed and started %s (%s)\n", service.UnitName, m.Path)
+			return nil
+		},
+	}
+
+	installCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the unit instead of installing it")
+
+	return installCmd
+}
+
+// daemonUnit describes a unit that runs the daemon with the current binary.
+// The daemon is started through the same command path as cmd, so it works
+// however the commands are nested.
+func daemonUnit(cmd *cobra.Command, cfg *config.Config) (service.Unit, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return service.Unit{}, fmt.Errorf("failed to locate the syncsh binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return service.Unit{}, err
+	}
+
+	args := strings.Fields(cmd.Parent().Parent().CommandPath())[1:]
+	args = append(args, "daemon")
+
+	// Relative paths are resolved against the working directory the unit
+	// starts the daemon in
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(wd, path)
+	}
+	paths := []string{configDir(), filepath.Dir(resolve(cfg.SQLitePath)), "%t/syncsh"}
+	if cfg.Logging.File != "" {
+		paths = append(paths, filepath.Dir(resolve(cfg.Logging.File)))
+	}
+
+	unit := service.Unit{
+		Executable:       exe,
+		Args:             args,
+		WorkingDirectory: wd,
+		ReadWritePaths:   paths,
+	}
+	// The keyring store reads its sealed copy after a reboot, so it needs
+	// the passphrase too
+	if kind, location, _ := strings.Cut(cfg.KeyStore, ":"); kind == keys.StoreEncrypted || (kind == keys.StoreKeyring && location != "") {
+		unit.Passphrase = filepath.Join(configDir(), "passphrase.cred")
+	}
+	return unit, nil
+}
+
+// sealPassphrase encrypts the key store passphrase into the credential file
+// at path, unless an earlier install already did. The passphrase is checked
+// against the key store first.
+func sealPassphrase(cmd *cobra.Command, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	passphrase := promptPassphrase()
+	cfg, ks, err := loadMachineWith(passphrase)
+	if err != nil {
+		return err
+	}
+	if _, err := machine.PrivateKey(cfg, ks); err != nil {
+		return err
+	}
+	secret, err := passphrase()
+	if err != nil {
+		return err
+	}
+	if err := service.EncryptCredential(cmd.Context(), service.PassphraseCredential, secret, path); err != nil {
+		return fmt.Errorf("failed to seal the key store passphrase: %w", err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Sealed the key store passphrase in %s\n", path)
+	return nil
+}
+
+func newServiceUninstallCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "uninstall",
+		Short: "Stop, disable and remove the syncsh user service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := service.NewManager()
+			if err != nil {
+				return err
+			}
+			if err := m.Uninstall(cmd.Context()); err != nil {
+				return fmt.Errorf("failed to uninstall service: %w", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Removed %s\n", service.UnitName)
+			return nil
+		},
+	}
+}
+
+func newServiceStatusCommand() *cobra.Command {
+	var asJSON bool
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the state of the syncsh user service",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			m, err := service.NewManager()
+			if err != nil {
+				return err
+			}
+			state, err := m.Status(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to query service: %w", err)
+			}
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(state)
+			}
+			if !state.Installed {
+				fmt.Fprintf(out, "%s is not installed, run syncsh service install\n", service.UnitName)
+				return nil
+			}
+			fmt.Fprintf(out, "%s: %s (%s), %s\n", service.UnitName, state.ActiveState, state.SubState, state.UnitFileState)
+			if state.MainPID != 0 {
+				fmt.Fprintf(out, "  pid %d since %s\n", state.MainPID, state.Since)
+			}
+			if state.Restarts > 0 {
+				fmt.Fprintf(out, "  restarted %d times\n", state.Restarts)
+			}
+			return nil
+		},
+	}
+
+	statusCmd.Flags().BoolVar(&asJSON, "json", false, "Print the state as JSON")
+
+	return statusCmd
+}
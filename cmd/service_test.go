@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
+	"github.com/spf13/cobra"
+)
+
+func TestDaemonUnit(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nested the way main nests the commands
+	root := &cobra.Command{Use: "syncsh"}
+	root.AddCommand(NewRootCommand())
+	install, _, err := root.Find([]string{"syncsh", "service", "install"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		SQLitePath:  filepath.Join(home, "data", "syncsh.db"),
+		HistoryPath: filepath.Join(home, ".bash_history"),
+		Logging:     logging.Config{File: "logs/syncsh.log"},
+	}
+	unit, err := daemonUnit(install, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"syncsh", "daemon"}; !slices.Equal(unit.Args, want) {
+		t.Errorf("Args = %v, want %v", unit.Args, want)
+	}
+	want := []string{
+		filepath.Join(home, ".config", "syncsh"),
+		filepath.Join(home, "data"),
+		"%t/syncsh",
+		filepath.Join(wd, "logs"),
+	}
+	if !slices.Equal(unit.ReadWritePaths, want) {
+		t.Errorf("ReadWritePaths = %v, want %v", unit.ReadWritePaths, want)
+	}
+
+	if unit.Passphrase != "" {
+		t.Errorf("Passphrase = %q for a file key store", unit.Passphrase)
+	}
+
+	// Without a log file the daemon logs to the journal
+	cfg.Logging.File = ""
+	if unit, _ = daemonUnit(install, cfg); len(unit.ReadWritePaths) != 3 {
+		t.Errorf("ReadWritePaths without a log file = %v", unit.ReadWritePaths)
+	}
+
+	cfg.KeyStore = "encrypted:" + filepath.Join(home, ".config", "syncsh", "keys")
+	if unit, _ = daemonUnit(install, cfg); unit.Passphrase != filepath.Join(home, ".config", "syncsh", "passphrase.cred") {
+		t.Errorf("Passphrase = %q for an encrypted key store", unit.Passphrase)
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func NewShareCommand() *cobra.Command {
+	var namespace string
+
+	shareCmd := &cobra.Command{
+		Use:   "share <history-id|hash|last>",
+		Short: "Publish a command to a team namespace",
+		Long: `This command copies a history entry into a shared namespace such as a team
+runbook. The copy is signed by this machine and sent to every peer that is a
+member of the namespace. "last" is the newest command run on this machine
+other than syncsh itself; any entry can be given by its ID or a prefix of its
+hash. --namespace can be left out when the peers share a single namespace.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			namespace, err := shareNamespace(cfg, namespace)
+			if err != nil {
+				return err
+			}
+			res, err := shareCommand(ctx, cfg, daemon.ShareParams{Ref: args[0], Namespace: namespace})
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Shared %q in %s with %s\n", res.Command, namespace,
+				strings.Join(cfg.NamespacePeers(namespace), ", "))
+			reportSync(out, res.Peers)
+			return nil
+		},
+	}
+
+	shareCmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace to publish the command to")
+
+	return shareCmd
+}
+
+// shareCommand shares through the daemon, or through the history store
+// when no daemon is running
+func shareCommand(ctx context.Context, cfg *config.Config, params daemon.ShareParams) (daemon.ShareResult, error) {
+	var res daemon.ShareResult
+	err := callDaemonWith(ctx, daemon.MethodShare, params, &res)
+	if daemonRunning(err) {
+		return res, err
+	}
+
+	_, ks, err := loadMachine()
+	if err != nil {
+		return daemon.ShareResult{}, err
+	}
+	signingKey, err := machine.SigningKey(cfg, ks)
+	if err != nil {
+		return daemon.ShareResult{}, err
+	}
+	st, err := store.Open(ctx, cfg.SQLitePath)
+	if err != nil {
+		return daemon.ShareResult{}, fmt.Errorf("failed to open history store: %w", err)
+	}
+	defer st.Close()
+	res, err = daemon.Share(ctx, st, cfg.MachineID, signingKey, params)
+	res.Peers = -1
+	return res, err
+}
+
+// shareNamespace checks that commands can be shared into the namespace
+// called name, defaulting to the only shared namespace
+func shareNamespace(cfg *config.Config, name string) (string, error) {
+	if name == "" {
+		shared := cfg.SharedNamespaces()
+		switch len(shared) {
+		case 0:
+			return "", errors.New("no peer is a member of a shared namespace: add one to the namespaces of the peers to share with")
+		case 1:
+			return shared[0], nil
+		default:
+			return "", fmt.Errorf("--namespace is required, peers share %s", strings.Join(shared, ", "))
+		}
+	}
+	if name == config.PersonalNamespace {
+		return "", errors.New("commands are in the personal namespace already")
+	}
+	if err := config.ValidateNamespaces([]string{name}); err != nil {
+		return "", err
+	}
+	if len(cfg.NamespacePeers(name)) == 0 {
+		return "", fmt.Errorf("no peer is a member of namespace %s", name)
+	}
+	return name, nil
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+)
+
+func TestShareNamespace(t *testing.T) {
+	cfg := &config.Config{Peers: []config.Peer{{Name: "laptop"}, {Name: "alice", Namespaces: []string{"team"}}}}
+	if ns, err := shareNamespace(cfg, ""); err != nil || ns != "team" {
+		t.Errorf("shareNamespace() = %q, %v; want the only shared namespace", ns, err)
+	}
+	for _, name := range []string{"personal", "ops", "Team"} {
+		if _, err := shareNamespace(cfg, name); err == nil {
+			t.Errorf("shareNamespace(%q) succeeded", name)
+		}
+	}
+
+	cfg.Peers = append(cfg.Peers, config.Peer{Name: "bob", Namespaces: []string{"ops"}})
+	if _, err := shareNamespace(cfg, ""); err == nil {
+		t.Error("shareNamespace() picked one of several namespaces")
+	}
+	if ns, err := shareNamespace(cfg, "ops"); err != nil || ns != "ops" {
+		t.Errorf("shareNamespace(ops) = %q, %v", ns, err)
+	}
+}
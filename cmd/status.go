@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func NewStatusCommand() *cobra.Command {
+	var asJSON bool
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show peer and sync health",
+		Long: `This command shows the state of the daemon, the history store, the history
+watcher and every peer: WireGuard handshake and traffic, when the peer last
+acknowledged history and how many entries it has yet to receive. Without a running
+daemon the state is read from the store.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var status daemon.Status
+			err := callDaemon(cmd.Context(), daemon.MethodStatus, &status)
+			if !daemonRunning(err) {
+				status, err = offlineStatus(cmd)
+			}
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				return enc.Encode(status)
+			}
+			printStatus(out, status, time.Now())
+			return nil
+		},
+	}
+
+	statusCmd.Flags().BoolVar(&asJSON, "json", false, "Print the status as JSON")
+
+	return statusCmd
+}
+
+func offlineStatus(cmd *cobra.Command) (daemon.Status, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return daemon.Status{}, err
+	}
+	st, err := store.Open(cmd.Context(), cfg.SQLitePath)
+	if err != nil {
+		return daemon.Status{}, fmt.Errorf("failed to open history store: %w", err)
+	}
+	defer st.Close()
+	return daemon.Offline(cmd.Context(), st, cfg)
+}
+
+func printStatus(w io.Writer, s daemon.Status, now time.Time) {
+	switch {
+	case !s.Running:
+		fmt.Fprintln(w, "Daemon:  not running")
+	case s.Paused:
+		fmt.Fprintf(w, "Daemon:  paused (pid %d, up %s)\n", s.PID, since(now, s.StartedAt))
+	default:
+		fmt.Fprintf(w, "Daemon:  running (pid %d, up %s)\n", s.PID, since(now, s.StartedAt))
+	}
+	fmt.Fprintf(w, "Store:   %d entries, %s\n", s.Entries, humanBytes(s.StoreBytes))
+
+	fmt.Fprintf(w, "Watcher: %s, %s, %s behind", s.Watcher.State, s.Watcher.Path, humanBytes(s.Watcher.Lag))
+	if !s.Watcher.LastIngest.IsZero() {
+		fmt.Fprintf(w, ", last ingest %s ago", since(now, s.Watcher.LastIngest))
+	}
+	if s.Watcher.LastError != "" {
+		fmt.Fprintf(w, ", last error: %s", s.Watcher.LastError)
+	}
+	fmt.Fprintln(w)
+
+	if len(s.Peers) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tSESSION\tHANDSHAKE\tRX\tTX\tLAST SYNC\tPENDING")
+	for _, p := range s.Peers {
+		session := "-"
+		if s.Running {
+			session = "down"
+			if p.Connected {
+				session = "up"
+			}
+			if p.State != "" && p.State != daemon.ConnConnected {
+				session = string(p.State)
+			}
+		}
+		handshake, rx, tx := "-", "-", "-"
+		if p.Tunnel != nil {
+			if !p.Tunnel.LastHandshake.IsZero() {
+				handshake = since(now, p.Tunnel.LastHandshake) + " ago"
+			} else {
+				handshake = "never"
+			}
+			rx, tx = humanBytes(p.Tunnel.BytesReceived), humanBytes(p.Tunnel.BytesTransmitted)
+		}
+		lastSync := "never"
+		if !p.LastSync.IsZero() {
+			lastSync = since(now, p.LastSync) + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", p.Name, session, handshake, rx, tx, lastSync, p.Pending)
+	}
+	tw.Flush()
+
+	printEvents(w, s.Peers, now)
+}
+
+// recentEvents is how many connection state changes status shows
+const recentEvents = 5
+
+// printEvents prints the latest connection state changes of all peers
+func printEvents(w io.Writer, peers []daemon.PeerStatus, now time.Time) {
+	var events []daemon.PeerEvent
+	for _, p := range peers {
+		events = append(events, p.Events...)
+	}
+	if len(events) == 0 {
+		return
+	}
+	slices.SortFunc(events, func(a, b daemon.PeerEvent) int { return a.At.Compare(b.At) })
+	events = events[max(0, len(events)-recentEvents):]
+
+	fmt.Fprintln(w, "\nRecent events:")
+	for _, ev := range events {
+		fmt.Fprintf(w, "  %s ago  %s: %s -> %s", since(now, ev.At), ev.Peer, ev.From, ev.To)
+		if ev.Reason != "" {
+			fmt.Fprintf(w, " (%s)", ev.Reason)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// since formats the time elapsed since t, rounded to the second
+func since(now, t time.Time) string {
+	return now.Sub(t).Round(time.Second).String()
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
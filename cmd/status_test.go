@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+)
+
+func TestPrintStatus(t *testing.T) {
+	now := time.Unix(1700003600, 0)
+	status := daemon.Status{
+		Running:    true,
+		PID:        42,
+		StartedAt:  now.Add(-time.Hour),
+		Entries:    1200,
+		StoreBytes: 3 * 1024 * 1024,
+		Watcher:    daemon.WatcherStatus{Path: "/home/me/.zsh_history", State: daemon.StateRunning},
+		Peers: []daemon.PeerStatus{
+			{
+				Name:      "server",
+				Connected: true,
+				LastSync:  now.Add(-30 * time.Second),
+				Pending:   3,
+				Tunnel: &network.SimplePeerStatus{
+					LastHandshake:    now.Add(-10 * time.Second),
+					BytesReceived:    2048,
+					BytesTransmitted: 512,
+				},
+			},
+			{Name: "laptop", Pending: 1200},
+			{
+				Name:    "desk",
+				Pending: 7,
+				State:   daemon.ConnBackoff,
+				Events: []daemon.PeerEvent{
+					{Peer: "desk", From: daemon.ConnConnecting, To: daemon.ConnConnected, At: now.Add(-5 * time.Minute)},
+					{Peer: "desk", From: daemon.ConnConnected, To: daemon.ConnBackoff, At: now.Add(-20 * time.Second), Reason: "no WireGuard handshake for too long"},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	printStatus(&buf, status, now)
+	out := buf.String()
+	for _, want := range []string{
+		"Daemon:  running (pid 42, up 1h0m0s)",
+		"Store:   1200 entries, 3.0 MiB",
+		"Watcher: running, /home/me/.zsh_history, 0 B behind",
+		"server  up       10s ago    2.0 KiB  512 B  30s ago    3",
+		"laptop  down     -          -        -      never      1200",
+		"desk    backoff  -          -        -      never      7",
+		"Recent events:\n  5m0s ago  desk: connecting -> connected\n  20s ago  desk: connected -> backoff (no WireGuard handshake for too long)",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		1023:            "1023 B",
+		1536:            "1.5 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+	}
+	for n, want := range tests {
+		if got := humanBytes(n); got != want {
+			t.Errorf("humanBytes(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/daemon"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func NewVerifyCommand() *cobra.Command {
+	var showUnsigned bool
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the signature of every stored history entry",
+		Long: `This command audits the local history store. Every entry must be signed by the
+machine it claims to come from: this machine's own signing key, or the key a peer
+presented on first contact. Entries with invalid signatures are listed and the
+command exits with an error.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report, err := auditHistory(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "Checked %d entries: %d valid, %d invalid, %d unsigned, %d from unknown machines\n",
+				report.Checked, report.Valid, len(report.Invalid), len(report.Unsigned), len(report.Unknown))
+			printEntries(out, "Invalid signature", report.Invalid)
+			printEntries(out, "Unknown machine", report.Unknown)
+			if showUnsigned {
+				printEntries(out, "Unsigned", report.Unsigned)
+			}
+
+			if len(report.Invalid) > 0 {
+				return fmt.Errorf("%d entries have invalid signatures", len(report.Invalid))
+			}
+			return nil
+		},
+	}
+
+	verifyCmd.Flags().BoolVar(&showUnsigned, "show-unsigned", false, "List entries written before entries were signed")
+
+	return verifyCmd
+}
+
+// auditHistory asks the daemon to audit the store, or opens the store itself
+// when no daemon is running
+func auditHistory(ctx context.Context) (provenance.Report, error) {
+	var report provenance.Report
+	err := callDaemon(ctx, daemon.MethodVerify, &report)
+	if daemonRunning(err) {
+		return report, err
+	}
+
+	cfg, _, err := loadMachine()
+	if err != nil {
+		return report, err
+	}
+	st, err := store.Open(ctx, cfg.SQLitePath)
+	if err != nil {
+		return report, fmt.Errorf("failed to open history store: %w", err)
+	}
+	defer st.Close()
+
+	entries, err := st.ListEntries(ctx, "", 0, 0)
+	if err != nil {
+		return report, fmt.Errorf("failed to read history: %w", err)
+	}
+	return provenance.Audit(entries, machine.SigningKeys(cfg)), nil
+}
+
+func printEntries(w io.Writer, label string, entries []parser.HistoryEntry) {
+	for _, e := range entries {
+		fmt.Fprintf(w, "  %s: %s %s %q\n", label,
+			time.Unix(e.Timestamp, 0).Format(time.RFC3339), e.MachineID, e.Command)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
 	"github.com/TheRealSibasishBehera/syncsh/pkg/utils"
 	"github.com/goccy/go-yaml"
 )
@@ -40,16 +43,64 @@ func (s ShellKind) GetDefaultHistoryPath() string {
 }
 
 type Config struct {
-	SQLitePath string    `yaml:"sql_path"` // path to the SQLite database file
-	kind       ShellKind `yaml:"shell"`    // kind of shell to use, e.g., ["bash", "zsh"]
+	SQLitePath string    `yaml:"sql_path"`   // path to the SQLite database file
+	Shell      ShellKind `yaml:"shell"`      // kind of shell to use, e.g., ["bash", "zsh"]
+	MachineID  string    `yaml:"machine_id"` // identifies the entries this machine produces
 
 	// WireGuard keys
-	PrivateKey string `yaml:"private_key"` // WireGuard private key (base64)
-	PublicKey  string `yaml:"public_key"`  // WireGuard public key (base64)
+	KeyStore      string   `yaml:"key_store,omitempty"`       // where private keys are kept, e.g. file:/path/to/keys
+	PrivateKeyRef string   `yaml:"private_key_ref,omitempty"` // name of the WireGuard private key in the key store
+	PublicKey     keys.Key `yaml:"public_key"`                // WireGuard public key (base64)
+
+	// Ed25519 key the machine signs its history entries with
+	SigningKeyRef    string   `yaml:"signing_key_ref,omitempty"` // name of the signing key seed in the key store
+	SigningPublicKey keys.Key `yaml:"signing_public_key,omitempty"`
+
+	// PrivateKey is the plaintext private key written by older versions.
+	// It is moved into the key store the next time the config is loaded.
+	PrivateKey string `yaml:"private_key,omitempty"`
+
+	// PreviousKeys are key pairs replaced by rotation that are still inside their grace window
+	PreviousKeys []RetiredKey `yaml:"previous_keys,omitempty"`
+
+	Peers []Peer `yaml:"peers,omitempty"` // machines to synchronize with
+
+	// BlockedKeys are public keys of blocked machines, refused even if they
+	// are added as peers again
+	BlockedKeys []keys.Key `yaml:"blocked_keys,omitempty"`
+
+	// Relay forwards packets to peers that cannot be reached directly
+	Relay *Relay `yaml:"relay,omitempty"`
+
+	// Transport is the WireGuard implementation: auto (the default) uses the
+	// kernel with CAP_NET_ADMIN and a userspace netstack otherwise
+	Transport network.TransportKind `yaml:"transport,omitempty"`
+
+	// MeshPrefix is the unique local /48 shared by all machines of the mesh.
+	// Tunnel addresses are derived from it and the machines' public keys.
+	// Without it tunnels use an IPv4 pair from TunnelPool, as do those with
+	// TunnelIPv4 set in addition to IPv6.
+	MeshPrefix string `yaml:"mesh_prefix,omitempty"`
+	TunnelIPv4 bool   `yaml:"tunnel_ipv4,omitempty"`
+
+	// TunnelPool are the IPv4 ranges tunnel /30s are picked from, skipping
+	// those that overlap routes or addresses of the host. Empty means
+	// 10.100.0.0/24.
+	TunnelPool []string `yaml:"tunnel_pool,omitempty"`
+
+	// NoDiscovery stops advertising this machine and looking for peers on
+	// the local network with mDNS
+	NoDiscovery bool `yaml:"no_discovery,omitempty"`
 
 	//optional path
 	HistoryPath   string `yaml:"history"`   // path for the history file
-	InterfaceName string `yaml:"interface"` // name for wireguard interface
+	InterfaceName string `yaml:"interface"` // base name for kernel wireguard interfaces
+
+	// MetricsListen is the address the daemon serves Prometheus metrics on,
+	// e.g. 127.0.0.1:9420. Metrics are off when it is empty.
+	MetricsListen string `yaml:"metrics_listen,omitempty"`
+
+	Logging logging.Config `yaml:"logging,omitempty"` // log format, levels and file
 
 	//internal
 	path string // config is read from this path
@@ -68,15 +119,15 @@ func NewConfigWithOpts(opts ...ConfigOption) (*Config, error) {
 	if cfg.InterfaceName == "" {
 		cfg.InterfaceName = "syncsh0"
 	}
-	if cfg.kind == "" {
+	if cfg.Shell == "" {
 		shellKind, err := utils.GetShellKind()
 		if err != nil {
 			return nil, err
 		}
-		cfg.kind = ShellKind(shellKind)
+		cfg.Shell = ShellKind(shellKind)
 	}
 	if cfg.HistoryPath == "" {
-		historyPath, err := utils.GetDefaultHistoryPath(string(cfg.kind))
+		historyPath, err := utils.GetDefaultHistoryPath(string(cfg.Shell))
 		if err != nil {
 			return nil, err
 		}
@@ -100,7 +151,7 @@ func (c *Config) GetResolvedHistoryPath() string {
 	if c.HistoryPath != "" {
 		return c.HistoryPath
 	}
-	return c.kind.GetDefaultHistoryPath()
+	return c.Shell.GetDefaultHistoryPath()
 }
 
 func NewFromFile(path string) (*Config, error) {
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// PersonalNamespace names a machine's own history in peer configurations.
+// Its entries carry no namespace.
+const PersonalNamespace = "personal"
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// ValidateNamespaces checks namespace names: lowercase letters, digits, dots,
+// dashes and underscores
+func ValidateNamespaces(names []string) error {
+	for _, name := range names {
+		if !namespacePattern.MatchString(name) {
+			return fmt.Errorf("invalid namespace %q: use lowercase letters, digits, '.', '-' and '_'", name)
+		}
+	}
+	return nil
+}
+
+// EntryNamespaces returns the namespaces of the history entries exchanged
+// with the peer, where the personal namespace is the empty string. A peer
+// without namespaces only gets personal history.
+func (p *Peer) EntryNamespaces() []string {
+	if len(p.Namespaces) == 0 {
+		return []string{""}
+	}
+	namespaces := make([]string, 0, len(p.Namespaces))
+	for _, name := range p.Namespaces {
+		if ns := EntryNamespace(name); !slices.Contains(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// SharedNamespaces returns the namespaces other than the personal one that
+// some peer is a member of, sorted
+func (c *Config) SharedNamespaces() []string {
+	var names []string
+	for _, p := range c.Peers {
+		for _, name := range p.Namespaces {
+			if name != PersonalNamespace && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NamespacePeers returns the names of the peers that are members of the
+// namespace
+func (c *Config) NamespacePeers(name string) []string {
+	var peers []string
+	for _, p := range c.Peers {
+		if slices.Contains(p.EntryNamespaces(), EntryNamespace(name)) {
+			peers = append(peers, p.Name)
+		}
+	}
+	return peers
+}
+
+// EntryNamespace returns the namespace history entries of the namespace
+// called name carry
+func EntryNamespace(name string) string {
+	if name == PersonalNamespace {
+		return ""
+	}
+	return name
+}
+
+// NamespaceName returns the name of the namespace entries carrying ns
+// belong to
+func NamespaceName(ns string) string {
+	if ns == "" {
+		return PersonalNamespace
+	}
+	return ns
+}
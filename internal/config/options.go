@@ -22,6 +22,18 @@ func WithSQLitePath(path string) ConfigOption {
 
 func WithShellKind(kind ShellKind) ConfigOption {
 	return func(c *Config) {
-		c.kind = kind
+		c.Shell = kind
+	}
+}
+
+func WithKeyStore(ref string) ConfigOption {
+	return func(c *Config) {
+		c.KeyStore = ref
+	}
+}
+
+func WithMeshPrefix(prefix string) ConfigOption {
+	return func(c *Config) {
+		c.MeshPrefix = prefix
 	}
 }
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+)
+
+var (
+	ErrPeerNotFound = errors.New("no such peer")
+	ErrPeerExists   = errors.New("peer already exists")
+	ErrBlockedKey   = errors.New("public key is blocked")
+)
+
+// Peer is a remote syncsh machine this machine synchronizes with
+type Peer struct {
+	Name      string   `yaml:"name"`
+	MachineID string   `yaml:"machine_id,omitempty"` // learned from the peer on first contact
+	PublicKey keys.Key `yaml:"public_key"`           // peer WireGuard public key
+	Endpoint  string   `yaml:"endpoint,omitempty"`   // address:port or hostname:port the peer listens on
+
+	// Endpoints are further candidates, tried in order after Endpoint when
+	// handshakes fail
+	Endpoints []string `yaml:"endpoints,omitempty"`
+
+	// ListenPort is the local UDP port of the tunnel to this peer. The peer
+	// uses it as its endpoint for us; 0 picks a random port, which only
+	// works if this machine dials the peer.
+	ListenPort int `yaml:"listen_port,omitempty"`
+
+	// TunnelPrefix pins the IPv4 /30 of the tunnel to this peer instead of
+	// picking one from the tunnel pool. Both sides must agree on it.
+	TunnelPrefix string `yaml:"tunnel_prefix,omitempty"`
+
+	// SigningKey verifies the history entries the peer produces. Like the
+	// machine ID it is learned on first contact.
+	SigningKey keys.Key `yaml:"signing_key,omitempty"`
+
+	// PreviousPublicKey is the key the peer used before its last rotation.
+	// It is still accepted until PreviousKeyExpiresAt.
+	PreviousPublicKey    keys.Key  `yaml:"previous_public_key,omitempty"`
+	PreviousKeyExpiresAt time.Time `yaml:"previous_key_expires_at,omitempty"`
+
+	// KnownPublicKey is our own public key as last acknowledged by the peer
+	KnownPublicKey keys.Key `yaml:"known_public_key,omitempty"`
+
+	// Policy limits what is exchanged with the peer
+	Policy Policy `yaml:"policy,omitempty"`
+
+	// Namespaces are the histories exchanged with the peer: "personal" for
+	// this machine's own and team namespaces commands are shared into with
+	// syncsh share. Empty means only personal history.
+	Namespaces []string `yaml:"namespaces,omitempty"`
+}
+
+// HasPublicKey reports whether key identifies the peer, either as its current
+// key or as a previous key that is still inside its grace window.
+func (p *Peer) HasPublicKey(key keys.Key, now time.Time) bool {
+	if key.IsZero() {
+		return false
+	}
+	if key == p.PublicKey {
+		return true
+	}
+	return key == p.PreviousPublicKey && now.Before(p.PreviousKeyExpiresAt)
+}
+
+// EndpointCandidates returns Endpoint followed by Endpoints, without
+// duplicates
+func (p *Peer) EndpointCandidates() []string {
+	var candidates []string
+	for _, e := range append([]string{p.Endpoint}, p.Endpoints...) {
+		if e != "" && !slices.Contains(candidates, e) {
+			candidates = append(candidates, e)
+		}
+	}
+	return candidates
+}
+
+// RetiredKey is a WireGuard key pair replaced by rotation. It is kept until
+// ExpiresAt so peers that have not yet learned the new key can still connect.
+type RetiredKey struct {
+	PrivateKeyRef string    `yaml:"private_key_ref,omitempty"` // name of the private key in the key store
+	PublicKey     keys.Key  `yaml:"public_key"`
+	RotatedAt     time.Time `yaml:"rotated_at"`
+	ExpiresAt     time.Time `yaml:"expires_at"`
+
+	// PrivateKey is the plaintext key written by older versions, moved into
+	// the key store on load.
+	PrivateKey string `yaml:"private_key,omitempty"`
+}
+
+// GetPeer returns the peer with the given name
+func (c *Config) GetPeer(name string) (*Peer, bool) {
+	for i := range c.Peers {
+		if c.Peers[i].Name == name {
+			return &c.Peers[i], true
+		}
+	}
+	return nil, false
+}
+
+// PeerByPublicKey returns the peer identified by key. Blocked keys identify
+// no peer.
+func (c *Config) PeerByPublicKey(key keys.Key, now time.Time) (*Peer, bool) {
+	if c.IsBlocked(key) {
+		return nil, false
+	}
+	for i := range c.Peers {
+		if c.Peers[i].HasPublicKey(key, now) {
+			return &c.Peers[i], true
+		}
+	}
+	return nil, false
+}
+
+// AddPeer adds peer. Its name and public key must not be taken by another
+// peer, and the key must not be blocked or our own.
+func (c *Config) AddPeer(peer Peer) error {
+	if peer.Name == "" {
+		return errors.New("peer name cannot be empty")
+	}
+	if peer.PublicKey.IsZero() {
+		return errors.New("peer public key cannot be empty")
+	}
+	if peer.PublicKey == c.PublicKey {
+		return errors.New("peer public key is this machine's own")
+	}
+	if c.IsBlocked(peer.PublicKey) {
+		return fmt.Errorf("add peer %s: %w", peer.Name, ErrBlockedKey)
+	}
+	if _, ok := c.GetPeer(peer.Name); ok {
+		return fmt.Errorf("add peer %s: %w", peer.Name, ErrPeerExists)
+	}
+	if other, ok := c.PeerByPublicKey(peer.PublicKey, time.Now()); ok {
+		return fmt.Errorf("add peer %s: %w: the key belongs to %s", peer.Name, ErrPeerExists, other.Name)
+	}
+	if err := peer.Policy.Validate(); err != nil {
+		return fmt.Errorf("add peer %s: %w", peer.Name, err)
+	}
+	if err := ValidateNamespaces(peer.Namespaces); err != nil {
+		return fmt.Errorf("add peer %s: %w", peer.Name, err)
+	}
+	c.Peers = append(c.Peers, peer)
+	return nil
+}
+
+// RenamePeer changes the name of the peer called name
+func (c *Config) RenamePeer(name, newName string) error {
+	if newName == "" {
+		return errors.New("peer name cannot be empty")
+	}
+	peer, ok := c.GetPeer(name)
+	if !ok {
+		return fmt.Errorf("rename peer %s: %w", name, ErrPeerNotFound)
+	}
+	if _, ok := c.GetPeer(newName); ok && newName != name {
+		return fmt.Errorf("rename peer %s: %w: %s", name, ErrPeerExists, newName)
+	}
+	peer.Name = newName
+	return nil
+}
+
+// RemovePeer removes the peer called name and returns it
+func (c *Config) RemovePeer(name string) (Peer, error) {
+	i := slices.IndexFunc(c.Peers, func(p Peer) bool { return p.Name == name })
+	if i < 0 {
+		return Peer{}, fmt.Errorf("remove peer %s: %w", name, ErrPeerNotFound)
+	}
+	peer := c.Peers[i]
+	c.Peers = slices.Delete(c.Peers, i, i+1)
+	return peer, nil
+}
+
+// Block adds key to the blocked keys
+func (c *Config) Block(key keys.Key) {
+	if !key.IsZero() && !c.IsBlocked(key) {
+		c.BlockedKeys = append(c.BlockedKeys, key)
+	}
+}
+
+// IsBlocked reports whether key is blocked
+func (c *Config) IsBlocked(key keys.Key) bool {
+	return slices.Contains(c.BlockedKeys, key)
+}
+
+// GetRetiredKey returns the retired key pair with the given public key if it
+// has not expired yet.
+func (c *Config) GetRetiredKey(publicKey keys.Key, now time.Time) (RetiredKey, bool) {
+	for _, k := range c.PreviousKeys {
+		if k.PublicKey == publicKey && now.Before(k.ExpiresAt) {
+			return k, true
+		}
+	}
+	return RetiredKey{}, false
+}
+
+// PruneRetiredKeys drops retired key pairs whose grace window has ended and
+// returns them so their private keys can be removed from the key store.
+func (c *Config) PruneRetiredKeys(now time.Time) []RetiredKey {
+	var expired []RetiredKey
+	kept := c.PreviousKeys[:0]
+	for _, k := range c.PreviousKeys {
+		if now.Before(k.ExpiresAt) {
+			kept = append(kept, k)
+		} else {
+			expired = append(expired, k)
+		}
+	}
+	c.PreviousKeys = kept
+	return expired
+}
+
+// Relay is a syncsh relay that forwards WireGuard packets between peers
+// behind NATs that block direct connections
+type Relay struct {
+	Address   string   `yaml:"address"`    // host:port the relay listens on
+	PublicKey keys.Key `yaml:"public_key"` // printed by syncsh relay
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+)
+
+func TestPeerManagement(t *testing.T) {
+	key := func() keys.Key {
+		k, _ := keys.GenerateKey()
+		pub, _ := k.PublicKey()
+		return pub
+	}
+	self, laptop, server := key(), key(), key()
+	c := &Config{PublicKey: self}
+
+	if err := c.AddPeer(Peer{Name: "laptop", PublicKey: laptop}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		peer Peer
+		want error
+	}{
+		{Peer{Name: "laptop", PublicKey: server}, ErrPeerExists},
+		{Peer{Name: "other", PublicKey: laptop}, ErrPeerExists},
+	} {
+		if err := c.AddPeer(tt.peer); !errors.Is(err, tt.want) {
+			t.Errorf("AddPeer(%s) = %v, want %v", tt.peer.Name, err, tt.want)
+		}
+	}
+	for _, bad := range []Peer{{PublicKey: server}, {Name: "me", PublicKey: self}, {Name: "nokey"}} {
+		if err := c.AddPeer(bad); err == nil {
+			t.Errorf("AddPeer(%+v) succeeded", bad)
+		}
+	}
+
+	if err := c.RenamePeer("laptop", "desk"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.GetPeer("desk"); !ok {
+		t.Errorf("Renamed peer not found")
+	}
+	if err := c.RenamePeer("laptop", "x"); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("RenamePeer() of a missing peer = %v", err)
+	}
+
+	removed, err := c.RemovePeer("desk")
+	if err != nil || removed.PublicKey != laptop || len(c.Peers) != 0 {
+		t.Fatalf("RemovePeer() = %+v, %v, peers left %d", removed, err, len(c.Peers))
+	}
+	c.Block(laptop)
+	c.Block(laptop)
+	if len(c.BlockedKeys) != 1 {
+		t.Errorf("BlockedKeys = %v", c.BlockedKeys)
+	}
+	if err := c.AddPeer(Peer{Name: "desk", PublicKey: laptop}); !errors.Is(err, ErrBlockedKey) {
+		t.Errorf("AddPeer() of a blocked key = %v, want %v", err, ErrBlockedKey)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	c := &Config{Peers: []Peer{
+		{Name: "laptop"},
+		{Name: "alice", Namespaces: []string{"team"}},
+		{Name: "desk", Namespaces: []string{"personal", "team", "ops", "team"}},
+	}}
+
+	for _, tt := range []struct {
+		peer int
+		want []string
+	}{
+		{0, []string{""}},
+		{1, []string{"team"}},
+		{2, []string{"", "team", "ops"}},
+	} {
+		if got := c.Peers[tt.peer].EntryNamespaces(); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: EntryNamespaces() = %q, want %q", c.Peers[tt.peer].Name, got, tt.want)
+		}
+	}
+	if got := c.SharedNamespaces(); !slices.Equal(got, []string{"ops", "team"}) {
+		t.Errorf("SharedNamespaces() = %q", got)
+	}
+	if got := c.NamespacePeers("team"); !slices.Equal(got, []string{"alice", "desk"}) {
+		t.Errorf("NamespacePeers(team) = %q", got)
+	}
+	if got := c.NamespacePeers(PersonalNamespace); !slices.Equal(got, []string{"laptop", "desk"}) {
+		t.Errorf("NamespacePeers(personal) = %q", got)
+	}
+
+	if err := ValidateNamespaces([]string{"team", "ops.eu-1", "personal"}); err != nil {
+		t.Error(err)
+	}
+	for _, bad := range []string{"", "Team", "-team", "team runbook"} {
+		if err := ValidateNamespaces([]string{bad}); err == nil {
+			t.Errorf("ValidateNamespaces(%q) succeeded", bad)
+		}
+	}
+}
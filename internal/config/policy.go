@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+// Direction is which way history flows between this machine and a peer
+type Direction string
+
+const (
+	DirectionBoth    Direction = "both"    // send and receive, the default
+	DirectionSend    Direction = "send"    // only send history to the peer
+	DirectionReceive Direction = "receive" // only accept history from the peer
+)
+
+// Policy limits the history exchanged with a peer. The zero Policy shares
+// everything in both directions.
+type Policy struct {
+	Direction Direction `yaml:"direction,omitempty"`
+
+	// Origins are the machine IDs whose history is exchanged with the peer,
+	// both ways. Empty allows every machine.
+	Origins []string `yaml:"origins,omitempty"`
+
+	// RateLimit caps the entries per minute sent to and accepted from the
+	// peer. 0 is unlimited.
+	RateLimit int `yaml:"rate_limit,omitempty"`
+
+	// Tags limits the history pushed to the peer to entries of this machine
+	// tagged here with one of them. Each goes out when it is tagged. Empty
+	// pushes all history.
+	Tags []string `yaml:"tags,omitempty"`
+}
+
+// Validate checks the direction, rate limit and tags
+func (p Policy) Validate() error {
+	switch p.Direction {
+	case "", DirectionBoth, DirectionSend, DirectionReceive:
+	default:
+		return fmt.Errorf("unknown direction %q (supported: both, send, receive)", p.Direction)
+	}
+	if p.RateLimit < 0 {
+		return fmt.Errorf("rate limit must not be negative, got %d", p.RateLimit)
+	}
+	return parser.ValidateTags(p.Tags)
+}
+
+// CanSend reports whether history may be sent to the peer
+func (p Policy) CanSend() bool {
+	return p.Direction != DirectionReceive
+}
+
+// CanReceive reports whether history from the peer may be accepted
+func (p Policy) CanReceive() bool {
+	return p.Direction != DirectionSend
+}
+
+// AllowsOrigin reports whether history of the machine with machineID may be
+// exchanged with the peer
+func (p Policy) AllowsOrigin(machineID string) bool {
+	return len(p.Origins) == 0 || slices.Contains(p.Origins, machineID)
+}
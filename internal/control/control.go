@@ -0,0 +1,359 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
+)
+
+var log = logging.For(logging.Daemon)
+
+// socketName is the file name of the control socket inside its directory
+const socketName = "syncsh.sock"
+
+// JSON-RPC 2.0 error codes
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+)
+
+var (
+	ErrNotRunning     = errors.New("syncsh daemon is not running")
+	ErrAlreadyRunning = errors.New("syncsh daemon is already running")
+)
+
+// Request is a JSON-RPC 2.0 request. Each request is a single line.
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response. Exactly one of Result and Error is set.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is a JSON-RPC 2.0 error object
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// SocketPath returns where the daemon listens: $XDG_RUNTIME_DIR/syncsh, or a
+// per-user directory in /tmp when no runtime directory is set.
+func SocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return filepath.Join(os.TempDir(), "syncsh-"+strconv.Itoa(os.Getuid()), socketName)
+	}
+	return filepath.Join(dir, "syncsh", socketName)
+}
+
+// Listen creates the control socket at path. The socket is only accessible
+// to the current user. A socket left behind by a daemon that exited without
+// cleaning up is replaced; a live one is not.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create socket directory: %w", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, ErrAlreadyRunning
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("restrict control socket: %w", err)
+	}
+	return ln, nil
+}
+
+// maxFiles is how many files a request can pass to the daemon
+const maxFiles = 4
+
+type filesKey struct{}
+
+// Files returns the open files the client passed along with the request a
+// handler answers. They are closed once the handler returns.
+func Files(ctx context.Context) []*os.File {
+	files, _ := ctx.Value(filesKey{}).([]*os.File)
+	return files
+}
+
+// HandlerFunc answers one method call. The returned value is encoded as the
+// result; an *Error is returned as is, any other error as an internal error.
+type HandlerFunc func(ctx context.Context, params json.RawMessage) (any, error)
+
+// Server dispatches JSON-RPC requests read from control socket connections
+type Server struct {
+	handlers map[string]HandlerFunc
+}
+
+func NewServer() *Server {
+	return &Server{handlers: make(map[string]HandlerFunc)}
+}
+
+// Handle registers fn for method, replacing any previous handler
+func (s *Server) Handle(method string, fn HandlerFunc) {
+	s.handlers[method] = fn
+}
+
+// Serve accepts connections on ln until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("accept control connection: %w", err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveConn(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var r io.Reader = conn
+	rights := &rightsReader{}
+	if uc, ok := conn.(*net.UnixConn); ok {
+		rights.conn = uc
+		r = rights
+	}
+	defer rights.closeFiles()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		// Calls are serialized, so the files read so far came with this
+		// request
+		resp := s.dispatch(context.WithValue(ctx, filesKey{}, rights.files), scanner.Bytes())
+		rights.closeFiles()
+		if err := enc.Encode(resp); err != nil {
+			log.Debug("Failed to write control response.", "error", err)
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(ctx context.Context, line []byte) Response {
+	var req Request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return errorResponse(0, &Error{Code: CodeParseError, Message: err.Error()})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return errorResponse(req.ID, &Error{Code: CodeInvalidRequest, Message: "invalid request"})
+	}
+	handler, ok := s.handlers[req.Method]
+	if !ok {
+		return errorResponse(req.ID, &Error{Code: CodeMethodNotFound, Message: "unknown method " + req.Method})
+	}
+
+	result, err := handler(ctx, req.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: CodeInternalError, Message: err.Error()}
+		}
+		return errorResponse(req.ID, rpcErr)
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, &Error{Code: CodeInternalError, Message: err.Error()})
+	}
+	return Response{JSONRPC: "2.0", ID: req.ID, Result: raw}
+}
+
+func errorResponse(id int64, err *Error) Response {
+	return Response{JSONRPC: "2.0", ID: id, Error: err}
+}
+
+// rightsReader reads from a Unix socket and keeps the file descriptors
+// passed along with the data
+type rightsReader struct {
+	conn  *net.UnixConn
+	files []*os.File
+}
+
+func (r *rightsReader) Read(p []byte) (int, error) {
+	oob := make([]byte, syscall.CmsgSpace(maxFiles*4))
+	n, oobn, _, _, err := r.conn.ReadMsgUnix(p, oob)
+	if oobn > 0 {
+		msgs, parseErr := syscall.ParseSocketControlMessage(oob[:oobn])
+		if parseErr != nil {
+			return n, fmt.Errorf("parse passed files: %w", parseErr)
+		}
+		for _, msg := range msgs {
+			fds, err := syscall.ParseUnixRights(&msg)
+			if err != nil {
+				continue
+			}
+			for _, fd := range fds {
+				r.files = append(r.files, os.NewFile(uintptr(fd), "passed file"))
+			}
+		}
+	}
+	return n, err
+}
+
+func (r *rightsReader) closeFiles() {
+	for _, f := range r.files {
+		f.Close()
+	}
+	r.files = nil
+}
+
+// Client calls methods on a running daemon. Calls are serialized.
+type Client struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+	mu      sync.Mutex
+	nextID  atomic.Int64
+}
+
+// Dial connects to the daemon listening at path. ErrNotRunning is returned
+// when nothing listens there.
+func Dial(ctx context.Context, path string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, ErrNotRunning
+		}
+		return nil, fmt.Errorf("connect to daemon: %w", err)
+	}
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &Client{conn: conn, scanner: scanner}, nil
+}
+
+// Call invokes method with params and decodes the result into result, which
+// may be nil
+func (c *Client) Call(ctx context.Context, method string, params, result any) error {
+	return c.CallWithFiles(ctx, method, params, result)
+}
+
+// CallWithFiles is Call that also passes open files to the daemon. The
+// daemon can use them even where its sandbox would not let it open them.
+func (c *Client) CallWithFiles(ctx context.Context, method string, params, result any, files ...*os.File) error {
+	if len(files) > maxFiles {
+		return fmt.Errorf("send %s: at most %d files can be passed", method, maxFiles)
+	}
+	req := Request{JSONRPC: "2.0", ID: c.nextID.Add(1), Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("encode %s params: %w", method, err)
+		}
+		req.Params = raw
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	if err := c.send(req, files); err != nil {
+		return fmt.Errorf("send %s: %w", method, err)
+	}
+	if !c.scanner.Scan() {
+		if err := c.scanner.Err(); err != nil {
+			return fmt.Errorf("read %s response: %w", method, err)
+		}
+		return fmt.Errorf("read %s response: daemon closed the connection", method)
+	}
+
+	var resp Response
+	if err := json.Unmarshal(c.scanner.Bytes(), &resp); err != nil {
+		return fmt.Errorf("decode %s response: %w", method, err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("decode %s result: %w", method, err)
+	}
+	return nil
+}
+
+// send writes req as one line, with files passed along with its first bytes
+func (c *Client) send(req Request, files []*os.File) error {
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if len(files) > 0 {
+		uc, ok := c.conn.(*net.UnixConn)
+		if !ok {
+			return errors.New("files can only be passed over a Unix socket")
+		}
+		fds := make([]int, len(files))
+		for i, f := range files {
+			fds[i] = int(f.Fd())
+		}
+		n, _, err := uc.WriteMsgUnix(line, syscall.UnixRights(fds...), nil)
+		if err != nil {
+			return err
+		}
+		line = line[n:]
+	}
+	_, err = c.conn.Write(line)
+	return err
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
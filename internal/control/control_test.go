@@ -0,0 +1,152 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func startServer(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), socketName)
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer()
+	srv.Handle("echo", func(ctx context.Context, params json.RawMessage) (any, error) {
+		var s string
+		if err := json.Unmarshal(params, &s); err != nil {
+			return nil, &Error{Code: CodeInvalidParams, Message: err.Error()}
+		}
+		return s, nil
+	})
+	srv.Handle("write", func(ctx context.Context, params json.RawMessage) (any, error) {
+		var s string
+		if err := json.Unmarshal(params, &s); err != nil {
+			return nil, err
+		}
+		files := Files(ctx)
+		for _, f := range files {
+			if _, err := f.WriteString(s); err != nil {
+				return nil, err
+			}
+		}
+		return len(files), nil
+	})
+	srv.Handle("fail", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return nil, errors.New("boom")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve returned: %v", err)
+		}
+	})
+	return path
+}
+
+func TestCall(t *testing.T) {
+	path := startServer(t)
+	ctx := context.Background()
+
+	c, err := Dial(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var got string
+	if err := c.Call(ctx, "echo", "hello", &got); err != nil || got != "hello" {
+		t.Errorf("Expected hello, got %q (%v)", got, err)
+	}
+
+	var rpcErr *Error
+	if err := c.Call(ctx, "echo", 42, nil); !errors.As(err, &rpcErr) || rpcErr.Code != CodeInvalidParams {
+		t.Errorf("Expected invalid params, got: %v", err)
+	}
+	if err := c.Call(ctx, "fail", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != CodeInternalError || rpcErr.Message != "boom" {
+		t.Errorf("Expected an internal error, got: %v", err)
+	}
+	if err := c.Call(ctx, "missing", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != CodeMethodNotFound {
+		t.Errorf("Expected method not found, got: %v", err)
+	}
+}
+
+func TestCallWithFiles(t *testing.T) {
+	path := startServer(t)
+	ctx := context.Background()
+
+	c, err := Dial(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	name := filepath.Join(t.TempDir(), "passed")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var n int
+	if err := c.CallWithFiles(ctx, "write", "hello", &n, f); err != nil || n != 1 {
+		t.Fatalf("Expected the file to be passed, got %d (%v)", n, err)
+	}
+	if got, _ := os.ReadFile(name); string(got) != "hello" {
+		t.Errorf("Passed file holds %q, want hello", got)
+	}
+	// Files are only passed with the call they were sent with
+	if err := c.Call(ctx, "write", "again", &n); err != nil || n != 0 {
+		t.Errorf("Expected no files, got %d (%v)", n, err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	path := startServer(t)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected the socket to be private, got %v", perm)
+	}
+	if _, err := Listen(path); !errors.Is(err, ErrAlreadyRunning) {
+		t.Errorf("Expected ErrAlreadyRunning, got: %v", err)
+	}
+}
+
+func TestListenReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), socketName)
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Expected the stale socket to be replaced, got: %v", err)
+	}
+	ln.Close()
+}
+
+func TestDialNotRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), socketName)
+	if _, err := Dial(context.Background(), path); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Expected ErrNotRunning, got: %v", err)
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if got := SocketPath(); got != "/run/user/1000/syncsh/syncsh.sock" {
+		t.Errorf("Unexpected socket path %s", got)
+	}
+}
@@ -0,0 +1,163 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/metrics"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/syncer"
+)
+
+const (
+	// minRebuildDelay and maxRebuildDelay bound the backoff between
+	// rebuilds of a peer's tunnel
+	minRebuildDelay = 2 * time.Second
+	maxRebuildDelay = 5 * time.Minute
+	// deadTimeout is how long a tunnel that had connected may stay
+	// disconnected before it is rebuilt. It leaves the endpoint loops time to
+	// move the tunnel to another candidate first.
+	deadTimeout = time.Minute
+	// maxPeerEvents is how many state transitions each peer keeps
+	maxPeerEvents = 20
+)
+
+var errTunnelDead = errors.New("no WireGuard handshake for too long")
+
+// ConnState is where the connection to a peer stands
+type ConnState string
+
+const (
+	ConnConnecting ConnState = "connecting" // the tunnel is up, waiting for a session
+	ConnConnected  ConnState = "connected"  // a sync session is running
+	ConnBackoff    ConnState = "backoff"    // the tunnel is down until the next rebuild
+	ConnStopped    ConnState = "stopped"
+)
+
+// PeerEvent is a transition of a peer's connection state
+type PeerEvent struct {
+	Peer   string    `json:"peer"`
+	From   ConnState `json:"from"`
+	To     ConnState `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// backoff is an exponential delay with jitter, so peers that lost their
+// tunnels at the same moment do not rebuild them in lockstep
+type backoff struct {
+	min, max time.Duration
+	next     time.Duration
+}
+
+// delay returns how long to wait before the next attempt: between half and
+// all of the current step, which doubles up to max
+func (b *backoff) delay() time.Duration {
+	d := max(b.next, b.min)
+	b.next = min(2*d, b.max)
+	return d/2 + rand.N(d/2+1)
+}
+
+func (b *backoff) reset() {
+	b.next = 0
+}
+
+// superviseConnection keeps a tunnel to peer up and a sync session running
+// over it. A tunnel that fails to open, or whose handshakes stop after it
+// had connected, is torn down and rebuilt after a jittered exponential
+// backoff. The new session resumes from the peer's last acknowledgement. It
+// only returns when ctx is cancelled.
+func (d *Daemon) superviseConnection(ctx context.Context, srv *syncer.Server, ks keys.KeyStore, peer *config.Peer, state *peerState) error {
+	b := backoff{min: minRebuildDelay, max: maxRebuildDelay}
+	for {
+		err := d.runPeer(ctx, srv, ks, peer, state, b.reset)
+		if ctx.Err() != nil {
+			state.setState(ConnStopped, nil)
+			return nil
+		}
+		delay := b.delay()
+		state.setState(ConnBackoff, err)
+		state.update(func(s *PeerStatus) { s.Rebuilds++ })
+		syncLog.Warn("Rebuilding tunnel.", "peer", peer.Name, "error", err, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			state.setState(ConnStopped, nil)
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// handshakeWatch tells a dead tunnel from one that has not connected yet
+type handshakeWatch struct {
+	connected bool      // the tunnel has had a handshake
+	lostAt    time.Time // when it stopped being connected
+}
+
+// dead reports whether the tunnel had connected and has not been connected
+// for deadTimeout, going by network.SimplePeerStatus.IsConnected
+func (w *handshakeWatch) dead(status network.SimplePeerStatus, now time.Time) bool {
+	switch {
+	case status.IsConnected():
+		w.connected, w.lostAt = true, time.Time{}
+	case !w.connected:
+	case w.lostAt.IsZero():
+		w.lostAt = now
+	default:
+		return now.Sub(w.lostAt) >= deadTimeout
+	}
+	return false
+}
+
+// watchHandshakes returns errTunnelDead once the tunnel is dead. It returns
+// nil when ctx is cancelled.
+func watchHandshakes(ctx context.Context, t network.Transport) error {
+	ticker := time.NewTicker(firstHandshakeTimeout / 3)
+	defer ticker.Stop()
+	var w handshakeWatch
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		status, err := network.TransportPeerStatus(t)
+		if err != nil {
+			return err
+		}
+		if w.dead(status, time.Now()) {
+			return errTunnelDead
+		}
+	}
+}
+
+// setState records a transition of the connection state. Transitions to
+// the current state are ignored.
+func (p *peerState) setState(to ConnState, reason error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	from := p.status.State
+	if from == to {
+		return
+	}
+	ev := PeerEvent{Peer: p.status.Name, From: from, To: to, At: time.Now()}
+	if reason != nil {
+		ev.Reason = reason.Error()
+		p.status.LastError = ev.Reason
+	}
+	p.status.State, p.status.StateSince = to, ev.At
+	p.status.Connected = to == ConnConnected
+	if to == ConnConnected {
+		p.status.LastError = ""
+	}
+	p.events = append(p.events, ev)
+	if len(p.events) > maxPeerEvents {
+		p.events = p.events[len(p.events)-maxPeerEvents:]
+	}
+	metrics.PeerTransitions.WithLabelValues(p.status.Name, string(to)).Inc()
+}
@@ -0,0 +1,73 @@
+package daemon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+)
+
+func TestBackoff(t *testing.T) {
+	b := backoff{min: time.Second, max: 8 * time.Second}
+	for _, step := range []time.Duration{1, 2, 4, 8, 8} {
+		step *= time.Second
+		if d := b.delay(); d < step/2 || d > step {
+			t.Errorf("delay() = %v, want between %v and %v", d, step/2, step)
+		}
+	}
+	b.reset()
+	if d := b.delay(); d > time.Second {
+		t.Errorf("delay() after reset = %v", d)
+	}
+}
+
+func TestHandshakeWatch(t *testing.T) {
+	start := time.Now()
+	up := network.SimplePeerStatus{LastHandshake: start}
+	down := network.SimplePeerStatus{LastHandshake: start.Add(-time.Hour)}
+
+	var w handshakeWatch
+	// A tunnel that never connected is not dead, the peer may be offline
+	if w.dead(network.SimplePeerStatus{}, start) || w.dead(down, start.Add(time.Hour)) {
+		t.Fatal("Tunnel that never connected is dead")
+	}
+	if w.dead(up, start) || w.dead(down, start) || w.dead(down, start.Add(deadTimeout-time.Second)) {
+		t.Fatal("Tunnel is dead before deadTimeout")
+	}
+	if !w.dead(down, start.Add(deadTimeout)) {
+		t.Error("Tunnel without handshakes for deadTimeout is not dead")
+	}
+	// A handshake in between starts the timeout over
+	w.dead(up, start)
+	if w.dead(down, start.Add(deadTimeout)) {
+		t.Error("Tunnel is dead right after losing its handshake")
+	}
+}
+
+func TestPeerStateEvents(t *testing.T) {
+	p := newPeerState(&config.Peer{Name: "server"})
+	p.setState(ConnConnecting, nil) // already connecting
+	p.setState(ConnConnected, nil)
+	p.setState(ConnBackoff, errTunnelDead)
+
+	s := p.snapshot()
+	if s.State != ConnBackoff || s.Connected || s.LastError != errTunnelDead.Error() {
+		t.Errorf("Status = %+v", s)
+	}
+	if len(s.Events) != 2 || s.Events[0].To != ConnConnected || s.Events[1].From != ConnConnected || s.Events[1].Reason != errTunnelDead.Error() {
+		t.Errorf("Events = %+v", s.Events)
+	}
+
+	for i := range 2 * maxPeerEvents {
+		if i%2 == 0 {
+			p.setState(ConnConnected, nil)
+		} else {
+			p.setState(ConnConnecting, errors.New("session closed"))
+		}
+	}
+	if n := len(p.snapshot().Events); n != maxPeerEvents {
+		t.Errorf("Kept %d events, want %d", n, maxPeerEvents)
+	}
+}
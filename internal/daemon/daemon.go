@@ -0,0 +1,193 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/control"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/metrics"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/TheRealSibasishBehera/syncsh/internal/syncer"
+)
+
+var (
+	log      = logging.For(logging.Daemon)
+	syncLog  = logging.For(logging.Sync)
+	watchLog = logging.For(logging.Watcher)
+)
+
+// Supervisor names of the services that are not per peer
+const (
+	watcherService   = "watcher"
+	metricsService   = "metrics"
+	discoveryService = "discovery"
+)
+
+// Loader reads the configuration and opens its key store. It is called at
+// start and on every reload.
+type Loader func() (*config.Config, keys.KeyStore, error)
+
+// Daemon watches the shell history, ingests new commands and keeps every
+// peer in sync. All of its loops run under one supervisor and are controlled
+// through a JSON-RPC API on the control socket.
+type Daemon struct {
+	load    Loader
+	store   *store.Store
+	sup     *Supervisor
+	started time.Time
+
+	// ctx is the lifetime of the daemon, services run under it
+	ctx context.Context
+
+	// reloadMu serializes reloads
+	reloadMu sync.Mutex
+
+	// ingestMu keeps the watcher from reading the history file while
+	// forget rewrites it
+	ingestMu sync.Mutex
+
+	mu         sync.Mutex
+	cfg        *config.Config
+	signingKey keys.Key
+	server     *syncer.Server
+	paused     bool
+	peers      map[string]*peerState
+	watcher    WatcherStatus
+}
+
+func New(load Loader, st *store.Store) *Daemon {
+	return &Daemon{
+		load:  load,
+		store: st,
+		sup:   NewSupervisor(),
+		peers: make(map[string]*peerState),
+	}
+}
+
+// Run starts every service and serves the control API on ln until ctx is
+// cancelled. Services are stopped before Run returns.
+func (d *Daemon) Run(ctx context.Context, ln net.Listener) error {
+	d.ctx = ctx
+	d.started = time.Now()
+	if err := d.Reload(); err != nil {
+		return err
+	}
+	defer d.sup.StopAll()
+
+	metrics.RegisterPeers(d.tunnelStatus)
+	defer metrics.RegisterPeers(nil)
+
+	rpc := control.NewServer()
+	d.register(rpc)
+	log.Info("Daemon started.", "socket", ln.Addr().String())
+	return rpc.Serve(ctx, ln)
+}
+
+// Reload reads the configuration again and restarts the watcher and the peer
+// loops with it
+func (d *Daemon) Reload() error {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	cfg, ks, err := d.load()
+	if err != nil {
+		return err
+	}
+	signingKey, err := machine.SigningKey(cfg, ks)
+	if err != nil {
+		return err
+	}
+
+	d.sup.StopAll()
+
+	srv := syncer.NewServer(cfg, ks, d.store)
+	peers := make(map[string]*peerState, len(cfg.Peers))
+	for i := range cfg.Peers {
+		peer := &cfg.Peers[i]
+		peers[peer.Name] = newPeerState(peer)
+	}
+	d.mu.Lock()
+	d.cfg, d.signingKey, d.server, d.peers = cfg, signingKey, srv, peers
+	d.mu.Unlock()
+
+	d.sup.Start(d.ctx, watcherService, func(ctx context.Context) error {
+		return d.watchHistory(ctx, cfg, signingKey)
+	})
+	if cfg.MetricsListen != "" {
+		d.sup.Start(d.ctx, metricsService, func(ctx context.Context) error {
+			return metrics.Serve(ctx, cfg.MetricsListen)
+		})
+	}
+	if !cfg.NoDiscovery {
+		d.sup.Start(d.ctx, discoveryService, func(ctx context.Context) error {
+			return d.discover(ctx, cfg)
+		})
+	}
+	for i := range cfg.Peers {
+		peer := &cfg.Peers[i]
+		state := peers[peer.Name]
+		d.sup.Start(d.ctx, "peer/"+peer.Name, func(ctx context.Context) error {
+			return d.superviseConnection(ctx, srv, ks, peer, state)
+		})
+	}
+	return nil
+}
+
+// SetPaused stops or resumes synchronization. History is still ingested
+// while paused.
+func (d *Daemon) SetPaused(paused bool) {
+	d.mu.Lock()
+	d.paused = paused
+	d.mu.Unlock()
+	if !paused {
+		d.SyncNow()
+	}
+}
+
+func (d *Daemon) isPaused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// SyncNow asks every connected peer loop to push pending history. It returns
+// the number of peers asked.
+func (d *Daemon) SyncNow() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, p := range d.peers {
+		if p.trigger() {
+			n++
+		}
+	}
+	return n
+}
+
+// tunnelStatus returns the tunnel state of every running peer loop
+func (d *Daemon) tunnelStatus() map[string]network.SimplePeerStatus {
+	d.mu.Lock()
+	states := d.peers
+	d.mu.Unlock()
+
+	statuses := make(map[string]network.SimplePeerStatus, len(states))
+	for name, p := range states {
+		if s := p.snapshot(); s.Tunnel != nil {
+			statuses[name] = *s.Tunnel
+		}
+	}
+	return statuses
+}
+
+func (d *Daemon) setWatcher(fn func(w *WatcherStatus)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(&d.watcher)
+}
@@ -0,0 +1,322 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/control"
+	"github.com/TheRealSibasishBehera/syncsh/internal/discovery"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network/tunnel"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+type testDaemon struct {
+	cfg    *config.Config
+	ks     keys.KeyStore
+	st     *store.Store
+	socket string
+}
+
+func newTestDaemon(t *testing.T, name string) *testDaemon {
+	priv, pub, err := network.NewMachineKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	ks := &keys.FileStore{Dir: filepath.Join(dir, "keys")}
+	if err := ks.Put("wireguard", priv); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		Shell:         config.ShellZsh,
+		MachineID:     name,
+		PrivateKeyRef: "wireguard",
+		PublicKey:     pub,
+		HistoryPath:   filepath.Join(dir, "zsh_history"),
+		NoDiscovery:   true,
+		Transport:     network.TransportNetstack,
+		MeshPrefix:    "fd12:3456:789a::/48",
+	}
+	cfg.SetPath(filepath.Join(dir, "config.yaml"))
+	if _, err := machine.EnsureSigningKey(cfg, ks); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := store.Open(context.Background(), filepath.Join(dir, "syncsh.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	return &testDaemon{cfg: cfg, ks: ks, st: st, socket: filepath.Join(dir, "syncsh.sock")}
+}
+
+// start runs the daemon until the test ends
+func (td *testDaemon) start(t *testing.T) {
+	if err := td.cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	load := func() (*config.Config, keys.KeyStore, error) {
+		cfg, err := config.NewFromFile(td.cfg.Path())
+		return cfg, td.ks, err
+	}
+	ln, err := control.Listen(td.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- New(load, td.st).Run(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Daemon exited with: %v", err)
+		}
+	})
+}
+
+func (td *testDaemon) call(t *testing.T, method string, result any) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := control.Dial(ctx, td.socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Call(ctx, method, nil, result); err != nil {
+		t.Fatalf("%s failed: %v", method, err)
+	}
+}
+
+func (td *testDaemon) appendHistory(t *testing.T, lines ...string) {
+	f, err := os.OpenFile(td.cfg.HistoryPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		fmt.Fprintln(f, line)
+	}
+}
+
+// freeUDPPort returns a UDP port that was free a moment ago
+func freeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func eventually(t *testing.T, what string, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func TestDaemonIngestsHistory(t *testing.T) {
+	td := newTestDaemon(t, "laptop")
+	td.appendHistory(t, ": 1700000000:0;make", "  private")
+	td.start(t)
+
+	eventually(t, "existing history to be ingested", func() bool {
+		var s Status
+		td.call(t, MethodStatus, &s)
+		return s.Entries == 1
+	})
+
+	td.appendHistory(t, ": 1700000005:0;git status")
+	eventually(t, "new history to be ingested", func() bool {
+		var s Status
+		td.call(t, MethodStatus, &s)
+		return s.Entries == 2 && s.Watcher.Ingested == 2
+	})
+
+	var report struct{ Checked, Valid int }
+	td.call(t, MethodVerify, &report)
+	if report.Checked != 2 || report.Valid != 2 {
+		t.Errorf("Expected every ingested entry to be signed, got %+v", report)
+	}
+
+	var paused PauseResult
+	td.call(t, MethodPause, &paused)
+	var s Status
+	td.call(t, MethodStatus, &s)
+	if !paused.Paused || !s.Paused {
+		t.Errorf("Expected the daemon to be paused, got %+v", s)
+	}
+}
+
+func TestDaemonSyncsPeers(t *testing.T) {
+	a := newTestDaemon(t, "a")
+	b := newTestDaemon(t, "b")
+	port := freeUDPPort(t)
+	a.cfg.Peers = []config.Peer{{Name: "b", PublicKey: b.cfg.PublicKey, Endpoint: fmt.Sprintf("127.0.0.1:%d", port), KnownPublicKey: a.cfg.PublicKey}}
+	b.cfg.Peers = []config.Peer{{Name: "a", PublicKey: a.cfg.PublicKey, ListenPort: port, KnownPublicKey: b.cfg.PublicKey}}
+
+	a.appendHistory(t, ": 1700000000:0;make test")
+	b.start(t)
+	a.start(t)
+
+	eventually(t, "a's history to reach b", func() bool {
+		entries, err := b.st.ListEntries(context.Background(), "a", 0, 0)
+		return err == nil && len(entries) == 1 && entries[0].Command == "make test"
+	})
+
+	b.appendHistory(t, ": 1700000010:0;uptime")
+	eventually(t, "b's history to reach a", func() bool {
+		entries, err := a.st.ListEntries(context.Background(), "b", 0, 0)
+		return err == nil && len(entries) == 1
+	})
+
+	var peers []PeerStatus
+	eventually(t, "b to acknowledge a's history", func() bool {
+		a.call(t, MethodPeers, &peers)
+		return len(peers) == 1 && peers[0].Pending == 0
+	})
+	p := peers[0]
+	if !p.Connected || p.MachineID != "b" || p.SyncedThrough != 1700000000 || p.LastSync.IsZero() {
+		t.Errorf("Unexpected peer status: %+v", p)
+	}
+	if p.Tunnel == nil || p.Tunnel.LastHandshake.IsZero() || p.Tunnel.BytesReceived == 0 {
+		t.Errorf("Expected tunnel counters, got %+v", p.Tunnel)
+	}
+}
+
+func TestOffline(t *testing.T) {
+	td := newTestDaemon(t, "laptop")
+	td.cfg.Peers = []config.Peer{{Name: "server", MachineID: "server"}, {Name: "new"}}
+	ctx := context.Background()
+
+	var synced parser.HistoryEntry
+	for _, ts := range []int64{100, 200} {
+		entry := parser.HistoryEntry{Timestamp: ts, MachineID: "laptop", Command: "ls"}
+		if err := td.st.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+		if ts == 100 {
+			synced = entry
+		}
+	}
+	if err := td.st.UpdateSyncCursor(ctx, "server", store.EntryCursor(&synced)); err != nil {
+		t.Fatal(err)
+	}
+	td.appendHistory(t, ": 1700000000:0;make")
+
+	s, err := Offline(ctx, td.st, td.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Running || s.Entries != 2 || s.StoreBytes == 0 || s.Watcher.Lag == 0 {
+		t.Errorf("Unexpected status: %+v", s)
+	}
+	if len(s.Peers) != 2 || s.Peers[0].Pending != 1 || s.Peers[0].LastSync.IsZero() || s.Peers[1].Pending != 2 {
+		t.Errorf("Unexpected peers: %+v", s.Peers)
+	}
+}
+
+func TestPeerDiscovered(t *testing.T) {
+	td := newTestDaemon(t, "laptop")
+	serverKey, _ := keys.GenerateKey()
+	serverPub, _ := serverKey.PublicKey()
+	retired, _ := keys.GenerateKey()
+	td.cfg.Peers = []config.Peer{{Name: "server", PublicKey: serverPub}}
+	td.cfg.PreviousKeys = []config.RetiredKey{{PublicKey: retired, ExpiresAt: time.Now().Add(time.Hour)}}
+
+	d := New(nil, td.st)
+	state := newPeerState(&td.cfg.Peers[0])
+	d.cfg, d.peers = td.cfg, map[string]*peerState{"server": state}
+
+	lan := netip.MustParseAddr("192.168.1.20")
+	announce := func(ports map[string]uint16) {
+		d.peerDiscovered(td.cfg, discovery.Announcement{PublicKey: serverPub, Ports: ports, Addrs: []netip.Addr{lan}})
+	}
+
+	// The server does not run a tunnel to us yet
+	announce(map[string]uint16{})
+	select {
+	case ev := <-state.endpoints:
+		t.Fatalf("Unexpected endpoint %v", ev.Endpoint)
+	default:
+	}
+
+	// It still knows us by our key from before a rotation
+	announce(map[string]uint16{discovery.Fingerprint(retired): 51900})
+	select {
+	case ev := <-state.endpoints:
+		if want := netip.AddrPortFrom(lan, 51900); ev.Endpoint != want || ev.PublicKey != serverPub {
+			t.Errorf("Endpoint = %v for %v, want %v", ev.Endpoint, ev.PublicKey, want)
+		}
+	default:
+		t.Fatal("No endpoint was handed to the peer loop")
+	}
+}
+
+func TestEndpointWalker(t *testing.T) {
+	home := machine.Candidate{Endpoint: "home.example.com:51820", Addr: netip.MustParseAddrPort("198.51.100.1:51820")}
+	office := machine.Candidate{Endpoint: "192.0.2.1:51820", Addr: netip.MustParseAddrPort("192.0.2.1:51820")}
+	candidates := []machine.Candidate{home, office}
+	resolves := 0
+	resolve := func() []machine.Candidate {
+		resolves++
+		return candidates
+	}
+	const relayEndpoint = "relay:peer"
+
+	now := time.Now()
+	w := newEndpointWalker(resolve, relayEndpoint, home.Addr.String(), now)
+	stats := tunnel.PeerStats{Endpoint: home.Addr.String()}
+	step := func(after time.Duration) (string, bool) {
+		now = now.Add(after)
+		endpoint, ok := w.step(stats, now)
+		if ok {
+			stats.Endpoint = endpoint
+		}
+		return endpoint, ok
+	}
+
+	if _, ok := step(firstHandshakeTimeout / 2); ok {
+		t.Error("Moved before the first handshake timed out")
+	}
+	// No handshake over home: office, then the relay, then home again
+	for _, want := range []string{office.Addr.String(), relayEndpoint, home.Addr.String()} {
+		if got, ok := step(firstHandshakeTimeout); !ok || got != want {
+			t.Fatalf("step() = %q, %v, want %q", got, ok, want)
+		}
+	}
+	if resolves != 2 {
+		t.Errorf("Resolved %d times, want again after a pass over the candidates", resolves)
+	}
+
+	// Handshakes over home succeed until its hostname moves
+	stats.LastHandshake = now.Add(time.Second)
+	if _, ok := step(time.Minute); ok {
+		t.Error("Moved away from a working endpoint")
+	}
+	moved := machine.Candidate{Endpoint: home.Endpoint, Addr: netip.MustParseAddrPort("198.51.100.2:51820")}
+	candidates = []machine.Candidate{moved, office}
+	stats.LastHandshake = now.Add(resolveInterval)
+	if got, ok := step(resolveInterval); !ok || got != moved.Addr.String() {
+		t.Errorf("step() = %q, %v, want the new address of the hostname", got, ok)
+	}
+}
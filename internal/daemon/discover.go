@@ -0,0 +1,92 @@
+package daemon
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/discovery"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+)
+
+// discover advertises this machine on the LAN and hands the LAN addresses
+// of known peers to their loops
+func (d *Daemon) discover(ctx context.Context, cfg *config.Config) error {
+	conn, err := discovery.Listen()
+	if err != nil {
+		return err
+	}
+	node := discovery.NewNode(conn, discovery.Group, d.announcement, func(a discovery.Announcement) {
+		d.peerDiscovered(cfg, a)
+	})
+	return node.Run(ctx)
+}
+
+// announcement is what this machine advertises: its key and the WireGuard
+// port of every running tunnel
+func (d *Daemon) announcement() (discovery.Announcement, bool) {
+	d.mu.Lock()
+	cfg, states := d.cfg, d.peers
+	d.mu.Unlock()
+
+	a := discovery.Announcement{
+		PublicKey: cfg.PublicKey,
+		MachineID: cfg.MachineID,
+		Ports:     make(map[string]uint16),
+		Addrs:     discovery.LocalAddrs(),
+	}
+	for i := range cfg.Peers {
+		peer := &cfg.Peers[i]
+		state, ok := states[peer.Name]
+		if !ok {
+			continue
+		}
+		if port, ok := state.listenPort(); ok {
+			a.Ports[discovery.Fingerprint(peer.PublicKey)] = port
+		}
+	}
+	return a, true
+}
+
+// peerDiscovered passes the LAN endpoint of a known peer to its loop. The
+// endpoint is the announcement's source address and the port the peer uses
+// for its tunnel to us.
+func (d *Daemon) peerDiscovered(cfg *config.Config, a discovery.Announcement) {
+	if a.PublicKey == cfg.PublicKey || len(a.Addrs) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range cfg.Peers {
+		peer := &cfg.Peers[i]
+		if peer.PublicKey != a.PublicKey {
+			continue
+		}
+		port, ok := portFor(cfg, a)
+		state, running := d.peers[peer.Name]
+		if !ok || !running {
+			return
+		}
+		state.discovered(network.EndpointChangeEvent{
+			PublicKey: peer.PublicKey,
+			Endpoint:  netip.AddrPortFrom(a.Addrs[0], port),
+		})
+		return
+	}
+}
+
+// portFor returns the port the announcing peer uses for us, which it knows
+// by our current key or, right after a rotation, by a previous one
+func portFor(cfg *config.Config, a discovery.Announcement) (uint16, bool) {
+	candidates := []keys.Key{cfg.PublicKey}
+	for _, k := range cfg.PreviousKeys {
+		candidates = append(candidates, k.PublicKey)
+	}
+	for _, key := range candidates {
+		if port, ok := a.PortFor(key); ok {
+			return port, true
+		}
+	}
+	return 0, false
+}
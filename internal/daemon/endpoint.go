@@ -0,0 +1,147 @@
+package daemon
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network/tunnel"
+	"github.com/TheRealSibasishBehera/syncsh/internal/relay"
+)
+
+// resolveInterval is how often hostname endpoints are resolved again to
+// follow dynamic DNS
+const resolveInterval = 5 * time.Minute
+
+// endpointWalker picks the endpoint a tunnel should move to. It tries the
+// peer's candidates in order whenever handshakes fail, then the relay if
+// there is one, and resolves the candidates again before starting over.
+type endpointWalker struct {
+	resolve func() []machine.Candidate
+	relay   string // the peer's relay endpoint, empty without a relay
+
+	candidates []machine.Candidate
+	next       int       // candidate to try on the next failure
+	since      time.Time // when the walker last moved the tunnel
+	resolvedAt time.Time
+}
+
+func newEndpointWalker(resolve func() []machine.Candidate, relayEndpoint, current string, now time.Time) *endpointWalker {
+	w := &endpointWalker{resolve: resolve, relay: relayEndpoint, since: now}
+	w.refresh(now)
+	if i := w.index(current); i >= 0 {
+		w.next = i + 1
+	}
+	return w
+}
+
+func (w *endpointWalker) refresh(now time.Time) {
+	w.candidates = w.resolve()
+	w.resolvedAt = now
+}
+
+// index returns the position of endpoint among the candidates, or -1
+func (w *endpointWalker) index(endpoint string) int {
+	return slices.IndexFunc(w.candidates, func(c machine.Candidate) bool {
+		return c.Addr.String() == endpoint
+	})
+}
+
+// failed reports whether handshakes over the current endpoint have stopped.
+// After the walker moves the tunnel a handshake must follow within
+// firstHandshakeTimeout; endpoints WireGuard or the other loops moved to keep
+// the last handshake and get handshakeTimeout.
+func (w *endpointWalker) failed(stats tunnel.PeerStats, now time.Time) bool {
+	if stats.LastHandshake.Before(w.since) {
+		return now.Sub(w.since) >= firstHandshakeTimeout
+	}
+	return now.Sub(stats.LastHandshake) >= handshakeTimeout
+}
+
+// step returns the endpoint to move the tunnel to, if any
+func (w *endpointWalker) step(stats tunnel.PeerStats, now time.Time) (string, bool) {
+	if now.Sub(w.resolvedAt) >= resolveInterval {
+		// Follow a hostname the tunnel is on to its new address
+		var current machine.Candidate
+		if i := w.index(stats.Endpoint); i >= 0 {
+			current = w.candidates[i]
+		}
+		w.refresh(now)
+		if current.Endpoint != "" && w.index(stats.Endpoint) < 0 {
+			if i := slices.IndexFunc(w.candidates, func(c machine.Candidate) bool { return c.Endpoint == current.Endpoint }); i >= 0 {
+				return w.move(i, now)
+			}
+		}
+	}
+	if !w.failed(stats, now) {
+		return "", false
+	}
+
+	if w.next >= len(w.candidates) {
+		if w.relay != "" && stats.Endpoint != w.relay {
+			w.since = now
+			return w.relay, true
+		}
+		w.refresh(now)
+		w.next = 0
+		if len(w.candidates) == 0 {
+			w.since = now
+			return "", false
+		}
+	}
+	return w.move(w.next, now)
+}
+
+func (w *endpointWalker) move(i int, now time.Time) (string, bool) {
+	w.next = i + 1
+	w.since = now
+	return w.candidates[i].Addr.String(), true
+}
+
+// superviseEndpoint keeps the tunnel on an endpoint where handshakes
+// succeed. Hostnames are resolved again periodically and after every pass
+// over the candidates, so peers on dynamic DNS are followed. While the
+// tunnel is relayed, the bind keeps punching for a direct path.
+func superviseEndpoint(ctx context.Context, t network.Transport, peer *config.Peer, relayed bool) {
+	stats, err := t.Stats()
+	if err != nil {
+		return
+	}
+	resolve := func() []machine.Candidate {
+		candidates, err := machine.ResolveCandidates(ctx, peer)
+		if err != nil {
+			syncLog.Warn("Failed to resolve peer endpoints.", "peer", peer.Name, "error", err)
+		}
+		return candidates
+	}
+	var relayEndpoint string
+	if relayed {
+		relayEndpoint = relay.Endpoint(peer.PublicKey)
+	}
+	w := newEndpointWalker(resolve, relayEndpoint, stats.Endpoint, time.Now())
+
+	ticker := time.NewTicker(firstHandshakeTimeout / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stats, err := t.Stats()
+		if err != nil {
+			return
+		}
+		endpoint, ok := w.step(stats, time.Now())
+		if !ok || endpoint == stats.Endpoint {
+			continue
+		}
+		syncLog.Info("Moving to another endpoint.", "peer", peer.Name, "from", stats.Endpoint, "to", endpoint)
+		if err := t.SetEndpoint(endpoint); err != nil {
+			syncLog.Warn("Failed to change endpoint.", "peer", peer.Name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/ingest"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+// lastLookback is how many recent entries "last" looks through for a
+// command that is not syncsh itself
+const lastLookback = 50
+
+// Entry is a history entry with its local ID, which its JSON leaves out
+type Entry struct {
+	ID int64 `json:"id"`
+	parser.HistoryEntry
+}
+
+// ShareParams are the parameters of share
+type ShareParams struct {
+	Ref       string `json:"ref"`       // entry to share, as ResolveEntry takes it
+	Namespace string `json:"namespace"` // shared namespace to publish it to
+}
+
+// ShareResult is the result of share
+type ShareResult struct {
+	Command string `json:"command"`
+	Peers   int    `json:"peers"` // connected peers asked to push
+}
+
+// Share publishes an entry to a namespace
+func (d *Daemon) Share(ctx context.Context, params ShareParams) (ShareResult, error) {
+	machineID, signingKey := d.identity()
+	res, err := Share(ctx, d.store, machineID, signingKey, params)
+	if err != nil {
+		return res, err
+	}
+	res.Peers = d.SyncNow()
+	return res, nil
+}
+
+// identity returns the machine ID and signing key of the running
+// configuration
+func (d *Daemon) identity() (string, keys.Key) {
+	d.mu.Lock()
+	srv, signingKey := d.server, d.signingKey
+	d.mu.Unlock()
+	var machineID string
+	srv.View(func(cfg *config.Config) {
+		machineID = cfg.MachineID
+	})
+	return machineID, signingKey
+}
+
+// Share copies the entry params.Ref refers to into params.Namespace, signed
+// as machineID
+func Share(ctx context.Context, st *store.Store, machineID string, signingKey keys.Key, params ShareParams) (ShareResult, error) {
+	entry, err := ResolveEntry(ctx, st, machineID, params.Ref)
+	if err != nil {
+		return ShareResult{}, err
+	}
+	// The copy is stamped with the time it is shared, so peers that
+	// acknowledged older history still get it.
+	shared := parser.HistoryEntry{
+		Timestamp: time.Now().Unix(),
+		Command:   entry.Command,
+		Duration:  entry.Duration,
+		ExitCode:  entry.ExitCode,
+		Namespace: params.Namespace,
+	}
+	if _, err := ingest.New(st, machineID, signingKey).Ingest(ctx, []parser.HistoryEntry{shared}); err != nil {
+		return ShareResult{}, fmt.Errorf("failed to share command: %w", err)
+	}
+	return ShareResult{Command: entry.Command}, nil
+}
+
+// AnnotateParams are the parameters of annotate. Tags are added, or removed
+// with Remove; a non-nil Note replaces the note, an empty one removes it.
+type AnnotateParams struct {
+	Ref    string   `json:"ref"` // entry to annotate, as ResolveEntry takes it
+	Tags   []string `json:"tags,omitempty"`
+	Remove bool     `json:"remove,omitempty"`
+	Note   *string  `json:"note,omitempty"`
+}
+
+// AnnotateResult is the result of annotate
+type AnnotateResult struct {
+	Command    string            `json:"command"`
+	Annotation parser.Annotation `json:"annotation"` // this machine's annotation after the change
+	Peers      int               `json:"peers"`      // connected peers asked to push
+}
+
+// Annotate changes this machine's annotation of an entry
+func (d *Daemon) Annotate(ctx context.Context, params AnnotateParams) (AnnotateResult, error) {
+	machineID, signingKey := d.identity()
+	res, err := Annotate(ctx, d.store, machineID, signingKey, params)
+	if err != nil {
+		return res, err
+	}
+	res.Peers = d.SyncNow()
+	return res, nil
+}
+
+// Annotate applies params to machineID's annotation of the entry params.Ref
+// refers to, then signs and stores it
+func Annotate(ctx context.Context, st *store.Store, machineID string, signingKey keys.Key, params AnnotateParams) (AnnotateResult, error) {
+	if err := parser.ValidateTags(params.Tags); err != nil {
+		return AnnotateResult{}, err
+	}
+	entry, err := ResolveEntry(ctx, st, machineID, params.Ref)
+	if err != nil {
+		return AnnotateResult{}, err
+	}
+	a, err := st.GetAnnotation(ctx, entry.Hash, machineID)
+	if errors.Is(err, store.ErrAnnotationNotFound) {
+		a, err = parser.Annotation{Hash: entry.Hash, MachineID: machineID}, nil
+	}
+	if err != nil {
+		return AnnotateResult{}, err
+	}
+
+	if params.Remove {
+		a.Tags = slices.DeleteFunc(a.Tags, func(t string) bool { return slices.Contains(params.Tags, t) })
+	} else {
+		for _, tag := range params.Tags {
+			if !slices.Contains(a.Tags, tag) {
+				a.Tags = append(a.Tags, tag)
+			}
+		}
+		slices.Sort(a.Tags)
+	}
+	if params.Note != nil {
+		a.Note = *params.Note
+	}
+	// Every change must be newer than the last, or peers would keep it
+	a.UpdatedAt = max(time.Now().Unix(), a.UpdatedAt+1)
+	provenance.SignAnnotation(&a, signingKey)
+	if _, err := st.PutAnnotation(ctx, a); err != nil {
+		return AnnotateResult{}, fmt.Errorf("failed to annotate command: %w", err)
+	}
+	return AnnotateResult{Command: entry.Command, Annotation: a}, nil
+}
+
+// SearchParams are the parameters of search, a store.Query
+type SearchParams struct {
+	Words     []string `json:"words,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Annotated bool     `json:"annotated,omitempty"`
+	Limit     int      `json:"limit,omitempty"` // 0 for no limit
+}
+
+// SearchResult is the result of search
+type SearchResult struct {
+	Entries     []Entry             `json:"entries"`
+	Annotations []parser.Annotation `json:"annotations"` // of the entries, by every machine
+}
+
+// Search lists the entries params select, newest first, with their
+// annotations
+func Search(ctx context.Context, st *store.Store, params SearchParams) (SearchResult, error) {
+	entries, err := st.Search(ctx, store.Query{
+		Words:     params.Words,
+		Tags:      params.Tags,
+		Annotated: params.Annotated,
+		Limit:     params.Limit,
+	})
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("failed to search history: %w", err)
+	}
+	res := SearchResult{Entries: make([]Entry, len(entries))}
+	hashes := make([]string, len(entries))
+	for i, e := range entries {
+		res.Entries[i] = Entry{ID: e.ID, HistoryEntry: e}
+		hashes[i] = e.Hash
+	}
+	if res.Annotations, err = st.ListAnnotations(ctx, hashes...); err != nil {
+		return SearchResult{}, err
+	}
+	return res, nil
+}
+
+// ResolveEntry finds the history entry ref refers to: "last" for the newest
+// command of this machine that is not syncsh itself, a local ID or a prefix
+// of a hash
+func ResolveEntry(ctx context.Context, st *store.Store, machineID, ref string) (parser.HistoryEntry, error) {
+	if ref == "last" {
+		entries, err := st.ListEntries(ctx, machineID, 0, lastLookback)
+		if err != nil {
+			return parser.HistoryEntry{}, err
+		}
+		for _, e := range entries {
+			if e.Namespace == "" && !isSyncshCommand(e.Command) {
+				return e, nil
+			}
+		}
+		return parser.HistoryEntry{}, errors.New("no recent command of this machine found")
+	}
+
+	var (
+		entry parser.HistoryEntry
+		err   error
+	)
+	if id, perr := strconv.ParseInt(ref, 10, 64); perr == nil {
+		entry, err = st.GetEntryByID(ctx, id)
+	} else {
+		entry, err = st.GetEntryByHashPrefix(ctx, strings.ToLower(ref))
+	}
+	if err != nil {
+		return parser.HistoryEntry{}, fmt.Errorf("history entry %s: %w", ref, err)
+	}
+	return entry, nil
+}
+
+// isSyncshCommand reports whether command runs syncsh, like the share or tag
+// command that is being run
+func isSyncshCommand(command string) bool {
+	fields := strings.Fields(command)
+	return len(fields) > 0 && filepath.Base(fields[0]) == "syncsh"
+}
@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+func TestResolveEntry(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, filepath.Join(t.TempDir(), "syncsh.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	for _, e := range []parser.HistoryEntry{
+		{Timestamp: 1, MachineID: "me", Command: "make deploy"},
+		{Timestamp: 2, MachineID: "other", Command: "uptime"},
+		{Timestamp: 3, MachineID: "me", Command: "make deploy", Namespace: "team"},
+		{Timestamp: 4, MachineID: "me", Command: "/usr/local/bin/syncsh share last"},
+	} {
+		if err := st.CreateEntry(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	last, err := ResolveEntry(ctx, st, "me", "last")
+	if err != nil || last.Timestamp != 1 {
+		t.Fatalf("ResolveEntry(last) = %+v, %v; want the newest own command that is not syncsh", last, err)
+	}
+	for _, ref := range []string{"1", last.Hash[:8]} {
+		if got, err := ResolveEntry(ctx, st, "me", ref); err != nil || got.Hash != last.Hash {
+			t.Errorf("ResolveEntry(%s) = %+v, %v", ref, got, err)
+		}
+	}
+	if _, err := ResolveEntry(ctx, st, "me", "99"); err == nil {
+		t.Error("ResolveEntry() of a missing ID succeeded")
+	}
+}
+
+func TestAnnotateAndSearch(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, filepath.Join(t.TempDir(), "syncsh.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	seed, _, err := keys.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []parser.HistoryEntry{
+		{Timestamp: 1, MachineID: "me", Command: "make deploy"},
+		{Timestamp: 2, MachineID: "me", Command: "uptime"},
+	} {
+		if err := st.CreateEntry(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Annotate(ctx, st, "me", seed, AnnotateParams{Ref: "1", Tags: []string{"prod", "deploy"}}); err != nil {
+		t.Fatal(err)
+	}
+	note := "Needs VPN"
+	res, err := Annotate(ctx, st, "me", seed, AnnotateParams{Ref: "1", Tags: []string{"prod"}, Remove: true, Note: &note})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Command != "make deploy" || !slices.Equal(res.Annotation.Tags, []string{"deploy"}) || res.Annotation.Note != note {
+		t.Errorf("Annotate() = %+v", res)
+	}
+	if _, err := Annotate(ctx, st, "me", seed, AnnotateParams{Ref: "1", Tags: []string{"Not A Tag"}}); err == nil {
+		t.Error("Annotate() with an invalid tag succeeded")
+	}
+
+	found, err := Search(ctx, st, SearchParams{Tags: []string{"deploy"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Entries) != 1 || found.Entries[0].ID != 1 || len(found.Annotations) != 1 || found.Annotations[0].Note != note {
+		t.Errorf("Search(tag:deploy) = %+v", found)
+	}
+}
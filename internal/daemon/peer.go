@@ -0,0 +1,372 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/protocol"
+	"github.com/TheRealSibasishBehera/syncsh/internal/relay"
+	"github.com/TheRealSibasishBehera/syncsh/internal/syncer"
+)
+
+const (
+	// dialTimeout bounds a single connection attempt to a peer
+	dialTimeout = 10 * time.Second
+	// redialInterval is how long to wait after a failed attempt
+	redialInterval = 30 * time.Second
+	// pushInterval is how often pending history is pushed without a trigger
+	pushInterval = 5 * time.Minute
+	// firstHandshakeTimeout is how long a tunnel tries an endpoint before
+	// moving to the next candidate or the relay, and handshakeTimeout how
+	// long an established one may go without a handshake. WireGuard renews
+	// handshakes every two minutes.
+	firstHandshakeTimeout = 15 * time.Second
+	handshakeTimeout      = 3 * time.Minute
+)
+
+// PeerStatus is the state of the loop synchronizing with one peer
+type PeerStatus struct {
+	Name      string    `json:"name"`
+	MachineID string    `json:"machine_id,omitempty"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Connected bool      `json:"connected"`
+	LastPush  time.Time `json:"last_push,omitzero"`
+
+	// State is where the connection stands since StateSince. Rebuilds counts
+	// the tunnels torn down since the daemon started, and Events are the
+	// latest transitions, oldest first.
+	State      ConnState   `json:"state"`
+	StateSince time.Time   `json:"state_since,omitzero"`
+	Rebuilds   int         `json:"rebuilds"`
+	Events     []PeerEvent `json:"events,omitempty"`
+
+	Pushed    int    `json:"pushed"` // entries pushed since the daemon started
+	LastError string `json:"last_error,omitempty"`
+
+	// LastSync is when the peer last acknowledged entries, SyncedThrough the
+	// timestamp of the newest one and Pending how many it has yet to get
+	LastSync      time.Time `json:"last_sync,omitzero"`
+	SyncedThrough int64     `json:"synced_through,omitempty"`
+	Pending       int64     `json:"pending"`
+
+	Tunnel *network.SimplePeerStatus `json:"tunnel,omitempty"`
+}
+
+type peerState struct {
+	push      chan struct{}
+	endpoints chan network.EndpointChangeEvent // found on the LAN
+
+	mu     sync.Mutex
+	status PeerStatus
+	events []PeerEvent
+	tunnel network.Transport // set while the loop runs
+}
+
+func newPeerState(peer *config.Peer) *peerState {
+	return &peerState{
+		push:      make(chan struct{}, 1),
+		endpoints: make(chan network.EndpointChangeEvent, 1),
+		status: PeerStatus{
+			Name:       peer.Name,
+			Endpoint:   peer.Endpoint,
+			State:      ConnConnecting,
+			StateSince: time.Now(),
+		},
+	}
+}
+
+// trigger asks the loop to push pending history. It reports whether the
+// peer is connected; a disconnected peer catches up when it connects.
+func (p *peerState) trigger() bool {
+	select {
+	case p.push <- struct{}{}:
+	default:
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.status.Connected
+}
+
+func (p *peerState) snapshot() PeerStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := p.status
+	status.Events = slices.Clone(p.events)
+	if p.tunnel != nil {
+		if ts, err := network.TransportPeerStatus(p.tunnel); err == nil {
+			status.Tunnel = &ts
+		}
+	}
+	return status
+}
+
+// discovered hands an endpoint found on the LAN to the loop. An endpoint
+// the loop has not picked up yet is replaced.
+func (p *peerState) discovered(ev network.EndpointChangeEvent) {
+	for {
+		select {
+		case p.endpoints <- ev:
+			return
+		default:
+		}
+		select {
+		case <-p.endpoints:
+		default:
+		}
+	}
+}
+
+// listenPort returns the local WireGuard port of the running tunnel
+func (p *peerState) listenPort() (uint16, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tunnel == nil {
+		return 0, false
+	}
+	port, err := p.tunnel.ListenPort()
+	return port, err == nil
+}
+
+func (p *peerState) setTunnel(t network.Transport) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tunnel = t
+}
+
+func (p *peerState) update(fn func(s *PeerStatus)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fn(&p.status)
+}
+
+// runPeer opens a tunnel to peer, keeps a session open over it and pushes
+// this machine's history. Both sides listen inside the tunnel; the side that
+// knows the other's endpoint also dials, and with a relay both do. Sessions
+// that arrive while one is already active are served but not pushed on.
+// connected is called whenever a session starts. runPeer returns why the
+// tunnel went down: it failed to come up, or its handshakes stopped.
+func (d *Daemon) runPeer(ctx context.Context, srv *syncer.Server, ks keys.KeyStore, peer *config.Peer, state *peerState, connected func()) error {
+	var (
+		privateKey keys.Key
+		opts       machine.TunnelOptions
+		err        error
+	)
+	srv.View(func(cfg *config.Config) {
+		privateKey, err = machine.PrivateKeyFor(cfg, ks, peer)
+		opts = machine.TunnelOptionsFor(cfg)
+	})
+	if err != nil {
+		return err
+	}
+
+	t, err := machine.OpenTunnel(peer, privateKey, opts)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	state.setTunnel(t)
+	defer state.setTunnel(nil)
+	state.setState(ConnConnecting, nil)
+
+	// Everything below runs on the tunnel and ends with it
+	tunnelCtx, kill := context.WithCancelCause(ctx)
+	defer kill(nil)
+
+	// The loops use t, so they must stop before it is closed
+	loopCtx, stopLoops := context.WithCancel(tunnelCtx)
+	var loops sync.WaitGroup
+	defer func() {
+		stopLoops()
+		loops.Wait()
+	}()
+	var punched <-chan network.EndpointChangeEvent
+	b, relayed := t.Bind().(*relay.Bind)
+	if relayed {
+		punched = b.Events()
+	}
+	if relayed || len(peer.EndpointCandidates()) > 0 {
+		loops.Add(1)
+		go func() {
+			defer loops.Done()
+			superviseEndpoint(loopCtx, t, peer, relayed)
+		}()
+	}
+	loops.Add(2)
+	go func() {
+		defer loops.Done()
+		followEndpoints(loopCtx, t, peer, state.endpoints, punched)
+	}()
+	go func() {
+		defer loops.Done()
+		if err := watchHandshakes(loopCtx, t); err != nil {
+			kill(err)
+		}
+	}()
+	ln, err := t.ListenTCP(protocol.DefaultPort)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	incoming := make(chan *syncer.Session)
+	go acceptSessions(tunnelCtx, srv, ln, peer, privateKey, incoming)
+
+	// Through a relay any peer can be dialed
+	dial := len(peer.EndpointCandidates()) > 0 || opts.Relay != nil
+	for {
+		sess, err := connectPeer(tunnelCtx, srv, t, peer, privateKey, dial, incoming)
+		if err != nil {
+			return context.Cause(tunnelCtx)
+		}
+		state.setState(ConnConnected, nil)
+		connected()
+		syncLog.Info("Connected to peer.", "peer", peer.Name)
+
+		err = d.syncSession(tunnelCtx, srv, sess, state)
+		if tunnelCtx.Err() != nil {
+			return context.Cause(tunnelCtx)
+		}
+		state.setState(ConnConnecting, err)
+		syncLog.Warn("Disconnected from peer.", "peer", peer.Name, "error", err)
+	}
+}
+
+// acceptSessions hands sessions opened by the peer to the loop if it is
+// waiting for one, and serves them passively otherwise
+func acceptSessions(ctx context.Context, srv *syncer.Server, ln net.Listener, peer *config.Peer, privateKey keys.Key, incoming chan<- *syncer.Session) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			sess, err := srv.Handshake(conn, peer, privateKey)
+			if err != nil {
+				syncLog.Warn("Rejected connection.", "peer", peer.Name, "error", err)
+				conn.Close()
+				return
+			}
+			select {
+			case incoming <- sess:
+			default:
+				if err := srv.Run(ctx, sess); err != nil {
+					syncLog.Warn("Session ended.", "peer", peer.Name, "error", err)
+				}
+			}
+		}()
+	}
+}
+
+// connectPeer waits for a session, dialing the peer if dial is set. It only
+// fails when ctx is cancelled.
+func connectPeer(ctx context.Context, srv *syncer.Server, t network.Transport, peer *config.Peer, privateKey keys.Key, dial bool, incoming <-chan *syncer.Session) (*syncer.Session, error) {
+	for {
+		if dial {
+			sess, err := dialSession(ctx, srv, t, peer, privateKey)
+			if err == nil {
+				return sess, nil
+			}
+			syncLog.Debug("Peer unreachable.", "peer", peer.Name, "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case sess := <-incoming:
+			return sess, nil
+		case <-time.After(redialInterval):
+		}
+	}
+}
+
+// followEndpoints points the tunnel at endpoints of the peer found on the
+// LAN or by hole punching
+func followEndpoints(ctx context.Context, t network.Transport, peer *config.Peer, discovered, punched <-chan network.EndpointChangeEvent) {
+	for {
+		var ev network.EndpointChangeEvent
+		select {
+		case <-ctx.Done():
+			return
+		case ev = <-discovered:
+		case ev = <-punched:
+		}
+		if ev.PublicKey != peer.PublicKey {
+			continue
+		}
+		endpoint := ev.Endpoint.String()
+		if stats, err := t.Stats(); err == nil && stats.Endpoint == endpoint {
+			continue
+		}
+		syncLog.Info("Switching to a direct path.", "peer", peer.Name, "endpoint", endpoint)
+		if err := t.SetEndpoint(endpoint); err != nil {
+			syncLog.Warn("Failed to switch to a direct path.", "peer", peer.Name, "error", err)
+		}
+	}
+}
+
+func dialSession(ctx context.Context, srv *syncer.Server, t network.Transport, peer *config.Peer, privateKey keys.Key) (*syncer.Session, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := machine.DialTunnel(dialCtx, t)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(dialCtx, func() { conn.Close() })
+	defer stop()
+	sess, err := srv.Handshake(conn, peer, privateKey)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sess, nil
+}
+
+// syncSession runs sess and pushes history over it at start, on trigger and
+// periodically, until the session ends
+func (d *Daemon) syncSession(ctx context.Context, srv *syncer.Server, sess *syncer.Session, state *peerState) error {
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx, sess) }()
+
+	ticker := time.NewTicker(pushInterval)
+	defer ticker.Stop()
+
+	push := func() error {
+		if d.isPaused() {
+			return nil
+		}
+		n, err := srv.PushHistory(ctx, sess)
+		if err != nil {
+			return err
+		}
+		state.update(func(s *PeerStatus) {
+			s.LastPush = time.Now()
+			s.Pushed += n
+		})
+		return nil
+	}
+
+	for {
+		if err := push(); err != nil {
+			sess.Close()
+			<-done
+			return err
+		}
+		select {
+		case err := <-done:
+			if err == nil {
+				err = errors.New("session closed")
+			}
+			return err
+		case <-state.push:
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/control"
+	"github.com/TheRealSibasishBehera/syncsh/internal/forget"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+// Methods of the control API
+const (
+	MethodStatus   = "status"
+	MethodPeers    = "peers"
+	MethodPause    = "pause"
+	MethodResume   = "resume"
+	MethodSyncNow  = "sync_now"
+	MethodReload   = "reload"
+	MethodVerify   = "verify"
+	MethodForget   = "forget"
+	MethodRevoke   = "revoke"
+	MethodShare    = "share"
+	MethodAnnotate = "annotate"
+	MethodSearch   = "search"
+)
+
+// SyncNowResult is the result of sync_now
+type SyncNowResult struct {
+	Peers int `json:"peers"` // connected peers asked to push
+}
+
+// ForgetParams are the parameters of forget. A dry run lists what Request
+// matches; the call that deletes takes the Selection the dry run returned,
+// so nothing the user has not seen is deleted.
+type ForgetParams struct {
+	forget.Request
+	DryRun    bool              `json:"dry_run,omitempty"`
+	Selection *forget.Selection `json:"selection,omitempty"`
+}
+
+// ForgetResult is the result of forget
+type ForgetResult struct {
+	Entries  []Entry         `json:"entries"`
+	Path     string          `json:"path"`              // history file
+	Records  []forget.Record `json:"records,omitempty"` // its records that match
+	Commands int             `json:"commands"`          // commands removed from the history file
+	Backup   string          `json:"backup,omitempty"`  // copy of the history file before it was rewritten
+	Peers    int             `json:"peers"`             // connected peers asked to push the deletions
+}
+
+// RevokeParams are the parameters of revoke
+type RevokeParams struct {
+	MachineID string `json:"machine_id"` // machine of the removed peer
+	Purge     bool   `json:"purge,omitempty"`
+}
+
+// RevokeResult is the result of revoke
+type RevokeResult struct {
+	Deleted int64 `json:"deleted"` // history entries deleted by purge
+}
+
+// PauseResult is the result of pause and resume
+type PauseResult struct {
+	Paused bool `json:"paused"`
+}
+
+func (d *Daemon) register(s *control.Server) {
+	s.Handle(MethodStatus, func(ctx context.Context, _ json.RawMessage) (any, error) {
+		return d.Status(ctx)
+	})
+	s.Handle(MethodPeers, func(ctx context.Context, _ json.RawMessage) (any, error) {
+		return d.Peers(ctx)
+	})
+	s.Handle(MethodPause, func(ctx context.Context, _ json.RawMessage) (any, error) {
+		d.SetPaused(true)
+		return PauseResult{Paused: true}, nil
+	})
+	s.Handle(MethodResume, func(ctx context.Context, _ json.RawMessage) (any, error) {
+		d.SetPaused(false)
+		return PauseResult{Paused: false}, nil
+	})
+	s.Handle(MethodSyncNow, func(ctx context.Context, _ json.RawMessage) (any, error) {
+		return SyncNowResult{Peers: d.SyncNow()}, nil
+	})
+	s.Handle(MethodReload, func(ctx context.Context, _ json.RawMessage) (any, error) {
+		return nil, d.Reload()
+	})
+	s.Handle(MethodVerify, func(ctx context.Context, _ json.RawMessage) (any, error) {
+		return d.Verify(ctx)
+	})
+	s.Handle(MethodRevoke, func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params RevokeParams
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("decode revoke params: %w", err)
+		}
+		return Revoke(ctx, d.store, params)
+	})
+	s.Handle(MethodForget, func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params ForgetParams
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("decode forget params: %w", err)
+		}
+		return d.Forget(ctx, params)
+	})
+	s.Handle(MethodShare, func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params ShareParams
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("decode share params: %w", err)
+		}
+		return d.Share(ctx, params)
+	})
+	s.Handle(MethodAnnotate, func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params AnnotateParams
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("decode annotate params: %w", err)
+		}
+		return d.Annotate(ctx, params)
+	})
+	s.Handle(MethodSearch, func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params SearchParams
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, fmt.Errorf("decode search params: %w", err)
+		}
+		return Search(ctx, d.store, params)
+	})
+}
+
+// Verify audits the signatures of every stored entry
+func (d *Daemon) Verify(ctx context.Context) (provenance.Report, error) {
+	entries, err := d.store.ListEntries(ctx, "", 0, 0)
+	if err != nil {
+		return provenance.Report{}, err
+	}
+
+	d.mu.Lock()
+	srv := d.server
+	d.mu.Unlock()
+
+	var report provenance.Report
+	srv.View(func(cfg *config.Config) {
+		report = provenance.Audit(entries, machine.SigningKeys(cfg))
+	})
+	return report, nil
+}
+
+// Forget deletes what the client previewed everywhere, rewriting the
+// history file through the file the client passed, if any. The watcher is
+// held off while the history file is rewritten, so it never reads the file
+// half written or at an offset into the old one.
+func (d *Daemon) Forget(ctx context.Context, params ForgetParams) (ForgetResult, error) {
+	d.mu.Lock()
+	srv, signingKey := d.server, d.signingKey
+	d.mu.Unlock()
+	var h forget.History
+	srv.View(func(cfg *config.Config) {
+		h = forget.HistoryOf(cfg)
+	})
+	var history *os.File
+	if files := control.Files(ctx); len(files) > 0 {
+		history = files[0]
+	}
+
+	d.ingestMu.Lock()
+	defer d.ingestMu.Unlock()
+	res, err := Forget(ctx, d.store, h, params, signingKey, history)
+	if err != nil || params.DryRun {
+		return res, err
+	}
+	log.Info("Forgot commands.", "entries", len(res.Entries), "commands", res.Commands)
+	if len(res.Entries) > 0 {
+		res.Peers = d.SyncNow()
+	}
+	return res, nil
+}
+
+// Forget lists what params.Request matches on a dry run, and otherwise
+// deletes exactly params.Selection. The history file is rewritten through
+// history, or opened if it is nil.
+func Forget(ctx context.Context, st *store.Store, h forget.History, params ForgetParams, signingKey keys.Key, history *os.File) (ForgetResult, error) {
+	var (
+		plan *forget.Plan
+		err  error
+	)
+	switch {
+	case params.DryRun:
+		plan, err = forget.NewPlan(ctx, st, h, params.Request)
+	case params.Selection != nil:
+		plan, err = forget.SelectPlan(ctx, st, h, *params.Selection)
+	default:
+		return ForgetResult{}, errors.New("forget deletes the selection of a dry run, and none was given")
+	}
+	if err != nil {
+		return ForgetResult{}, err
+	}
+	res := ForgetResult{Path: h.Path, Records: plan.Records, Commands: plan.Commands()}
+	for _, e := range plan.Entries {
+		res.Entries = append(res.Entries, Entry{ID: e.ID, HistoryEntry: e})
+	}
+	if params.DryRun {
+		return res, nil
+	}
+	if res.Backup, err = plan.Apply(ctx, st, signingKey, history, time.Now()); err != nil {
+		return ForgetResult{}, err
+	}
+	return res, nil
+}
+
+// Revoke forgets how far the machine of a removed peer was synced, and with
+// Purge deletes the history it contributed
+func Revoke(ctx context.Context, st *store.Store, params RevokeParams) (RevokeResult, error) {
+	if err := st.DeleteSyncState(ctx, params.MachineID); err != nil {
+		return RevokeResult{}, err
+	}
+	if !params.Purge {
+		return RevokeResult{}, nil
+	}
+	n, err := st.DeleteMachineEntries(ctx, params.MachineID)
+	if err != nil {
+		return RevokeResult{}, err
+	}
+	log.Info("Purged history of a revoked peer.", "machine", params.MachineID, "entries", n)
+	return RevokeResult{Deleted: n}, nil
+}
@@ -0,0 +1,162 @@
+package daemon
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+// Status is the daemon state reported by the status method. Without a
+// running daemon only the parts read from the store are filled in.
+type Status struct {
+	Running    bool            `json:"running"`
+	PID        int             `json:"pid,omitempty"`
+	StartedAt  time.Time       `json:"started_at,omitzero"`
+	Paused     bool            `json:"paused"`
+	Entries    int64           `json:"entries"`
+	StoreBytes int64           `json:"store_bytes"`
+	Watcher    WatcherStatus   `json:"watcher"`
+	Services   []ServiceStatus `json:"services,omitempty"`
+	Peers      []PeerStatus    `json:"peers"`
+}
+
+// Status reports the daemon state
+func (d *Daemon) Status(ctx context.Context) (Status, error) {
+	d.mu.Lock()
+	status := Status{
+		Running:   true,
+		PID:       os.Getpid(),
+		StartedAt: d.started,
+		Paused:    d.paused,
+		Watcher:   d.watcher,
+	}
+	d.mu.Unlock()
+	status.Services = d.sup.Status()
+	for _, svc := range status.Services {
+		if svc.Name == watcherService {
+			status.Watcher.State, status.Watcher.LastError = svc.State, svc.LastError
+		}
+	}
+	status.Watcher.Lag = historyLag(status.Watcher.Path, status.Watcher.Offset)
+
+	peers, err := d.Peers(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	status.Peers = peers
+	if err := fillStoreStatus(ctx, d.store, &status); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+// Peers reports the state of every peer loop, with the tunnel counters and
+// how far each peer has acknowledged this machine's history
+func (d *Daemon) Peers(ctx context.Context) ([]PeerStatus, error) {
+	d.mu.Lock()
+	srv, states := d.server, d.peers
+	d.mu.Unlock()
+
+	var (
+		machineID  string
+		statuses   []PeerStatus
+		namespaces [][]string
+	)
+	srv.View(func(cfg *config.Config) {
+		machineID = cfg.MachineID
+		statuses = make([]PeerStatus, 0, len(cfg.Peers))
+		for _, peer := range cfg.Peers {
+			if p, ok := states[peer.Name]; ok {
+				status := p.snapshot()
+				status.MachineID = peer.MachineID
+				statuses = append(statuses, status)
+				namespaces = append(namespaces, peer.EntryNamespaces())
+			}
+		}
+	})
+	for i := range statuses {
+		if err := fillSyncStatus(ctx, d.store, machineID, namespaces[i], &statuses[i]); err != nil {
+			return nil, err
+		}
+	}
+	return statuses, nil
+}
+
+// Offline reports what can be known without a running daemon: the store,
+// the sync state of every peer and, if it exists, the kernel WireGuard
+// interface.
+func Offline(ctx context.Context, st *store.Store, cfg *config.Config) (Status, error) {
+	path := cfg.GetResolvedHistoryPath()
+	offset, err := st.GetHistoryOffset(ctx, path)
+	if err != nil {
+		return Status{}, err
+	}
+	status := Status{
+		Watcher: WatcherStatus{
+			Path:   path,
+			Offset: offset,
+			State:  StateStopped,
+			Lag:    historyLag(path, offset),
+		},
+	}
+
+	for _, peer := range cfg.Peers {
+		ps := PeerStatus{Name: peer.Name, MachineID: peer.MachineID, Endpoint: peer.Endpoint}
+		// Without a kernel interface there are no tunnel counters to show.
+		tunnels, _ := network.KernelPeerStatus(network.PeerInterfaceName(cfg.InterfaceName, peer.PublicKey))
+		for _, t := range tunnels {
+			if t.PublicKey == peer.PublicKey.String() {
+				ps.Tunnel = &t
+			}
+		}
+		if err := fillSyncStatus(ctx, st, cfg.MachineID, peer.EntryNamespaces(), &ps); err != nil {
+			return Status{}, err
+		}
+		status.Peers = append(status.Peers, ps)
+	}
+
+	if err := fillStoreStatus(ctx, st, &status); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+func fillStoreStatus(ctx context.Context, st *store.Store, status *Status) error {
+	var err error
+	if status.Entries, err = st.CountEntries(ctx); err != nil {
+		return err
+	}
+	status.StoreBytes, err = st.Size(ctx)
+	return err
+}
+
+// fillSyncStatus sets when the peer last acknowledged history and how many
+// entries of this machine in the namespaces it gets it has yet to acknowledge
+func fillSyncStatus(ctx context.Context, st *store.Store, machineID string, namespaces []string, ps *PeerStatus) error {
+	if ps.MachineID == "" {
+		// Never connected: everything is pending.
+		n, err := st.CountEntriesSince(ctx, machineID, store.Cursor{}, namespaces...)
+		ps.Pending = n
+		return err
+	}
+	state, err := st.GetSyncState(ctx, ps.MachineID)
+	if err != nil {
+		return err
+	}
+	ps.LastSync, ps.SyncedThrough = state.SyncedAt, state.Through.Timestamp
+	ps.Pending, err = st.CountEntriesSince(ctx, machineID, state.Through, namespaces...)
+	return err
+}
+
+// historyLag returns how many bytes of the history file are not read yet
+func historyLag(path string, offset int64) int64 {
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < offset {
+		return 0
+	}
+	return info.Size() - offset
+}
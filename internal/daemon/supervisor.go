@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Restart backoff for failed services
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+)
+
+// ServiceState is the lifecycle state of a supervised service
+type ServiceState string
+
+const (
+	StateRunning    ServiceState = "running"
+	StateRestarting ServiceState = "restarting"
+	StateStopped    ServiceState = "stopped"
+)
+
+// ServiceStatus describes a supervised service
+type ServiceStatus struct {
+	Name      string       `json:"name"`
+	State     ServiceState `json:"state"`
+	Restarts  int          `json:"restarts"`
+	LastError string       `json:"last_error,omitempty"`
+	Since     time.Time    `json:"since"`
+}
+
+type service struct {
+	status ServiceStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Supervisor runs named services and restarts them with exponential backoff
+// when they fail. A service that returns nil is finished and not restarted.
+type Supervisor struct {
+	mu       sync.Mutex
+	services map[string]*service
+	minDelay time.Duration
+	maxDelay time.Duration
+}
+
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		services: make(map[string]*service),
+		minDelay: minRestartDelay,
+		maxDelay: maxRestartDelay,
+	}
+}
+
+// Start runs fn as the service name until ctx is cancelled or Stop is called.
+// A running service with the same name is stopped first.
+func (s *Supervisor) Start(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	s.Stop(name)
+
+	ctx, cancel := context.WithCancel(ctx)
+	svc := &service{
+		status: ServiceStatus{Name: name, State: StateRunning, Since: time.Now()},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	s.mu.Lock()
+	s.services[name] = svc
+	s.mu.Unlock()
+
+	go s.run(ctx, svc, fn)
+}
+
+func (s *Supervisor) run(ctx context.Context, svc *service, fn func(ctx context.Context) error) {
+	defer close(svc.done)
+	delay := s.minDelay
+	for {
+		err := fn(ctx)
+		if err == nil || ctx.Err() != nil {
+			s.setState(svc, StateStopped, err)
+			return
+		}
+		log.Error("Service failed, restarting.", "service", svc.status.Name, "error", err, "delay", delay)
+		s.setState(svc, StateRestarting, err)
+
+		select {
+		case <-ctx.Done():
+			s.setState(svc, StateStopped, nil)
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, s.maxDelay)
+
+		s.mu.Lock()
+		svc.status.Restarts++
+		s.mu.Unlock()
+		s.setState(svc, StateRunning, nil)
+	}
+}
+
+func (s *Supervisor) setState(svc *service, state ServiceState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc.status.State = state
+	svc.status.Since = time.Now()
+	if err != nil {
+		svc.status.LastError = err.Error()
+	}
+}
+
+// Stop cancels the service name and waits for it to return
+func (s *Supervisor) Stop(name string) {
+	s.mu.Lock()
+	svc, ok := s.services[name]
+	delete(s.services, name)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	svc.cancel()
+	<-svc.done
+}
+
+// StopAll stops every service
+func (s *Supervisor) StopAll() {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.services))
+	for name := range s.services {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	for _, name := range names {
+		s.Stop(name)
+	}
+}
+
+// Status returns the state of every service, sorted by name
+func (s *Supervisor) Status() []ServiceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]ServiceStatus, 0, len(s.services))
+	for _, svc := range s.services {
+		statuses = append(statuses, svc.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
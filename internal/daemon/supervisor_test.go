@@ -0,0 +1,60 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSupervisorRestartsFailedService(t *testing.T) {
+	s := NewSupervisor()
+	s.minDelay, s.maxDelay = time.Millisecond, time.Millisecond
+
+	var runs atomic.Int32
+	s.Start(context.Background(), "flaky", func(ctx context.Context) error {
+		if runs.Add(1) < 3 {
+			return errors.New("boom")
+		}
+		<-ctx.Done()
+		return nil
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runs.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	waitForState(t, s, "flaky", StateRunning)
+
+	status := s.Status()
+	if status[0].Restarts != 2 || status[0].LastError != "boom" {
+		t.Errorf("Unexpected status: %+v", status[0])
+	}
+
+	s.Stop("flaky")
+	if len(s.Status()) != 0 {
+		t.Errorf("Expected a stopped service to be removed, got %+v", s.Status())
+	}
+}
+
+func TestSupervisorFinishedService(t *testing.T) {
+	s := NewSupervisor()
+	s.Start(context.Background(), "once", func(ctx context.Context) error { return nil })
+	waitForState(t, s, "once", StateStopped)
+	s.StopAll()
+}
+
+func waitForState(t *testing.T, s *Supervisor, name string, want ServiceState) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, status := range s.Status() {
+			if status.Name == name && status.State == want {
+				return
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Service %s never reached %s: %+v", name, want, s.Status())
+}
@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/ingest"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/metrics"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/watcher"
+	"github.com/TheRealSibasishBehera/syncsh/pkg/utils"
+)
+
+// WatcherStatus is the state of the history watcher
+type WatcherStatus struct {
+	Path       string       `json:"path"`
+	State      ServiceState `json:"state"`
+	LastError  string       `json:"last_error,omitempty"`
+	Offset     int64        `json:"offset"`   // bytes of the history file read so far
+	Lag        int64        `json:"lag"`      // bytes written but not read yet
+	Ingested   int          `json:"ingested"` // new entries since the daemon started
+	LastIngest time.Time    `json:"last_ingest,omitzero"`
+}
+
+// watchHistory ingests commands appended to the shell history file. The
+// directory is watched rather than the file, since shells replace the file
+// when they rewrite it. The read offset is persisted so commands run while
+// the daemon was down are picked up on start.
+func (d *Daemon) watchHistory(ctx context.Context, cfg *config.Config, signingKey keys.Key) error {
+	shell := string(cfg.Shell)
+	if shell == "" {
+		detected, err := utils.GetShellKind()
+		if err != nil {
+			return err
+		}
+		shell = detected
+	}
+	path := filepath.Clean(cfg.GetResolvedHistoryPath())
+	p, err := parser.NewParser(shell, path)
+	if err != nil {
+		return err
+	}
+
+	w, err := watcher.NewWatcher([]string{filepath.Dir(path)})
+	if err != nil {
+		return fmt.Errorf("watch history directory: %w", err)
+	}
+	defer w.Watcher.Close()
+
+	offset, err := d.store.GetHistoryOffset(ctx, path)
+	if err != nil {
+		return err
+	}
+	f := &watcher.Follower{Path: path, Offset: offset}
+	ing := ingest.New(d.store, cfg.MachineID, signingKey)
+	d.setWatcher(func(s *WatcherStatus) { s.Path, s.Offset = path, offset })
+
+	if err := d.ingestNew(ctx, f, p, ing); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.Watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != path {
+				continue
+			}
+			metrics.WatcherEvents.Inc()
+			if err := d.ingestNew(ctx, f, p, ing); err != nil {
+				return err
+			}
+		case err, ok := <-w.Watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("watch history file: %w", err)
+		}
+	}
+}
+
+// ingestNew stores the commands appended since the last read and asks the
+// peer loops to push them. A stored offset behind the follower's was
+// lowered by Forget after it removed commands from the file, and is where
+// reading continues.
+func (d *Daemon) ingestNew(ctx context.Context, f *watcher.Follower, p parser.ShellParser, ing *ingest.Ingester) error {
+	d.ingestMu.Lock()
+	defer d.ingestMu.Unlock()
+
+	offset, err := d.store.GetHistoryOffset(ctx, f.Path)
+	if err != nil {
+		return err
+	}
+	f.Offset = min(f.Offset, offset)
+
+	lines, err := f.ReadLines()
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	now := time.Now().Unix()
+	entries := make([]parser.HistoryEntry, 0, len(lines))
+	for _, line := range lines {
+		command, timestamp, skip := p.ParseLine(line)
+		if skip {
+			continue
+		}
+		// Histories without timestamps are stamped when they are read.
+		if timestamp == 0 {
+			timestamp = now
+		}
+		entries = append(entries, parser.HistoryEntry{Timestamp: timestamp, Command: command})
+	}
+
+	stored, err := ing.Ingest(ctx, entries)
+	if err != nil {
+		return fmt.Errorf("ingest history: %w", err)
+	}
+	if err := d.store.SetHistoryOffset(ctx, f.Path, f.Offset); err != nil {
+		return err
+	}
+	d.setWatcher(func(s *WatcherStatus) {
+		s.Offset = f.Offset
+		s.Ingested += stored
+		if stored > 0 {
+			s.LastIngest = time.Now()
+		}
+	})
+
+	metrics.EntriesIngested.Add(float64(stored))
+	if stored > 0 {
+		watchLog.Debug("Ingested history.", "path", f.Path, "new", stored)
+		d.SyncNow()
+	}
+	return nil
+}
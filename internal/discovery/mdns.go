@@ -0,0 +1,419 @@
+// Package discovery finds syncsh machines on the local network with
+// multicast DNS service discovery. Every machine advertises _syncsh._udp
+// under the fingerprint of its WireGuard public key, with the key, its
+// machine ID and the WireGuard port of its tunnel to each peer in TXT
+// records.
+package discovery
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var log = logging.For(logging.Network)
+
+const (
+	// Service is the DNS-SD service type syncsh advertises
+	Service = "_syncsh._udp.local."
+
+	// announceInterval is how often a node announces itself and queries for
+	// others without being asked
+	announceInterval = time.Minute
+
+	// recordTTL is the TTL of advertised records, in seconds
+	recordTTL = 120
+
+	txtVersion = "v=1"
+	txtKey     = "pk="
+	txtMachine = "id="
+	txtPort    = "wg." // wg.<peer fingerprint>=<port>
+)
+
+// Group is the IPv4 mDNS multicast group
+var Group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+var serviceName = dnsmessage.MustNewName(Service)
+
+// Announcement is what a machine advertises about itself
+type Announcement struct {
+	PublicKey keys.Key
+	MachineID string
+	Host      string
+
+	// Ports holds the local WireGuard port of the machine's tunnel to each
+	// of its peers, by peer fingerprint
+	Ports map[string]uint16
+
+	// Addrs are the addresses the machine may be reached at, the one the
+	// announcement came from first. They are not advertised.
+	Addrs []netip.Addr
+}
+
+// PortFor returns the WireGuard port the announcing machine uses for its
+// tunnel to the machine with publicKey
+func (a Announcement) PortFor(publicKey keys.Key) (uint16, bool) {
+	port, ok := a.Ports[Fingerprint(publicKey)]
+	return port, ok
+}
+
+// Fingerprint is a short, stable name for a public key: the first 8 bytes of
+// its SHA-256 in hex
+func Fingerprint(publicKey keys.Key) string {
+	sum := sha256.Sum256(publicKey[:])
+	return hex.EncodeToString(sum[:8])
+}
+
+// Node answers mDNS queries for this machine and reports the announcements
+// of other machines
+type Node struct {
+	conn  net.PacketConn
+	group net.Addr
+
+	// self returns this machine's announcement; false advertises nothing
+	self func() (Announcement, bool)
+	// found is called with every announcement heard, including our own
+	found func(Announcement)
+}
+
+// NewNode returns a node that sends to group over conn. self may be nil to
+// only browse.
+func NewNode(conn net.PacketConn, group net.Addr, self func() (Announcement, bool), found func(Announcement)) *Node {
+	return &Node{conn: conn, group: group, self: self, found: found}
+}
+
+// Listen joins the IPv4 mDNS group on every multicast interface
+func Listen() (net.PacketConn, error) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, Group)
+	if err != nil {
+		return nil, fmt.Errorf("join mDNS group: %w", err)
+	}
+	return conn, nil
+}
+
+// Run announces this machine and queries for others every
+// announceInterval, answers queries and reports announcements until ctx is
+// cancelled. It closes the connection when it returns.
+func (n *Node) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { n.conn.Close() })
+	defer stop()
+
+	go func() {
+		ticker := time.NewTicker(announceInterval)
+		defer ticker.Stop()
+		for {
+			n.announce()
+			if err := n.Query(); err != nil {
+				log.Debug("Failed to send mDNS query.", "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		size, from, err := n.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Debug("Failed to read mDNS packet.", "error", err)
+			continue
+		}
+		n.handle(buf[:size], from)
+	}
+}
+
+// Query asks every machine on the network to announce itself
+func (n *Node) Query() error {
+	msg := dnsmessage.Message{
+		Questions: []dnsmessage.Question{{Name: serviceName, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}},
+	}
+	packet, err := msg.Pack()
+	if err != nil {
+		return fmt.Errorf("pack mDNS query: %w", err)
+	}
+	_, err = n.conn.WriteTo(packet, n.group)
+	return err
+}
+
+func (n *Node) announce() {
+	if n.self == nil {
+		return
+	}
+	a, ok := n.self()
+	if !ok {
+		return
+	}
+	packet, err := packAnnouncement(a)
+	if err != nil {
+		log.Warn("Failed to build mDNS announcement.", "error", err)
+		return
+	}
+	if _, err := n.conn.WriteTo(packet, n.group); err != nil {
+		log.Debug("Failed to send mDNS announcement.", "error", err)
+	}
+}
+
+func (n *Node) handle(packet []byte, from net.Addr) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil {
+		return
+	}
+	if !msg.Response {
+		for _, q := range msg.Questions {
+			if q.Name == serviceName && (q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL) {
+				n.announce()
+				return
+			}
+		}
+		return
+	}
+	if n.found == nil {
+		return
+	}
+	var source netip.Addr
+	if udp, ok := from.(*net.UDPAddr); ok {
+		source, _ = netip.AddrFromSlice(udp.IP)
+		source = source.Unmap()
+	}
+	for _, a := range parseAnnouncements(msg, source) {
+		n.found(a)
+	}
+}
+
+// packAnnouncement builds the mDNS response advertising a: a PTR from the
+// service to the instance, SRV and TXT records for the instance and the
+// addresses of the host
+func packAnnouncement(a Announcement) ([]byte, error) {
+	instance, err := dnsmessage.NewName(Fingerprint(a.PublicKey) + "." + Service)
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(hostLabel(a.Host) + ".local.")
+	if err != nil {
+		return nil, err
+	}
+
+	txt := []string{txtVersion, txtKey + a.PublicKey.String()}
+	if a.MachineID != "" {
+		txt = append(txt, txtMachine+a.MachineID)
+	}
+	for fp, port := range a.Ports {
+		txt = append(txt, txtPort+fp+"="+strconv.Itoa(int(port)))
+	}
+
+	header := func(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: recordTTL}
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: []dnsmessage.Resource{{
+			Header: header(serviceName, dnsmessage.TypePTR),
+			Body:   &dnsmessage.PTRResource{PTR: instance},
+		}},
+		Additionals: []dnsmessage.Resource{
+			{
+				// WireGuard ports differ per peer, so they are in the TXT
+				// record and the SRV record carries none
+				Header: header(instance, dnsmessage.TypeSRV),
+				Body:   &dnsmessage.SRVResource{Target: host},
+			},
+			{
+				Header: header(instance, dnsmessage.TypeTXT),
+				Body:   &dnsmessage.TXTResource{TXT: txt},
+			},
+		},
+	}
+	for _, addr := range a.Addrs {
+		if addr.Is4() {
+			msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+				Header: header(host, dnsmessage.TypeA),
+				Body:   &dnsmessage.AResource{A: addr.As4()},
+			})
+		} else {
+			msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+				Header: header(host, dnsmessage.TypeAAAA),
+				Body:   &dnsmessage.AAAAResource{AAAA: addr.As16()},
+			})
+		}
+	}
+	return msg.Pack()
+}
+
+// parseAnnouncements returns the syncsh instances in an mDNS response.
+// source, if valid, is listed as the first address of each.
+func parseAnnouncements(msg dnsmessage.Message, source netip.Addr) []Announcement {
+	records := append(append([]dnsmessage.Resource(nil), msg.Answers...), msg.Additionals...)
+
+	var instances []dnsmessage.Name
+	txts := make(map[dnsmessage.Name][]string)
+	targets := make(map[dnsmessage.Name]dnsmessage.Name)
+	addrs := make(map[dnsmessage.Name][]netip.Addr)
+	for _, r := range records {
+		switch body := r.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if r.Header.Name == serviceName {
+				instances = append(instances, body.PTR)
+			}
+		case *dnsmessage.TXTResource:
+			txts[r.Header.Name] = body.TXT
+		case *dnsmessage.SRVResource:
+			targets[r.Header.Name] = body.Target
+		case *dnsmessage.AResource:
+			addrs[r.Header.Name] = append(addrs[r.Header.Name], netip.AddrFrom4(body.A))
+		case *dnsmessage.AAAAResource:
+			addrs[r.Header.Name] = append(addrs[r.Header.Name], netip.AddrFrom16(body.AAAA))
+		}
+	}
+
+	var found []Announcement
+	for _, instance := range instances {
+		a, err := parseTXT(txts[instance])
+		if err != nil {
+			log.Debug("Ignoring mDNS announcement.", "instance", instance.String(), "error", err)
+			continue
+		}
+		if source.IsValid() {
+			a.Addrs = append(a.Addrs, source)
+		}
+		if target, ok := targets[instance]; ok {
+			a.Host = strings.TrimSuffix(target.String(), ".local.")
+			for _, addr := range addrs[target] {
+				if addr != source {
+					a.Addrs = append(a.Addrs, addr)
+				}
+			}
+		}
+		found = append(found, a)
+	}
+	return found
+}
+
+func parseTXT(txt []string) (Announcement, error) {
+	a := Announcement{Ports: make(map[string]uint16)}
+	var version bool
+	for _, s := range txt {
+		switch {
+		case s == txtVersion:
+			version = true
+		case strings.HasPrefix(s, txtKey):
+			key, err := keys.ParseKey(strings.TrimPrefix(s, txtKey))
+			if err != nil {
+				return Announcement{}, err
+			}
+			a.PublicKey = key
+		case strings.HasPrefix(s, txtMachine):
+			a.MachineID = strings.TrimPrefix(s, txtMachine)
+		case strings.HasPrefix(s, txtPort):
+			fp, value, ok := strings.Cut(strings.TrimPrefix(s, txtPort), "=")
+			if !ok {
+				continue
+			}
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				continue
+			}
+			a.Ports[fp] = uint16(port)
+		}
+	}
+	if !version {
+		return Announcement{}, errors.New("unsupported version")
+	}
+	if a.PublicKey.IsZero() {
+		return Announcement{}, errors.New("no public key")
+	}
+	return a, nil
+}
+
+// hostLabel makes a hostname usable as a single DNS label
+func hostLabel(host string) string {
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	host, _, _ = strings.Cut(host, ".")
+	label := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, host)
+	if label == "" {
+		return "syncsh"
+	}
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label
+}
+
+// Browse queries the network and returns the machines that announce
+// themselves within timeout, one per public key
+func Browse(ctx context.Context, timeout time.Duration) ([]Announcement, error) {
+	conn, err := Listen()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		seen  = make(map[keys.Key]int)
+		found []Announcement
+	)
+	node := NewNode(conn, Group, nil, func(a Announcement) {
+		mu.Lock()
+		defer mu.Unlock()
+		if i, ok := seen[a.PublicKey]; ok {
+			found[i] = a
+			return
+		}
+		seen[a.PublicKey] = len(found)
+		found = append(found, a)
+	})
+	if err := node.Run(ctx); err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return found, nil
+}
+
+// LocalAddrs returns the addresses of this machine's interfaces that other
+// machines on the LAN may reach it at
+func LocalAddrs() []netip.Addr {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var addrs []netip.Addr
+	for _, a := range ifaceAddrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil || !prefix.Addr().IsGlobalUnicast() {
+			continue
+		}
+		addrs = append(addrs, prefix.Addr())
+	}
+	return addrs
+}
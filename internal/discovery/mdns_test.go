@@ -0,0 +1,114 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newPublicKey(t *testing.T) keys.Key {
+	t.Helper()
+	private, err := keys.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := private.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public
+}
+
+func TestAnnouncementRoundTrip(t *testing.T) {
+	peerKey := newPublicKey(t)
+	a := Announcement{
+		PublicKey: newPublicKey(t),
+		MachineID: "m-1",
+		Host:      "build box.example.com",
+		Ports:     map[string]uint16{Fingerprint(peerKey): 51821},
+		Addrs:     []netip.Addr{netip.MustParseAddr("192.168.1.20"), netip.MustParseAddr("fd00::20")},
+	}
+	packet, err := packAnnouncement(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(packet); err != nil {
+		t.Fatal(err)
+	}
+
+	source := netip.MustParseAddr("192.168.1.20")
+	found := parseAnnouncements(msg, source)
+	if len(found) != 1 {
+		t.Fatalf("Expected one announcement, got %d", len(found))
+	}
+	got := found[0]
+	if got.PublicKey != a.PublicKey || got.MachineID != "m-1" || got.Host != "build-box" {
+		t.Errorf("Announcement = %+v", got)
+	}
+	if port, ok := got.PortFor(peerKey); !ok || port != 51821 {
+		t.Errorf("PortFor = %d, %v", port, ok)
+	}
+	// The source comes first and is not repeated
+	if !slices.Equal(got.Addrs, a.Addrs) {
+		t.Errorf("Addrs = %v, want %v", got.Addrs, a.Addrs)
+	}
+}
+
+func TestParseIgnoresOtherServices(t *testing.T) {
+	other := dnsmessage.MustNewName("_http._tcp.local.")
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{Response: true},
+		Answers: []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: other, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+			Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("web._http._tcp.local.")},
+		}},
+	}
+	if found := parseAnnouncements(msg, netip.Addr{}); len(found) != 0 {
+		t.Errorf("Expected no announcements, got %v", found)
+	}
+}
+
+// TestNodes runs two nodes over loopback, each sending to the other instead
+// of the multicast group
+func TestNodes(t *testing.T) {
+	listen := func() net.PacketConn {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	connA, connB := listen(), listen()
+	self := Announcement{PublicKey: newPublicKey(t), MachineID: "a", Ports: map[string]uint16{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := NewNode(connA, connB.LocalAddr(), func() (Announcement, bool) { return self, true }, nil)
+	found := make(chan Announcement, 4)
+	b := NewNode(connB, connA.LocalAddr(), nil, func(a Announcement) { found <- a })
+	go a.Run(ctx)
+	go b.Run(ctx)
+
+	// A announces at start; B's query makes it announce again
+	for range 2 {
+		select {
+		case got := <-found:
+			if got.PublicKey != self.PublicKey || got.MachineID != "a" {
+				t.Errorf("Found %+v", got)
+			}
+			if len(got.Addrs) == 0 || got.Addrs[0] != netip.MustParseAddr("127.0.0.1") {
+				t.Errorf("Addrs = %v, want the source first", got.Addrs)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("B never heard A")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const nonceSize = 24
+
+var (
+	ErrOpen            = errors.New("history batch cannot be opened: wrong recipient or tampered payload")
+	ErrMachineMismatch = errors.New("history batch contains entries of another machine")
+)
+
+// Batch is a set of history entries and annotations produced by a single
+// machine. Tombstones are passed on from machine to machine, so they may be
+// of any machine.
+type Batch struct {
+	MachineID   string                `json:"machine_id"`
+	Entries     []parser.HistoryEntry `json:"entries"`
+	Annotations []parser.Annotation   `json:"annotations,omitempty"`
+	Tombstones  []parser.Tombstone    `json:"tombstones,omitempty"`
+}
+
+// Envelope is a Batch sealed for one recipient with NaCl box
+// (X25519, XSalsa20-Poly1305). Only the recipient can read it, and since box
+// authenticates the sender's static key, the recipient also learns that the
+// holder of Sender produced it. Anything in between, a relay or a peer that
+// stores and forwards, only sees the two public keys.
+type Envelope struct {
+	Sender    keys.Key `json:"sender"`    // public key of the origin machine
+	Recipient keys.Key `json:"recipient"` // public key the batch is sealed to
+	Nonce     []byte   `json:"nonce"`
+	Box       []byte   `json:"box"`
+}
+
+// Seal encrypts b for recipientPublicKey and authenticates it with the origin
+// machine's senderPrivateKey.
+func Seal(b Batch, senderPrivateKey, recipientPublicKey keys.Key) (Envelope, error) {
+	if err := b.check(); err != nil {
+		return Envelope{}, err
+	}
+	senderPublicKey, err := senderPrivateKey.PublicKey()
+	if err != nil {
+		return Envelope{}, err
+	}
+	payload, err := json.Marshal(b)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("encode history batch: %w", err)
+	}
+
+	var nonce [nonceSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return Envelope{}, fmt.Errorf("generate nonce: %w", err)
+	}
+	sealed := box.Seal(nil, payload, &nonce,
+		(*[keys.KeySize]byte)(&recipientPublicKey), (*[keys.KeySize]byte)(&senderPrivateKey))
+
+	return Envelope{
+		Sender:    senderPublicKey,
+		Recipient: recipientPublicKey,
+		Nonce:     nonce[:],
+		Box:       sealed,
+	}, nil
+}
+
+// Open decrypts the envelope with the recipient's private key. The caller must
+// still check that e.Sender is the machine the batch claims to come from.
+func (e Envelope) Open(recipientPrivateKey keys.Key) (Batch, error) {
+	if len(e.Nonce) != nonceSize {
+		return Batch{}, ErrOpen
+	}
+	payload, ok := box.Open(nil, e.Box, (*[nonceSize]byte)(e.Nonce),
+		(*[keys.KeySize]byte)(&e.Sender), (*[keys.KeySize]byte)(&recipientPrivateKey))
+	if !ok {
+		return Batch{}, ErrOpen
+	}
+
+	var b Batch
+	if err := json.Unmarshal(payload, &b); err != nil {
+		return Batch{}, fmt.Errorf("decode history batch: %w", err)
+	}
+	if err := b.check(); err != nil {
+		return Batch{}, err
+	}
+	return b, nil
+}
+
+// check makes sure every entry and annotation was produced by the batch's
+// machine
+func (b Batch) check() error {
+	if b.MachineID == "" {
+		return errors.New("history batch has no machine ID")
+	}
+	for _, entry := range b.Entries {
+		if entry.MachineID != b.MachineID {
+			return fmt.Errorf("%w: %q in a batch from %q", ErrMachineMismatch, entry.MachineID, b.MachineID)
+		}
+	}
+	for _, a := range b.Annotations {
+		if a.MachineID != b.MachineID {
+			return fmt.Errorf("%w: annotation by %q in a batch from %q", ErrMachineMismatch, a.MachineID, b.MachineID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package envelope
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+func newKeyPair(t *testing.T) (priv, pub keys.Key) {
+	priv, err := keys.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err = priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pub
+}
+
+func testBatch() Batch {
+	return Batch{
+		MachineID: "laptop",
+		Entries: []parser.HistoryEntry{
+			{Timestamp: 1700000000, MachineID: "laptop", Command: "export TOKEN=hunter2"},
+		},
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	senderPriv, senderPub := newKeyPair(t)
+	recipientPriv, recipientPub := newKeyPair(t)
+	relayPriv, _ := newKeyPair(t)
+
+	env, err := Seal(testBatch(), senderPriv, recipientPub)
+	if err != nil {
+		t.Fatalf("Failed to seal batch: %v", err)
+	}
+	if env.Sender != senderPub || env.Recipient != recipientPub {
+		t.Errorf("Envelope does not name the sender and recipient")
+	}
+
+	got, err := env.Open(recipientPriv)
+	if err != nil {
+		t.Fatalf("Failed to open batch: %v", err)
+	}
+	if got.MachineID != "laptop" || len(got.Entries) != 1 || got.Entries[0].Command != "export TOKEN=hunter2" {
+		t.Errorf("Unexpected batch: %+v", got)
+	}
+
+	if _, err := env.Open(relayPriv); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected a relay to be unable to open the batch, got: %v", err)
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	senderPriv, _ := newKeyPair(t)
+	recipientPriv, recipientPub := newKeyPair(t)
+	_, impostorPub := newKeyPair(t)
+
+	env, err := Seal(testBatch(), senderPriv, recipientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := env
+	tampered.Box = append([]byte(nil), env.Box...)
+	tampered.Box[len(tampered.Box)-1] ^= 1
+	if _, err := tampered.Open(recipientPriv); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected a modified payload to be rejected, got: %v", err)
+	}
+
+	spoofed := env
+	spoofed.Sender = impostorPub
+	if _, err := spoofed.Open(recipientPriv); !errors.Is(err, ErrOpen) {
+		t.Errorf("Expected a substituted sender to be rejected, got: %v", err)
+	}
+}
+
+func TestSealRejectsForeignEntries(t *testing.T) {
+	senderPriv, _ := newKeyPair(t)
+	_, recipientPub := newKeyPair(t)
+
+	b := testBatch()
+	b.Entries = append(b.Entries, parser.HistoryEntry{MachineID: "server", Command: "ls"})
+	if _, err := Seal(b, senderPriv, recipientPub); !errors.Is(err, ErrMachineMismatch) {
+		t.Errorf("Expected ErrMachineMismatch, got: %v", err)
+	}
+
+	b = testBatch()
+	b.Annotations = []parser.Annotation{{Hash: "abc", MachineID: "server", Tags: []string{"deploy"}}}
+	if _, err := Seal(b, senderPriv, recipientPub); !errors.Is(err, ErrMachineMismatch) {
+		t.Errorf("Expected ErrMachineMismatch for an annotation, got: %v", err)
+	}
+}
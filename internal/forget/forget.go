@@ -0,0 +1,294 @@
+// Package forget deletes commands everywhere, such as a leaked secret. The
+// matching entries are replaced by signed tombstones that peers apply, and
+// the commands are removed from the shell history file.
+package forget
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"github.com/TheRealSibasishBehera/syncsh/pkg/utils"
+)
+
+// ErrHistoryChanged is returned when the history file no longer holds the
+// commands a preview listed
+var ErrHistoryChanged = errors.New("the history file changed since the preview, run forget again")
+
+// Request selects the commands to forget
+type Request struct {
+	Match     string `json:"match"`                // regular expression the commands match
+	Since     int64  `json:"since,omitempty"`      // only commands run at or after this Unix time
+	MachineID string `json:"machine_id,omitempty"` // only commands of this machine, every machine if empty
+}
+
+// Record is one command of the history file, which may span several lines
+type Record struct {
+	Offset int64  `json:"offset"` // where it starts in the file
+	Text   string `json:"text"`
+}
+
+// Selection is exactly what a confirmed forget deletes: the entries and
+// history file records a preview listed
+type Selection struct {
+	Hashes  []string `json:"hashes"`
+	Records []Record `json:"records,omitempty"`
+}
+
+// History is the shell history file of this machine
+type History struct {
+	MachineID string
+	Path      string
+	Shell     string
+	BackupDir string // where the file is copied before it is rewritten
+}
+
+// HistoryOf returns the history file cfg syncs. Backups go next to the
+// history store.
+func HistoryOf(cfg *config.Config) History {
+	shell := string(cfg.Shell)
+	if shell == "" {
+		shell, _ = utils.GetShellKind()
+	}
+	backupDir, err := filepath.Abs(filepath.Join(filepath.Dir(cfg.SQLitePath), "backups"))
+	if err != nil {
+		backupDir = filepath.Join(filepath.Dir(cfg.SQLitePath), "backups")
+	}
+	return History{
+		MachineID: cfg.MachineID,
+		Path:      filepath.Clean(cfg.GetResolvedHistoryPath()),
+		Shell:     shell,
+		BackupDir: backupDir,
+	}
+}
+
+// Plan is what forgetting deletes
+type Plan struct {
+	Entries []parser.HistoryEntry
+	Records []Record // commands removed from the history file
+	History History
+}
+
+// NewPlan finds the entries req selects and the commands to remove from the
+// history file. The history file is left alone unless req covers this
+// machine.
+func NewPlan(ctx context.Context, st *store.Store, h History, req Request) (*Plan, error) {
+	re, err := regexp.Compile(req.Match)
+	if err != nil {
+		return nil, fmt.Errorf("invalid match: %w", err)
+	}
+	var after int64
+	if req.Since > 0 {
+		after = req.Since - 1
+	}
+	entries, err := st.ListEntries(ctx, req.MachineID, after, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list history: %w", err)
+	}
+	p := &Plan{History: h}
+	for _, e := range entries {
+		if re.MatchString(e.Command) {
+			p.Entries = append(p.Entries, e)
+		}
+	}
+
+	if req.MachineID == "" || req.MachineID == h.MachineID {
+		if p.Records, err = matchRecords(h.Path, h.Shell, func(command string, timestamp int64) bool {
+			return re.MatchString(command) && (req.Since == 0 || timestamp == 0 || timestamp >= req.Since)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// SelectPlan plans to delete exactly what sel names. Entries deleted since
+// the preview are skipped.
+func SelectPlan(ctx context.Context, st *store.Store, h History, sel Selection) (*Plan, error) {
+	p := &Plan{History: h, Records: sel.Records}
+	for _, hash := range sel.Hashes {
+		e, err := st.GetEntryByHash(ctx, hash)
+		if errors.Is(err, store.ErrEntryNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.Entries = append(p.Entries, e)
+	}
+	return p, nil
+}
+
+// Selection returns what the plan deletes, to confirm it with SelectPlan
+func (p *Plan) Selection() Selection {
+	sel := Selection{Hashes: make([]string, len(p.Entries)), Records: p.Records}
+	for i, e := range p.Entries {
+		sel.Hashes[i] = e.Hash
+	}
+	return sel
+}
+
+// Commands returns how many commands are removed from the history file
+func (p *Plan) Commands() int {
+	return len(p.Records)
+}
+
+// Apply deletes the entries and removes the commands from the history file,
+// which is rewritten in place through f, or opened if f is nil. It returns
+// the path of the history file's backup, empty if the file was left alone.
+//
+// A running daemon must not read the history file meanwhile: the file is
+// rewritten before its read offset is lowered.
+func (p *Plan) Apply(ctx context.Context, st *store.Store, signingKey keys.Key, f *os.File, now time.Time) (string, error) {
+	for _, e := range p.Entries {
+		// Storing the tombstone deletes the entry with its tags and notes in
+		// one transaction, and keeps peers from sending it back
+		tombstone := parser.Tombstone{Hash: e.Hash, DeletedAt: now.Unix(), MachineID: p.History.MachineID}
+		provenance.SignTombstone(&tombstone, signingKey)
+		if _, err := st.PutTombstone(ctx, tombstone); err != nil {
+			return "", fmt.Errorf("failed to record the deletion of entry %d: %w", e.ID, err)
+		}
+	}
+	if len(p.Records) == 0 {
+		return "", nil
+	}
+	if f == nil {
+		var err error
+		if f, err = os.OpenFile(p.History.Path, os.O_RDWR, 0); err != nil {
+			return "", fmt.Errorf("failed to open history file: %w", err)
+		}
+		defer f.Close()
+	}
+	return rewrite(ctx, st, p.History, f, p.Records, now)
+}
+
+// matchRecords reads the history file at path and returns the records with
+// a command drop selects. A missing file has nothing to remove.
+func matchRecords(path, shell string, drop func(command string, timestamp int64) bool) ([]Record, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+	p, err := parser.NewParser(shell, path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	trimmed := make([]string, len(lines))
+	for i, line := range lines {
+		trimmed[i] = strings.TrimSuffix(line, "\n")
+	}
+
+	// Records hold the trimmed lines in order, so the raw lines are
+	// consumed alongside them
+	var (
+		records []Record
+		offset  int64
+		next    int
+	)
+	for _, record := range parser.Records(shell, trimmed) {
+		raw := strings.Join(lines[next:next+len(record)], "")
+		next += len(record)
+		for _, line := range record {
+			if command, timestamp, skip := p.ParseLine(line); !skip && drop(command, timestamp) {
+				records = append(records, Record{Offset: offset, Text: raw})
+				break
+			}
+		}
+		offset += int64(len(raw))
+	}
+	return records, nil
+}
+
+// remove returns data without records, which must still be where they were
+// found. Records are in file order.
+func remove(data []byte, records []Record) ([]byte, error) {
+	var (
+		out  []byte
+		prev int64
+	)
+	for _, r := range records {
+		end := r.Offset + int64(len(r.Text))
+		if r.Offset < prev || end > int64(len(data)) || string(data[r.Offset:end]) != r.Text {
+			return nil, ErrHistoryChanged
+		}
+		out = append(out, data[prev:r.Offset]...)
+		prev = end
+	}
+	return append(out, data[prev:]...), nil
+}
+
+// offset maps an offset into the file before records were removed to the
+// rewritten file
+func offset(original int64, records []Record) int64 {
+	removed := int64(0)
+	for _, r := range records {
+		if r.Offset >= original {
+			break
+		}
+		removed += min(int64(len(r.Text)), original-r.Offset)
+	}
+	return original - removed
+}
+
+// rewrite copies the history file to a backup in h.BackupDir and writes it
+// back through f without records. The file is rewritten in place, so the
+// daemon only needs f rather than write access to the file's directory.
+// The stored read offset is lowered once the file is rewritten, so reading
+// continues from the same command. It returns the backup's path.
+func rewrite(ctx context.Context, st *store.Store, h History, f *os.File, records []Record, now time.Time) (string, error) {
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<62))
+	if err != nil {
+		return "", fmt.Errorf("failed to read history file: %w", err)
+	}
+	rewritten, err := remove(data, records)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(h.BackupDir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	backup := filepath.Join(h.BackupDir, filepath.Base(h.Path)+"."+now.Format("20060102-150405")+".bak")
+	if err := os.WriteFile(backup, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to back up history file: %w", err)
+	}
+
+	_, err = f.WriteAt(rewritten, 0)
+	if err == nil {
+		err = f.Truncate(int64(len(rewritten)))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to rewrite history file, the original is in %s: %w", backup, err)
+	}
+
+	stored, err := st.GetHistoryOffset(ctx, h.Path)
+	if err != nil {
+		return "", err
+	}
+	if err := st.SetHistoryOffset(ctx, h.Path, offset(stored, records)); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
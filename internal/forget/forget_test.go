@@ -0,0 +1,170 @@
+package forget
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+func TestRewrite(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	h := History{Path: filepath.Join(dir, ".bash_history"), Shell: "bash", BackupDir: filepath.Join(dir, "backups")}
+	original := "ls\n#1700000000\nexport TOKEN=secret\n#1700000100\nmake\nexport TOKEN=other\ngit sta"
+	if err := os.WriteFile(h.Path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := matchRecords(h.Path, h.Shell, func(command string, timestamp int64) bool {
+		return strings.Contains(command, "TOKEN") && (timestamp == 0 || timestamp >= 1700000000)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0] != (Record{Offset: 3, Text: "#1700000000\nexport TOKEN=secret\n"}) {
+		t.Fatalf("Matched records %+v", records)
+	}
+
+	// Offsets at the end of each command of the original
+	for original, rewritten := range map[int64]int64{0: 0, 3: 3, 35: 3, 52: 20, 71: 20, 78: 27} {
+		if got := offset(original, records); got != rewritten {
+			t.Errorf("offset(%d) = %d, want %d", original, got, rewritten)
+		}
+	}
+
+	st, err := store.Open(ctx, filepath.Join(dir, "syncsh.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if err := st.SetHistoryOffset(ctx, h.Path, 71); err != nil {
+		t.Fatal(err)
+	}
+	// A command appended since the preview stays
+	if err := os.WriteFile(h.Path, []byte(original+"\nexport TOKEN=new\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(h.Path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	before, _ := os.Stat(h.Path)
+	backup, err := rewrite(ctx, st, h, f, records, time.Date(2026, 10, 18, 22, 5, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "ls\n#1700000100\nmake\ngit sta\nexport TOKEN=new\n"
+	if got, _ := os.ReadFile(h.Path); string(got) != want {
+		t.Errorf("History file = %q, want %q", got, want)
+	}
+	if after, _ := os.Stat(h.Path); !os.SameFile(before, after) {
+		t.Error("History file was replaced rather than rewritten in place")
+	}
+	if got, _ := os.ReadFile(backup); string(got) != original+"\nexport TOKEN=new\n" || backup != filepath.Join(h.BackupDir, ".bash_history.20261018-220500.bak") {
+		t.Errorf("Backup %s = %q, want the original", backup, got)
+	}
+	if offset, _ := st.GetHistoryOffset(ctx, h.Path); offset != 20 {
+		t.Errorf("Stored offset = %d, want 20", offset)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, ".bash_history*")); len(names) != 1 {
+		t.Errorf("History directory holds %v, want only the history file", names)
+	}
+
+	// The records are gone now, so applying them again fails
+	if _, err := rewrite(ctx, st, h, f, records, time.Now()); !errors.Is(err, ErrHistoryChanged) {
+		t.Errorf("Rewrite of a changed file = %v, want ErrHistoryChanged", err)
+	}
+	if records, err := matchRecords(filepath.Join(dir, "missing"), "zsh", nil); err != nil || len(records) != 0 {
+		t.Errorf("Records of a missing file = %+v, %v", records, err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	st, err := store.Open(ctx, filepath.Join(dir, "syncsh.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	seed, pub, err := keys.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []parser.HistoryEntry{
+		{Timestamp: 1700000000, MachineID: "laptop", Command: "export TOKEN=secret"},
+		{Timestamp: 1700000100, MachineID: "laptop", Command: "make"},
+		{Timestamp: 1700000200, MachineID: "desktop", Command: "export TOKEN=other"},
+	} {
+		if err := st.CreateEntry(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := History{MachineID: "laptop", Path: filepath.Join(dir, ".bash_history"), Shell: "bash", BackupDir: dir}
+	if err := os.WriteFile(h.Path, []byte("export TOKEN=secret\nmake\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Commands of a peer leave the history file alone
+	p, err := NewPlan(ctx, st, h, Request{Match: "TOKEN", MachineID: "desktop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Entries) != 1 || p.Entries[0].MachineID != "desktop" || p.Commands() != 0 {
+		t.Fatalf("Plan for desktop = %d entries and %d commands, want 1 and 0", len(p.Entries), p.Commands())
+	}
+
+	preview, err := NewPlan(ctx, st, h, Request{Match: "TOKEN", Since: 1700000100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Entries) != 1 || preview.Entries[0].MachineID != "desktop" || preview.Commands() != 1 {
+		t.Fatalf("Plan since 1700000100 = %d entries and %d commands, want 1 and 1", len(preview.Entries), preview.Commands())
+	}
+
+	// Only what the preview listed is deleted, not what matches now
+	later := parser.HistoryEntry{Timestamp: 1700000300, MachineID: "desktop", Command: "export TOKEN=later"}
+	if err := st.CreateEntry(ctx, &later); err != nil {
+		t.Fatal(err)
+	}
+	p, err = SelectPlan(ctx, st, h, preview.Selection())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Entries) != 1 || p.Entries[0].Hash != preview.Entries[0].Hash {
+		t.Fatalf("Selected plan = %+v, want the previewed entry", p.Entries)
+	}
+	if _, err := p.Apply(ctx, st, seed, nil, time.Unix(1700001000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.GetEntryByHash(ctx, p.Entries[0].Hash); err == nil {
+		t.Error("Forgotten entry is still stored")
+	}
+	tombstones, err := st.ListTombstones(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tombstones) != 1 || tombstones[0].Hash != p.Entries[0].Hash || provenance.VerifyTombstone(tombstones[0], pub) != nil {
+		t.Errorf("Tombstones = %+v, want one signed by laptop", tombstones)
+	}
+	if got, _ := os.ReadFile(h.Path); string(got) != "make\n" {
+		t.Errorf("History file = %q, want the secret removed", got)
+	}
+	if _, err := st.GetEntryByHash(ctx, later.Hash); err != nil {
+		t.Errorf("Entry added after the preview was deleted: %v", err)
+	}
+
+	if _, err := NewPlan(ctx, st, h, Request{Match: "("}); err == nil {
+		t.Error("Plan with an invalid match succeeded")
+	}
+}
@@ -0,0 +1,47 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+// Ingester records commands run on this machine. Every entry is stamped with
+// the machine ID and signed before it reaches the store, so it can be
+// verified wherever it is synchronized to.
+type Ingester struct {
+	store      *store.Store
+	machineID  string
+	signingKey keys.Key
+}
+
+func New(st *store.Store, machineID string, signingKey keys.Key) *Ingester {
+	return &Ingester{
+		store:      st,
+		machineID:  machineID,
+		signingKey: signingKey,
+	}
+}
+
+// Ingest stores entries and returns how many of them were new
+func (i *Ingester) Ingest(ctx context.Context, entries []parser.HistoryEntry) (int, error) {
+	stored := 0
+	for _, entry := range entries {
+		entry.ID, entry.Hash = 0, ""
+		entry.MachineID = i.machineID
+		provenance.Sign(&entry, i.signingKey)
+
+		if err := i.store.CreateEntry(ctx, &entry); err != nil {
+			if errors.Is(err, store.ErrDuplicateHash) || errors.Is(err, store.ErrDeletedEntry) {
+				continue
+			}
+			return stored, err
+		}
+		stored++
+	}
+	return stored, nil
+}
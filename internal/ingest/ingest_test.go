@@ -0,0 +1,64 @@
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestIngestSignsEntries(t *testing.T) {
+	ctx := context.Background()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+	st := store.New(db)
+	if err := st.InitSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	seed, pub, err := keys.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ing := New(st, "laptop", seed)
+
+	entries := []parser.HistoryEntry{
+		{Timestamp: 1700000000, Command: "go test ./..."},
+		{Timestamp: 1700000001, Command: "git commit", MachineID: "spoofed"},
+	}
+	n, err := ing.Ingest(ctx, entries)
+	if err != nil {
+		t.Fatalf("Failed to ingest: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Stored %d entries, want 2", n)
+	}
+	if n, _ := ing.Ingest(ctx, entries); n != 0 {
+		t.Errorf("Re-ingesting stored %d duplicates", n)
+	}
+
+	got, err := st.ListEntries(ctx, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Store has %d entries, want 2", len(got))
+	}
+	for _, e := range got {
+		if e.MachineID != "laptop" {
+			t.Errorf("Entry %q has machine ID %q, want laptop", e.Command, e.MachineID)
+		}
+		if err := provenance.Verify(e, pub); err != nil {
+			t.Errorf("Entry %q: %v", e.Command, err)
+		}
+	}
+}
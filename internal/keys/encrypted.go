@@ -0,0 +1,117 @@
+package keys
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	kdfArgon2id = "argon2id"
+
+	// argon2id parameters recommended by RFC 9106 for memory constrained environments
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	saltSize      = 16
+)
+
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted key file")
+
+// EncryptedFileStore keeps each key in its own file, sealed with
+// XChaCha20-Poly1305 under a key derived from a passphrase with argon2id.
+type EncryptedFileStore struct {
+	Dir        string
+	Passphrase PassphraseFunc
+}
+
+// sealedKey is the on-disk format of an encrypted key. The KDF parameters are
+// stored alongside the ciphertext so they can be raised without breaking
+// existing files.
+type sealedKey struct {
+	KDF        string `json:"kdf"`
+	Time       uint32 `json:"time"`
+	Memory     uint32 `json:"memory"`
+	Threads    uint8  `json:"threads"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func (s *EncryptedFileStore) Get(name string) (Key, error) {
+	data, err := readKeyFile(s.Dir, name, encryptedKeyExt)
+	if err != nil {
+		return Key{}, err
+	}
+
+	var sealed sealedKey
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return Key{}, fmt.Errorf("parse encrypted key %s: %w", name, err)
+	}
+	if sealed.KDF != kdfArgon2id {
+		return Key{}, fmt.Errorf("encrypted key %s uses unsupported KDF %q", name, sealed.KDF)
+	}
+
+	passphrase, err := s.Passphrase()
+	if err != nil {
+		return Key{}, fmt.Errorf("read passphrase: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, sealed.Salt,
+		sealed.Time, sealed.Memory, sealed.Threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return Key{}, err
+	}
+	if len(sealed.Nonce) != aead.NonceSize() {
+		return Key{}, fmt.Errorf("encrypted key %s: %w", name, ErrWrongPassphrase)
+	}
+
+	key, err := aead.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(name))
+	if err != nil {
+		return Key{}, fmt.Errorf("decrypt key %s: %w", name, ErrWrongPassphrase)
+	}
+	return NewKey(key)
+}
+
+func (s *EncryptedFileStore) Put(name string, key Key) error {
+	passphrase, err := s.Passphrase()
+	if err != nil {
+		return fmt.Errorf("read passphrase: %w", err)
+	}
+
+	sealed := sealedKey{
+		KDF:     kdfArgon2id,
+		Time:    argon2Time,
+		Memory:  argon2Memory,
+		Threads: argon2Threads,
+		Salt:    make([]byte, saltSize),
+		Nonce:   make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return fmt.Errorf("generate salt: %w", err)
+	}
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(passphrase, sealed.Salt,
+		sealed.Time, sealed.Memory, sealed.Threads, chacha20poly1305.KeySize))
+	if err != nil {
+		return err
+	}
+	// The key name is bound as associated data so files can't be swapped.
+	sealed.Ciphertext = aead.Seal(nil, sealed.Nonce, key[:], []byte(name))
+
+	data, err := json.Marshal(sealed)
+	if err != nil {
+		return fmt.Errorf("encode encrypted key %s: %w", name, err)
+	}
+	return writeKeyFile(s.Dir, name, encryptedKeyExt, append(data, '\n'))
+}
+
+func (s *EncryptedFileStore) Delete(name string) error {
+	return deleteKeyFile(s.Dir, name, encryptedKeyExt)
+}
@@ -0,0 +1,124 @@
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// KeySize is the length of X25519 keys used by WireGuard
+const KeySize = 32
+
+var (
+	ErrKeyLength     = fmt.Errorf("key must be %d bytes", KeySize)
+	ErrKeyNotClamped = errors.New("private key is not a clamped X25519 scalar")
+)
+
+// Key is a WireGuard (X25519) private or public key, or an Ed25519 signing
+// key seed or public key. Its text form is standard base64, the same encoding
+// wg(8) uses, so keys can be copied between syncsh and WireGuard tooling. Hex
+// is accepted when parsing for configs written by older versions.
+type Key [KeySize]byte
+
+// GenerateKey returns a new random, clamped private key
+func GenerateKey() (Key, error) {
+	var k Key
+	if _, err := rand.Read(k[:]); err != nil {
+		return Key{}, fmt.Errorf("generate key: %w", err)
+	}
+	k.clamp()
+	return k, nil
+}
+
+// NewKey copies b into a Key
+func NewKey(b []byte) (Key, error) {
+	var k Key
+	if len(b) != KeySize {
+		return Key{}, fmt.Errorf("%w, got %d", ErrKeyLength, len(b))
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// ParseKey parses a base64 or hex encoded key. Its errors never include the
+// input, which may be a private key.
+func ParseKey(s string) (Key, error) {
+	var (
+		b        []byte
+		err      error
+		encoding string
+	)
+	switch len(s) {
+	case base64.StdEncoding.EncodedLen(KeySize):
+		b, err = base64.StdEncoding.DecodeString(s)
+		encoding = "base64"
+	case hex.EncodedLen(KeySize):
+		b, err = hex.DecodeString(s)
+		encoding = "hex"
+	default:
+		return Key{}, fmt.Errorf("invalid key: got %d characters, expected %d base64 or %d hex characters",
+			len(s), base64.StdEncoding.EncodedLen(KeySize), hex.EncodedLen(KeySize))
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid key: not valid %s", encoding)
+	}
+	return NewKey(b)
+}
+
+// String returns the base64 encoding of the key
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// Hex returns the hex encoding of the key, as used by the WireGuard UAPI
+func (k Key) Hex() string {
+	return hex.EncodeToString(k[:])
+}
+
+// IsZero reports whether the key is unset
+func (k Key) IsZero() bool {
+	return k == Key{}
+}
+
+// ValidatePrivate checks that k is usable as an X25519 private key
+func (k Key) ValidatePrivate() error {
+	if k.IsZero() {
+		return errors.New("private key is empty")
+	}
+	if k[0]&7 != 0 || k[31]&0x80 != 0 || k[31]&0x40 == 0 {
+		return ErrKeyNotClamped
+	}
+	return nil
+}
+
+// PublicKey derives the public key for the private key k
+func (k Key) PublicKey() (Key, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(k[:])
+	if err != nil {
+		return Key{}, fmt.Errorf("parse private key: %w", err)
+	}
+	return NewKey(priv.PublicKey().Bytes())
+}
+
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *Key) UnmarshalText(text []byte) error {
+	parsed, err := ParseKey(string(text))
+	if err != nil {
+		return err
+	}
+	*k = parsed
+	return nil
+}
+
+// clamp turns random bytes into a valid X25519 scalar (RFC 7748, section 5)
+func (k *Key) clamp() {
+	k[0] &= 248
+	k[31] &= 127
+	k[31] |= 64
+}
@@ -0,0 +1,138 @@
+package keys
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+func TestParseKey(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		input       string
+		expectError bool
+	}{
+		{name: "base64", input: key.String()},
+		{name: "hex", input: key.Hex()},
+		{name: "empty", input: "", expectError: true},
+		{name: "short base64", input: key.String()[:40], expectError: true},
+		{name: "bad base64", input: strings.Repeat("!", 44), expectError: true},
+		{name: "bad hex", input: strings.Repeat("zz", KeySize), expectError: true},
+		{name: "raw bytes", input: string(key[:]), expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				} else if tt.input != "" && strings.Contains(err.Error(), tt.input) {
+					t.Errorf("error %q includes the input", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error but got: %v", err)
+			}
+			if got != key {
+				t.Errorf("Key mismatch: got %s, want %s", got, key)
+			}
+		})
+	}
+}
+
+func TestNewKeyLength(t *testing.T) {
+	if _, err := NewKey(make([]byte, 31)); !errors.Is(err, ErrKeyLength) {
+		t.Errorf("Expected ErrKeyLength, got: %v", err)
+	}
+}
+
+func TestKeyMarshalRoundTrip(t *testing.T) {
+	type doc struct {
+		PublicKey Key  `yaml:"public_key" json:"public_key"`
+		Optional  *Key `yaml:"optional,omitempty" json:"optional,omitempty"`
+	}
+	key, _ := GenerateKey()
+	in := doc{PublicKey: key}
+
+	t.Run("yaml", func(t *testing.T) {
+		data, err := yaml.Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), key.String()) {
+			t.Errorf("YAML should hold the base64 key, got:\n%s", data)
+		}
+		var out doc
+		if err := yaml.Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.PublicKey != key {
+			t.Errorf("Key mismatch: got %s, want %s", out.PublicKey, key)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		data, err := json.Marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `"public_key":"` + key.String() + `"`; !strings.Contains(string(data), want) {
+			t.Errorf("JSON should hold the base64 key, got: %s", data)
+		}
+		var out doc
+		if err := json.Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.PublicKey != key {
+			t.Errorf("Key mismatch: got %s, want %s", out.PublicKey, key)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var out doc
+		if err := yaml.Unmarshal([]byte("public_key: dG9vIHNob3J0\n"), &out); err == nil {
+			t.Error("Expected a short key to be rejected")
+		}
+	})
+}
+
+func TestValidatePrivate(t *testing.T) {
+	key, _ := GenerateKey()
+	if err := key.ValidatePrivate(); err != nil {
+		t.Errorf("Generated key should be valid: %v", err)
+	}
+
+	unclamped := key
+	unclamped[0] |= 7
+	if err := unclamped.ValidatePrivate(); !errors.Is(err, ErrKeyNotClamped) {
+		t.Errorf("Expected ErrKeyNotClamped, got: %v", err)
+	}
+	if err := (Key{}).ValidatePrivate(); err == nil {
+		t.Error("Expected the zero key to be rejected")
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	// Alice's key pair from RFC 7748, section 6.1
+	priv, err := ParseKey("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := pub.Hex(), "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a"; got != want {
+		t.Errorf("Public key mismatch: got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+//go:build linux
+
+package keys
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+const keyringPrefix = "syncsh"
+
+// KeyringStore caches keys in the user's persistent kernel keyring. The
+// kernel keeps that keyring in memory only: it is gone after a reboot and
+// expires after a few days without use (see persistent-keyring(7)). Keys are
+// therefore written to a durable store first, and read back from it into the
+// keyring whenever the keyring lost them.
+type KeyringStore struct {
+	prefix  string
+	ring    int
+	durable KeyStore
+}
+
+// NewKeyringStore opens the persistent keyring of the current user in front
+// of durable. Keys are cached as "user" keys described as "<prefix>:<name>".
+// A nil durable store only serves the keys already in the keyring, left by
+// versions that kept no other copy, and refuses new ones.
+func NewKeyringStore(prefix string, durable KeyStore) (*KeyringStore, error) {
+	if prefix == "" {
+		prefix = keyringPrefix
+	}
+	ring, err := unix.KeyctlInt(unix.KEYCTL_GET_PERSISTENT, -1, unix.KEY_SPEC_USER_KEYRING, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open persistent keyring: %w", err)
+	}
+	return &KeyringStore{prefix: prefix, ring: ring, durable: durable}, nil
+}
+
+func (s *KeyringStore) description(name string) string {
+	return s.prefix + ":" + name
+}
+
+func (s *KeyringStore) Get(name string) (Key, error) {
+	key, err := s.cached(name)
+	if !errors.Is(err, ErrKeyNotFound) || s.durable == nil {
+		return key, err
+	}
+	if key, err = s.durable.Get(name); err != nil {
+		return Key{}, err
+	}
+	// Failing to cache only means the durable copy is read again next time
+	_ = s.cache(name, key)
+	return key, nil
+}
+
+// cached reads a key from the keyring
+func (s *KeyringStore) cached(name string) (Key, error) {
+	id, err := unix.KeyctlSearch(s.ring, "user", s.description(name), 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOKEY) {
+			return Key{}, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+		}
+		return Key{}, fmt.Errorf("search keyring for %s: %w", name, err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+	if err != nil {
+		return Key{}, fmt.Errorf("read key %s from keyring: %w", name, err)
+	}
+	if n > len(buf) {
+		return Key{}, fmt.Errorf("key %s in keyring is too large", name)
+	}
+	return NewKey(buf[:n])
+}
+
+func (s *KeyringStore) Put(name string, key Key) error {
+	if s.durable == nil {
+		return ErrNoDurableCopy
+	}
+	if err := s.durable.Put(name, key); err != nil {
+		return err
+	}
+	return s.cache(name, key)
+}
+
+// cache adds a key to the keyring
+func (s *KeyringStore) cache(name string, key Key) error {
+	if _, err := unix.AddKey("user", s.description(name), key[:], s.ring); err != nil {
+		return fmt.Errorf("add key %s to keyring: %w", name, err)
+	}
+	return nil
+}
+
+func (s *KeyringStore) Delete(name string) error {
+	if s.durable != nil {
+		if err := s.durable.Delete(name); err != nil {
+			return err
+		}
+	}
+	id, err := unix.KeyctlSearch(s.ring, "user", s.description(name), 0)
+	if err != nil {
+		if errors.Is(err, unix.ENOKEY) {
+			return nil
+		}
+		return fmt.Errorf("search keyring for %s: %w", name, err)
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_INVALIDATE, id, 0, 0, 0); err != nil {
+		return fmt.Errorf("delete key %s from keyring: %w", name, err)
+	}
+	return nil
+}
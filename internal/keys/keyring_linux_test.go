@@ -0,0 +1,65 @@
+//go:build linux
+
+package keys
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestKeyringStore(t *testing.T) {
+	durable := &EncryptedFileStore{Dir: t.TempDir(), Passphrase: staticPassphrase("correct horse")}
+	ks, err := NewKeyringStore("syncsh-test", durable)
+	if err != nil {
+		t.Skipf("kernel keyring not available: %v", err)
+	}
+
+	key, _ := GenerateKey()
+	if err := ks.Put(t.Name(), key); err != nil {
+		t.Skipf("kernel keyring not writable: %v", err)
+	}
+	t.Cleanup(func() { _ = ks.Delete(t.Name()) })
+
+	got, err := ks.Get(t.Name())
+	if err != nil {
+		t.Fatalf("Failed to get key: %v", err)
+	}
+	if got != key {
+		t.Errorf("Key mismatch: got %s, want %s", got, key)
+	}
+	if got, err := durable.Get(t.Name()); err != nil || got != key {
+		t.Errorf("Durable copy = %s, %v; want %s", got, err, key)
+	}
+
+	// The kernel drops the keyring on reboot or expiry
+	id, err := unix.KeyctlSearch(ks.ring, "user", ks.description(t.Name()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_INVALIDATE, id, 0, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ks.Get(t.Name()); err != nil || got != key {
+		t.Errorf("Get() after the keyring lost the key = %s, %v; want %s", got, err, key)
+	}
+
+	if err := ks.Delete(t.Name()); err != nil {
+		t.Fatalf("Failed to delete key: %v", err)
+	}
+	if _, err := ks.Get(t.Name()); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound after Delete, got: %v", err)
+	}
+}
+
+func TestKeyringStoreWithoutDurableCopy(t *testing.T) {
+	ks, err := NewKeyringStore("syncsh-test", nil)
+	if err != nil {
+		t.Skipf("kernel keyring not available: %v", err)
+	}
+	key, _ := GenerateKey()
+	if err := ks.Put(t.Name(), key); !errors.Is(err, ErrNoDurableCopy) {
+		t.Errorf("Put() without a durable store = %v, want ErrNoDurableCopy", err)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package keys
+
+import "errors"
+
+// KeyringStore is a stub for non-Linux systems
+type KeyringStore struct{}
+
+// NewKeyringStore is a stub for non-Linux systems
+func NewKeyringStore(prefix string, durable KeyStore) (*KeyringStore, error) {
+	return nil, errors.New("kernel keyring key storage is only supported on Linux")
+}
+
+func (s *KeyringStore) Get(name string) (Key, error) {
+	return Key{}, errors.ErrUnsupported
+}
+
+func (s *KeyringStore) Put(name string, key Key) error {
+	return errors.ErrUnsupported
+}
+
+func (s *KeyringStore) Delete(name string) error {
+	return errors.ErrUnsupported
+}
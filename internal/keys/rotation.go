@@ -0,0 +1,129 @@
+package keys
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/secret"
+)
+
+const rotationMACInfo = "syncsh key rotation v1"
+
+var (
+	ErrInvalidMAC   = errors.New("key rotation announcement has an invalid MAC")
+	ErrBrokenChain  = errors.New("key rotation announcements do not chain from the known key")
+	ErrUnknownChain = errors.New("no key rotation announcement starts from the known key")
+)
+
+// Rotation records that a machine replaced OldPublicKey with NewPublicKey.
+// The old key stays valid until GraceUntil so peers that were offline during
+// the rotation can still reach the machine and learn the new key.
+type Rotation struct {
+	OldPublicKey Key       `json:"old_public_key"`
+	NewPublicKey Key       `json:"new_public_key"`
+	RotatedAt    time.Time `json:"rotated_at"`
+	GraceUntil   time.Time `json:"grace_until"`
+}
+
+// Announcement is a Rotation authenticated for a single peer.
+// The MAC is keyed with the X25519 shared secret between the old private key
+// and the peer's public key, so only the holder of the old key can produce it
+// and only that peer can check it.
+type Announcement struct {
+	Rotation
+	MAC secret.Secret `json:"mac"`
+}
+
+// Announce authenticates r for the peer identified by peerPublicKey.
+func Announce(r Rotation, oldPrivateKey, peerPublicKey Key) (Announcement, error) {
+	key, err := rotationKey(oldPrivateKey, peerPublicKey)
+	if err != nil {
+		return Announcement{}, err
+	}
+	return Announcement{Rotation: r, MAC: r.mac(key)}, nil
+}
+
+// Verify checks the announcement MAC using the receiving peer's private key.
+func (a Announcement) Verify(localPrivateKey Key) error {
+	key, err := rotationKey(localPrivateKey, a.OldPublicKey)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(a.MAC, a.mac(key)) {
+		return ErrInvalidMAC
+	}
+	return nil
+}
+
+// VerifyChain walks announcements starting at the one whose old key is known
+// and returns the last announcement of the chain. Every link must be
+// authenticated by the key it replaces, so a peer that missed several
+// rotations can catch up in one go.
+func VerifyChain(announcements []Announcement, known, localPrivateKey Key) (Announcement, error) {
+	start := -1
+	for i, a := range announcements {
+		if a.OldPublicKey == known {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return Announcement{}, ErrUnknownChain
+	}
+
+	current := known
+	var last Announcement
+	for _, a := range announcements[start:] {
+		if a.OldPublicKey != current {
+			return Announcement{}, ErrBrokenChain
+		}
+		if err := a.Verify(localPrivateKey); err != nil {
+			return Announcement{}, err
+		}
+		current = a.NewPublicKey
+		last = a
+	}
+	return last, nil
+}
+
+// mac computes the HMAC over the canonical encoding of the rotation.
+func (r Rotation) mac(key []byte) secret.Secret {
+	h := hmac.New(sha256.New, key)
+	h.Write(r.OldPublicKey[:])
+	h.Write(r.NewPublicKey[:])
+	var ts [16]byte
+	binary.BigEndian.PutUint64(ts[:8], uint64(r.RotatedAt.Unix()))
+	binary.BigEndian.PutUint64(ts[8:], uint64(r.GraceUntil.Unix()))
+	h.Write(ts[:])
+	return h.Sum(nil)
+}
+
+// rotationKey derives the announcement MAC key from an X25519 key agreement.
+func rotationKey(privateKey, publicKey Key) ([]byte, error) {
+	return SharedKey(privateKey, publicKey, rotationMACInfo)
+}
+
+// SharedKey derives a key for the purpose named by info from the X25519
+// shared secret of privateKey and publicKey, which only the holders of
+// either private key can compute.
+func SharedKey(privateKey, publicKey Key, info string) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(privateKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(publicKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("derive shared secret: %w", err)
+	}
+	return hkdf.Key(sha256.New, shared, nil, info, sha256.Size)
+}
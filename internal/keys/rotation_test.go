@@ -0,0 +1,106 @@
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newKeyPair(t *testing.T) (priv, pub Key) {
+	priv, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err = priv.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return priv, pub
+}
+
+func TestAnnouncementVerify(t *testing.T) {
+	oldPriv, oldPub := newKeyPair(t)
+	_, newPub := newKeyPair(t)
+	peerPriv, peerPub := newKeyPair(t)
+	otherPriv, _ := newKeyPair(t)
+
+	now := time.Now()
+	rotation := Rotation{
+		OldPublicKey: oldPub,
+		NewPublicKey: newPub,
+		RotatedAt:    now,
+		GraceUntil:   now.Add(time.Hour),
+	}
+
+	a, err := Announce(rotation, oldPriv, peerPub)
+	if err != nil {
+		t.Fatalf("Failed to announce: %v", err)
+	}
+	if err := a.Verify(peerPriv); err != nil {
+		t.Errorf("Expected announcement to verify, got: %v", err)
+	}
+	if err := a.Verify(otherPriv); !errors.Is(err, ErrInvalidMAC) {
+		t.Errorf("Expected ErrInvalidMAC for a different peer, got: %v", err)
+	}
+
+	tampered := a
+	tampered.GraceUntil = now.Add(24 * time.Hour)
+	if err := tampered.Verify(peerPriv); !errors.Is(err, ErrInvalidMAC) {
+		t.Errorf("Expected ErrInvalidMAC for a tampered grace window, got: %v", err)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	peerPriv, peerPub := newKeyPair(t)
+	privs := make([]Key, 4)
+	pubs := make([]Key, 4)
+	for i := range privs {
+		privs[i], pubs[i] = newKeyPair(t)
+	}
+
+	now := time.Now()
+	var chain []Announcement
+	for i := 0; i < 3; i++ {
+		a, err := Announce(Rotation{
+			OldPublicKey: pubs[i],
+			NewPublicKey: pubs[i+1],
+			RotatedAt:    now,
+			GraceUntil:   now.Add(time.Hour),
+		}, privs[i], peerPub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chain = append(chain, a)
+	}
+
+	tests := []struct {
+		name    string
+		chain   []Announcement
+		known   Key
+		want    Key
+		wantErr error
+	}{
+		{name: "full chain", chain: chain, known: pubs[0], want: pubs[3]},
+		{name: "start in the middle", chain: chain, known: pubs[1], want: pubs[3]},
+		{name: "unknown start", chain: chain, known: peerPub, wantErr: ErrUnknownChain},
+		{name: "missing link", chain: []Announcement{chain[0], chain[2]}, known: pubs[0], wantErr: ErrBrokenChain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last, err := VerifyChain(tt.chain, tt.known, peerPriv)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Expected %v, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected chain to verify, got: %v", err)
+			}
+			if last.NewPublicKey != tt.want {
+				t.Errorf("Chain ended at %s, want %s", last.NewPublicKey, tt.want)
+			}
+		})
+	}
+}
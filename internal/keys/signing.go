@@ -0,0 +1,33 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+)
+
+// GenerateSigningKey returns a new Ed25519 key pair. The private key is kept
+// as its 32 byte seed so it fits a Key and any KeyStore.
+func GenerateSigningKey() (seed, publicKey Key, err error) {
+	if _, err := rand.Read(seed[:]); err != nil {
+		return Key{}, Key{}, fmt.Errorf("generate signing key: %w", err)
+	}
+	return seed, SigningPublicKey(seed), nil
+}
+
+// SigningPublicKey returns the Ed25519 public key for seed
+func SigningPublicKey(seed Key) Key {
+	var pub Key
+	copy(pub[:], ed25519.NewKeyFromSeed(seed[:]).Public().(ed25519.PublicKey))
+	return pub
+}
+
+// Sign signs msg with the Ed25519 key derived from seed
+func Sign(seed Key, msg []byte) []byte {
+	return ed25519.Sign(ed25519.NewKeyFromSeed(seed[:]), msg)
+}
+
+// VerifySignature reports whether sig is a valid signature of msg by publicKey
+func VerifySignature(publicKey Key, msg, sig []byte) bool {
+	return len(sig) == ed25519.SignatureSize && ed25519.Verify(publicKey[:], msg, sig)
+}
@@ -0,0 +1,180 @@
+package keys
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	StoreFile      = "file"
+	StoreEncrypted = "encrypted"
+	StoreKeyring   = "keyring"
+)
+
+const (
+	plainKeyExt     = ".key"
+	encryptedKeyExt = ".enc"
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found in key store")
+	// ErrNoDurableCopy is returned when keys would only be kept in the kernel
+	// keyring, which loses them on reboot
+	ErrNoDurableCopy = errors.New(`key store "keyring:" keeps no copy that survives a reboot, run syncsh keys migrate --to keyring`)
+)
+
+// KeyStore keeps private keys outside of config.yaml. Keys are addressed by
+// a name that the configuration references instead of holding the key itself.
+type KeyStore interface {
+	Get(name string) (Key, error)
+	Put(name string, key Key) error
+	Delete(name string) error
+}
+
+// PassphraseFunc returns the passphrase protecting an encrypted key store
+type PassphraseFunc func() ([]byte, error)
+
+// Open returns the key store described by ref. References have the form
+// "<kind>:<location>":
+//
+//	file:/home/me/.config/syncsh/keys       one 0600 file per key
+//	encrypted:/home/me/.config/syncsh/keys  one file per key, sealed with a passphrase
+//	keyring:/home/me/.config/syncsh/keyring sealed like encrypted, cached in the kernel keyring
+//
+// "keyring:" without a directory is what older versions wrote. Its keys are
+// only in the kernel keyring, so it can still be read but takes no new keys.
+func Open(ref string, passphrase PassphraseFunc) (KeyStore, error) {
+	kind, location, ok := strings.Cut(ref, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid key store reference %q", ref)
+	}
+
+	switch kind {
+	case StoreFile:
+		if location == "" {
+			return nil, fmt.Errorf("key store %q needs a directory", ref)
+		}
+		return &FileStore{Dir: location}, nil
+	case StoreEncrypted:
+		if location == "" {
+			return nil, fmt.Errorf("key store %q needs a directory", ref)
+		}
+		if passphrase == nil {
+			return nil, fmt.Errorf("key store %q needs a passphrase", ref)
+		}
+		return &EncryptedFileStore{Dir: location, Passphrase: passphrase}, nil
+	case StoreKeyring:
+		prefix, durable := keyringPrefix, KeyStore(nil)
+		if location != "" {
+			if passphrase == nil {
+				return nil, fmt.Errorf("key store %q needs a passphrase", ref)
+			}
+			prefix += ":" + location
+			durable = &EncryptedFileStore{Dir: location, Passphrase: passphrase}
+		}
+		ks, err := NewKeyringStore(prefix, durable)
+		if err != nil {
+			return nil, err
+		}
+		return ks, nil
+	default:
+		return nil, fmt.Errorf("unsupported key store %q (supported: file, encrypted, keyring)", kind)
+	}
+}
+
+// FileStore keeps each key in its own file, readable only by the owner.
+// Keys are written in base64 like wg(8) does; hex files from older versions
+// are still read.
+type FileStore struct {
+	Dir string
+}
+
+func (s *FileStore) Get(name string) (Key, error) {
+	data, err := readKeyFile(s.Dir, name, plainKeyExt)
+	if err != nil {
+		return Key{}, err
+	}
+	key, err := ParseKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return Key{}, fmt.Errorf("key file %s: %w", name, err)
+	}
+	return key, nil
+}
+
+func (s *FileStore) Put(name string, key Key) error {
+	return writeKeyFile(s.Dir, name, plainKeyExt, []byte(key.String()+"\n"))
+}
+
+func (s *FileStore) Delete(name string) error {
+	return deleteKeyFile(s.Dir, name, plainKeyExt)
+}
+
+func keyPath(dir, name, ext string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid key name %q", name)
+	}
+	return filepath.Join(dir, name+ext), nil
+}
+
+func readKeyFile(dir, name, ext string) ([]byte, error) {
+	path, err := keyPath(dir, name, ext)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+		}
+		return nil, fmt.Errorf("read key file '%s': %w", path, err)
+	}
+	return data, nil
+}
+
+// writeKeyFile atomically replaces the key file so a crash never leaves a
+// truncated key behind.
+func writeKeyFile(dir, name, ext string, data []byte) error {
+	path, err := keyPath(dir, name, ext)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create key directory '%s': %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+name+"-*")
+	if err != nil {
+		return fmt.Errorf("write key file '%s': %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write key file '%s': %w", path, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write key file '%s': %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write key file '%s': %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write key file '%s': %w", path, err)
+	}
+	return nil
+}
+
+func deleteKeyFile(dir, name, ext string) error {
+	path, err := keyPath(dir, name, ext)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("delete key file '%s': %w", path, err)
+	}
+	return nil
+}
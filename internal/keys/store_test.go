@@ -0,0 +1,160 @@
+package keys
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func staticPassphrase(p string) PassphraseFunc {
+	return func() ([]byte, error) { return []byte(p), nil }
+}
+
+func TestKeyStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	stores := map[string]KeyStore{
+		"file":      &FileStore{Dir: filepath.Join(dir, "file")},
+		"encrypted": &EncryptedFileStore{Dir: filepath.Join(dir, "encrypted"), Passphrase: staticPassphrase("correct horse")},
+	}
+
+	for name, ks := range stores {
+		t.Run(name, func(t *testing.T) {
+			key, err := GenerateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := ks.Get("wireguard"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected ErrKeyNotFound before Put, got: %v", err)
+			}
+			if err := ks.Put("wireguard", key); err != nil {
+				t.Fatalf("Failed to put key: %v", err)
+			}
+			got, err := ks.Get("wireguard")
+			if err != nil {
+				t.Fatalf("Failed to get key: %v", err)
+			}
+			if got != key {
+				t.Errorf("Key mismatch: got %s, want %s", got, key)
+			}
+			if err := ks.Delete("wireguard"); err != nil {
+				t.Fatalf("Failed to delete key: %v", err)
+			}
+			if _, err := ks.Get("wireguard"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("Expected ErrKeyNotFound after Delete, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestFileStorePermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "keys")
+	ks := &FileStore{Dir: dir}
+	key, _ := GenerateKey()
+	if err := ks.Put("wireguard", key); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "wireguard.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Key file has permissions %o, want 600", perm)
+	}
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	dir := t.TempDir()
+	ks := &EncryptedFileStore{Dir: dir, Passphrase: staticPassphrase("correct horse")}
+	key, _ := GenerateKey()
+	if err := ks.Put("wireguard", key); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "wireguard.enc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), key.String()) {
+		t.Error("Encrypted key file contains the plaintext key")
+	}
+
+	wrong := &EncryptedFileStore{Dir: dir, Passphrase: staticPassphrase("battery staple")}
+	if _, err := wrong.Get("wireguard"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected ErrWrongPassphrase, got: %v", err)
+	}
+
+	// A key file renamed to another name must not decrypt.
+	if err := os.Rename(filepath.Join(dir, "wireguard.enc"), filepath.Join(dir, "other.enc")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("other"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Expected swapped key file to fail, got: %v", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		ref         string
+		expectError bool
+	}{
+		{ref: "file:/tmp/keys"},
+		{ref: "encrypted:/tmp/keys"},
+		{ref: "file:", expectError: true},
+		{ref: "vault:secret/syncsh", expectError: true},
+		{ref: "no-scheme", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			_, err := Open(tt.ref, staticPassphrase("x"))
+			if tt.expectError && err == nil {
+				t.Errorf("expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestInvalidKeyName(t *testing.T) {
+	ks := &FileStore{Dir: t.TempDir()}
+	for _, name := range []string{"", "../escape", ".hidden", "a/b"} {
+		if err := ks.Put(name, Key{1}); err == nil {
+			t.Errorf("Expected key name %q to be rejected", name)
+		}
+	}
+}
+
+func TestFileStoreReadsHex(t *testing.T) {
+	dir := t.TempDir()
+	ks := &FileStore{Dir: dir}
+	key, _ := GenerateKey()
+	// Older versions wrote key files in hex.
+	if err := os.WriteFile(filepath.Join(dir, "wireguard.key"), []byte(key.Hex()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ks.Get("wireguard")
+	if err != nil {
+		t.Fatalf("Failed to get hex key: %v", err)
+	}
+	if got != key {
+		t.Errorf("Key mismatch: got %s, want %s", got, key)
+	}
+
+	if err := ks.Put("wireguard", key); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "wireguard.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != key.String() {
+		t.Errorf("Key file should be rewritten in base64, got %q", data)
+	}
+}
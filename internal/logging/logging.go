@@ -0,0 +1,218 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Subsystems that can be given their own level
+const (
+	Network   = "network"
+	Watcher   = "watcher"
+	Store     = "store"
+	Sync      = "sync"
+	WireGuard = "wireguard"
+	Daemon    = "daemon"
+)
+
+// subsystems are the names Config.Subsystems accepts
+var subsystems = []string{Network, Watcher, Store, Sync, WireGuard, Daemon}
+
+// SubsystemKey is the attribute that names the subsystem of a record
+const SubsystemKey = "subsystem"
+
+// Formats of the log output
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config is the logging section of config.yaml
+type Config struct {
+	Format     string            `yaml:"format,omitempty"`     // text (default) or json
+	Level      string            `yaml:"level,omitempty"`      // debug, info (default), warn or error
+	Subsystems map[string]string `yaml:"subsystems,omitempty"` // level per subsystem, e.g. wireguard: debug
+	File       string            `yaml:"file,omitempty"`       // log to this file instead of stderr
+	MaxSizeMB  int               `yaml:"max_size_mb,omitempty"`
+	MaxFiles   int               `yaml:"max_files,omitempty"` // rotated files kept besides the current one
+}
+
+// levels is the active configuration, read on every record
+type levels struct {
+	global     slog.Level
+	subsystems map[string]slog.Level
+}
+
+func (l *levels) of(subsystem string) slog.Level {
+	if level, ok := l.subsystems[subsystem]; ok {
+		return level
+	}
+	return l.global
+}
+
+var (
+	active atomic.Pointer[levels]
+	root   atomic.Pointer[slog.Handler]
+)
+
+func init() {
+	active.Store(&levels{global: slog.LevelInfo})
+}
+
+// Setup installs the configured handler as the default logger. Each
+// verbosity step lowers every level by one (info to debug). The returned
+// closer closes the log file, if any.
+func Setup(cfg Config, verbosity int) (io.Closer, error) {
+	l, err := parseLevels(cfg)
+	if err != nil {
+		return nil, err
+	}
+	shift := slog.Level(-4 * verbosity)
+	l.global += shift
+	for name := range l.subsystems {
+		l.subsystems[name] += shift
+	}
+
+	var (
+		w      io.Writer = os.Stderr
+		closer io.Closer = nopCloser{}
+	)
+	if cfg.File != "" {
+		f, err := OpenRotating(cfg.File, int64(cfg.MaxSizeMB)<<20, cfg.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		w, closer = f, f
+	}
+
+	// Records are filtered by level before they reach the handler.
+	opts := &slog.HandlerOptions{Level: slog.Level(-100)}
+	var h slog.Handler
+	switch cfg.Format {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		closer.Close()
+		return nil, fmt.Errorf("unknown log format %q, want text or json", cfg.Format)
+	}
+
+	active.Store(l)
+	root.Store(&h)
+	slog.SetDefault(slog.New(&handler{}))
+	return closer, nil
+}
+
+func parseLevels(cfg Config) (*levels, error) {
+	l := &levels{global: slog.LevelInfo, subsystems: make(map[string]slog.Level)}
+	if cfg.Level != "" {
+		if err := l.global.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+		}
+	}
+	for name, value := range cfg.Subsystems {
+		if !slices.Contains(subsystems, strings.ToLower(name)) {
+			return nil, fmt.Errorf("unknown log subsystem %q, want one of %s", name, strings.Join(subsystems, ", "))
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q for %s: %w", value, name, err)
+		}
+		l.subsystems[strings.ToLower(name)] = level
+	}
+	return l, nil
+}
+
+// For returns the logger of a subsystem. It follows later calls to Setup,
+// so it can be stored in a package variable.
+func For(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem}).With(SubsystemKey, subsystem)
+}
+
+// handler filters records by the level of their subsystem and passes them
+// to the handler installed by Setup
+type handler struct {
+	subsystem string
+	steps     []step // WithAttrs and WithGroup calls, in order
+}
+
+// step is one WithAttrs or WithGroup call to replay on the root handler
+type step struct {
+	group string
+	attrs []slog.Attr
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= active.Load().of(h.subsystem)
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	next := current()
+	for _, s := range h.steps {
+		if s.group != "" {
+			next = next.WithGroup(s.group)
+		} else {
+			next = next.WithAttrs(s.attrs)
+		}
+	}
+	return next.Handle(ctx, r)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	clone := h.with(step{attrs: attrs})
+	// Only a top-level subsystem attribute selects the level.
+	if !h.inGroup() {
+		for _, a := range attrs {
+			if a.Key == SubsystemKey {
+				clone.subsystem = a.Value.String()
+			}
+		}
+	}
+	return clone
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(step{group: name})
+}
+
+func (h *handler) with(s step) *handler {
+	return &handler{
+		subsystem: h.subsystem,
+		steps:     append(append([]step(nil), h.steps...), s),
+	}
+}
+
+func (h *handler) inGroup() bool {
+	for _, s := range h.steps {
+		if s.group != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the handler installed by Setup, or a text handler on
+// stderr before Setup is called
+func current() slog.Handler {
+	if h := root.Load(); h != nil {
+		return *h
+	}
+	return slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.Level(-100)})
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
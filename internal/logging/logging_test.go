@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setup(t *testing.T, cfg Config, verbosity int) string {
+	t.Helper()
+	cfg.File = filepath.Join(t.TempDir(), "syncsh.log")
+	closer, err := Setup(cfg, verbosity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closer.Close()
+		if _, err := Setup(Config{}, 0); err != nil {
+			t.Fatal(err)
+		}
+	})
+	return cfg.File
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	// Loggers created before Setup must follow it.
+	network, store := For(Network), For(Store)
+	path := setup(t, Config{Level: "warn", Subsystems: map[string]string{"network": "debug"}}, 0)
+
+	network.Debug("network debug")
+	store.Info("store info")
+	store.Warn("store warn")
+
+	out := read(t, path)
+	for _, want := range []string{"network debug", "subsystem=network", "store warn"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Log is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "store info") {
+		t.Errorf("Store info must be filtered at warn level:\n%s", out)
+	}
+}
+
+func TestVerbosity(t *testing.T) {
+	path := setup(t, Config{}, 1)
+	For(Sync).Debug("sync debug")
+	if out := read(t, path); !strings.Contains(out, "sync debug") {
+		t.Errorf("-v must enable debug logs:\n%s", out)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	path := setup(t, Config{Format: FormatJSON}, 0)
+	For(Watcher).With("path", "/tmp/h").Info("ingested", "count", 2)
+
+	var record map[string]any
+	if err := json.Unmarshal([]byte(read(t, path)), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["subsystem"] != Watcher || record["msg"] != "ingested" || record["path"] != "/tmp/h" || record["count"] != 2.0 {
+		t.Errorf("Unexpected record %v", record)
+	}
+}
+
+func TestSetupErrors(t *testing.T) {
+	for _, cfg := range []Config{
+		{Format: "xml"},
+		{Level: "loud"},
+		{Subsystems: map[string]string{"store": "quiet"}},
+		{Subsystems: map[string]string{"netwrok": "debug"}},
+	} {
+		if _, err := Setup(cfg, 0); err == nil {
+			t.Errorf("Setup(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "syncsh.log")
+	f, err := OpenRotating(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for file, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		if got := read(t, file); got != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Files beyond max_files must be removed")
+	}
+}
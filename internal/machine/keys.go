@@ -0,0 +1,328 @@
+package machine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+)
+
+// DefaultRotationGrace is how long a rotated key stays valid by default
+const DefaultRotationGrace = 7 * 24 * time.Hour
+
+var (
+	ErrNoKeys    = errors.New("machine has no WireGuard keys, run syncsh init first")
+	ErrNotOurKey = errors.New("public key does not belong to this machine")
+)
+
+// keyName returns the key store name for the private key matching pubKey
+func keyName(pubKey keys.Key) string {
+	sum := sha256.Sum256(pubKey[:])
+	return "wireguard-" + hex.EncodeToString(sum[:4])
+}
+
+// signingKeyName returns the key store name for the signing key matching pubKey
+func signingKeyName(pubKey keys.Key) string {
+	sum := sha256.Sum256(pubKey[:])
+	return "signing-" + hex.EncodeToString(sum[:4])
+}
+
+// EnsureSigningKey gives machines initialized by older versions an Ed25519
+// signing key. It reports whether the config changed and needs to be saved.
+func EnsureSigningKey(cfg *config.Config, ks keys.KeyStore) (bool, error) {
+	if cfg.SigningKeyRef != "" {
+		return false, nil
+	}
+	seed, pubKey, err := keys.GenerateSigningKey()
+	if err != nil {
+		return false, err
+	}
+	name := signingKeyName(pubKey)
+	if err := ks.Put(name, seed); err != nil {
+		return false, fmt.Errorf("store signing key: %w", err)
+	}
+	cfg.SigningKeyRef = name
+	cfg.SigningPublicKey = pubKey
+	return true, nil
+}
+
+// SigningKey loads the seed of the machine's Ed25519 signing key
+func SigningKey(cfg *config.Config, ks keys.KeyStore) (keys.Key, error) {
+	if cfg.SigningKeyRef == "" {
+		return keys.Key{}, ErrNoKeys
+	}
+	seed, err := ks.Get(cfg.SigningKeyRef)
+	if err != nil {
+		return keys.Key{}, fmt.Errorf("load signing key: %w", err)
+	}
+	if keys.SigningPublicKey(seed) != cfg.SigningPublicKey {
+		return keys.Key{}, fmt.Errorf("signing key %s does not match the configured public key", cfg.SigningKeyRef)
+	}
+	return seed, nil
+}
+
+// SigningKeys looks up signing keys of this machine and of the peers that
+// presented one on first contact
+func SigningKeys(cfg *config.Config) provenance.KeyLookup {
+	return func(machineID string) (keys.Key, bool) {
+		if machineID == cfg.MachineID {
+			return cfg.SigningPublicKey, !cfg.SigningPublicKey.IsZero()
+		}
+		for _, p := range cfg.Peers {
+			if p.MachineID == machineID && !p.SigningKey.IsZero() {
+				return p.SigningKey, true
+			}
+		}
+		return keys.Key{}, false
+	}
+}
+
+// PrivateKey loads the machine's current WireGuard private key
+func PrivateKey(cfg *config.Config, ks keys.KeyStore) (keys.Key, error) {
+	if cfg.PrivateKeyRef == "" {
+		return keys.Key{}, ErrNoKeys
+	}
+	return loadPrivateKey(ks, cfg.PrivateKeyRef)
+}
+
+// loadPrivateKey reads a private key from the store and checks that it is a
+// usable X25519 scalar before it reaches WireGuard.
+func loadPrivateKey(ks keys.KeyStore, name string) (keys.Key, error) {
+	key, err := ks.Get(name)
+	if err != nil {
+		return keys.Key{}, fmt.Errorf("load private key %s: %w", name, err)
+	}
+	if err := key.ValidatePrivate(); err != nil {
+		return keys.Key{}, fmt.Errorf("load private key %s: %w", name, err)
+	}
+	return key, nil
+}
+
+// parseLegacyKey decodes a plaintext private key from an old config. The
+// first versions wrote the raw bytes, later ones hex or base64.
+func parseLegacyKey(s string) (keys.Key, error) {
+	if len(s) == keys.KeySize {
+		return keys.NewKey([]byte(s))
+	}
+	return keys.ParseKey(s)
+}
+
+// MigratePlaintextKeys moves private keys that older versions wrote into the
+// config into ks and replaces them with references. It reports whether the
+// config changed and needs to be saved.
+func MigratePlaintextKeys(cfg *config.Config, ks keys.KeyStore) (bool, error) {
+	migrated := false
+
+	if cfg.PrivateKey != "" {
+		privKey, err := parseLegacyKey(cfg.PrivateKey)
+		if err != nil {
+			return false, fmt.Errorf("migrate WireGuard private key: %w", err)
+		}
+		// The public key of old configs may be as mangled as the private
+		// key was, so derive it again.
+		pubKey, err := privKey.PublicKey()
+		if err != nil {
+			return false, fmt.Errorf("migrate WireGuard private key: %w", err)
+		}
+		name := keyName(pubKey)
+		if err := ks.Put(name, privKey); err != nil {
+			return false, fmt.Errorf("migrate WireGuard private key: %w", err)
+		}
+		cfg.PublicKey = pubKey
+		cfg.PrivateKeyRef = name
+		cfg.PrivateKey = ""
+		migrated = true
+	}
+
+	for i := range cfg.PreviousKeys {
+		k := &cfg.PreviousKeys[i]
+		if k.PrivateKey == "" {
+			continue
+		}
+		privKey, err := parseLegacyKey(k.PrivateKey)
+		if err != nil {
+			return false, fmt.Errorf("migrate retired private key: %w", err)
+		}
+		name := keyName(k.PublicKey)
+		if err := ks.Put(name, privKey); err != nil {
+			return false, fmt.Errorf("migrate retired private key: %w", err)
+		}
+		k.PrivateKeyRef = name
+		k.PrivateKey = ""
+		migrated = true
+	}
+
+	if migrated {
+		slog.Info("Moved plaintext private keys out of the config into the key store")
+	}
+	return migrated, nil
+}
+
+// MoveKeys copies every private key referenced by cfg from one key store to
+// another, then calls save to point the configuration at the new store. The
+// originals are only deleted once save succeeded, so the configuration
+// never references a store whose keys are gone.
+func MoveKeys(cfg *config.Config, from, to keys.KeyStore, save func() error) error {
+	names := []string{cfg.PrivateKeyRef, cfg.SigningKeyRef}
+	for _, k := range cfg.PreviousKeys {
+		names = append(names, k.PrivateKeyRef)
+	}
+
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		key, err := from.Get(name)
+		if err != nil {
+			return fmt.Errorf("read key %s: %w", name, err)
+		}
+		if err := to.Put(name, key); err != nil {
+			return fmt.Errorf("write key %s: %w", name, err)
+		}
+	}
+	if err := save(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if err := from.Delete(name); err != nil {
+			slog.Warn("Failed to delete key from old key store.", "name", name, "error", err)
+		}
+	}
+	return nil
+}
+
+// RotateKeys replaces the machine's WireGuard key pair. The previous pair is
+// kept until the grace window ends so peers can still connect with it while
+// they learn the new key. The caller is responsible for saving cfg.
+func RotateKeys(cfg *config.Config, ks keys.KeyStore, grace time.Duration) (keys.Rotation, error) {
+	if cfg.PrivateKeyRef == "" || cfg.PublicKey.IsZero() {
+		return keys.Rotation{}, ErrNoKeys
+	}
+
+	slog.Info("Generating new WireGuard keys")
+	privKey, pubKey, err := network.NewMachineKeys()
+	if err != nil {
+		return keys.Rotation{}, fmt.Errorf("failed to generate WireGuard keys: %w", err)
+	}
+	name := keyName(pubKey)
+	if err := ks.Put(name, privKey); err != nil {
+		return keys.Rotation{}, fmt.Errorf("failed to store WireGuard private key: %w", err)
+	}
+
+	now := time.Now()
+	rotation := keys.Rotation{
+		OldPublicKey: cfg.PublicKey,
+		NewPublicKey: pubKey,
+		RotatedAt:    now,
+		GraceUntil:   now.Add(grace),
+	}
+
+	for _, k := range cfg.PruneRetiredKeys(now) {
+		if err := ks.Delete(k.PrivateKeyRef); err != nil {
+			slog.Warn("Failed to delete expired key.", "name", k.PrivateKeyRef, "error", err)
+		}
+	}
+	cfg.PreviousKeys = append(cfg.PreviousKeys, config.RetiredKey{
+		PrivateKeyRef: cfg.PrivateKeyRef,
+		PublicKey:     cfg.PublicKey,
+		RotatedAt:     rotation.RotatedAt,
+		ExpiresAt:     rotation.GraceUntil,
+	})
+	// Peers we never heard back from can only know us by the old key.
+	for i := range cfg.Peers {
+		if cfg.Peers[i].KnownPublicKey.IsZero() {
+			cfg.Peers[i].KnownPublicKey = rotation.OldPublicKey
+		}
+	}
+	cfg.PrivateKeyRef = name
+	cfg.PublicKey = pubKey
+
+	return rotation, nil
+}
+
+// PrivateKeyFor returns the private key to use on the tunnel to peer. A peer
+// that has not acknowledged the latest rotation still knows us by a retired
+// key, which remains usable until its grace window ends.
+func PrivateKeyFor(cfg *config.Config, ks keys.KeyStore, peer *config.Peer) (keys.Key, error) {
+	if retired, ok := cfg.GetRetiredKey(peer.KnownPublicKey, time.Now()); ok {
+		return loadPrivateKey(ks, retired.PrivateKeyRef)
+	}
+	return PrivateKey(cfg, ks)
+}
+
+// PrivateKeyByPublic returns the private key matching publicKey, which must
+// be our current key or a retired key that is still inside its grace window.
+func PrivateKeyByPublic(cfg *config.Config, ks keys.KeyStore, publicKey keys.Key) (keys.Key, error) {
+	if publicKey == cfg.PublicKey {
+		return PrivateKey(cfg, ks)
+	}
+	if retired, ok := cfg.GetRetiredKey(publicKey, time.Now()); ok {
+		return loadPrivateKey(ks, retired.PrivateKeyRef)
+	}
+	return keys.Key{}, ErrNotOurKey
+}
+
+// Announcements returns the authenticated rotation chain that takes peer from
+// the key it knows us by to our current key. It is empty if the peer is up to
+// date or none of the retired keys it could know are still valid.
+func Announcements(cfg *config.Config, ks keys.KeyStore, peer *config.Peer) ([]keys.Announcement, error) {
+	current := cfg.PublicKey
+	if peer.KnownPublicKey == current {
+		return nil, nil
+	}
+
+	now := time.Now()
+	retired := make([]config.RetiredKey, 0, len(cfg.PreviousKeys))
+	for _, k := range cfg.PreviousKeys {
+		if now.Before(k.ExpiresAt) {
+			retired = append(retired, k)
+		}
+	}
+	sort.Slice(retired, func(i, j int) bool {
+		return retired[i].RotatedAt.Before(retired[j].RotatedAt)
+	})
+
+	// Start the chain at the key the peer acknowledged. If we never heard
+	// back from the peer, send every link and let it pick its starting point.
+	start := 0
+	for i, k := range retired {
+		if k.PublicKey == peer.KnownPublicKey {
+			start = i
+			break
+		}
+	}
+
+	var announcements []keys.Announcement
+	for i := start; i < len(retired); i++ {
+		next := current
+		if i+1 < len(retired) {
+			next = retired[i+1].PublicKey
+		}
+		oldPrivKey, err := loadPrivateKey(ks, retired[i].PrivateKeyRef)
+		if err != nil {
+			return nil, err
+		}
+		a, err := keys.Announce(keys.Rotation{
+			OldPublicKey: retired[i].PublicKey,
+			NewPublicKey: next,
+			RotatedAt:    retired[i].RotatedAt,
+			GraceUntil:   retired[i].ExpiresAt,
+		}, oldPrivKey, peer.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("announce rotation to %s: %w", peer.Name, err)
+		}
+		announcements = append(announcements, a)
+	}
+	return announcements, nil
+}
@@ -0,0 +1,115 @@
+package machine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+)
+
+func TestMigratePlaintextKeys(t *testing.T) {
+	dir := t.TempDir()
+	priv, pub, err := network.NewMachineKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPriv, oldPub, err := network.NewMachineKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		// The first versions wrote the raw key bytes into the config.
+		PrivateKey: string(priv[:]),
+		PreviousKeys: []config.RetiredKey{{
+			PrivateKey: oldPriv.Hex(),
+			PublicKey:  oldPub,
+			ExpiresAt:  time.Now().Add(time.Hour),
+		}},
+	}
+	cfg.SetPath(filepath.Join(dir, "config.yaml"))
+	ks := &keys.FileStore{Dir: filepath.Join(dir, "keys")}
+
+	migrated, err := MigratePlaintextKeys(cfg, ks)
+	if err != nil {
+		t.Fatalf("Failed to migrate keys: %v", err)
+	}
+	if !migrated {
+		t.Fatal("Expected plaintext keys to be migrated")
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(cfg.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "private_key:") {
+		t.Errorf("Config still contains a plaintext private key:\n%s", data)
+	}
+
+	if cfg.PublicKey != pub {
+		t.Errorf("Public key was not derived from the migrated private key")
+	}
+	got, err := PrivateKey(cfg, ks)
+	if err != nil {
+		t.Fatalf("Failed to load migrated key: %v", err)
+	}
+	if got != priv {
+		t.Error("Migrated private key does not match")
+	}
+	peer := &config.Peer{KnownPublicKey: oldPub}
+	got, err = PrivateKeyFor(cfg, ks, peer)
+	if err != nil {
+		t.Fatalf("Failed to load migrated retired key: %v", err)
+	}
+	if got != oldPriv {
+		t.Error("Migrated retired private key does not match")
+	}
+
+	migrated, err = MigratePlaintextKeys(cfg, ks)
+	if err != nil || migrated {
+		t.Errorf("Expected second migration to be a no-op, got migrated=%v err=%v", migrated, err)
+	}
+}
+
+func TestMoveKeys(t *testing.T) {
+	dir := t.TempDir()
+	from := &keys.FileStore{Dir: dir}
+	to := &keys.EncryptedFileStore{Dir: dir, Passphrase: func() ([]byte, error) { return []byte("pass"), nil }}
+
+	key, _ := keys.GenerateKey()
+	if err := from.Put("wireguard", key); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{PrivateKeyRef: "wireguard"}
+
+	// A failed save leaves the originals in place
+	if err := MoveKeys(cfg, from, to, func() error { return errors.New("disk full") }); err == nil {
+		t.Fatal("Expected the failed save to be reported")
+	}
+	if _, err := from.Get("wireguard"); err != nil {
+		t.Fatalf("Key was deleted although the config was not saved: %v", err)
+	}
+
+	if err := MoveKeys(cfg, from, to, func() error { return nil }); err != nil {
+		t.Fatalf("Failed to move keys: %v", err)
+	}
+	if _, err := from.Get("wireguard"); err == nil {
+		t.Error("Expected key to be removed from the old store")
+	}
+	got, err := to.Get("wireguard")
+	if err != nil {
+		t.Fatalf("Failed to read moved key: %v", err)
+	}
+	if got != key {
+		t.Error("Moved key does not match")
+	}
+}
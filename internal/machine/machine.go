@@ -5,7 +5,9 @@ import (
 
 	"fmt"
 	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
 	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/secret"
 	"github.com/TheRealSibasishBehera/syncsh/internal/watcher"
 	"log/slog"
 )
@@ -19,22 +21,49 @@ type Machine struct {
 	Watcher *watcher.Watcher
 }
 
-func NewMachine(config *config.Config) error {
-	_, err := network.GetOrCreateWireGuardInterface(config.InterfaceName)
-	if err != nil {
-		return fmt.Errorf("failed to create WireGuard interface: %w", err)
-	}
-
+func NewMachine(config *config.Config, ks keys.KeyStore) error {
 	slog.Info("Generating WireGuard keys")
 	privKey, pubKey, err := network.NewMachineKeys()
 	if err != nil {
 		return fmt.Errorf("failed to generate WireGuard keys: %w", err)
 	}
 
-	slog.Info("Saving WireGuard keys to config")
-	config.PrivateKey = string(privKey)
-	config.PublicKey = string(pubKey)
-	config.Save()
+	slog.Info("Saving WireGuard private key to key store")
+	name := keyName(pubKey)
+	if err := ks.Put(name, privKey); err != nil {
+		return fmt.Errorf("failed to store WireGuard private key: %w", err)
+	}
+	config.PrivateKeyRef = name
+	config.PublicKey = pubKey
 
-	return nil
+	if _, err := EnsureMachineID(config); err != nil {
+		return err
+	}
+	if config.MeshPrefix == "" {
+		prefix, err := network.NewMeshPrefix()
+		if err != nil {
+			return err
+		}
+		config.MeshPrefix = prefix.String()
+	}
+	slog.Info("Generating history signing key")
+	if _, err := EnsureSigningKey(config, ks); err != nil {
+		return err
+	}
+
+	return config.Save()
+}
+
+// EnsureMachineID assigns a machine ID to configs that do not have one yet.
+// It reports whether the config changed and needs to be saved.
+func EnsureMachineID(config *config.Config) (bool, error) {
+	if config.MachineID != "" {
+		return false, nil
+	}
+	id, err := secret.NewID()
+	if err != nil {
+		return false, fmt.Errorf("generate machine ID: %w", err)
+	}
+	config.MachineID = id
+	return true, nil
 }
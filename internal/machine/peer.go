@@ -0,0 +1,201 @@
+package machine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/protocol"
+	"github.com/TheRealSibasishBehera/syncsh/internal/relay"
+	"go4.org/netipx"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// TunnelOptions are the machine-wide settings tunnels are opened with
+type TunnelOptions struct {
+	Relay     *config.Relay // packets can also go through this relay
+	Transport network.TransportKind
+	Interface string // base name of kernel interfaces
+
+	// MeshPrefix is the ULA /48 tunnel addresses are derived from. IPv4 adds
+	// an IPv4 pair from TunnelPool; without a prefix it is the only one.
+	MeshPrefix string
+	IPv4       bool
+	TunnelPool []string
+}
+
+// TunnelOptionsFor returns the tunnel settings of cfg
+func TunnelOptionsFor(cfg *config.Config) TunnelOptions {
+	return TunnelOptions{
+		Relay:      cfg.Relay,
+		Transport:  cfg.Transport,
+		Interface:  cfg.InterfaceName,
+		MeshPrefix: cfg.MeshPrefix,
+		IPv4:       cfg.TunnelIPv4,
+		TunnelPool: cfg.TunnelPool,
+	}
+}
+
+// OpenTunnel brings up a tunnel to peer using privateKey. Both sides derive
+// the same tunnel addresses from their public keys, see network.P2PAddrs.
+// The tunnel starts at the first of the peer's endpoint candidates that
+// resolves.
+func OpenTunnel(peer *config.Peer, privateKey keys.Key, opts TunnelOptions) (network.Transport, error) {
+	publicKey, err := privateKey.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	meshPrefix, err := network.ParseMeshPrefix(opts.MeshPrefix)
+	if err != nil {
+		return nil, err
+	}
+	rc := opts.Relay
+	kind, err := network.SelectTransport(opts.Transport, rc != nil)
+	if err != nil {
+		return nil, err
+	}
+	var bind conn.Bind
+	if rc != nil {
+		addr, err := ResolveRelay(rc)
+		if err != nil {
+			return nil, err
+		}
+		bind = relay.NewBind(addr, rc.PublicKey, privateKey)
+	}
+
+	// Endpoints that do not resolve yet are retried by the caller
+	var endpoint string
+	if candidates, _ := ResolveCandidates(context.Background(), peer); len(candidates) > 0 {
+		endpoint = candidates[0].Addr.String()
+	}
+	name := network.PeerInterfaceName(opts.Interface, peer.PublicKey)
+	var ipv4Prefix netip.Prefix
+	if opts.IPv4 || !meshPrefix.IsValid() {
+		ipv4Prefix, err = tunnelIPv4Prefix(peer, opts.TunnelPool, kind, name)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel to %s: %w", peer.Name, err)
+		}
+	}
+	addrs := network.P2PAddrs(meshPrefix, ipv4Prefix, publicKey, peer.PublicKey)
+	t, err := network.CreateP2PTransport(kind, name, addrs, endpoint, peer.ListenPort, privateKey, peer.PublicKey, bind)
+	if err != nil {
+		return nil, fmt.Errorf("create tunnel to %s: %w", peer.Name, err)
+	}
+	// Without a direct endpoint the relay is the only way to reach the peer.
+	// WireGuard moves to a direct path as soon as the peer uses one.
+	if rc != nil && endpoint == "" {
+		if err := t.SetEndpoint(relay.Endpoint(peer.PublicKey)); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// tunnelIPv4Prefix returns the /30 of the tunnel's IPv4 pair: the one
+// pinned for the peer, or the first in the pool that is free. Only kernel
+// interfaces share the host's routing table, so only they check routes and
+// addresses, leaving out the interface that is about to be reused.
+func tunnelIPv4Prefix(peer *config.Peer, pool []string, kind network.TransportKind, name string) (netip.Prefix, error) {
+	used := &netipx.IPSet{}
+	if kind == network.TransportKernel {
+		var err error
+		used, err = network.UsedPrefixes(func(link string) bool { return link == name })
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+	}
+	if peer.TunnelPrefix != "" {
+		prefix, err := network.ParseP2PPrefix(peer.TunnelPrefix)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if used.OverlapsPrefix(prefix) {
+			return netip.Prefix{}, fmt.Errorf("tunnel prefix %s overlaps an existing route or address", prefix)
+		}
+		return prefix, nil
+	}
+	prefixes, err := network.ParsePool(pool)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return network.PickP2PPrefix(prefixes, used)
+}
+
+// Candidate is one resolved address of a peer endpoint
+type Candidate struct {
+	Endpoint string // as configured
+	Addr     netip.AddrPort
+}
+
+// ResolveCandidates resolves the peer's endpoint candidates in order. A
+// hostname contributes all of its addresses. Endpoints that fail to resolve
+// are skipped and their errors joined.
+func ResolveCandidates(ctx context.Context, peer *config.Peer) ([]Candidate, error) {
+	var (
+		candidates []Candidate
+		errs       []error
+	)
+	for _, endpoint := range peer.EndpointCandidates() {
+		addrs, err := network.ResolveEndpoint(ctx, endpoint)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, addr := range addrs {
+			candidates = append(candidates, Candidate{Endpoint: endpoint, Addr: addr})
+		}
+	}
+	return candidates, errors.Join(errs...)
+}
+
+// ResolveRelay returns the UDP address of the relay. The port defaults to
+// relay.DefaultPort.
+func ResolveRelay(rc *config.Relay) (netip.AddrPort, error) {
+	address := rc.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(relay.DefaultPort))
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("resolve relay %s: %w", rc.Address, err)
+	}
+	return addr.AddrPort(), nil
+}
+
+// DialPeer brings up a tunnel to peer using privateKey and opens a
+// connection to its syncsh listener. The caller must close both the
+// connection and the tunnel.
+func DialPeer(ctx context.Context, peer *config.Peer, privateKey keys.Key, opts TunnelOptions) (net.Conn, network.Transport, error) {
+	if opts.Relay == nil {
+		if len(peer.EndpointCandidates()) == 0 {
+			return nil, nil, fmt.Errorf("peer %s has no endpoint", peer.Name)
+		}
+		if candidates, err := ResolveCandidates(ctx, peer); len(candidates) == 0 {
+			return nil, nil, err
+		}
+	}
+
+	t, err := OpenTunnel(peer, privateKey, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := DialTunnel(ctx, t)
+	if err != nil {
+		t.Close()
+		return nil, nil, fmt.Errorf("dial %s: %w", peer.Name, err)
+	}
+	return conn, t, nil
+}
+
+// DialTunnel connects to the syncsh listener at the far end of t
+func DialTunnel(ctx context.Context, t network.Transport) (net.Conn, error) {
+	remote := netip.AddrPortFrom(t.RemoteAddr(), protocol.DefaultPort)
+	return t.DialContext(ctx, "tcp", remote.String())
+}
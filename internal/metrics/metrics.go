@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "syncsh"
+
+// registry holds every syncsh metric plus the Go runtime and process metrics
+var registry = prometheus.NewRegistry()
+
+var (
+	EntriesIngested = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_ingested_total",
+		Help:      "New history entries read from the local shell history.",
+	}))
+	EntriesSent = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_sent_total",
+		Help:      "History entries sent to a peer.",
+	}, []string{"peer"}))
+	EntriesReceived = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "entries_received_total",
+		Help:      "History entries received from a peer and accepted.",
+	}, []string{"peer"}))
+	SyncRounds = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_rounds_total",
+		Help:      "Rounds of bringing a peer up to date with this machine's history, by result.",
+	}, []string{"peer", "result"}))
+	SyncRoundTrip = register(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "sync_round_trip_seconds",
+		Help:      "Time from sending a history batch to its acknowledgement.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	}, []string{"peer"}))
+	PeerTransitions = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "peer_state_transitions_total",
+		Help:      "Changes of the connection state of a peer, by the state entered.",
+	}, []string{"peer", "state"}))
+	WatcherEvents = register(prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "watcher_events_total",
+		Help:      "File system events on the shell history file.",
+	}))
+	SQLiteErrors = register(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sqlite_errors_total",
+		Help:      "Failed history store operations.",
+	}, []string{"op"}))
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+func register[C prometheus.Collector](c C) C {
+	registry.MustRegister(c)
+	return c
+}
+
+// RoundResult returns the result label of a sync round
+func RoundResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// PeerSource returns the tunnel status of every peer, keyed by peer name
+type PeerSource func() map[string]network.SimplePeerStatus
+
+// RegisterPeers reports WireGuard state from src on every scrape. It
+// replaces any previous source.
+func RegisterPeers(src PeerSource) {
+	peers.set(src)
+}
+
+var peers = &peerCollector{}
+
+func init() {
+	registry.MustRegister(peers)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Serve serves /metrics on addr until ctx is cancelled. The endpoint is
+// meant for a local scraper and has no authentication.
+func Serve(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serve metrics: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Scrape failed with %d", rec.Code)
+	}
+	return rec.Body.String()
+}
+
+func TestHandler(t *testing.T) {
+	EntriesSent.WithLabelValues("server").Add(3)
+	SyncRoundTrip.WithLabelValues("server").Observe(0.02)
+
+	RegisterPeers(func() map[string]network.SimplePeerStatus {
+		return map[string]network.SimplePeerStatus{
+			"server": {LastHandshake: time.Now().Add(-time.Minute), BytesReceived: 100, BytesTransmitted: 200},
+			"idle":   {},
+		}
+	})
+	defer RegisterPeers(nil)
+
+	body := scrape(t)
+	for _, want := range []string{
+		`syncsh_entries_sent_total{peer="server"} 3`,
+		`syncsh_sync_round_trip_seconds_count{peer="server"} 1`,
+		`syncsh_wireguard_connected{peer="server"} 1`,
+		`syncsh_wireguard_connected{peer="idle"} 0`,
+		`syncsh_wireguard_received_bytes_total{peer="server"} 100`,
+		`syncsh_wireguard_handshake_age_seconds{peer="server"} `,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Scrape is missing %q", want)
+		}
+	}
+	if strings.Contains(body, `syncsh_wireguard_handshake_age_seconds{peer="idle"}`) {
+		t.Error("A peer without a handshake must have no handshake age")
+	}
+}
+
+func TestServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Serve(ctx, addr) }()
+
+	var resp *http.Response
+	for i := 0; i < 100; i++ {
+		if resp, err = http.Get("http://" + addr + "/metrics"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "syncsh_entries_ingested_total") {
+		t.Errorf("Unexpected metrics: %s", body)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Serve returned: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	handshakeAgeDesc = prometheus.NewDesc(namespace+"_wireguard_handshake_age_seconds",
+		"Time since the last WireGuard handshake with a peer.", []string{"peer"}, nil)
+	connectedDesc = prometheus.NewDesc(namespace+"_wireguard_connected",
+		"Whether a peer had a WireGuard handshake in the last 3 minutes.", []string{"peer"}, nil)
+	receivedBytesDesc = prometheus.NewDesc(namespace+"_wireguard_received_bytes_total",
+		"Bytes received from a peer over the tunnel.", []string{"peer"}, nil)
+	transmittedBytesDesc = prometheus.NewDesc(namespace+"_wireguard_transmitted_bytes_total",
+		"Bytes sent to a peer over the tunnel.", []string{"peer"}, nil)
+)
+
+// peerCollector turns peer statuses into metrics at scrape time
+type peerCollector struct {
+	mu  sync.Mutex
+	src PeerSource
+}
+
+func (c *peerCollector) set(src PeerSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.src = src
+}
+
+func (c *peerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- handshakeAgeDesc
+	ch <- connectedDesc
+	ch <- receivedBytesDesc
+	ch <- transmittedBytesDesc
+}
+
+func (c *peerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	src := c.src
+	c.mu.Unlock()
+	if src == nil {
+		return
+	}
+
+	now := time.Now()
+	for name, s := range src() {
+		connected := 0.0
+		if s.IsConnected() {
+			connected = 1
+		}
+		ch <- prometheus.MustNewConstMetric(connectedDesc, prometheus.GaugeValue, connected, name)
+		ch <- prometheus.MustNewConstMetric(receivedBytesDesc, prometheus.CounterValue, float64(s.BytesReceived), name)
+		ch <- prometheus.MustNewConstMetric(transmittedBytesDesc, prometheus.CounterValue, float64(s.BytesTransmitted), name)
+		// A peer never handshaken with has no age.
+		if !s.LastHandshake.IsZero() {
+			ch <- prometheus.MustNewConstMetric(handshakeAgeDesc, prometheus.GaugeValue, now.Sub(s.LastHandshake).Seconds(), name)
+		}
+	}
+}
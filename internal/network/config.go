@@ -5,64 +5,63 @@ import (
 	"net"
 	"net/netip"
 
-	"github.com/TheRealSibasishBehera/syncsh/internal/secret"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 // Simple P2P WireGuard configuration
 type P2PConfig struct {
-	LocalIP         netip.Addr
-	RemoteIP        netip.Addr
-	LocalPrivateKey secret.Secret
-	RemotePublicKey secret.Secret
-	RemoteEndpoint  netip.AddrPort
+	LocalIPs        []netip.Addr // the first is the one syncsh talks over
+	RemoteIPs       []netip.Addr
+	LocalPrivateKey keys.Key
+	RemotePublicKey keys.Key
+	RemoteEndpoint  netip.AddrPort // unset if the peer connects to us first
+	ListenPort      int            // 0 picks a random port
 }
 
 // SimplePeer represents a single peer in P2P connection
 type SimplePeer struct {
 	IP        netip.Addr
-	PublicKey secret.Secret
+	PublicKey keys.Key
 	Endpoint  netip.AddrPort
 }
 
 // IsConfigured returns true if the P2P configuration is complete
 func (c P2PConfig) IsConfigured() bool {
-	return c.LocalIP.IsValid() && c.RemoteIP.IsValid() &&
-		c.LocalPrivateKey != nil && c.RemotePublicKey != nil &&
+	return len(c.LocalIPs) > 0 && len(c.RemoteIPs) > 0 &&
+		!c.LocalPrivateKey.IsZero() && !c.RemotePublicKey.IsZero() &&
 		c.RemoteEndpoint.IsValid()
 }
 
 // CreateP2PDeviceConfig creates a simple WireGuard device config for P2P connection
 func (c P2PConfig) CreateP2PDeviceConfig() (wgtypes.Config, error) {
-	privateKey, err := wgtypes.NewKey(c.LocalPrivateKey)
-	if err != nil {
+	if err := c.LocalPrivateKey.ValidatePrivate(); err != nil {
 		return wgtypes.Config{}, fmt.Errorf("parse private key: %w", err)
 	}
+	privateKey := wgtypes.Key(c.LocalPrivateKey)
+	publicKey := wgtypes.Key(c.RemotePublicKey)
 	
-	publicKey, err := wgtypes.NewKey(c.RemotePublicKey)
-	if err != nil {
-		return wgtypes.Config{}, fmt.Errorf("parse remote public key: %w", err)
-	}
-	
-	listenPort := WireGuardPort
+	listenPort := c.ListenPort
 	keepalive := WireGuardKeepaliveInterval
 	
 	// Simple P2P peer configuration
+	var allowedIPs []net.IPNet
+	for _, ip := range c.RemoteIPs {
+		prefix, err := addrToSingleIPPrefix(ip) // Single host
+		if err != nil {
+			return wgtypes.Config{}, err
+		}
+		allowedIPs = append(allowedIPs, prefixToIPNet(prefix))
+	}
 	peerConfig := wgtypes.PeerConfig{
-		PublicKey: publicKey,
-		Endpoint: &net.UDPAddr{
-			IP:   c.RemoteEndpoint.Addr().AsSlice(),
-			Port: int(c.RemoteEndpoint.Port()),
-		},
-		AllowedIPs: []net.IPNet{
-			{
-				IP:   c.RemoteIP.AsSlice(),
-				Mask: net.CIDRMask(32, 32), // Single host
-			},
-		},
+		PublicKey:                   publicKey,
+		AllowedIPs:                  allowedIPs,
 		PersistentKeepaliveInterval: &keepalive,
 		ReplaceAllowedIPs:           true,
 	}
+	if c.RemoteEndpoint.IsValid() {
+		peerConfig.Endpoint = net.UDPAddrFromAddrPort(c.RemoteEndpoint)
+	}
 	
 	return wgtypes.Config{
 		PrivateKey:   &privateKey,
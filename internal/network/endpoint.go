@@ -0,0 +1,43 @@
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// ResolveEndpoint returns the UDP addresses of a peer endpoint, which is
+// either a literal address:port or a hostname:port such as a dynamic DNS
+// name. The port defaults to WireGuardPort. Addresses keep the resolver's
+// order.
+func ResolveEndpoint(ctx context.Context, endpoint string) ([]netip.AddrPort, error) {
+	if addr, err := netip.ParseAddrPort(endpoint); err == nil {
+		return []netip.AddrPort{addr}, nil
+	}
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		host, portStr = endpoint, strconv.Itoa(WireGuardPort)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("parse endpoint %s: invalid port %q", endpoint, portStr)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.AddrPort{netip.AddrPortFrom(addr, uint16(port))}, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve endpoint %s: %w", endpoint, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("resolve endpoint %s: no addresses", endpoint)
+	}
+	resolved := make([]netip.AddrPort, 0, len(addrs))
+	for _, addr := range addrs {
+		resolved = append(resolved, netip.AddrPortFrom(addr.Unmap(), uint16(port)))
+	}
+	return resolved, nil
+}
@@ -0,0 +1,44 @@
+package network
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestResolveEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     []netip.AddrPort
+		wantErr  bool
+	}{
+		{"192.0.2.1:51821", []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:51821")}, false},
+		{"[fd00::1]:51821", []netip.AddrPort{netip.MustParseAddrPort("[fd00::1]:51821")}, false},
+		{"192.0.2.1", []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:51820")}, false},
+		{"localhost:51821", nil, false},
+		{"localhost:port", nil, true},
+		{"no-such-host.invalid:51820", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			got, err := ResolveEndpoint(context.Background(), tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.want == nil {
+				// A hostname resolves to loopback addresses on the given port
+				if len(got) == 0 || !got[0].Addr().IsLoopback() || got[0].Port() != 51821 {
+					t.Errorf("ResolveEndpoint() = %v", got)
+				}
+				return
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ResolveEndpoint() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,47 +1,65 @@
 package network
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"fmt"
-	"github.com/vishvananda/netlink"
 	"net"
 	"net/netip"
-)
 
-const (
-	// Fixed IPs for point-to-point
-	MachineAIP  = "10.100.0.1"
-	MachineBIP  = "10.100.0.2"
-	NetworkCIDR = "10.100.0.0/30"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
 )
 
-// since we are not using any distributed database
-// we have only one point-to-point connection
+// MeshPrefixBits is the length of a mesh's unique local prefix
+const MeshPrefixBits = 48
 
-type Peer struct {
-	TunnelIP netip.Addr
+// NewMeshPrefix returns a random unique local /48 inside fd00::/8, with the
+// 40-bit global ID RFC 4193 asks for
+func NewMeshPrefix() (netip.Prefix, error) {
+	var a [16]byte
+	a[0] = 0xfd
+	if _, err := rand.Read(a[1:6]); err != nil {
+		return netip.Prefix{}, fmt.Errorf("generate mesh prefix: %w", err)
+	}
+	return netip.PrefixFrom(netip.AddrFrom16(a), MeshPrefixBits), nil
 }
 
-// its clear that init shout now create or assign IP ,
-// connect should
-// so when you try to connect to a peer
-// it should first assign itself an IP
-// mark it and say it in the connection , its already assigned , use the next one
-
-func GetIP(initiator bool) (netip.Addr, error) {
-	if initiator {
-		return netip.MustParseAddr(MachineAIP), nil
+// ValidateMeshPrefix checks that prefix is a /48 inside fd00::/8
+func ValidateMeshPrefix(prefix netip.Prefix) error {
+	addr := prefix.Addr()
+	if !addr.Is6() || addr.Is4In6() || addr.As16()[0] != 0xfd || prefix.Bits() != MeshPrefixBits {
+		return fmt.Errorf("mesh prefix %s is not a /48 inside fd00::/8", prefix)
+	}
+	if prefix.Masked() != prefix {
+		return fmt.Errorf("mesh prefix %s has host bits set", prefix)
 	}
-	return netip.MustParseAddr(MachineBIP), nil
+	return nil
 }
 
-func ReserveIp(addr netip.Addr, link string) error {
-	devLink, err := netlink.LinkByName(link)
+// ParseMeshPrefix parses and validates a mesh prefix. The empty string is
+// the zero prefix.
+func ParseMeshPrefix(s string) (netip.Prefix, error) {
+	if s == "" {
+		return netip.Prefix{}, nil
+	}
+	prefix, err := netip.ParsePrefix(s)
 	if err != nil {
-		return fmt.Errorf("find link %q: %w", link, err)
+		return netip.Prefix{}, fmt.Errorf("parse mesh prefix: %w", err)
 	}
-	addrNetlink := &netlink.Addr{}
-	netlink.AddrAdd(devLink, addrNetlink)
-	return nil
+	if err := ValidateMeshPrefix(prefix); err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix, nil
+}
+
+// AddrFromKey returns the tunnel address of the machine with key: the mesh
+// prefix, subnet 0 and an interface ID hashed from the key. Every machine
+// computes the same address for a key, so none has to be allocated.
+func AddrFromKey(meshPrefix netip.Prefix, key keys.Key) netip.Addr {
+	a := meshPrefix.Masked().Addr().As16()
+	sum := sha256.Sum256(append([]byte("syncsh tunnel address\x00"), key[:]...))
+	copy(a[8:], sum[:8])
+	return netip.AddrFrom16(a)
 }
 
 func addrToSingleIPPrefix(addr netip.Addr) (netip.Prefix, error) {
@@ -61,3 +79,12 @@ func prefixToIPNet(prefix netip.Prefix) net.IPNet {
 		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
 	}
 }
+
+func ipNetToPrefix(ipNet net.IPNet) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(ipNet.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, _ := ipNet.Mask.Size()
+	return netip.PrefixFrom(addr.Unmap(), ones), true
+}
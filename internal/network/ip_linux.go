@@ -0,0 +1,128 @@
+//go:build linux
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"go4.org/netipx"
+	"golang.org/x/sys/unix"
+)
+
+// ReserveIp assigns addr, e.g. 10.100.0.1/32, to link. Assigning an address
+// the link already has is not an error.
+func ReserveIp(addr netip.Prefix, link string) error {
+	devLink, err := netlink.LinkByName(link)
+	if err != nil {
+		return fmt.Errorf("find link %q: %w", link, err)
+	}
+	if err := netlink.AddrReplace(devLink, netlinkAddr(addr)); err != nil {
+		return fmt.Errorf("assign %s to %s: %w", addr, link, err)
+	}
+	return nil
+}
+
+// ReleaseIp removes addr from link
+func ReleaseIp(addr netip.Prefix, link string) error {
+	devLink, err := netlink.LinkByName(link)
+	if err != nil {
+		return fmt.Errorf("find link %q: %w", link, err)
+	}
+	if err := netlink.AddrDel(devLink, netlinkAddr(addr)); err != nil {
+		return fmt.Errorf("remove %s from %s: %w", addr, link, err)
+	}
+	return nil
+}
+
+// AddRoutes routes prefixes over link
+func AddRoutes(link string, prefixes []netip.Prefix) error {
+	devLink, err := netlink.LinkByName(link)
+	if err != nil {
+		return fmt.Errorf("find link %q: %w", link, err)
+	}
+	for _, prefix := range prefixes {
+		if err := netlink.RouteReplace(linkRoute(devLink, prefix)); err != nil {
+			return fmt.Errorf("route %s over %s: %w", prefix, link, err)
+		}
+	}
+	return nil
+}
+
+// DeleteRoutes removes the routes AddRoutes installed. Routes that are gone
+// already are skipped.
+func DeleteRoutes(link string, prefixes []netip.Prefix) error {
+	devLink, err := netlink.LinkByName(link)
+	if err != nil {
+		return fmt.Errorf("find link %q: %w", link, err)
+	}
+	var errs []error
+	for _, prefix := range prefixes {
+		if err := netlink.RouteDel(linkRoute(devLink, prefix)); err != nil && !errors.Is(err, syscall.ESRCH) {
+			errs = append(errs, fmt.Errorf("remove route %s over %s: %w", prefix, link, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UsedPrefixes returns the IPv4 prefixes routed in any table or assigned
+// to an interface on this machine. Default routes and links skip reports
+// true for are left out.
+func UsedPrefixes(skip func(link string) bool) (*netipx.IPSet, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("list links: %w", err)
+	}
+	skipped := make(map[int]bool)
+	for _, link := range links {
+		if skip(link.Attrs().Name) {
+			skipped[link.Attrs().Index] = true
+		}
+	}
+
+	var b netipx.IPSetBuilder
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	for _, r := range routes {
+		if r.Dst == nil || skipped[r.LinkIndex] {
+			continue
+		}
+		if prefix, ok := ipNetToPrefix(*r.Dst); ok && prefix.Bits() > 0 {
+			b.AddPrefix(prefix.Masked())
+		}
+	}
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, fmt.Errorf("list addresses: %w", err)
+	}
+	for _, a := range addrs {
+		if skipped[a.LinkIndex] {
+			continue
+		}
+		if prefix, ok := ipNetToPrefix(*a.IPNet); ok {
+			b.AddPrefix(prefix.Masked())
+		}
+	}
+	return b.IPSet()
+}
+
+func netlinkAddr(addr netip.Prefix) *netlink.Addr {
+	ipNet := prefixToIPNet(addr)
+	a := &netlink.Addr{IPNet: &ipNet}
+	if addr.Addr().Is6() {
+		// Tunnel addresses are unique by construction; waiting for duplicate
+		// address detection would only delay binding to them
+		a.Flags = unix.IFA_F_NODAD
+	}
+	return a
+}
+
+func linkRoute(link netlink.Link, prefix netip.Prefix) *netlink.Route {
+	dst := prefixToIPNet(prefix.Masked())
+	return &netlink.Route{LinkIndex: link.Attrs().Index, Dst: &dst, Scope: netlink.SCOPE_LINK}
+}
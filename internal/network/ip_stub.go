@@ -0,0 +1,37 @@
+//go:build !linux
+
+package network
+
+import (
+	"errors"
+	"net/netip"
+
+	"go4.org/netipx"
+)
+
+var errNoNetlink = errors.New("managing addresses and routes is only supported on Linux")
+
+// ReserveIp is a stub for non-Linux systems
+func ReserveIp(addr netip.Prefix, link string) error {
+	return errNoNetlink
+}
+
+// ReleaseIp is a stub for non-Linux systems
+func ReleaseIp(addr netip.Prefix, link string) error {
+	return errNoNetlink
+}
+
+// AddRoutes is a stub for non-Linux systems
+func AddRoutes(link string, prefixes []netip.Prefix) error {
+	return errNoNetlink
+}
+
+// DeleteRoutes is a stub for non-Linux systems
+func DeleteRoutes(link string, prefixes []netip.Prefix) error {
+	return errNoNetlink
+}
+
+// UsedPrefixes is a stub for non-Linux systems
+func UsedPrefixes(skip func(link string) bool) (*netipx.IPSet, error) {
+	return nil, errNoNetlink
+}
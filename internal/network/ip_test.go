@@ -0,0 +1,80 @@
+package network
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+)
+
+func TestMeshPrefix(t *testing.T) {
+	for range 10 {
+		prefix, err := NewMeshPrefix()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateMeshPrefix(prefix); err != nil {
+			t.Errorf("NewMeshPrefix() = %v: %v", prefix, err)
+		}
+	}
+
+	tests := []struct {
+		prefix  string
+		wantErr bool
+	}{
+		{"", false},
+		{"fd12:3456:789a::/48", false},
+		{"fd12:3456:789a::/64", true},
+		{"fd12:3456:789a::1/48", true},
+		{"fc00::/48", true},
+		{"2001:db8::/48", true},
+		{"10.100.0.0/30", true},
+		{"fd12::3456::/48", true},
+	}
+	for _, tt := range tests {
+		if _, err := ParseMeshPrefix(tt.prefix); (err != nil) != tt.wantErr {
+			t.Errorf("ParseMeshPrefix(%q) error = %v, wantErr %v", tt.prefix, err, tt.wantErr)
+		}
+	}
+}
+
+func TestP2PAddrs(t *testing.T) {
+	prefix := netip.MustParsePrefix("fd12:3456:789a::/48")
+	a, _ := keys.GenerateKey()
+	b, _ := keys.GenerateKey()
+
+	addr := AddrFromKey(prefix, a)
+	if !prefix.Contains(addr) || addr == AddrFromKey(prefix, b) || addr != AddrFromKey(prefix, a) {
+		t.Fatalf("AddrFromKey() = %v", addr)
+	}
+
+	ipv4 := netip.MustParsePrefix("10.100.0.4/30")
+	tests := []struct {
+		name   string
+		prefix netip.Prefix
+		ipv4   netip.Prefix
+		is6    []bool
+	}{
+		{"IPv6", prefix, netip.Prefix{}, []bool{true}},
+		{"dual stack", prefix, ipv4, []bool{true, false}},
+		{"no prefix", netip.Prefix{}, ipv4, []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ab := P2PAddrs(tt.prefix, tt.ipv4, a, b)
+			ba := P2PAddrs(tt.prefix, tt.ipv4, b, a)
+			if len(ab.Local) != len(tt.is6) || len(ab.Remote) != len(tt.is6) {
+				t.Fatalf("P2PAddrs() = %+v", ab)
+			}
+			for i, is6 := range tt.is6 {
+				// Both sides agree on each other's addresses
+				if ab.Local[i] != ba.Remote[i] || ab.Remote[i] != ba.Local[i] || ab.Local[i] == ab.Remote[i] {
+					t.Errorf("Pair %d: %v/%v and %v/%v", i, ab.Local[i], ab.Remote[i], ba.Local[i], ba.Remote[i])
+				}
+				if ab.Local[i].Is6() != is6 {
+					t.Errorf("Pair %d: Is6 = %v, want %v", i, ab.Local[i].Is6(), is6)
+				}
+			}
+		})
+	}
+}
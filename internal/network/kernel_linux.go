@@ -0,0 +1,297 @@
+//go:build linux
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network/tunnel"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// KernelSupported returns an error wrapping ErrKernelUnsupported unless the
+// process has CAP_NET_ADMIN and the kernel has the WireGuard module
+func KernelSupported() error {
+	if !hasNetAdmin() {
+		return fmt.Errorf("%w: missing CAP_NET_ADMIN", ErrKernelUnsupported)
+	}
+	if !hasWireGuardModule() {
+		return fmt.Errorf("%w: no wireguard kernel module", ErrKernelUnsupported)
+	}
+	return nil
+}
+
+func hasNetAdmin() bool {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return false
+	}
+	return data[unix.CAP_NET_ADMIN/32].Effective&(1<<(unix.CAP_NET_ADMIN%32)) != 0
+}
+
+// hasWireGuardModule reports whether the module is loaded or built in, or
+// can be loaded when the first interface is created
+func hasWireGuardModule() bool {
+	if _, err := os.Stat("/sys/module/wireguard"); err == nil {
+		return true
+	}
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return false
+	}
+	dir := filepath.Join("/lib/modules", unix.ByteSliceToString(uts.Release[:]))
+	for _, name := range []string{"modules.builtin", "modules.dep"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil && strings.Contains(string(data), "/wireguard.ko") {
+			return true
+		}
+	}
+	return false
+}
+
+// kernelTransport is a WireGuard interface managed through netlink. Traffic
+// to the peer goes through the host network stack.
+type kernelTransport struct {
+	name      string
+	client    *wgctrl.Client
+	created   bool           // the interface was created for the tunnel
+	local     []netip.Prefix // the first is the one syncsh talks over
+	routes    []netip.Prefix // the peer's allowed IPs
+	remote    netip.Addr
+	remoteKey keys.Key
+}
+
+// NewKernelTransport creates or reuses the WireGuard interface name,
+// configures it for the peer in cfg, assigns the local address, brings it
+// up and routes the peer's allowed IPs over it. Close undoes all of it.
+func NewKernelTransport(name string, cfg P2PConfig) (Transport, error) {
+	devCfg, err := cfg.CreateP2PDeviceConfig()
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.LocalIPs) == 0 || len(cfg.RemoteIPs) == 0 {
+		return nil, errors.New("tunnel has no addresses")
+	}
+	var local []netip.Prefix
+	for _, ip := range cfg.LocalIPs {
+		prefix, err := addrToSingleIPPrefix(ip)
+		if err != nil {
+			return nil, err
+		}
+		local = append(local, prefix)
+	}
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("open wgctrl: %w", err)
+	}
+	link, created, err := getOrCreateWireGuardInterface(name)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	t := &kernelTransport{
+		name:      name,
+		client:    client,
+		created:   created,
+		local:     local,
+		remote:    cfg.RemoteIPs[0],
+		remoteKey: cfg.RemotePublicKey,
+	}
+	for _, peer := range devCfg.Peers {
+		for _, ipNet := range peer.AllowedIPs {
+			if prefix, ok := ipNetToPrefix(ipNet); ok {
+				t.routes = append(t.routes, prefix)
+			}
+		}
+	}
+	if err := t.setUp(link, devCfg); err != nil {
+		t.Close()
+		return nil, err
+	}
+	log.Info("Configured WireGuard interface.", "name", name, "address", local, "routes", t.routes)
+	return t, nil
+}
+
+func (t *kernelTransport) setUp(link netlink.Link, devCfg wgtypes.Config) error {
+	if err := t.client.ConfigureDevice(t.name, devCfg); err != nil {
+		return fmt.Errorf("configure WireGuard interface %s: %w", t.name, err)
+	}
+	for _, local := range t.local {
+		if err := ReserveIp(local, t.name); err != nil {
+			return err
+		}
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("bring up %s: %w", t.name, err)
+	}
+	return AddRoutes(t.name, t.routes)
+}
+
+func (t *kernelTransport) LocalAddr() netip.Addr  { return t.local[0].Addr() }
+func (t *kernelTransport) RemoteAddr() netip.Addr { return t.remote }
+func (t *kernelTransport) Bind() conn.Bind        { return nil }
+
+// ListenTCP listens on the tunnel address. Unlike a netstack, the socket is
+// on the host, so it is bound to the WireGuard interface to keep other
+// hosts on the link from reaching it, and connections from any address but
+// the peer's are dropped, such as those of local processes.
+func (t *kernelTransport) ListenTCP(port uint16) (net.Listener, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, t.name)
+		}); cerr != nil {
+			return cerr
+		}
+		if err != nil {
+			return fmt.Errorf("bind to %s: %w", t.name, err)
+		}
+		return nil
+	}}
+	ln, err := lc.Listen(context.Background(), "tcp", netip.AddrPortFrom(t.LocalAddr(), port).String())
+	if err != nil {
+		return nil, err
+	}
+	return &peerListener{Listener: ln, remote: t.remote}, nil
+}
+
+// peerListener accepts connections from remote only
+type peerListener struct {
+	net.Listener
+	remote netip.Addr
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		addr, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+		if err == nil && addr.Addr().Unmap() == l.remote {
+			return conn, nil
+		}
+		log.Warn("Dropped connection from outside the tunnel.", "from", conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+func (t *kernelTransport) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d := net.Dialer{LocalAddr: net.TCPAddrFromAddrPort(netip.AddrPortFrom(t.LocalAddr(), 0))}
+	return d.DialContext(ctx, network, address)
+}
+
+func (t *kernelTransport) device() (*wgtypes.Device, error) {
+	if t.client == nil {
+		return nil, net.ErrClosed
+	}
+	dev, err := t.client.Device(t.name)
+	if err != nil {
+		return nil, fmt.Errorf("read WireGuard interface %s: %w", t.name, err)
+	}
+	return dev, nil
+}
+
+func (t *kernelTransport) Stats() (tunnel.PeerStats, error) {
+	dev, err := t.device()
+	if err != nil {
+		return tunnel.PeerStats{}, err
+	}
+	for _, p := range dev.Peers {
+		if keys.Key(p.PublicKey) != t.remoteKey {
+			continue
+		}
+		stats := tunnel.PeerStats{
+			PublicKey:     t.remoteKey,
+			LastHandshake: p.LastHandshakeTime,
+			RxBytes:       p.ReceiveBytes,
+			TxBytes:       p.TransmitBytes,
+		}
+		if p.Endpoint != nil {
+			stats.Endpoint = p.Endpoint.AddrPort().String()
+		}
+		return stats, nil
+	}
+	return tunnel.PeerStats{}, fmt.Errorf("WireGuard interface %s has no peer %s", t.name, t.remoteKey)
+}
+
+// SetEndpoint takes address:port; the kernel cannot send through a relay
+func (t *kernelTransport) SetEndpoint(endpoint string) error {
+	if t.client == nil {
+		return net.ErrClosed
+	}
+	addr, err := netip.ParseAddrPort(endpoint)
+	if err != nil {
+		return fmt.Errorf("set WireGuard endpoint: %w", err)
+	}
+	err = t.client.ConfigureDevice(t.name, wgtypes.Config{Peers: []wgtypes.PeerConfig{{
+		PublicKey:  wgtypes.Key(t.remoteKey),
+		UpdateOnly: true,
+		Endpoint:   net.UDPAddrFromAddrPort(addr),
+	}}})
+	if err != nil {
+		return fmt.Errorf("set WireGuard endpoint: %w", err)
+	}
+	return nil
+}
+
+func (t *kernelTransport) ListenPort() (uint16, error) {
+	dev, err := t.device()
+	if err != nil {
+		return 0, err
+	}
+	return uint16(dev.ListenPort), nil
+}
+
+// Close deletes the interface if the tunnel created it. An interface that
+// existed before only loses the routes, the address and the peer.
+func (t *kernelTransport) Close() {
+	if t.client == nil {
+		return
+	}
+	defer func() {
+		t.client.Close()
+		t.client = nil
+	}()
+
+	link, err := netlink.LinkByName(t.name)
+	if err != nil {
+		return
+	}
+	if t.created {
+		if err := netlink.LinkDel(link); err != nil {
+			log.Warn("Failed to delete WireGuard interface.", "name", t.name, "error", err)
+		}
+		return
+	}
+	if err := DeleteRoutes(t.name, t.routes); err != nil {
+		log.Warn("Failed to remove routes.", "name", t.name, "error", err)
+	}
+	for _, local := range t.local {
+		if err := ReleaseIp(local, t.name); err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+			log.Warn("Failed to remove address.", "name", t.name, "error", err)
+		}
+	}
+	err = t.client.ConfigureDevice(t.name, wgtypes.Config{Peers: []wgtypes.PeerConfig{{
+		PublicKey: wgtypes.Key(t.remoteKey),
+		Remove:    true,
+	}}})
+	if err != nil {
+		log.Warn("Failed to remove WireGuard peer.", "name", t.name, "error", err)
+	}
+}
@@ -0,0 +1,339 @@
+//go:build linux
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+// inNetns runs fn on a thread of its own that starts in a new network
+// namespace. The thread is never unlocked, so it exits with the goroutine
+// and no other code runs in the namespaces fn switched to.
+func inNetns(t *testing.T, fn func(ns netns.NsHandle) error) {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		ns, err := netns.New()
+		if err != nil {
+			errc <- fmt.Errorf("%w: %v", errors.ErrUnsupported, err)
+			return
+		}
+		defer ns.Close()
+		lo, err := netlink.LinkByName("lo")
+		if err == nil {
+			err = netlink.LinkSetUp(lo)
+		}
+		if err != nil {
+			errc <- err
+			return
+		}
+		errc <- fn(ns)
+	}()
+	if err := <-errc; errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("Network namespaces are unavailable: %v", err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddressesAndRoutes(t *testing.T) {
+	inNetns(t, func(netns.NsHandle) error {
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return err
+		}
+		addr := netip.MustParsePrefix("10.100.0.1/32")
+		addr6 := netip.MustParsePrefix("fd12:3456:789a::1/128")
+		routes := []netip.Prefix{netip.MustParsePrefix("10.100.0.2/32"), netip.MustParsePrefix("10.200.0.0/24")}
+
+		// Both are idempotent
+		for range 2 {
+			if err := ReserveIp(addr, "lo"); err != nil {
+				return err
+			}
+			if err := ReserveIp(addr6, "lo"); err != nil {
+				return err
+			}
+			if err := AddRoutes("lo", routes); err != nil {
+				return err
+			}
+		}
+		if got, err := linkAddrs(lo); err != nil || !slices.Contains(got, addr) || !slices.Contains(got, addr6) {
+			return fmt.Errorf("addresses of lo = %v (%v), want %v and %v", got, err, addr, addr6)
+		}
+		if got, err := linkRoutes(lo); err != nil || !slices.Equal(got, routes) {
+			return fmt.Errorf("routes over lo = %v (%v), want %v", got, err, routes)
+		}
+
+		if err := DeleteRoutes("lo", routes); err != nil {
+			return err
+		}
+		if err := DeleteRoutes("lo", routes); err != nil {
+			return fmt.Errorf("deleting missing routes: %w", err)
+		}
+		if err := ReleaseIp(addr, "lo"); err != nil {
+			return err
+		}
+		if got, err := linkAddrs(lo); err != nil || slices.Contains(got, addr) {
+			return fmt.Errorf("addresses of lo = %v (%v), want %v gone", got, err, addr)
+		}
+		if got, err := linkRoutes(lo); err != nil || len(got) != 0 {
+			return fmt.Errorf("routes over lo = %v (%v), want none", got, err)
+		}
+		return nil
+	})
+}
+
+func TestUsedPrefixes(t *testing.T) {
+	inNetns(t, func(netns.NsHandle) error {
+		if err := ReserveIp(netip.MustParsePrefix("10.100.0.1/32"), "lo"); err != nil {
+			return err
+		}
+		if err := AddRoutes("lo", []netip.Prefix{netip.MustParsePrefix("10.100.0.4/30")}); err != nil {
+			return err
+		}
+		used, err := UsedPrefixes(func(string) bool { return false })
+		if err != nil {
+			return err
+		}
+		pool, _ := ParsePool(nil)
+		if got, err := PickP2PPrefix(pool, used); err != nil || got.String() != "10.100.0.8/30" {
+			return fmt.Errorf("PickP2PPrefix() = %v (%v), want 10.100.0.8/30", got, err)
+		}
+
+		// The tunnel's own interface does not count
+		used, err = UsedPrefixes(func(link string) bool { return link == "lo" })
+		if err != nil {
+			return err
+		}
+		if used.Contains(netip.MustParseAddr("10.100.0.1")) || used.Contains(netip.MustParseAddr("127.0.0.1")) {
+			return fmt.Errorf("UsedPrefixes() skipping lo = %v", used.Prefixes())
+		}
+		return nil
+	})
+}
+
+func TestKernelTransport(t *testing.T) {
+	if err := KernelSupported(); err != nil {
+		t.Skip(err)
+	}
+	privA, _ := keys.GenerateKey()
+	pubA, _ := privA.PublicKey()
+	privB, _ := keys.GenerateKey()
+	pubB, _ := privB.PublicKey()
+	prefix, err := NewMeshPrefix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrsA := P2PAddrs(prefix, netip.MustParsePrefix("10.100.0.0/30"), pubA, pubB)
+	addrsB := P2PAddrs(prefix, netip.MustParsePrefix("10.100.0.0/30"), pubB, pubA)
+
+	inNetns(t, func(nsA netns.NsHandle) error {
+		nsB, err := netns.New()
+		if err != nil {
+			return err
+		}
+		defer nsB.Close()
+
+		// A veth pair between the namespaces carries the encrypted packets
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "veth-b"}, PeerName: "veth-a"}
+		if err := netlink.LinkAdd(veth); err != nil {
+			return fmt.Errorf("%w: veth: %v", errors.ErrUnsupported, err)
+		}
+		peer, err := netlink.LinkByName("veth-a")
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetNsFd(peer, int(nsA)); err != nil {
+			return err
+		}
+		if err := upWithAddr("veth-b", "192.0.2.2/24"); err != nil {
+			return err
+		}
+		if err := netns.Set(nsA); err != nil {
+			return err
+		}
+		if err := upWithAddr("veth-a", "192.0.2.1/24"); err != nil {
+			return err
+		}
+
+		a, err := NewKernelTransport("wg-a", P2PConfig{
+			LocalIPs:        addrsA.Local,
+			RemoteIPs:       addrsA.Remote,
+			LocalPrivateKey: privA,
+			RemotePublicKey: pubB,
+			RemoteEndpoint:  netip.MustParseAddrPort("192.0.2.2:51821"),
+			ListenPort:      51820,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := netns.Set(nsB); err != nil {
+			return err
+		}
+		b, err := NewKernelTransport("wg-b", P2PConfig{
+			LocalIPs:        addrsB.Local,
+			RemoteIPs:       addrsB.Remote,
+			LocalPrivateKey: privB,
+			RemotePublicKey: pubA,
+			ListenPort:      51821,
+		})
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		ln, err := b.ListenTCP(7420)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+
+		if err := netns.Set(nsA); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		conn, err := a.DialContext(ctx, "tcp", netip.AddrPortFrom(a.RemoteAddr(), 7420).String())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return err
+		}
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			return fmt.Errorf("echo = %q, %v", buf, err)
+		}
+		if a.RemoteAddr() != b.LocalAddr() || !a.RemoteAddr().Is6() {
+			return fmt.Errorf("A dials %v, B listens on %v", a.RemoteAddr(), b.LocalAddr())
+		}
+
+		stats, err := a.Stats()
+		if err != nil {
+			return err
+		}
+		if stats.LastHandshake.IsZero() || stats.Endpoint != "192.0.2.2:51821" {
+			return fmt.Errorf("stats = %+v", stats)
+		}
+		if err := a.SetEndpoint("192.0.2.2:51822"); err != nil {
+			return err
+		}
+		if stats, err := a.Stats(); err != nil || stats.Endpoint != "192.0.2.2:51822" {
+			return fmt.Errorf("endpoint after SetEndpoint = %q (%v)", stats.Endpoint, err)
+		}
+
+		// The interface was created for the tunnel, so closing deletes it
+		a.Close()
+		if _, err := netlink.LinkByName("wg-a"); err == nil {
+			return errors.New("wg-a is still there after Close")
+		}
+		return nil
+	})
+}
+
+func upWithAddr(name, addr string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if err := netlink.AddrAdd(link, netlinkAddr(netip.MustParsePrefix(addr))); err != nil {
+		return err
+	}
+	return netlink.LinkSetUp(link)
+}
+
+func linkAddrs(link netlink.Link) ([]netip.Prefix, error) {
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	for _, a := range addrs {
+		if p, ok := ipNetToPrefix(*a.IPNet); ok {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes, nil
+}
+
+// linkRoutes lists the main table's link-scope routes over link
+func linkRoutes(link netlink.Link) ([]netip.Prefix, error) {
+	routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	for _, r := range routes {
+		if r.Dst == nil || r.Scope != netlink.SCOPE_LINK {
+			continue
+		}
+		if p, ok := ipNetToPrefix(*r.Dst); ok {
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes, nil
+}
+
+func TestPeerListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := &peerListener{Listener: inner, remote: netip.MustParseAddr("127.0.0.2")}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// A local process is not the peer
+	stranger, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stranger.Close()
+	stranger.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := stranger.Read(make([]byte, 1)); err == nil {
+		t.Error("Connection from outside the tunnel was not dropped")
+	}
+
+	d := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+	peer, err := d.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Error("Connection from the peer was not accepted")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package network
+
+import "fmt"
+
+// KernelSupported is always an error on non-Linux systems
+func KernelSupported() error {
+	return fmt.Errorf("%w: only supported on Linux", ErrKernelUnsupported)
+}
+
+// NewKernelTransport is a stub for non-Linux systems
+func NewKernelTransport(name string, cfg P2PConfig) (Transport, error) {
+	return nil, KernelSupported()
+}
@@ -1,64 +1,132 @@
 package network
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"errors"
 	"net/netip"
 
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
 	"github.com/TheRealSibasishBehera/syncsh/internal/network/tunnel"
-	"github.com/TheRealSibasishBehera/syncsh/internal/secret"
+	"golang.zx2c4.com/wireguard/conn"
 )
 
-// CreateP2PConnection creates a simple point-to-point WireGuard connection
-func CreateP2PConnection(isInitiator bool, remoteEndpoint string, localPrivKey, remotePubKey secret.Secret) (*tunnel.Tunnel, error) {
-	var localIP, remoteIP netip.Addr
+// IsInitiator reports whether the machine with localPubKey takes the
+// initiator address in a point-to-point tunnel with remotePubKey. Both sides
+// compare the same two keys, so they always pick opposite addresses.
+func IsInitiator(localPubKey, remotePubKey keys.Key) bool {
+	return bytes.Compare(localPubKey[:], remotePubKey[:]) < 0
+}
+
+// TunnelAddrs are the addresses of this machine and of the peer inside a
+// point-to-point tunnel. Local[0] and Remote[0] are the ones syncsh talks
+// over; the rest stay reachable for peers that still use them.
+type TunnelAddrs struct {
+	Local  []netip.Addr
+	Remote []netip.Addr
+}
 
-	// Assign fixed IPs for P2P
-	if isInitiator {
-		localIP = netip.MustParseAddr(MachineAIP)
-		remoteIP = netip.MustParseAddr(MachineBIP)
-	} else {
-		localIP = netip.MustParseAddr(MachineBIP)
-		remoteIP = netip.MustParseAddr(MachineAIP)
+// P2PAddrs returns the tunnel addresses of two machines. With a mesh prefix
+// each machine has the IPv6 address derived from its key. With an IPv4
+// prefix, see PickP2PPrefix, the machines also get its pair of addresses,
+// assigned by key order.
+func P2PAddrs(meshPrefix, ipv4Prefix netip.Prefix, localPubKey, remotePubKey keys.Key) TunnelAddrs {
+	var addrs TunnelAddrs
+	if meshPrefix.IsValid() {
+		addrs.Local = append(addrs.Local, AddrFromKey(meshPrefix, localPubKey))
+		addrs.Remote = append(addrs.Remote, AddrFromKey(meshPrefix, remotePubKey))
+	}
+	if ipv4Prefix.IsValid() {
+		a, b := P2PIPv4(ipv4Prefix, IsInitiator(localPubKey, remotePubKey))
+		addrs.Local = append(addrs.Local, a)
+		addrs.Remote = append(addrs.Remote, b)
 	}
+	return addrs
+}
 
-	endpoint, err := netip.ParseAddrPort(remoteEndpoint)
+// CreateP2PConnection creates a simple point-to-point WireGuard connection
+// over a userspace netstack.
+// remoteEndpoint may be a hostname, which is resolved to its first address,
+// or empty if the peer connects to us first, in which case listenPort must be
+// the port the peer has as our endpoint. bind may be nil
+// to send packets over plain UDP.
+func CreateP2PConnection(addrs TunnelAddrs, remoteEndpoint string, listenPort int, localPrivKey, remotePubKey keys.Key, bind conn.Bind) (*tunnel.Tunnel, error) {
+	if len(addrs.Local) == 0 || len(addrs.Remote) == 0 {
+		return nil, errors.New("tunnel has no addresses")
+	}
+	endpoint, err := resolveRemoteEndpoint(remoteEndpoint)
 	if err != nil {
-		return nil, fmt.Errorf("parse remote endpoint: %w", err)
+		return nil, err
+	}
+
+	var remoteNetworks []netip.Prefix
+	for _, addr := range addrs.Remote {
+		prefix, err := addrToSingleIPPrefix(addr) // Single host
+		if err != nil {
+			return nil, err
+		}
+		remoteNetworks = append(remoteNetworks, prefix)
 	}
 
 	config := &tunnel.Config{
-		LocalAddress:    localIP,
+		LocalAddress:    addrs.Local[0],
 		LocalPrivateKey: localPrivKey,
+		ListenPort:      listenPort,
 		Endpoint:        endpoint,
 		RemotePublicKey: remotePubKey,
-		RemoteNetwork:   netip.PrefixFrom(remoteIP, 32), // Single host
+		RemoteNetwork:   remoteNetworks[0],
+		ExtraAddresses:  addrs.Local[1:],
+		ExtraNetworks:   remoteNetworks[1:],
+		Bind:            bind,
 	}
 
 	return tunnel.Connect(config)
 }
 
-// CreateP2PConfig creates a P2PConfig for the given parameters
-func CreateP2PConfig(isInitiator bool, remoteEndpoint string, localPrivKey, remotePubKey secret.Secret) (P2PConfig, error) {
-	var localIP, remoteIP netip.Addr
-
-	if isInitiator {
-		localIP = netip.MustParseAddr(MachineAIP)
-		remoteIP = netip.MustParseAddr(MachineBIP)
-	} else {
-		localIP = netip.MustParseAddr(MachineBIP)
-		remoteIP = netip.MustParseAddr(MachineAIP)
+// CreateP2PTransport creates a point-to-point connection over the kernel
+// interface name or a netstack, depending on kind. bind only applies to the
+// netstack.
+func CreateP2PTransport(kind TransportKind, name string, addrs TunnelAddrs, remoteEndpoint string, listenPort int, localPrivKey, remotePubKey keys.Key, bind conn.Bind) (Transport, error) {
+	if kind != TransportKernel {
+		t, err := CreateP2PConnection(addrs, remoteEndpoint, listenPort, localPrivKey, remotePubKey, bind)
+		if err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	config, err := CreateP2PConfig(addrs, remoteEndpoint, localPrivKey, remotePubKey)
+	if err != nil {
+		return nil, err
 	}
+	config.ListenPort = listenPort
+	return NewKernelTransport(name, config)
+}
 
-	endpoint, err := netip.ParseAddrPort(remoteEndpoint)
+// CreateP2PConfig creates a P2PConfig for the given parameters
+func CreateP2PConfig(addrs TunnelAddrs, remoteEndpoint string, localPrivKey, remotePubKey keys.Key) (P2PConfig, error) {
+	endpoint, err := resolveRemoteEndpoint(remoteEndpoint)
 	if err != nil {
-		return P2PConfig{}, fmt.Errorf("parse remote endpoint: %w", err)
+		return P2PConfig{}, err
 	}
 
 	return P2PConfig{
-		LocalIP:         localIP,
-		RemoteIP:        remoteIP,
+		LocalIPs:        addrs.Local,
+		RemoteIPs:       addrs.Remote,
 		LocalPrivateKey: localPrivKey,
 		RemotePublicKey: remotePubKey,
 		RemoteEndpoint:  endpoint,
 	}, nil
 }
+
+// resolveRemoteEndpoint resolves a hostname endpoint to its first address.
+// An empty endpoint stays unset.
+func resolveRemoteEndpoint(remoteEndpoint string) (netip.AddrPort, error) {
+	if remoteEndpoint == "" {
+		return netip.AddrPort{}, nil
+	}
+	addrs, err := ResolveEndpoint(context.Background(), remoteEndpoint)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return addrs[0], nil
+}
@@ -1,7 +1,15 @@
 package network
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
 	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 // Simple peer status for P2P connections
@@ -12,15 +20,91 @@ const (
 
 // SimplePeerStatus represents basic peer connection state
 type SimplePeerStatus struct {
-	IP               string
-	PublicKey        string
-	Status           string
-	LastHandshake    time.Time
-	BytesReceived    int64
-	BytesTransmitted int64
+	IP               string    `json:"ip,omitempty"`
+	PublicKey        string    `json:"public_key"`
+	Status           string    `json:"status"`
+	LastHandshake    time.Time `json:"last_handshake,omitzero"`
+	BytesReceived    int64     `json:"bytes_received"`
+	BytesTransmitted int64     `json:"bytes_transmitted"`
 }
 
 // IsConnected returns true if peer had a handshake in the last 3 minutes
 func (s SimplePeerStatus) IsConnected() bool {
 	return time.Since(s.LastHandshake) < 3*time.Minute
 }
+
+func newPeerStatus(ip string, publicKey keys.Key, handshake time.Time, rx, tx int64) SimplePeerStatus {
+	s := SimplePeerStatus{
+		IP:               ip,
+		PublicKey:        publicKey.String(),
+		LastHandshake:    handshake,
+		BytesReceived:    rx,
+		BytesTransmitted: tx,
+	}
+	s.Status = PeerStatusDisconnected
+	if s.IsConnected() {
+		s.Status = PeerStatusConnected
+	}
+	return s
+}
+
+// TransportPeerStatus reports the peer of a tunnel
+func TransportPeerStatus(t Transport) (SimplePeerStatus, error) {
+	stats, err := t.Stats()
+	if err != nil {
+		return SimplePeerStatus{}, err
+	}
+	return newPeerStatus(t.RemoteAddr().String(), stats.PublicKey, stats.LastHandshake, stats.RxBytes, stats.TxBytes), nil
+}
+
+// KernelPeerStatus reports the peers of a kernel WireGuard interface
+func KernelPeerStatus(iface string) ([]SimplePeerStatus, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("open wgctrl: %w", err)
+	}
+	defer client.Close()
+
+	dev, err := client.Device(iface)
+	if err != nil {
+		return nil, fmt.Errorf("read WireGuard interface %s: %w", iface, err)
+	}
+	statuses := make([]SimplePeerStatus, 0, len(dev.Peers))
+	for _, p := range dev.Peers {
+		var ip string
+		if len(p.AllowedIPs) > 0 {
+			ip = p.AllowedIPs[0].IP.String()
+		}
+		statuses = append(statuses, newPeerStatus(ip, keys.Key(p.PublicKey), p.LastHandshakeTime, p.ReceiveBytes, p.TransmitBytes))
+	}
+	return statuses, nil
+}
+
+// RemoveKernelPeer removes the peer with key from the kernel WireGuard
+// interface iface. A missing interface or peer is not an error.
+func RemoveKernelPeer(iface string, key keys.Key) error {
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("open wgctrl: %w", err)
+	}
+	defer client.Close()
+
+	dev, err := client.Device(iface)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read WireGuard interface %s: %w", iface, err)
+	}
+	if !slices.ContainsFunc(dev.Peers, func(p wgtypes.Peer) bool { return keys.Key(p.PublicKey) == key }) {
+		return nil
+	}
+	err = client.ConfigureDevice(iface, wgtypes.Config{Peers: []wgtypes.PeerConfig{{
+		PublicKey: wgtypes.Key(key),
+		Remove:    true,
+	}}})
+	if err != nil {
+		return fmt.Errorf("remove peer from %s: %w", iface, err)
+	}
+	return nil
+}
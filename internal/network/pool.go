@@ -0,0 +1,78 @@
+package network
+
+import (
+	"fmt"
+	"net/netip"
+
+	"go4.org/netipx"
+)
+
+const (
+	// DefaultTunnelPool is where IPv4 tunnel prefixes come from. Its first
+	// /30 is the one older versions always used.
+	DefaultTunnelPool = "10.100.0.0/24"
+	// p2pPrefixBits is the size of an IPv4 point-to-point tunnel prefix
+	p2pPrefixBits = 30
+)
+
+// ParsePool parses the prefixes of a tunnel pool. An empty pool is
+// DefaultTunnelPool.
+func ParsePool(pool []string) ([]netip.Prefix, error) {
+	if len(pool) == 0 {
+		pool = []string{DefaultTunnelPool}
+	}
+	prefixes := make([]netip.Prefix, 0, len(pool))
+	for _, s := range pool {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("parse tunnel pool: %w", err)
+		}
+		if !prefix.Addr().Is4() || prefix.Bits() > p2pPrefixBits {
+			return nil, fmt.Errorf("tunnel pool %s is not an IPv4 prefix of /%d or larger", prefix, p2pPrefixBits)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// ParseP2PPrefix parses a prefix pinned for one tunnel, which must be an
+// IPv4 /30
+func ParseP2PPrefix(s string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("parse tunnel prefix: %w", err)
+	}
+	if !prefix.Addr().Is4() || prefix.Bits() != p2pPrefixBits {
+		return netip.Prefix{}, fmt.Errorf("tunnel prefix %s is not an IPv4 /%d", prefix, p2pPrefixBits)
+	}
+	return prefix.Masked(), nil
+}
+
+// PickP2PPrefix returns a /30 from the pool that does not overlap used,
+// trying the pool's prefixes in order
+func PickP2PPrefix(pool []netip.Prefix, used *netipx.IPSet) (netip.Prefix, error) {
+	for _, prefix := range pool {
+		var b netipx.IPSetBuilder
+		b.AddPrefix(prefix)
+		b.RemoveSet(used)
+		free, err := b.IPSet()
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("compute free tunnel prefixes: %w", err)
+		}
+		if p, _, ok := free.RemoveFreePrefix(p2pPrefixBits); ok {
+			return p, nil
+		}
+	}
+	return netip.Prefix{}, fmt.Errorf("no free /%d left in tunnel pool %v: every prefix overlaps an existing route or address, set tunnel_pool to a range that is not in use", p2pPrefixBits, pool)
+}
+
+// P2PIPv4 returns the pair of addresses inside a /30: the first host goes to
+// the initiator and the second to the other side
+func P2PIPv4(prefix netip.Prefix, isInitiator bool) (local, remote netip.Addr) {
+	a := prefix.Masked().Addr().Next()
+	b := a.Next()
+	if isInitiator {
+		return a, b
+	}
+	return b, a
+}
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"net/netip"
+	"testing"
+
+	"go4.org/netipx"
+)
+
+func TestPickP2PPrefix(t *testing.T) {
+	set := func(prefixes ...string) *netipx.IPSet {
+		var b netipx.IPSetBuilder
+		for _, p := range prefixes {
+			b.AddPrefix(netip.MustParsePrefix(p))
+		}
+		s, err := b.IPSet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	pool, err := ParsePool(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	twoPools, err := ParsePool([]string{"10.100.0.0/29", "172.31.255.0/30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pool    []netip.Prefix
+		used    *netipx.IPSet
+		want    string
+		wantErr bool
+	}{
+		{"nothing used", pool, set(), "10.100.0.0/30", false},
+		{"route over the first", pool, set("10.100.0.2/32"), "10.100.0.4/30", false},
+		{"address in the first two", pool, set("10.100.0.1/32", "10.100.0.6/32"), "10.100.0.8/30", false},
+		{"next pool", twoPools, set("10.100.0.0/30", "10.100.0.5/32"), "172.31.255.0/30", false},
+		{"larger route", pool, set("10.0.0.0/8"), "", true},
+		{"all used", twoPools, set("10.100.0.0/29", "172.31.255.3/32"), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PickP2PPrefix(tt.pool, tt.used)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PickP2PPrefix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("PickP2PPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range [][]string{{"10.100.0.0/31"}, {"fd00::/64"}, {"10.100.0.0"}} {
+		if _, err := ParsePool(bad); err == nil {
+			t.Errorf("ParsePool(%q) succeeded", bad)
+		}
+	}
+	for s, wantErr := range map[string]bool{"10.100.0.4/30": false, "10.100.0.0/24": true, "fd00::/126": true} {
+		if _, err := ParseP2PPrefix(s); (err != nil) != wantErr {
+			t.Errorf("ParseP2PPrefix(%q) error = %v, wantErr %v", s, err, wantErr)
+		}
+	}
+}
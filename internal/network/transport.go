@@ -0,0 +1,94 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/network/tunnel"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// Transport is a WireGuard tunnel to one peer, either a kernel interface or
+// a userspace netstack
+type Transport interface {
+	// LocalAddr and RemoteAddr are the two ends' addresses inside the tunnel
+	LocalAddr() netip.Addr
+	RemoteAddr() netip.Addr
+
+	ListenTCP(port uint16) (net.Listener, error)
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+
+	// Stats reads the peer's endpoint, last handshake and counters
+	Stats() (tunnel.PeerStats, error)
+	// SetEndpoint changes where packets to the peer are sent
+	SetEndpoint(endpoint string) error
+	// ListenPort returns the local UDP port of the tunnel
+	ListenPort() (uint16, error)
+	// Bind returns the bind a userspace tunnel sends its packets with, nil
+	// for the kernel
+	Bind() conn.Bind
+
+	Close()
+}
+
+var _ Transport = (*tunnel.Tunnel)(nil)
+
+// TransportKind selects the WireGuard implementation
+type TransportKind string
+
+const (
+	// TransportAuto uses the kernel when it can and the netstack otherwise
+	TransportAuto TransportKind = "auto"
+	// TransportKernel uses the WireGuard kernel module through netlink. It
+	// needs CAP_NET_ADMIN.
+	TransportKernel TransportKind = "kernel"
+	// TransportNetstack runs wireguard-go with a gVisor network stack in the
+	// process. It needs no privileges and works in containers.
+	TransportNetstack TransportKind = "netstack"
+)
+
+// ErrKernelUnsupported is returned by KernelSupported when the kernel
+// transport cannot be used
+var ErrKernelUnsupported = errors.New("kernel WireGuard is unavailable")
+
+// Validate checks that k is a known kind. The empty kind means auto.
+func (k TransportKind) Validate() error {
+	switch k {
+	case "", TransportAuto, TransportKernel, TransportNetstack:
+		return nil
+	default:
+		return fmt.Errorf("unsupported transport: %s (supported: auto, kernel, netstack)", k)
+	}
+}
+
+// SelectTransport resolves kind to the kernel or the netstack. Auto picks
+// the kernel when KernelSupported. Tunnels through a relay need the
+// netstack, whose bind can forward packets to it.
+func SelectTransport(kind TransportKind, relayed bool) (TransportKind, error) {
+	if err := kind.Validate(); err != nil {
+		return "", err
+	}
+	switch kind {
+	case TransportNetstack:
+		return TransportNetstack, nil
+	case TransportKernel:
+		if relayed {
+			return "", errors.New("the relay needs the netstack transport")
+		}
+		if err := KernelSupported(); err != nil {
+			return "", err
+		}
+		return TransportKernel, nil
+	}
+	if relayed {
+		return TransportNetstack, nil
+	}
+	if err := KernelSupported(); err != nil {
+		log.Debug("Using the netstack transport.", "reason", err)
+		return TransportNetstack, nil
+	}
+	return TransportKernel, nil
+}
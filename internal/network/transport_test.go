@@ -0,0 +1,68 @@
+package network
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+)
+
+func TestSelectTransport(t *testing.T) {
+	kernel := KernelSupported() == nil
+	auto := TransportNetstack
+	if kernel {
+		auto = TransportKernel
+	}
+
+	tests := []struct {
+		kind    TransportKind
+		relayed bool
+		want    TransportKind
+		wantErr bool
+	}{
+		{"", false, auto, false},
+		{TransportAuto, false, auto, false},
+		{TransportAuto, true, TransportNetstack, false},
+		{TransportNetstack, false, TransportNetstack, false},
+		{TransportNetstack, true, TransportNetstack, false},
+		{TransportKernel, true, "", true},
+		{"tun", false, "", true},
+	}
+	for _, tt := range tests {
+		got, err := SelectTransport(tt.kind, tt.relayed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SelectTransport(%q, %v) error = %v, wantErr %v", tt.kind, tt.relayed, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("SelectTransport(%q, %v) = %q, want %q", tt.kind, tt.relayed, got, tt.want)
+		}
+	}
+
+	_, err := SelectTransport(TransportKernel, false)
+	if kernel && err != nil {
+		t.Errorf("SelectTransport(kernel) error = %v", err)
+	}
+	if !kernel && !errors.Is(err, ErrKernelUnsupported) {
+		t.Errorf("SelectTransport(kernel) error = %v, want ErrKernelUnsupported", err)
+	}
+}
+
+func TestPeerInterfaceName(t *testing.T) {
+	a, _ := keys.GenerateKey()
+	b, _ := keys.GenerateKey()
+
+	name := PeerInterfaceName("syncsh0", a)
+	if len(name) > maxInterfaceName || name[:7] != "syncsh-" {
+		t.Errorf("PeerInterfaceName() = %q", name)
+	}
+	if PeerInterfaceName("syncsh0", a) != name {
+		t.Error("PeerInterfaceName() is not stable")
+	}
+	if PeerInterfaceName("syncsh0", b) == name {
+		t.Error("Two peers share an interface")
+	}
+	if long := PeerInterfaceName("averylonginterface", a); len(long) != maxInterfaceName {
+		t.Errorf("PeerInterfaceName() = %q, want it cut to %d characters", long, maxInterfaceName)
+	}
+}
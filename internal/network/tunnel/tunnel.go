@@ -2,8 +2,15 @@ package tunnel
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
-	"github.com/TheRealSibasishBehera/syncsh/internal/secret"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
 
 	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/device"
@@ -19,20 +26,46 @@ const (
 	DefaultKeepaliveInterval = 25 * time.Second
 )
 
+var log = logging.For(logging.WireGuard)
+
+// newLogger routes wireguard-go's output to the wireguard subsystem:
+// verbose messages at debug level and errors at error level
+func newLogger() *device.Logger {
+	return &device.Logger{
+		Verbosef: func(format string, args ...any) {
+			if log.Enabled(context.Background(), slog.LevelDebug) {
+				log.Debug(fmt.Sprintf(format, args...))
+			}
+		},
+		Errorf: func(format string, args ...any) {
+			log.Error(fmt.Sprintf(format, args...))
+		},
+	}
+}
+
 type Tunnel struct {
-	dev *device.Device
-	net *netstack.Net
+	mu        sync.Mutex // guards dev and net, which Close clears
+	dev       *device.Device
+	bind      conn.Bind
+	net       *netstack.Net
+	local     netip.Addr
+	remote    netip.Addr
+	remoteKey keys.Key
 }
 
 type Config struct {
 	LocalAddress    netip.Addr
-	LocalPrivateKey secret.Secret
-	Endpoint        netip.AddrPort
-	RemotePublicKey secret.Secret
+	LocalPrivateKey keys.Key
+	ListenPort      int            // local UDP port, 0 picks a random one
+	Endpoint        netip.AddrPort // unset if the peer connects to us first
+	RemotePublicKey keys.Key
 	RemoteNetwork   netip.Prefix
+	ExtraAddresses  []netip.Addr   // more local addresses, e.g. IPv4 next to IPv6
+	ExtraNetworks   []netip.Prefix // more allowed IPs of the peer
 	DNS             *netip.Addr
 	MTU             int
 	KeepAlive       time.Duration
+	Bind            conn.Bind // sends and receives the encrypted packets, nil uses plain UDP
 }
 
 func Connect(config *Config) (*Tunnel, error) {
@@ -51,12 +84,17 @@ func Connect(config *Config) (*Tunnel, error) {
 		keepAlive = DefaultKeepaliveInterval
 	}
 
-	tun, tnet, err := netstack.CreateNetTUN([]netip.Addr{config.LocalAddress}, []netip.Addr{dns}, mtu)
+	addrs := append([]netip.Addr{config.LocalAddress}, config.ExtraAddresses...)
+	tun, tnet, err := netstack.CreateNetTUN(addrs, []netip.Addr{dns}, mtu)
 	if err != nil {
 		return nil, fmt.Errorf("create WireGuard TUN device: %w", err)
 	}
 
-	dev := device.NewDevice(tun, conn.NewDefaultBind(), device.NewLogger(device.LogLevelError, "WireGuard tunnel: "))
+	bind := config.Bind
+	if bind == nil {
+		bind = conn.NewDefaultBind()
+	}
+	dev := device.NewDevice(tun, bind, newLogger())
 	/*
 		example config:
 			private_key=private_key
@@ -67,39 +105,220 @@ func Connect(config *Config) (*Tunnel, error) {
 	*/
 	conf := fmt.Sprintf(
 		"private_key=%s\n"+
+			"listen_port=%d\n"+
 			"public_key=%s\n"+
-			"endpoint=%s\n"+
 			"allowed_ip=%s\n"+
 			"persistent_keepalive_interval=%d\n",
-		config.LocalPrivateKey.String(),
-		config.RemotePublicKey.String(),
-		config.Endpoint.String(),
+		config.LocalPrivateKey.Hex(),
+		config.ListenPort,
+		config.RemotePublicKey.Hex(),
 		config.RemoteNetwork.String(),
 		int(keepAlive.Seconds()),
 	)
+	for _, network := range config.ExtraNetworks {
+		conf += fmt.Sprintf("allowed_ip=%s\n", network.String())
+	}
+	if config.Endpoint.IsValid() {
+		conf += fmt.Sprintf("endpoint=%s\n", config.Endpoint.String())
+	}
 	err = dev.IpcSet(conf)
 	if err != nil {
+		dev.Close()
 		return nil, fmt.Errorf("configure WireGuard device: %w", err)
 	}
 
 	err = dev.Up()
 	if err != nil {
+		dev.Close()
 		return nil, fmt.Errorf("enable WireGuard device: %w", err)
 	}
 
 	return &Tunnel{
-		dev: dev,
-		net: tnet,
+		dev:       dev,
+		bind:      bind,
+		net:       tnet,
+		local:     config.LocalAddress,
+		remote:    config.RemoteNetwork.Addr(),
+		remoteKey: config.RemotePublicKey,
 	}, nil
 }
 
+// Close brings the device down and closes its bind and netstack, which ends
+// every connection inside the tunnel. It is safe to call more than once and
+// while other methods run; they fail with net.ErrClosed afterwards.
 func (t *Tunnel) Close() {
-	if t.dev != nil {
-		t.dev.Close()
-	}
+	t.mu.Lock()
+	dev := t.dev
 	t.dev, t.net = nil, nil
+	t.mu.Unlock()
+	if dev != nil {
+		dev.Close()
+	}
+}
+
+func (t *Tunnel) device() (*device.Device, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dev == nil {
+		return nil, net.ErrClosed
+	}
+	return t.dev, nil
+}
+
+func (t *Tunnel) netstack() (*netstack.Net, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.net == nil {
+		return nil, net.ErrClosed
+	}
+	return t.net, nil
+}
+
+// Bind returns the bind the tunnel sends its packets with
+func (t *Tunnel) Bind() conn.Bind {
+	return t.bind
+}
+
+// SetEndpoint changes where packets to the peer are sent. endpoint is
+// anything the tunnel's bind can parse, e.g. 192.0.2.1:51820.
+func (t *Tunnel) SetEndpoint(endpoint string) error {
+	dev, err := t.device()
+	if err != nil {
+		return err
+	}
+	conf := fmt.Sprintf("public_key=%s\nupdate_only=true\nendpoint=%s\n", t.remoteKey.Hex(), endpoint)
+	if err := dev.IpcSet(conf); err != nil {
+		return fmt.Errorf("set WireGuard endpoint: %w", err)
+	}
+	return nil
+}
+
+// LocalAddr returns this machine's address inside the tunnel
+func (t *Tunnel) LocalAddr() netip.Addr {
+	return t.local
+}
+
+// RemoteAddr returns the peer's address inside the tunnel
+func (t *Tunnel) RemoteAddr() netip.Addr {
+	return t.remote
+}
+
+// ListenTCP listens on port at this machine's address inside the tunnel
+func (t *Tunnel) ListenTCP(port uint16) (net.Listener, error) {
+	tnet, err := t.netstack()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := tnet.ListenTCPAddrPort(netip.AddrPortFrom(t.local, port))
+	if err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+// PeerStats are the counters of the tunnel's peer
+type PeerStats struct {
+	PublicKey     keys.Key
+	Endpoint      string
+	LastHandshake time.Time // zero until the first handshake
+	RxBytes       int64
+	TxBytes       int64
+}
+
+// Stats reads the peer counters of the userspace device
+func (t *Tunnel) Stats() (PeerStats, error) {
+	dev, err := t.device()
+	if err != nil {
+		return PeerStats{}, err
+	}
+	conf, err := dev.IpcGet()
+	if err != nil {
+		return PeerStats{}, fmt.Errorf("read WireGuard device: %w", err)
+	}
+	return parseStats(conf)
+}
+
+// ListenPort returns the local UDP port the tunnel's packets use
+func (t *Tunnel) ListenPort() (uint16, error) {
+	dev, err := t.device()
+	if err != nil {
+		return 0, err
+	}
+	conf, err := dev.IpcGet()
+	if err != nil {
+		return 0, fmt.Errorf("read WireGuard device: %w", err)
+	}
+	for _, line := range strings.Split(conf, "\n") {
+		if value, ok := strings.CutPrefix(line, "listen_port="); ok {
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return 0, fmt.Errorf("parse WireGuard device state: %w", err)
+			}
+			return uint16(port), nil
+		}
+	}
+	return 0, fmt.Errorf("WireGuard device has no listen port")
+}
+
+// parseStats reads the peer section of the UAPI "get" output
+func parseStats(conf string) (PeerStats, error) {
+	var (
+		stats    PeerStats
+		sec, ns  int64
+		inPeer   bool
+		parseErr error
+	)
+	parseInt := func(v string) int64 {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil && parseErr == nil {
+			parseErr = fmt.Errorf("parse WireGuard device state: %w", err)
+		}
+		return n
+	}
+	for _, line := range strings.Split(conf, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "public_key":
+			if inPeer {
+				break
+			}
+			inPeer = true
+			b, err := hex.DecodeString(value)
+			if err != nil {
+				return PeerStats{}, fmt.Errorf("parse WireGuard device state: %w", err)
+			}
+			stats.PublicKey, err = keys.NewKey(b)
+			if err != nil {
+				return PeerStats{}, fmt.Errorf("parse WireGuard device state: %w", err)
+			}
+		case "endpoint":
+			stats.Endpoint = value
+		case "last_handshake_time_sec":
+			sec = parseInt(value)
+		case "last_handshake_time_nsec":
+			ns = parseInt(value)
+		case "rx_bytes":
+			stats.RxBytes = parseInt(value)
+		case "tx_bytes":
+			stats.TxBytes = parseInt(value)
+		}
+	}
+	if parseErr != nil {
+		return PeerStats{}, parseErr
+	}
+	if sec != 0 || ns != 0 {
+		stats.LastHandshake = time.Unix(sec, ns)
+	}
+	return stats, nil
 }
 
 func (t *Tunnel) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	return t.net.DialContext(ctx, network, address)
+	tnet, err := t.netstack()
+	if err != nil {
+		return nil, err
+	}
+	return tnet.DialContext(ctx, network, address)
 }
@@ -0,0 +1,50 @@
+package tunnel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+)
+
+func TestParseStats(t *testing.T) {
+	pub, _ := keys.GenerateKey()
+	conf := strings.Join([]string{
+		"private_key=" + strings.Repeat("11", 32),
+		"listen_port=51820",
+		"public_key=" + pub.Hex(),
+		"endpoint=192.0.2.1:51820",
+		"last_handshake_time_sec=1700000000",
+		"last_handshake_time_nsec=500",
+		"rx_bytes=1024",
+		"tx_bytes=2048",
+		"persistent_keepalive_interval=25",
+		"errno=0",
+		"",
+	}, "\n")
+
+	stats, err := parseStats(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := PeerStats{
+		PublicKey:     pub,
+		Endpoint:      "192.0.2.1:51820",
+		LastHandshake: time.Unix(1700000000, 500),
+		RxBytes:       1024,
+		TxBytes:       2048,
+	}
+	if !stats.LastHandshake.Equal(want.LastHandshake) {
+		t.Errorf("LastHandshake = %v, want %v", stats.LastHandshake, want.LastHandshake)
+	}
+	stats.LastHandshake = want.LastHandshake
+	if stats != want {
+		t.Errorf("parseStats = %+v, want %+v", stats, want)
+	}
+
+	idle, err := parseStats("public_key=" + pub.Hex() + "\nlast_handshake_time_sec=0\nlast_handshake_time_nsec=0\n")
+	if err != nil || !idle.LastHandshake.IsZero() {
+		t.Errorf("Expected no handshake, got %v (%v)", idle.LastHandshake, err)
+	}
+}
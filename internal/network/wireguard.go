@@ -1,13 +1,18 @@
 package network
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"github.com/TheRealSibasishBehera/syncsh/internal/secret"
-	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
 	"net/netip"
+	"strings"
 	"time"
 )
 
+var log = logging.For(logging.Network)
+
 const (
 	WireGuardInterfaceName = "syncsh0"
 	WireGuardPort          = 51820
@@ -15,20 +20,36 @@ const (
 	WireGuardKeepaliveInterval = 25 * time.Second
 )
 
+// maxInterfaceName is the longest interface name Linux accepts
+const maxInterfaceName = 15
+
+// PeerInterfaceName returns the kernel interface for the tunnel to the peer
+// with peerKey: base without its trailing digits, then a hash of the key
+func PeerInterfaceName(base string, peerKey keys.Key) string {
+	sum := sha256.Sum256(peerKey[:])
+	suffix := "-" + hex.EncodeToString(sum[:4])
+	base = strings.TrimRight(base, "0123456789")
+	if limit := maxInterfaceName - len(suffix); len(base) > limit {
+		base = base[:limit]
+	}
+	return base + suffix
+}
+
 type EndpointChangeEvent struct {
-	PublicKey secret.Secret
+	PublicKey keys.Key
 	// Endpoint is the new endpoint of the peer.
 	Endpoint netip.AddrPort
 }
 
 // NewMachineKeys generates a new WireGuard private and public key pair.
-func NewMachineKeys() (privKey, pubKey secret.Secret, err error) {
-	wgPrivKey, err := wgtypes.GeneratePrivateKey()
+func NewMachineKeys() (privKey, pubKey keys.Key, err error) {
+	privKey, err = keys.GenerateKey()
+	if err != nil {
+		return keys.Key{}, keys.Key{}, fmt.Errorf("generate WireGuard private key: %w", err)
+	}
+	pubKey, err = privKey.PublicKey()
 	if err != nil {
-		return nil, nil, fmt.Errorf("generate WireGuard private key: %w", err)
+		return keys.Key{}, keys.Key{}, fmt.Errorf("derive WireGuard public key: %w", err)
 	}
-	privKey = wgPrivKey[:]
-	wgPubKey := wgPrivKey.PublicKey()
-	pubKey = wgPubKey[:]
 	return
 }
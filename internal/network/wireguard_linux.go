@@ -5,7 +5,6 @@ package network
 import (
 	"fmt"
 	"github.com/vishvananda/netlink"
-	"log/slog"
 )
 
 const (
@@ -16,27 +15,33 @@ const (
 // this is the network interface for that
 
 func GetOrCreateWireGuardInterface(name string) (netlink.Link, error) {
+	link, _, err := getOrCreateWireGuardInterface(name)
+	return link, err
+}
+
+// getOrCreateWireGuardInterface also reports whether the interface is new
+func getOrCreateWireGuardInterface(name string) (netlink.Link, bool, error) {
 	link, err := netlink.LinkByName(name)
 	if err == nil {
-		slog.Info("Found existing WireGuard interface.", "name", name)
+		log.Info("Found existing WireGuard interface.", "name", name)
 		if _, ok := link.(*netlink.Wireguard); !ok {
-			return nil, fmt.Errorf("link %q is not a WireGuard interface", name)
+			return nil, false, fmt.Errorf("link %q is not a WireGuard interface", name)
 		}
-		return link, nil
+		return link, false, nil
 	}
 	if _, ok := err.(netlink.LinkNotFoundError); !ok {
-		return nil, fmt.Errorf("find WireGuard link %q: %v", name, err)
+		return nil, false, fmt.Errorf("find WireGuard link %q: %v", name, err)
 	}
 	if link, err = NewWireGuardInterface(name); err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	slog.Info("Created WireGuard interface.", "name", name)
+	log.Info("Created WireGuard interface.", "name", name)
 
 	link, err = netlink.LinkByName(name)
 	if err != nil {
-		return nil, fmt.Errorf("find created WireGuard link %q: %v", name, err)
+		return nil, false, fmt.Errorf("find created WireGuard link %q: %v", name, err)
 	}
-	return link, nil
+	return link, true, nil
 }
 
 func NewWireGuardInterface(name string) (*netlink.Wireguard, error) {
@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// Annotation is what one machine attached to a history entry. Each machine
+// keeps a single annotation per entry, the newest one wins.
+type Annotation struct {
+	Hash      string   `json:"hash"`       // hash of the annotated entry
+	MachineID string   `json:"machine_id"` // machine that annotated the entry
+	Tags      []string `json:"tags,omitempty"`
+	Note      string   `json:"note,omitempty"`
+	UpdatedAt int64    `json:"updated_at"` // Unix time of the last change
+	Signature []byte   `json:"signature"`  // Ed25519 signature by the annotating machine
+}
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._:-]{0,62}$`)
+
+// ValidateTags checks tags: lowercase letters, digits, dots, colons, dashes
+// and underscores
+func ValidateTags(tags []string) error {
+	for _, tag := range tags {
+		if !tagPattern.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q: use lowercase letters, digits, '.', ':', '-' and '_'", tag)
+		}
+	}
+	return nil
+}
+
+// HasTag reports whether the annotation carries one of tags
+func (a Annotation) HasTag(tags ...string) bool {
+	return slices.ContainsFunc(a.Tags, func(t string) bool { return slices.Contains(tags, t) })
+}
+
+// Empty reports whether the annotation carries neither tags nor a note
+func (a Annotation) Empty() bool {
+	return len(a.Tags) == 0 && a.Note == ""
+}
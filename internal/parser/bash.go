@@ -1,3 +1,47 @@
 package parser
 
-// TODO: Implement bash history parser
\ No newline at end of file
+import (
+	"strconv"
+	"strings"
+)
+
+type BashParser struct {
+	Path string
+
+	// timestamp from the last "#<unix time>" comment, written by bash when
+	// HISTTIMEFORMAT is set
+	pending int64
+}
+
+func NewBashParser(path string) *BashParser {
+	return &BashParser{Path: path}
+}
+
+// ParseLine parses one line of a bash history file. Timestamp comments are
+// skipped and applied to the command on the following line.
+func (p *BashParser) ParseLine(line string) (command string, timestamp int64, skip bool) {
+	if ts, ok := bashTimestamp(line); ok {
+		p.pending = ts
+		return "", 0, true
+	}
+
+	timestamp, p.pending = p.pending, 0
+	if isPrivate(line) {
+		return "", 0, true
+	}
+	return line, timestamp, false
+}
+
+func (p *BashParser) GetHistoryPath() []string {
+	return []string{p.Path}
+}
+
+// bashTimestamp parses a "#<unix time>" comment
+func bashTimestamp(line string) (int64, bool) {
+	ts, ok := strings.CutPrefix(line, "#")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(ts, 10, 64)
+	return n, err == nil
+}
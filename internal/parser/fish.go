@@ -1,3 +1,53 @@
 package parser
 
-// TODO: Implement fish history parser
\ No newline at end of file
+import (
+	"strconv"
+	"strings"
+)
+
+type FishParser struct {
+	Path string
+
+	// command from the last "- cmd:" line, waiting for its "when:" line
+	pending string
+}
+
+func NewFishParser(path string) *FishParser {
+	return &FishParser{Path: path}
+}
+
+// ParseLine parses one line of fish's YAML-like history file:
+//
+//	# ~/.local/share/fish/fish_history
+//	- cmd: git status
+//	  when: 1700000000
+//	  paths:
+//	    - ...
+//
+// A command is returned once its "when:" line has been read.
+func (p *FishParser) ParseLine(line string) (command string, timestamp int64, skip bool) {
+	if cmd, ok := strings.CutPrefix(line, "- cmd: "); ok {
+		p.pending = unescapeFish(cmd)
+		return "", 0, true
+	}
+	when, ok := strings.CutPrefix(strings.TrimSpace(line), "when: ")
+	if !ok || p.pending == "" {
+		return "", 0, true
+	}
+
+	command, p.pending = p.pending, ""
+	timestamp, _ = strconv.ParseInt(when, 10, 64)
+	if isPrivate(command) {
+		return "", 0, true
+	}
+	return command, timestamp, false
+}
+
+func (p *FishParser) GetHistoryPath() []string {
+	return []string{p.Path}
+}
+
+// unescapeFish reverses the escaping fish applies to newlines and backslashes
+func unescapeFish(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(s)
+}
@@ -1,13 +1,20 @@
 package parser
 
+import "fmt"
+
 type HistoryEntry struct {
-	ID        int64  // Auto-increment primary key
-	Timestamp int64  // Unix timestamp when command was executed
-	MachineID string // Identifier for the machine that executed it
-	Command   string // The actual shell command
-	Duration  int    // Command execution duration in seconds
-	ExitCode  int    // Command exit code (0 = success)
-	Hash      string // SHA256 hash for deduplication
+	ID        int64  `json:"-"`          // Auto-increment primary key
+	Timestamp int64  `json:"timestamp"`  // Unix timestamp when command was executed
+	MachineID string `json:"machine_id"` // Identifier for the machine that executed it
+	Command   string `json:"command"`    // The actual shell command
+	Duration  int    `json:"duration"`   // Command execution duration in seconds
+	ExitCode  int    `json:"exit_code"`  // Command exit code (0 = success)
+	Hash      string `json:"hash"`       // SHA256 hash for deduplication
+	Signature []byte `json:"signature"`  // Ed25519 signature by the origin machine
+
+	// Namespace is the shared history the entry was published to, empty for
+	// the origin machine's own history
+	Namespace string `json:"namespace,omitempty"`
 }
 
 type ShellParser interface {
@@ -15,6 +22,20 @@ type ShellParser interface {
 	GetHistoryPath() []string
 }
 
+// NewParser returns the parser for the history file of the given shell
+func NewParser(shell, path string) (ShellParser, error) {
+	switch shell {
+	case "bash":
+		return NewBashParser(path), nil
+	case "zsh":
+		return NewZshParser(path), nil
+	case "fish":
+		return NewFishParser(path), nil
+	default:
+		return nil, fmt.Errorf("unsupported shell: %s", shell)
+	}
+}
+
 // so there would be a history file
 // by default we will only look at a default shell
 // optionally the user can set in the config the kind of shell they use
@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+type parsed struct {
+	command   string
+	timestamp int64
+}
+
+func parseAll(p ShellParser, lines ...string) []parsed {
+	var out []parsed
+	for _, line := range lines {
+		command, timestamp, skip := p.ParseLine(line)
+		if !skip {
+			out = append(out, parsed{command, timestamp})
+		}
+	}
+	return out
+}
+
+func TestParsers(t *testing.T) {
+	tests := []struct {
+		name  string
+		shell string
+		lines []string
+		want  []parsed
+	}{
+		{
+			name:  "bash plain",
+			shell: "bash",
+			lines: []string{"ls -la", " echo private", "git status"},
+			want:  []parsed{{"ls -la", 0}, {"git status", 0}},
+		},
+		{
+			name:  "bash timestamps",
+			shell: "bash",
+			lines: []string{"#1700000000", "make", "#not-a-time", "#1700000005", "make test"},
+			want:  []parsed{{"make", 1700000000}, {"#not-a-time", 0}, {"make test", 1700000005}},
+		},
+		{
+			name:  "zsh extended",
+			shell: "zsh",
+			lines: []string{": 1666062975:0;echo hello", ": 1666062980:3; export TOKEN=x", "plain"},
+			want:  []parsed{{"echo hello", 1666062975}, {"plain", 0}},
+		},
+		{
+			name:  "fish",
+			shell: "fish",
+			lines: []string{
+				"- cmd: git status",
+				"  when: 1700000000",
+				"- cmd: echo a\\nb",
+				"  when: 1700000001",
+				"  paths:",
+				"    - /tmp",
+				"- cmd: no time",
+			},
+			want: []parsed{{"git status", 1700000000}, {"echo a\nb", 1700000001}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewParser(tt.shell, "/dev/null")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := parseAll(p, tt.lines...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d commands %v, want %v", len(got), got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("command %d: got %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewParserUnsupported(t *testing.T) {
+	if _, err := NewParser("csh", "/dev/null"); err == nil {
+		t.Error("expected an error for an unsupported shell")
+	}
+}
+
+func TestRecords(t *testing.T) {
+	tests := []struct {
+		shell string
+		lines []string
+		want  [][]string
+	}{
+		{shell: "bash", lines: []string{"#1700000000", "make", "ls", "#1700000005"},
+			want: [][]string{{"#1700000000", "make"}, {"ls"}, {"#1700000005"}}},
+		{shell: "zsh", lines: []string{": 1666062975:0;echo hello", "#1700000000"},
+			want: [][]string{{": 1666062975:0;echo hello"}, {"#1700000000"}}},
+		{shell: "fish", lines: []string{"- cmd: ls", "  when: 1", "  paths:", "    - /tmp", "- cmd: pwd", "  when: 2"},
+			want: [][]string{{"- cmd: ls", "  when: 1", "  paths:", "    - /tmp"}, {"- cmd: pwd", "  when: 2"}}},
+	}
+	for _, tt := range tests {
+		if got := Records(tt.shell, tt.lines); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Records(%s) = %q, want %q", tt.shell, got, tt.want)
+		}
+	}
+}
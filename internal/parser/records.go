@@ -0,0 +1,25 @@
+package parser
+
+import "strings"
+
+// Records splits the lines of a history file into the lines each command
+// takes up: a bash timestamp comment and the command after it, or a fish
+// "- cmd:" line and the lines indented below it. Every other line is a
+// record of its own. Dropping a record removes its command from the file.
+func Records(shell string, lines []string) [][]string {
+	var records [][]string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if _, ok := bashTimestamp(line); ok && shell == "bash" && i+1 < len(lines) {
+			records = append(records, []string{line, lines[i+1]})
+			i++
+			continue
+		}
+		if shell == "fish" && !strings.HasPrefix(line, "- cmd: ") && len(records) > 0 {
+			records[len(records)-1] = append(records[len(records)-1], line)
+			continue
+		}
+		records = append(records, []string{line})
+	}
+	return records
+}
@@ -0,0 +1,10 @@
+package parser
+
+// Tombstone records that a history entry was deleted. Peers delete the entry
+// too and refuse it while the tombstone is kept.
+type Tombstone struct {
+	Hash      string `json:"hash"`       // hash of the deleted entry
+	DeletedAt int64  `json:"deleted_at"` // Unix time of the deletion
+	MachineID string `json:"machine_id"` // machine that deleted the entry
+	Signature []byte `json:"signature"`  // Ed25519 signature by the deleting machine
+}
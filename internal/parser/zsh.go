@@ -2,33 +2,40 @@ package parser
 
 import (
 	"regexp"
+	"strconv"
+	"strings"
 )
 
+// zshExtendedLine matches the EXTENDED_HISTORY format ": <start>:<elapsed>;<command>"
+var zshExtendedLine = regexp.MustCompile(`^: (\d+):\d+;(.*)$`)
+
 type ZshParser struct {
 	Path string
 }
 
-func ParseLine(line string) (command string, timestamp int64, skip bool) {
-	return "", 0, false
-}
-
 func NewZshParser(path string) *ZshParser {
 	return &ZshParser{Path: path}
 }
 
+// ParseLine parses one line of a zsh history file. Lines written without
+// EXTENDED_HISTORY have no timestamp.
+func (p *ZshParser) ParseLine(line string) (command string, timestamp int64, skip bool) {
+	if matches := zshExtendedLine.FindStringSubmatch(line); len(matches) == 3 {
+		timestamp, _ = strconv.ParseInt(matches[1], 10, 64)
+		line = matches[2]
+	}
+	if isPrivate(line) {
+		return "", 0, true
+	}
+	return line, timestamp, false
+}
+
 func (p *ZshParser) GetHistoryPath() []string {
 	return []string{p.Path}
 }
 
-func stripZshWeirdness(line string) string {
-	// Regex: `: \d+:\d;(.*)`
-	// Input:  ": 1666062975:0;echo hello"
-	// Output: "echo hello"
-	re := regexp.MustCompile(`: \d+:\d;(.*)`)
-	matches := re.FindStringSubmatch(line)
-	if len(matches) == 2 {
-		return matches[1]
-	}
-	return line
+// isPrivate reports whether a command should not be recorded. Like
+// HISTCONTROL=ignorespace, commands starting with a space are kept private.
+func isPrivate(command string) bool {
+	return strings.TrimSpace(command) == "" || strings.HasPrefix(command, " ")
 }
-
@@ -0,0 +1,225 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/envelope"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/secret"
+)
+
+const (
+	// Version is the protocol version announced in Hello
+	Version = 2
+	// DefaultPort is the TCP port syncsh listens on inside the tunnel
+	DefaultPort = 7420
+)
+
+// helloProofInfo separates the HelloProof MAC key from other keys derived
+// from the same key pair
+const helloProofInfo = "syncsh hello proof v1"
+
+// ErrInvalidProof is returned when the remote side of a handshake cannot
+// prove it holds the key it presented
+var ErrInvalidProof = errors.New("hello proof does not match the presented key")
+
+type MessageType string
+
+const (
+	TypeHello          MessageType = "hello"
+	TypeHelloProof     MessageType = "hello_proof"
+	TypeError          MessageType = "error"
+	TypeKeyRotation    MessageType = "key_rotation"
+	TypeKeyRotationAck MessageType = "key_rotation_ack"
+	TypeHistoryBatch   MessageType = "history_batch"
+	TypeHistoryAck     MessageType = "history_ack"
+)
+
+// Message is a single frame exchanged between peers. Frames are encoded as
+// one JSON object per line.
+type Message struct {
+	Type    MessageType     `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Decode unmarshals the message payload into v
+func (m Message) Decode(v any) error {
+	if err := json.Unmarshal(m.Payload, v); err != nil {
+		return fmt.Errorf("decode %s payload: %w", m.Type, err)
+	}
+	return nil
+}
+
+// Hello is the first message sent by both sides of a connection
+type Hello struct {
+	Version   int      `json:"version"`
+	PublicKey keys.Key `json:"public_key"` // public key the sender uses on this tunnel
+	MachineID string   `json:"machine_id"` // machine ID the sender's history entries carry
+	// SigningKey is the Ed25519 key the sender signs its history entries with
+	SigningKey keys.Key `json:"signing_key"`
+	// Namespaces are the history namespaces the sender exchanges with the
+	// receiver, "" being the sender's own history. Older versions omit it
+	// and only exchange their own history.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Nonce is a fresh challenge the receiver's HelloProof must answer
+	Nonce secret.Secret `json:"nonce"`
+}
+
+// HelloProof follows Hello and proves that its sender holds the private key
+// of the public key it presented. The MAC is keyed with the X25519 shared
+// secret of that key and the receiver's, and covers the sender's identity
+// and the receiver's nonce, so it can be neither replayed nor reflected.
+type HelloProof struct {
+	MAC secret.Secret `json:"mac"`
+}
+
+// ProveHello answers remote's challenge for the sender of local
+func ProveHello(local, remote Hello, localPrivateKey keys.Key) (HelloProof, error) {
+	key, err := keys.SharedKey(localPrivateKey, remote.PublicKey, helloProofInfo)
+	if err != nil {
+		return HelloProof{}, err
+	}
+	return HelloProof{MAC: helloMAC(key, local, remote.Nonce)}, nil
+}
+
+// Verify checks that p proves remote's claim, answering local's challenge
+func (p HelloProof) Verify(local, remote Hello, localPrivateKey keys.Key) error {
+	key, err := keys.SharedKey(localPrivateKey, remote.PublicKey, helloProofInfo)
+	if err != nil {
+		return err
+	}
+	if len(local.Nonce) == 0 || !hmac.Equal(p.MAC, helloMAC(key, remote, local.Nonce)) {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// helloMAC authenticates the identity h claims for the holder of nonce
+func helloMAC(key []byte, h Hello, nonce []byte) secret.Secret {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(h.PublicKey[:])
+	mac.Write(h.SigningKey[:])
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(h.MachineID)))
+	mac.Write(n[:])
+	mac.Write([]byte(h.MachineID))
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// Error reports a fatal problem to the remote side before closing
+type Error struct {
+	Message string `json:"message"`
+}
+
+// KeyRotation carries the rotations the receiver has not acknowledged yet,
+// oldest first.
+type KeyRotation struct {
+	Announcements []keys.Announcement `json:"announcements"`
+}
+
+// KeyRotationAck confirms the receiver now knows the sender by PublicKey
+type KeyRotationAck struct {
+	PublicKey keys.Key `json:"public_key"`
+}
+
+// HistoryBatch carries history entries sealed end to end for the receiver
+type HistoryBatch struct {
+	Envelope envelope.Envelope `json:"envelope"`
+}
+
+// HistoryAck confirms that a batch has been stored: every entry up to
+// Through, the newest entry timestamp in it, and ThroughHash, the hash of the
+// newest entry at that timestamp; every annotation up to AnnotationsThrough
+// and AnnotationsThroughHash, likewise by update time; and the tombstones
+// with the hashes in Tombstones
+type HistoryAck struct {
+	Through                int64    `json:"through"`
+	ThroughHash            string   `json:"through_hash,omitempty"`
+	AnnotationsThrough     int64    `json:"annotations_through,omitempty"`
+	AnnotationsThroughHash string   `json:"annotations_through_hash,omitempty"`
+	Tombstones             []string `json:"tombstones,omitempty"`
+}
+
+// Conn frames messages over a stream connection. Send is safe for
+// concurrent use; Receive must only be called from a single goroutine.
+type Conn struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	mu   sync.Mutex
+}
+
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(conn),
+	}
+}
+
+// Send encodes payload and writes it as a message of type t
+func (c *Conn) Send(t MessageType, payload any) error {
+	msg := Message{Type: t}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("encode %s payload: %w", t, err)
+		}
+		msg.Payload = data
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(msg); err != nil {
+		return fmt.Errorf("send %s: %w", t, err)
+	}
+	return nil
+}
+
+// Receive reads the next message
+func (c *Conn) Receive() (Message, error) {
+	var msg Message
+	if err := c.dec.Decode(&msg); err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+// Expect reads the next message and decodes it into v, failing if the
+// message is not of type t.
+func (c *Conn) Expect(t MessageType, v any) error {
+	msg, err := c.Receive()
+	if err != nil {
+		return fmt.Errorf("receive %s: %w", t, err)
+	}
+	if msg.Type == TypeError {
+		var e Error
+		if err := msg.Decode(&e); err != nil {
+			return err
+		}
+		return fmt.Errorf("remote error: %s", e.Message)
+	}
+	if msg.Type != t {
+		return fmt.Errorf("unexpected message %q, want %q", msg.Type, t)
+	}
+	if v == nil {
+		return nil
+	}
+	return msg.Decode(v)
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,47 @@
+package provenance
+
+import (
+	"errors"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+// KeyLookup returns the signing key registered for a machine ID
+type KeyLookup func(machineID string) (keys.Key, bool)
+
+// Report summarizes an audit of stored history entries
+type Report struct {
+	Checked  int
+	Valid    int
+	Unsigned []parser.HistoryEntry // written before entries were signed
+	Invalid  []parser.HistoryEntry // signature does not match the entry
+	Unknown  []parser.HistoryEntry // no signing key registered for the machine
+}
+
+// OK reports whether every entry carries a valid signature
+func (r Report) OK() bool {
+	return r.Valid == r.Checked
+}
+
+// Audit verifies every entry against the signing key of its machine
+func Audit(entries []parser.HistoryEntry, lookup KeyLookup) Report {
+	var r Report
+	for _, e := range entries {
+		r.Checked++
+		key, ok := lookup(e.MachineID)
+		if !ok {
+			r.Unknown = append(r.Unknown, e)
+			continue
+		}
+		switch err := Verify(e, key); {
+		case err == nil:
+			r.Valid++
+		case errors.Is(err, ErrUnsigned):
+			r.Unsigned = append(r.Unsigned, e)
+		default:
+			r.Invalid = append(r.Invalid, e)
+		}
+	}
+	return r
+}
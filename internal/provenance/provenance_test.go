@@ -0,0 +1,157 @@
+package provenance
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+func TestSignVerify(t *testing.T) {
+	seed, pub, err := keys.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPub, _ := keys.GenerateSigningKey()
+
+	entry := parser.HistoryEntry{Timestamp: 1700000000, MachineID: "laptop", Command: "make", ExitCode: 2}
+	if err := Verify(entry, pub); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned, got: %v", err)
+	}
+
+	Sign(&entry, seed)
+	if err := Verify(entry, pub); err != nil {
+		t.Errorf("Expected a valid signature, got: %v", err)
+	}
+	if err := Verify(entry, otherPub); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected a signature by another key to fail, got: %v", err)
+	}
+
+	// The local ID and hash are not covered.
+	entry.ID, entry.Hash = 42, "abc"
+	if err := Verify(entry, pub); err != nil {
+		t.Errorf("Expected ID and hash changes to keep the signature valid, got: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(e *parser.HistoryEntry)
+	}{
+		{name: "timestamp", modify: func(e *parser.HistoryEntry) { e.Timestamp++ }},
+		{name: "machine", modify: func(e *parser.HistoryEntry) { e.MachineID = "server" }},
+		{name: "command", modify: func(e *parser.HistoryEntry) { e.Command = "make install" }},
+		{name: "duration", modify: func(e *parser.HistoryEntry) { e.Duration = 1 }},
+		{name: "exit code", modify: func(e *parser.HistoryEntry) { e.ExitCode = 0 }},
+		{name: "namespace", modify: func(e *parser.HistoryEntry) { e.Namespace = "team" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := entry
+			tt.modify(&modified)
+			if err := Verify(modified, pub); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestCanonicalFieldBoundaries(t *testing.T) {
+	a := parser.HistoryEntry{MachineID: "ab", Command: "c"}
+	b := parser.HistoryEntry{MachineID: "a", Command: "bc"}
+	if string(Canonical(a)) == string(Canonical(b)) {
+		t.Error("Entries with different fields must not share an encoding")
+	}
+}
+
+func TestSignVerifyAnnotation(t *testing.T) {
+	seed, pub, _ := keys.GenerateSigningKey()
+
+	a := parser.Annotation{Hash: "abc", MachineID: "laptop", Tags: []string{"deploy", "prod"}, Note: "Rollback", UpdatedAt: 10}
+	if err := VerifyAnnotation(a, pub); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned, got: %v", err)
+	}
+	SignAnnotation(&a, seed)
+	if err := VerifyAnnotation(a, pub); err != nil {
+		t.Errorf("Expected a valid signature, got: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(a *parser.Annotation)
+	}{
+		{name: "hash", modify: func(a *parser.Annotation) { a.Hash = "abd" }},
+		{name: "machine", modify: func(a *parser.Annotation) { a.MachineID = "server" }},
+		{name: "tags", modify: func(a *parser.Annotation) { a.Tags = []string{"deploy"} }},
+		{name: "tag boundaries", modify: func(a *parser.Annotation) { a.Tags = []string{"deploy prod"} }},
+		{name: "note", modify: func(a *parser.Annotation) { a.Note = "" }},
+		{name: "updated at", modify: func(a *parser.Annotation) { a.UpdatedAt++ }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := a
+			tt.modify(&modified)
+			if err := VerifyAnnotation(modified, pub); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+			}
+		})
+	}
+
+	// An entry signature must not pass as an annotation signature.
+	entry := parser.HistoryEntry{Timestamp: 1, MachineID: "laptop", Command: "ls"}
+	Sign(&entry, seed)
+	if err := VerifyAnnotation(parser.Annotation{MachineID: "laptop", Signature: entry.Signature}, pub); err == nil {
+		t.Error("Expected an entry signature to fail as an annotation signature")
+	}
+}
+
+func TestSignVerifyTombstone(t *testing.T) {
+	seed, pub, _ := keys.GenerateSigningKey()
+
+	tombstone := parser.Tombstone{Hash: "abc", DeletedAt: 1700000000, MachineID: "laptop"}
+	if err := VerifyTombstone(tombstone, pub); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned, got: %v", err)
+	}
+	SignTombstone(&tombstone, seed)
+	if err := VerifyTombstone(tombstone, pub); err != nil {
+		t.Errorf("Expected a valid signature, got: %v", err)
+	}
+	for _, modified := range []parser.Tombstone{
+		{Hash: "abd", DeletedAt: tombstone.DeletedAt, MachineID: "laptop", Signature: tombstone.Signature},
+		{Hash: "abc", DeletedAt: tombstone.DeletedAt + 1, MachineID: "laptop", Signature: tombstone.Signature},
+		{Hash: "abc", DeletedAt: tombstone.DeletedAt, MachineID: "server", Signature: tombstone.Signature},
+	} {
+		if err := VerifyTombstone(modified, pub); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature for %+v, got: %v", modified, err)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	seed, pub, _ := keys.GenerateSigningKey()
+	valid := parser.HistoryEntry{Timestamp: 1, MachineID: "laptop", Command: "ls"}
+	Sign(&valid, seed)
+	tampered := parser.HistoryEntry{Timestamp: 2, MachineID: "laptop", Command: "ls"}
+	Sign(&tampered, seed)
+	tampered.Command = "rm -rf /"
+	unsigned := parser.HistoryEntry{Timestamp: 3, MachineID: "laptop", Command: "pwd"}
+	stranger := parser.HistoryEntry{Timestamp: 4, MachineID: "stranger", Command: "id"}
+
+	lookup := func(machineID string) (keys.Key, bool) {
+		return pub, machineID == "laptop"
+	}
+	r := Audit([]parser.HistoryEntry{valid, tampered, unsigned, stranger}, lookup)
+
+	if r.Checked != 4 || r.Valid != 1 || r.OK() {
+		t.Errorf("Unexpected totals: %+v", r)
+	}
+	if len(r.Invalid) != 1 || r.Invalid[0].Timestamp != 2 {
+		t.Errorf("Expected the tampered entry to be invalid, got %+v", r.Invalid)
+	}
+	if len(r.Unsigned) != 1 || r.Unsigned[0].Timestamp != 3 {
+		t.Errorf("Expected the unsigned entry to be reported, got %+v", r.Unsigned)
+	}
+	if len(r.Unknown) != 1 || r.Unknown[0].Timestamp != 4 {
+		t.Errorf("Expected the stranger's entry to be unknown, got %+v", r.Unknown)
+	}
+}
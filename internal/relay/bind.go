@@ -0,0 +1,434 @@
+package relay
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+const (
+	// registerInterval is how often a bind renews its registration. It is
+	// shorter than common NAT mapping timeouts, so the relay can keep
+	// reaching the client.
+	registerInterval = 20 * time.Second
+
+	// punchInterval is how often a peer that is only reached through the
+	// relay is looked up for a direct path. Each lookup sends
+	// punchAttempts rounds of pings, punchRetry apart.
+	punchInterval = 30 * time.Second
+	punchAttempts = 5
+	punchRetry    = 200 * time.Millisecond
+)
+
+// EndpointPrefix marks WireGuard endpoints that are reached through the
+// relay, e.g. relay:<base64 public key>
+const EndpointPrefix = "relay:"
+
+// Endpoint returns the WireGuard endpoint that reaches the peer with
+// publicKey through the relay
+func Endpoint(publicKey keys.Key) string {
+	return EndpointPrefix + publicKey.String()
+}
+
+// IsEndpoint reports whether a WireGuard endpoint goes through the relay
+func IsEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, EndpointPrefix)
+}
+
+// Bind is a WireGuard bind that sends packets directly over UDP, or through
+// a relay when the peer's endpoint is a relay endpoint. Relayed packets
+// share the UDP socket with direct ones, and the bind stays registered
+// with the relay while it is open.
+//
+// The relay is also a rendezvous: while a peer is only reached through it,
+// the bind asks the relay for the peer's candidate addresses, and both
+// sides send pings to each other's candidates to open their NATs. A path
+// that answers is reported on Events.
+type Bind struct {
+	conn.Bind
+	relay      netip.AddrPort
+	relayKey   keys.Key
+	privateKey keys.Key
+	events     chan network.EndpointChangeEvent
+
+	mu         sync.Mutex
+	relayEP    conn.Endpoint // the relay, in the type of the inner bind
+	port       uint16
+	stop       chan struct{}
+	registered netip.AddrPort // this machine as seen by the relay
+	paths      map[keys.Key]*path
+}
+
+// path is what the bind knows about reaching one peer directly
+type path struct {
+	lastLookup time.Time
+	pings      map[[txIDSize]byte]netip.AddrPort // outstanding pings by transaction ID
+	direct     netip.AddrPort                    // confirmed by a pong since punching started
+}
+
+// NewBind returns a bind that registers with the relay at addr, which is
+// identified by relayKey, as the owner of privateKey
+func NewBind(addr netip.AddrPort, relayKey, privateKey keys.Key) *Bind {
+	return &Bind{
+		Bind:       conn.NewDefaultBind(),
+		relay:      netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()),
+		relayKey:   relayKey,
+		privateKey: privateKey,
+		events:     make(chan network.EndpointChangeEvent, 4),
+		paths:      make(map[keys.Key]*path),
+	}
+}
+
+// Events reports direct paths found to peers. The caller should point the
+// peer's WireGuard endpoint at them. Events are dropped while the channel
+// is full.
+func (b *Bind) Events() <-chan network.EndpointChangeEvent {
+	return b.events
+}
+
+func (b *Bind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	fns, actual, err := b.Bind.Open(port)
+	if err != nil {
+		return nil, 0, err
+	}
+	relayEP, err := b.Bind.ParseEndpoint(b.relay.String())
+	if err != nil {
+		b.Bind.Close()
+		return nil, 0, fmt.Errorf("parse relay address: %w", err)
+	}
+
+	stop := make(chan struct{})
+	b.mu.Lock()
+	b.relayEP, b.port, b.stop = relayEP, actual, stop
+	b.mu.Unlock()
+	go b.registerLoop(relayEP, stop)
+
+	wrapped := make([]conn.ReceiveFunc, len(fns))
+	for i, fn := range fns {
+		wrapped[i] = b.receive(fn)
+	}
+	return wrapped, actual, nil
+}
+
+func (b *Bind) Close() error {
+	b.mu.Lock()
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+	b.mu.Unlock()
+	return b.Bind.Close()
+}
+
+func (b *Bind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	if !IsEndpoint(s) {
+		return b.Bind.ParseEndpoint(s)
+	}
+	key, err := keys.ParseKey(strings.TrimPrefix(s, EndpointPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("parse relay endpoint: %w", err)
+	}
+	return &peerEndpoint{key: key, relay: b.relay}, nil
+}
+
+func (b *Bind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	peer, ok := ep.(*peerEndpoint)
+	if !ok {
+		return b.Bind.Send(bufs, ep)
+	}
+	b.mu.Lock()
+	relayEP := b.relayEP
+	b.mu.Unlock()
+	if relayEP == nil {
+		return net.ErrClosed
+	}
+
+	frames := make([][]byte, len(bufs))
+	for i, buf := range bufs {
+		frames[i] = keyFrame(typeSend, peer.key, buf)
+	}
+	b.lookup(peer.key, relayEP)
+	return b.Bind.Send(frames, relayEP)
+}
+
+// lookup asks the relay for the candidates of a peer that is reached
+// through it, at most once per punchInterval
+func (b *Bind) lookup(key keys.Key, relayEP conn.Endpoint) {
+	b.mu.Lock()
+	p := b.path(key)
+	if time.Since(p.lastLookup) < punchInterval {
+		b.mu.Unlock()
+		return
+	}
+	p.lastLookup = time.Now()
+	b.mu.Unlock()
+
+	if err := b.Bind.Send([][]byte{keyFrame(typeLookup, key, nil)}, relayEP); err != nil {
+		log.Debug("Failed to look up peer at relay.", "peer", key, "error", err)
+	}
+}
+
+// path returns the path state of a peer. b.mu must be held.
+func (b *Bind) path(key keys.Key) *path {
+	p, ok := b.paths[key]
+	if !ok {
+		p = &path{pings: make(map[[txIDSize]byte]netip.AddrPort)}
+		b.paths[key] = p
+	}
+	return p
+}
+
+// punch pings every candidate of a peer a few times. Pings through our own
+// NAT open it for the peer's pings, which it sends at the same time.
+func (b *Bind) punch(key keys.Key, candidates []netip.AddrPort) {
+	b.mu.Lock()
+	stop := b.stop
+	p := b.path(key)
+	clear(p.pings)
+	p.direct = netip.AddrPort{}
+	b.mu.Unlock()
+	if stop == nil {
+		return
+	}
+
+	for range punchAttempts {
+		for _, addr := range candidates {
+			var txID [txIDSize]byte
+			if _, err := rand.Read(txID[:]); err != nil {
+				return
+			}
+			frame, err := probeFrame(typePing, b.privateKey, key, txID)
+			if err != nil {
+				log.Error("Failed to build ping.", "error", err)
+				return
+			}
+			ep, err := b.Bind.ParseEndpoint(addr.String())
+			if err != nil {
+				continue
+			}
+			b.mu.Lock()
+			p.pings[txID] = addr
+			b.mu.Unlock()
+			if err := b.Bind.Send([][]byte{frame}, ep); err != nil {
+				log.Debug("Failed to send ping.", "peer", key, "addr", addr, "error", err)
+			}
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(punchRetry):
+		}
+		b.mu.Lock()
+		found := p.direct.IsValid()
+		b.mu.Unlock()
+		if found {
+			return
+		}
+	}
+}
+
+// Registered returns the address the relay last confirmed it sees this
+// machine at. It is invalid until the first registration succeeds.
+func (b *Bind) Registered() netip.AddrPort {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.registered
+}
+
+func (b *Bind) registerLoop(relayEP conn.Endpoint, stop <-chan struct{}) {
+	ticker := time.NewTicker(registerInterval)
+	defer ticker.Stop()
+	for {
+		frame, err := registerFrame(b.privateKey, b.relayKey, time.Now(), localAddrs(b.localPort()))
+		if err != nil {
+			log.Error("Failed to build relay registration.", "error", err)
+			return
+		}
+		if err := b.Bind.Send([][]byte{frame}, relayEP); err != nil {
+			log.Debug("Failed to register with relay.", "relay", b.relay, "error", err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Bind) localPort() uint16 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.port
+}
+
+// localAddrs returns the addresses of this machine's interfaces that peers
+// on the same network could reach port at
+func localAddrs(port uint16) []netip.AddrPort {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil || port == 0 {
+		return nil
+	}
+	var addrs []netip.AddrPort
+	for _, a := range ifaceAddrs {
+		prefix, err := netip.ParsePrefix(a.String())
+		if err != nil {
+			continue
+		}
+		addr := prefix.Addr()
+		if !addr.IsGlobalUnicast() {
+			continue
+		}
+		addrs = append(addrs, netip.AddrPortFrom(addr, port))
+		if len(addrs) == maxCandidates-1 { // one is left for the reflexive address
+			break
+		}
+	}
+	return addrs
+}
+
+// receive wraps a receive function of the inner bind. Relayed packets are
+// unwrapped and attributed to a relay endpoint of their sender, other relay
+// frames are consumed.
+func (b *Bind) receive(fn conn.ReceiveFunc) conn.ReceiveFunc {
+	return func(packets [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		for {
+			n, err := fn(packets, sizes, eps)
+			if err != nil {
+				return n, err
+			}
+			// WireGuard reads the packets from its own buffers, so kept
+			// packets are moved by copying rather than by swapping slices.
+			kept := 0
+			for i := 0; i < n; i++ {
+				packet := packets[i][:sizes[i]]
+				ep := eps[i]
+				if isFrame(packet) {
+					var ok bool
+					if packet, ep, ok = b.handleFrame(packet, ep); !ok {
+						continue
+					}
+				}
+				sizes[kept] = copy(packets[kept], packet)
+				eps[kept] = ep
+				kept++
+			}
+			if kept > 0 {
+				return kept, nil
+			}
+		}
+	}
+}
+
+// handleFrame returns the WireGuard packet carried by a frame from the
+// relay, if any. Other frames are handled here.
+func (b *Bind) handleFrame(frame []byte, from conn.Endpoint) ([]byte, conn.Endpoint, bool) {
+	switch frame[len(magic)] {
+	case typePing:
+		b.handlePing(frame, from)
+		return nil, nil, false
+	case typePong:
+		b.handlePong(frame, from)
+		return nil, nil, false
+	}
+
+	if from.DstToString() != b.relay.String() {
+		return nil, nil, false
+	}
+	switch frame[len(magic)] {
+	case typeCandidates:
+		key, candidates, err := parseCandidates(frame)
+		if err != nil {
+			return nil, nil, false
+		}
+		log.Debug("Punching to peer.", "peer", key, "candidates", candidates)
+		go b.punch(key, candidates)
+	case typeRecv:
+		src, packet, err := parseKeyFrame(frame)
+		if err != nil {
+			return nil, nil, false
+		}
+		return packet, &peerEndpoint{key: src, relay: b.relay}, true
+	case typeRegistered:
+		addr, err := parseRegistered(frame)
+		if err != nil {
+			return nil, nil, false
+		}
+		b.mu.Lock()
+		changed := b.registered != addr
+		b.registered = addr
+		b.mu.Unlock()
+		if changed {
+			log.Debug("Registered with relay.", "relay", b.relay, "seen_as", addr)
+		}
+	}
+	return nil, nil, false
+}
+
+// handlePing answers a peer's ping on the path it arrived on
+func (b *Bind) handlePing(frame []byte, from conn.Endpoint) {
+	sender, txID, err := parseProbe(frame, b.privateKey)
+	if err != nil {
+		return
+	}
+	pong, err := probeFrame(typePong, b.privateKey, sender, txID)
+	if err != nil {
+		return
+	}
+	if err := b.Bind.Send([][]byte{pong}, from); err != nil {
+		log.Debug("Failed to send pong.", "peer", sender, "error", err)
+	}
+}
+
+// handlePong records the direct path a pong confirms and reports it if it
+// is new
+func (b *Bind) handlePong(frame []byte, from conn.Endpoint) {
+	sender, txID, err := parseProbe(frame, b.privateKey)
+	if err != nil {
+		return
+	}
+	addr, err := netip.ParseAddrPort(from.DstToString())
+	if err != nil {
+		return
+	}
+	addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+
+	b.mu.Lock()
+	p, ok := b.paths[sender]
+	if !ok {
+		b.mu.Unlock()
+		return
+	}
+	if _, ok := p.pings[txID]; !ok || p.direct == addr {
+		b.mu.Unlock()
+		return
+	}
+	p.direct = addr
+	b.mu.Unlock()
+
+	log.Info("Found a direct path to peer.", "peer", sender, "endpoint", addr)
+	select {
+	case b.events <- network.EndpointChangeEvent{PublicKey: sender, Endpoint: addr}:
+	default:
+	}
+}
+
+// peerEndpoint is a peer reached through the relay
+type peerEndpoint struct {
+	key   keys.Key
+	relay netip.AddrPort
+}
+
+func (e *peerEndpoint) ClearSrc()           {}
+func (e *peerEndpoint) SrcToString() string { return "" }
+func (e *peerEndpoint) DstToString() string { return Endpoint(e.key) }
+func (e *peerEndpoint) DstToBytes() []byte  { return e.key[:] }
+func (e *peerEndpoint) DstIP() netip.Addr   { return e.relay.Addr() }
+func (e *peerEndpoint) SrcIP() netip.Addr   { return netip.Addr{} }
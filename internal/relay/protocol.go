@@ -0,0 +1,269 @@
+// Package relay forwards WireGuard packets between peers that cannot reach
+// each other directly, e.g. two machines behind symmetric NATs. Peers
+// register their public key with a relay on a reachable host and address
+// packets to each other by public key; the relay only ever sees WireGuard
+// ciphertext.
+package relay
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+)
+
+// DefaultPort is the UDP port syncsh relay listens on
+const DefaultPort = 7421
+
+// Every relay frame starts with magic. WireGuard messages start with their
+// type (1 to 4) in little endian, so frames never look like one.
+var magic = []byte{0xff, 's', 'r', 'l'}
+
+// Frame types
+const (
+	// typeRegister: client public key, unix nanoseconds, local addresses, MAC
+	typeRegister byte = iota + 1
+	// typeRegistered: the client address as seen by the relay
+	typeRegistered
+	// typeSend: destination public key, WireGuard packet
+	typeSend
+	// typeRecv: source public key, WireGuard packet
+	typeRecv
+	// typeLookup: public key of the peer whose candidates are wanted
+	typeLookup
+	// typeCandidates: peer public key, addresses the peer may be reached at
+	typeCandidates
+	// typePing and typePong are sent directly between peers to find a
+	// working path: sender public key, transaction ID, MAC
+	typePing
+	typePong
+)
+
+const (
+	headerSize   = 5                                               // magic and type
+	registerSize = headerSize + keys.KeySize + 8 + 1 + sha256.Size // without addresses
+	txIDSize     = 12
+	probeSize    = headerSize + keys.KeySize + txIDSize + sha256.Size
+
+	// maxCandidates bounds the addresses a client announces
+	maxCandidates = 8
+
+	// registerSkew is how far a registration timestamp may be off the
+	// relay's clock
+	registerSkew = 2 * time.Minute
+)
+
+const (
+	registrationInfo = "syncsh relay registration v1"
+	probeInfo        = "syncsh path probe v1"
+)
+
+var errMalformed = errors.New("malformed relay frame")
+
+// isFrame reports whether packet is a relay frame rather than WireGuard
+func isFrame(packet []byte) bool {
+	return len(packet) >= headerSize && bytes.Equal(packet[:len(magic)], magic)
+}
+
+func header(typ byte) []byte {
+	return append(append(make([]byte, 0, registerSize), magic...), typ)
+}
+
+// keyFrame builds a send or receive frame
+func keyFrame(typ byte, key keys.Key, packet []byte) []byte {
+	frame := make([]byte, 0, headerSize+keys.KeySize+len(packet))
+	frame = append(append(frame, magic...), typ)
+	frame = append(frame, key[:]...)
+	return append(frame, packet...)
+}
+
+// parseKeyFrame splits the body of a send or receive frame
+func parseKeyFrame(frame []byte) (keys.Key, []byte, error) {
+	body := frame[headerSize:]
+	if len(body) < keys.KeySize {
+		return keys.Key{}, nil, errMalformed
+	}
+	return keys.Key(body[:keys.KeySize]), body[keys.KeySize:], nil
+}
+
+// registerFrame proves to the relay that the sender holds privateKey and
+// announces the local addresses it can be reached at. The MAC is keyed with
+// the X25519 agreement between the client and the relay.
+func registerFrame(privateKey, relayKey keys.Key, now time.Time, local []netip.AddrPort) ([]byte, error) {
+	publicKey, err := privateKey.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := macKey(privateKey, relayKey, registrationInfo)
+	if err != nil {
+		return nil, err
+	}
+	frame := header(typeRegister)
+	frame = append(frame, publicKey[:]...)
+	frame = binary.BigEndian.AppendUint64(frame, uint64(now.UnixNano()))
+	frame = appendAddrs(frame, local)
+	return append(frame, mac(macKey, frame)...), nil
+}
+
+// parseRegister verifies a registration and returns the client key,
+// timestamp and local addresses
+func parseRegister(frame []byte, relayPrivateKey keys.Key) (keys.Key, time.Time, []netip.AddrPort, error) {
+	if len(frame) < registerSize {
+		return keys.Key{}, time.Time{}, nil, errMalformed
+	}
+	body := frame[headerSize:]
+	clientKey := keys.Key(body[:keys.KeySize])
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(body[keys.KeySize:])))
+
+	macKey, err := macKey(relayPrivateKey, clientKey, registrationInfo)
+	if err != nil {
+		return keys.Key{}, time.Time{}, nil, err
+	}
+	signed := frame[:len(frame)-sha256.Size]
+	if !hmac.Equal(mac(macKey, signed), frame[len(signed):]) {
+		return keys.Key{}, time.Time{}, nil, errors.New("invalid registration MAC")
+	}
+	local, err := parseAddrs(signed[headerSize+keys.KeySize+8:])
+	if err != nil {
+		return keys.Key{}, time.Time{}, nil, err
+	}
+	return clientKey, ts, local, nil
+}
+
+// probeFrame builds a ping or pong from the holder of privateKey to the
+// peer with peerKey
+func probeFrame(typ byte, privateKey, peerKey keys.Key, txID [txIDSize]byte) ([]byte, error) {
+	publicKey, err := privateKey.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	macKey, err := macKey(privateKey, peerKey, probeInfo)
+	if err != nil {
+		return nil, err
+	}
+	frame := header(typ)
+	frame = append(frame, publicKey[:]...)
+	frame = append(frame, txID[:]...)
+	return append(frame, mac(macKey, frame)...), nil
+}
+
+// parseProbe verifies a ping or pong sent to the holder of privateKey and
+// returns its sender and transaction ID
+func parseProbe(frame []byte, privateKey keys.Key) (keys.Key, [txIDSize]byte, error) {
+	if len(frame) != probeSize {
+		return keys.Key{}, [txIDSize]byte{}, errMalformed
+	}
+	body := frame[headerSize:]
+	sender := keys.Key(body[:keys.KeySize])
+	txID := [txIDSize]byte(body[keys.KeySize:])
+
+	macKey, err := macKey(privateKey, sender, probeInfo)
+	if err != nil {
+		return keys.Key{}, [txIDSize]byte{}, err
+	}
+	signed := frame[:probeSize-sha256.Size]
+	if !hmac.Equal(mac(macKey, signed), frame[len(signed):]) {
+		return keys.Key{}, [txIDSize]byte{}, errors.New("invalid probe MAC")
+	}
+	return sender, txID, nil
+}
+
+func mac(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// macKey derives a MAC key for info from an X25519 key agreement
+func macKey(privateKey, publicKey keys.Key, info string) ([]byte, error) {
+	priv, err := ecdh.X25519().NewPrivateKey(privateKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(publicKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	shared, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("derive shared secret: %w", err)
+	}
+	return hkdf.Key(sha256.New, shared, nil, info, sha256.Size)
+}
+
+// registeredFrame tells a client the address the relay sees it at
+func registeredFrame(addr netip.AddrPort) []byte {
+	frame := header(typeRegistered)
+	b, _ := addr.MarshalBinary()
+	return append(frame, b...)
+}
+
+func parseRegistered(frame []byte) (netip.AddrPort, error) {
+	var addr netip.AddrPort
+	if err := addr.UnmarshalBinary(frame[headerSize:]); err != nil {
+		return netip.AddrPort{}, errMalformed
+	}
+	return addr, nil
+}
+
+// candidatesFrame tells a client where the peer with key may be reached
+func candidatesFrame(key keys.Key, addrs []netip.AddrPort) []byte {
+	return appendAddrs(keyFrame(typeCandidates, key, nil), addrs)
+}
+
+func parseCandidates(frame []byte) (keys.Key, []netip.AddrPort, error) {
+	key, body, err := parseKeyFrame(frame)
+	if err != nil {
+		return keys.Key{}, nil, err
+	}
+	addrs, err := parseAddrs(body)
+	if err != nil {
+		return keys.Key{}, nil, err
+	}
+	return key, addrs, nil
+}
+
+// appendAddrs appends a count and each address prefixed with its length
+func appendAddrs(b []byte, addrs []netip.AddrPort) []byte {
+	if len(addrs) > maxCandidates {
+		addrs = addrs[:maxCandidates]
+	}
+	b = append(b, byte(len(addrs)))
+	for _, addr := range addrs {
+		enc, _ := addr.MarshalBinary()
+		b = append(b, byte(len(enc)))
+		b = append(b, enc...)
+	}
+	return b
+}
+
+func parseAddrs(b []byte) ([]netip.AddrPort, error) {
+	if len(b) < 1 || int(b[0]) > maxCandidates {
+		return nil, errMalformed
+	}
+	n, b := int(b[0]), b[1:]
+	addrs := make([]netip.AddrPort, 0, n)
+	for range n {
+		if len(b) < 1 || len(b) < 1+int(b[0]) {
+			return nil, errMalformed
+		}
+		var addr netip.AddrPort
+		if err := addr.UnmarshalBinary(b[1 : 1+b[0]]); err != nil {
+			return nil, errMalformed
+		}
+		addrs = append(addrs, addr)
+		b = b[1+b[0]:]
+	}
+	if len(b) != 0 {
+		return nil, errMalformed
+	}
+	return addrs, nil
+}
@@ -0,0 +1,318 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network/tunnel"
+)
+
+func newKey(t *testing.T) (private, public keys.Key) {
+	t.Helper()
+	private, err := keys.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err = private.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, public
+}
+
+// startRelay serves a relay on a loopback port until the test ends
+func startRelay(t *testing.T) (netip.AddrPort, keys.Key) {
+	t.Helper()
+	private, public := newKey(t)
+	pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewServer(private).Serve(ctx, pc)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return pc.LocalAddr().(*net.UDPAddr).AddrPort(), public
+}
+
+// peer is one end of a test tunnel
+type peer struct {
+	tunnel *tunnel.Tunnel
+	bind   *Bind
+	key    keys.Key
+	addr   netip.Addr
+}
+
+// connectPair brings up tunnels between a and b that both use the relay.
+// a starts with endpoint for b, b with none.
+func connectPair(t *testing.T, relayAddr netip.AddrPort, relayKey keys.Key, endpoint netip.AddrPort) (a, b peer) {
+	t.Helper()
+	privA, pubA := newKey(t)
+	privB, pubB := newKey(t)
+	a = peer{bind: NewBind(relayAddr, relayKey, privA), key: pubA, addr: netip.MustParseAddr("10.99.0.1")}
+	b = peer{bind: NewBind(relayAddr, relayKey, privB), key: pubB, addr: netip.MustParseAddr("10.99.0.2")}
+
+	var err error
+	a.tunnel, err = tunnel.Connect(&tunnel.Config{
+		LocalAddress:    a.addr,
+		LocalPrivateKey: privA,
+		Endpoint:        endpoint,
+		RemotePublicKey: pubB,
+		RemoteNetwork:   netip.PrefixFrom(b.addr, 32),
+		Bind:            a.bind,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.tunnel.Close)
+	b.tunnel, err = tunnel.Connect(&tunnel.Config{
+		LocalAddress:    b.addr,
+		LocalPrivateKey: privB,
+		RemotePublicKey: pubA,
+		RemoteNetwork:   netip.PrefixFrom(a.addr, 32),
+		Bind:            b.bind,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.tunnel.Close)
+
+	// b must be registered before a's packets can reach it
+	deadline := time.Now().Add(5 * time.Second)
+	for !b.bind.Registered().IsValid() {
+		if time.Now().After(deadline) {
+			t.Fatal("Peer never registered with the relay")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ln, err := b.tunnel.ListenTCP(7420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return a, b
+}
+
+// echo sends a message from a to b's echo listener and checks the reply
+func echo(t *testing.T, a, b peer) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	conn, err := a.tunnel.DialContext(ctx, "tcp", netip.AddrPortFrom(b.addr, 7420).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("Echo = %q, %v", buf, err)
+	}
+}
+
+func TestRelayedTunnel(t *testing.T) {
+	relayAddr, relayKey := startRelay(t)
+
+	// A knows an endpoint for B that nothing listens on, B knows none: the
+	// only path between them is the relay.
+	dead, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadAddr := dead.LocalAddr().(*net.UDPAddr).AddrPort()
+	dead.Close()
+
+	a, b := connectPair(t, relayAddr, relayKey, deadAddr)
+	if err := a.tunnel.SetEndpoint(Endpoint(b.key)); err != nil {
+		t.Fatal(err)
+	}
+	echo(t, a, b)
+
+	// B learned A's relay endpoint from the relayed handshake
+	stats, err := b.tunnel.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Endpoint != Endpoint(a.key) {
+		t.Errorf("B's endpoint for A = %q, want %q", stats.Endpoint, Endpoint(a.key))
+	}
+}
+
+func TestHolePunching(t *testing.T) {
+	relayAddr, relayKey := startRelay(t)
+	a, b := connectPair(t, relayAddr, relayKey, netip.AddrPort{})
+	if err := a.tunnel.SetEndpoint(Endpoint(b.key)); err != nil {
+		t.Fatal(err)
+	}
+	echo(t, a, b)
+
+	// Relaying made A look B up; both pinged each other's candidates
+	var ev network.EndpointChangeEvent
+	select {
+	case ev = <-a.bind.Events():
+	case <-time.After(10 * time.Second):
+		t.Fatal("No direct path was found")
+	}
+	want := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), b.bind.localPort())
+	if ev.PublicKey != b.key || ev.Endpoint != want {
+		t.Fatalf("Event = %v %v, want %v %v", ev.PublicKey, ev.Endpoint, b.key, want)
+	}
+
+	if err := a.tunnel.SetEndpoint(ev.Endpoint.String()); err != nil {
+		t.Fatal(err)
+	}
+	echo(t, a, b)
+	stats, err := b.tunnel.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsEndpoint(stats.Endpoint) {
+		t.Errorf("B still reaches A through the relay at %s", stats.Endpoint)
+	}
+}
+
+func TestServerRejectsReplays(t *testing.T) {
+	relayPriv, relayPub := newKey(t)
+	s := NewServer(relayPriv)
+	privA, pubA := newKey(t)
+	_, pubB := newKey(t)
+	addrA := netip.MustParseAddrPort("192.0.2.1:1000")
+	attacker := netip.MustParseAddrPort("198.51.100.1:2000")
+
+	now := time.Now()
+	frame, err := registerFrame(privA, relayPub, now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := s.handle(frame, addrA); len(out) != 1 || out[0].to != addrA {
+		t.Fatal("Valid registration was rejected")
+	}
+	if out := s.handle(frame, attacker); out != nil {
+		t.Error("Replayed registration was accepted")
+	}
+
+	stale, _ := registerFrame(privA, relayPub, now.Add(-time.Hour), nil)
+	if out := s.handle(stale, addrA); out != nil {
+		t.Error("Registration with a stale timestamp was accepted")
+	}
+
+	// A registration for someone else's key fails the MAC
+	forged := append([]byte(nil), frame...)
+	copy(forged[headerSize:], pubB[:])
+	if out := s.handle(forged, attacker); out != nil {
+		t.Error("Forged registration was accepted")
+	}
+
+	// Only registered clients can send, and only to registered clients
+	if out := s.handle(keyFrame(typeSend, pubA, []byte("x")), attacker); out != nil {
+		t.Error("Frame from an unregistered address was forwarded")
+	}
+	if out := s.handle(keyFrame(typeSend, pubB, []byte("x")), addrA); out != nil {
+		t.Error("Frame to an unregistered key was forwarded")
+	}
+	out := s.handle(keyFrame(typeSend, pubA, []byte("x")), addrA)
+	if len(out) != 1 {
+		t.Fatalf("Expected one forwarded frame, got %d", len(out))
+	}
+	if src, packet, err := parseKeyFrame(out[0].data); err != nil || out[0].to != addrA || src != pubA || string(packet) != "x" {
+		t.Errorf("Forwarded frame = %v %v %q (%v)", out[0].to, src, packet, err)
+	}
+}
+
+func TestServerExchangesCandidates(t *testing.T) {
+	relayPriv, relayPub := newKey(t)
+	s := NewServer(relayPriv)
+	privA, pubA := newKey(t)
+	privB, pubB := newKey(t)
+	addrA := netip.MustParseAddrPort("192.0.2.1:1000")
+	addrB := netip.MustParseAddrPort("198.51.100.1:2000")
+	localB := netip.MustParseAddrPort("[fd00::2]:51820")
+
+	for _, c := range []struct {
+		priv  keys.Key
+		addr  netip.AddrPort
+		local []netip.AddrPort
+	}{{privA, addrA, nil}, {privB, addrB, []netip.AddrPort{localB}}} {
+		frame, err := registerFrame(c.priv, relayPub, time.Now(), c.local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.handle(frame, c.addr) == nil {
+			t.Fatal("Registration was rejected")
+		}
+	}
+
+	out := s.handle(keyFrame(typeLookup, pubB, nil), addrA)
+	if len(out) != 2 {
+		t.Fatalf("Expected candidates for both sides, got %d frames", len(out))
+	}
+	want := map[netip.AddrPort]struct {
+		key        keys.Key
+		candidates []netip.AddrPort
+	}{
+		addrA: {pubB, []netip.AddrPort{addrB, localB}},
+		addrB: {pubA, []netip.AddrPort{addrA}},
+	}
+	for _, d := range out {
+		key, candidates, err := parseCandidates(d.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := want[d.to]
+		if key != w.key || !slices.Equal(candidates, w.candidates) {
+			t.Errorf("Candidates sent to %v = %v %v, want %v %v", d.to, key, candidates, w.key, w.candidates)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	privA, pubA := newKey(t)
+	privB, pubB := newKey(t)
+	_, pubC := newKey(t)
+	txID := [txIDSize]byte{1, 2, 3}
+
+	frame, err := probeFrame(typePing, privA, pubC, txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := parseProbe(frame, privB); err == nil {
+		t.Error("A ping for another peer must not verify")
+	}
+
+	frame, err = probeFrame(typePing, privA, pubB, txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, got, err := parseProbe(frame, privB)
+	if err != nil || sender != pubA || got != txID {
+		t.Errorf("parseProbe = %v %v (%v)", sender, got, err)
+	}
+}
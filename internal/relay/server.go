@@ -0,0 +1,197 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
+)
+
+var log = logging.For(logging.Network)
+
+// registrationTTL is how long a registration lasts. Clients register again
+// every registerInterval.
+const registrationTTL = 3 * registerInterval
+
+// maxFrameSize fits any WireGuard packet with the relay header
+const maxFrameSize = 1 << 16
+
+// Server forwards frames between registered clients
+type Server struct {
+	privateKey keys.Key
+	now        func() time.Time
+
+	mu      sync.Mutex
+	clients map[keys.Key]*client
+	addrs   map[netip.AddrPort]*client
+}
+
+type client struct {
+	key      keys.Key
+	addr     netip.AddrPort
+	local    []netip.AddrPort // announced local addresses
+	lastTS   time.Time        // newest registration timestamp, older ones are replays
+	lastSeen time.Time
+}
+
+// NewServer returns a relay that authenticates clients with privateKey. Clients
+// are configured with its public key.
+func NewServer(privateKey keys.Key) *Server {
+	return &Server{
+		privateKey: privateKey,
+		now:        time.Now,
+		clients:    make(map[keys.Key]*client),
+		addrs:      make(map[netip.AddrPort]*client),
+	}
+}
+
+// Serve forwards frames arriving on pc until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, pc *net.UDPConn) error {
+	stop := context.AfterFunc(ctx, func() { pc.Close() })
+	defer stop()
+
+	buf := make([]byte, maxFrameSize)
+	for {
+		n, from, err := pc.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Warn("Failed to read relay frame.", "error", err)
+			continue
+		}
+		from = netip.AddrPortFrom(from.Addr().Unmap(), from.Port())
+		for _, d := range s.handle(buf[:n], from) {
+			if _, err := pc.WriteToUDPAddrPort(d.data, d.to); err != nil {
+				log.Debug("Failed to write relay frame.", "to", d.to, "error", err)
+			}
+		}
+	}
+}
+
+// datagram is a frame to write and where to
+type datagram struct {
+	data []byte
+	to   netip.AddrPort
+}
+
+// handle processes one frame from addr and returns the frames to write in
+// response
+func (s *Server) handle(frame []byte, from netip.AddrPort) []datagram {
+	if !isFrame(frame) {
+		return nil
+	}
+	switch frame[len(magic)] {
+	case typeRegister:
+		key, ts, local, err := parseRegister(frame, s.privateKey)
+		if err != nil {
+			log.Debug("Rejected relay registration.", "from", from, "error", err)
+			return nil
+		}
+		if !s.register(key, ts, from, local) {
+			return nil
+		}
+		return []datagram{{registeredFrame(from), from}}
+
+	case typeSend:
+		dst, packet, err := parseKeyFrame(frame)
+		if err != nil {
+			return nil
+		}
+		src, c, ok := s.route(from, dst)
+		if !ok {
+			return nil
+		}
+		return []datagram{{keyFrame(typeRecv, src.key, packet), c.addr}}
+
+	case typeLookup:
+		dst, _, err := parseKeyFrame(frame)
+		if err != nil {
+			return nil
+		}
+		src, c, ok := s.route(from, dst)
+		if !ok {
+			return nil
+		}
+		// Both sides learn the other's candidates at the same time, so
+		// they can punch through their NATs simultaneously.
+		return []datagram{
+			{candidatesFrame(c.key, c.candidates()), src.addr},
+			{candidatesFrame(src.key, src.candidates()), c.addr},
+		}
+	}
+	return nil
+}
+
+// candidates are the addresses the client may be reached at directly, the
+// one the relay sees first
+func (c *client) candidates() []netip.AddrPort {
+	return append([]netip.AddrPort{c.addr}, c.local...)
+}
+
+// register records that key is reachable at addr. It rejects timestamps
+// outside registerSkew and replays of earlier registrations.
+func (s *Server) register(key keys.Key, ts time.Time, addr netip.AddrPort, local []netip.AddrPort) bool {
+	now := s.now()
+	if ts.Before(now.Add(-registerSkew)) || ts.After(now.Add(registerSkew)) {
+		log.Debug("Rejected relay registration with a skewed clock.", "key", key, "from", addr)
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[key]
+	if ok {
+		if !ts.After(c.lastTS) {
+			return false
+		}
+		if c.addr != addr {
+			delete(s.addrs, c.addr)
+		}
+	} else {
+		c = &client{key: key}
+		s.clients[key] = c
+		log.Info("Relay client registered.", "key", key, "addr", addr)
+	}
+	c.addr, c.local, c.lastTS, c.lastSeen = addr, local, ts, now
+	s.addrs[addr] = c
+	s.expire(now)
+	return true
+}
+
+// route returns copies of the client at from and of the client with key dst
+func (s *Server) route(from netip.AddrPort, dst keys.Key) (client, client, bool) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	src, ok := s.addrs[from]
+	if !ok || now.Sub(src.lastSeen) > registrationTTL {
+		return client{}, client{}, false
+	}
+	c, ok := s.clients[dst]
+	if !ok || now.Sub(c.lastSeen) > registrationTTL {
+		return client{}, client{}, false
+	}
+	return *src, *c, true
+}
+
+// expire drops registrations that were not renewed. They are kept past
+// their TTL for as long as a replayed registration could still be accepted.
+func (s *Server) expire(now time.Time) {
+	for key, c := range s.clients {
+		if now.Sub(c.lastSeen) > registrationTTL+registerSkew {
+			delete(s.clients, key)
+			if s.addrs[c.addr] == c {
+				delete(s.addrs, c.addr)
+			}
+		}
+	}
+}
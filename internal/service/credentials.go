@@ -0,0 +1,47 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// PassphraseCredential is the name of the credential that holds the key
+// store passphrase
+const PassphraseCredential = "passphrase"
+
+// EncryptCredential seals secret with systemd-creds so that only the user's
+// service manager can decrypt it, and writes it to path. Encrypting for user
+// units needs systemd 256 or later.
+func EncryptCredential(ctx context.Context, name string, secret []byte, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create credential directory: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "systemd-creds", "encrypt", "--user", "--name="+name, "-", path)
+	cmd.Stdin = bytes.NewReader(secret)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("systemd-creds encrypt: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return os.Chmod(path, 0o600)
+}
+
+// ReadCredential returns a credential systemd passed to the running unit.
+// It reports false outside a unit or when the unit does not load it.
+func ReadCredential(name string) ([]byte, bool, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("read credential %s: %w", name, err)
+	}
+	return data, true, nil
+}
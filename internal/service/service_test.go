@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	u := Unit{
+		Executable:       "/usr/local/bin/syncsh",
+		Args:             []string{"syncsh", "daemon"},
+		WorkingDirectory: "/home/me",
+		ReadWritePaths:   []string{"/home/me/.config/syncsh", "%t/syncsh"},
+	}
+	unit, err := u.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"ExecStart=/usr/local/bin/syncsh syncsh daemon\n",
+		"WorkingDirectory=/home/me\n",
+		"Restart=on-failure\n",
+		"NoNewPrivileges=yes\n",
+		"ProtectSystem=strict\n",
+		"ReadWritePaths=-/home/me/.config/syncsh\n",
+		"ReadWritePaths=-%t/syncsh\n",
+		"WantedBy=default.target\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("Unit is missing %q:\n%s", want, unit)
+		}
+	}
+
+	if strings.Contains(unit, "Credential") {
+		t.Errorf("Unit without a passphrase loads a credential:\n%s", unit)
+	}
+	u.Passphrase = "/home/me/.config/syncsh/passphrase.cred"
+	if unit, _ = u.Render(); !strings.Contains(unit, "LoadCredentialEncrypted=passphrase:/home/me/.config/syncsh/passphrase.cred\n") {
+		t.Errorf("Unit does not load the passphrase:\n%s", unit)
+	}
+
+	if _, err := (Unit{Executable: "syncsh"}).Render(); err == nil {
+		t.Error("Expected a relative executable to be rejected")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"daemon":           "daemon",
+		"/opt/my apps/bin": `"/opt/my apps/bin"`,
+		"100%":             "100%%",
+		`say "hi"`:         `"say \"hi\""`,
+		"$HOME":            "$$HOME",
+	}
+	for in, want := range tests {
+		if got := quote(in); got != want {
+			t.Errorf("quote(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+// fakeSystemctl records calls and answers show with output
+func fakeSystemctl(calls *[]string, output string) Runner {
+	return func(ctx context.Context, args ...string) ([]byte, error) {
+		*calls = append(*calls, strings.Join(args, " "))
+		if args[0] == "show" {
+			return []byte(output), nil
+		}
+		return nil, nil
+	}
+}
+
+func TestInstallUninstall(t *testing.T) {
+	var calls []string
+	m := &Manager{
+		Run:  fakeSystemctl(&calls, ""),
+		Path: filepath.Join(t.TempDir(), "systemd", "user", UnitName),
+	}
+	ctx := context.Background()
+
+	if err := m.Install(ctx, Unit{Executable: "/usr/bin/syncsh", Args: []string{"daemon"}}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(m.Path); err != nil || !strings.Contains(string(data), "ExecStart=/usr/bin/syncsh daemon") {
+		t.Errorf("Unit was not written: %s (%v)", data, err)
+	}
+	want := []string{"daemon-reload", "enable syncsh.service", "restart syncsh.service"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Install ran %q, want %q", calls, want)
+	}
+
+	calls = nil
+	if err := m.Uninstall(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(m.Path); !os.IsNotExist(err) {
+		t.Errorf("Expected the unit file to be removed, got: %v", err)
+	}
+	want = []string{"disable --now syncsh.service", "daemon-reload"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Uninstall ran %q, want %q", calls, want)
+	}
+	if err := m.Uninstall(ctx); err == nil {
+		t.Error("Expected uninstalling a missing unit to fail")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	var calls []string
+	m := &Manager{
+		Run: fakeSystemctl(&calls, `LoadState=loaded
+ActiveState=active
+SubState=running
+UnitFileState=enabled
+MainPID=4242
+NRestarts=1
+ActiveEnterTimestamp=Sun 2026-10-18 10:00:00 UTC
+`),
+		Path: filepath.Join(t.TempDir(), UnitName),
+	}
+	state, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := State{
+		LoadState:     "loaded",
+		ActiveState:   "active",
+		SubState:      "running",
+		UnitFileState: "enabled",
+		MainPID:       4242,
+		Restarts:      1,
+		Since:         "Sun 2026-10-18 10:00:00 UTC",
+	}
+	if state != want {
+		t.Errorf("Status = %+v, want %+v", state, want)
+	}
+}
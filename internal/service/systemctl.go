@@ -0,0 +1,129 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Runner runs systemctl --user with args and returns its combined output
+type Runner func(ctx context.Context, args ...string) ([]byte, error)
+
+// SystemctlUser runs the real systemctl against the user's service manager
+func SystemctlUser(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("systemctl --user %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+// Manager installs and inspects the unit through systemctl
+type Manager struct {
+	Run  Runner
+	Path string // unit file path
+}
+
+// NewManager returns a manager for the unit at its default path
+func NewManager() (*Manager, error) {
+	path, err := UnitPath()
+	if err != nil {
+		return nil, err
+	}
+	return &Manager{Run: SystemctlUser, Path: path}, nil
+}
+
+// Install writes the unit, then enables and starts it. A unit that is
+// already running is restarted so it picks up the new binary and unit.
+func (m *Manager) Install(ctx context.Context, u Unit) error {
+	contents, err := u.Render()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.Path), 0o755); err != nil {
+		return fmt.Errorf("create unit directory: %w", err)
+	}
+	if err := os.WriteFile(m.Path, []byte(contents), 0o644); err != nil {
+		return fmt.Errorf("write unit: %w", err)
+	}
+
+	if _, err := m.Run(ctx, "daemon-reload"); err != nil {
+		return err
+	}
+	if _, err := m.Run(ctx, "enable", UnitName); err != nil {
+		return err
+	}
+	_, err = m.Run(ctx, "restart", UnitName)
+	return err
+}
+
+// Uninstall stops and disables the unit and removes its file
+func (m *Manager) Uninstall(ctx context.Context) error {
+	if _, err := os.Stat(m.Path); errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s is not installed", UnitName)
+	}
+	if _, err := m.Run(ctx, "disable", "--now", UnitName); err != nil {
+		return err
+	}
+	if err := os.Remove(m.Path); err != nil {
+		return fmt.Errorf("remove unit: %w", err)
+	}
+	_, err := m.Run(ctx, "daemon-reload")
+	return err
+}
+
+// State is what the service manager reports about the unit
+type State struct {
+	Installed     bool   `json:"installed"`
+	LoadState     string `json:"load_state"`      // loaded, not-found, ...
+	ActiveState   string `json:"active_state"`    // active, inactive, failed, ...
+	SubState      string `json:"sub_state"`       // running, dead, auto-restart, ...
+	UnitFileState string `json:"unit_file_state"` // enabled, disabled, ...
+	MainPID       int    `json:"main_pid,omitempty"`
+	Restarts      int    `json:"restarts"`
+	Since         string `json:"since,omitempty"`
+}
+
+// Status asks the service manager for the state of the unit
+func (m *Manager) Status(ctx context.Context) (State, error) {
+	_, err := os.Stat(m.Path)
+	state := State{Installed: err == nil}
+
+	out, err := m.Run(ctx, "show", UnitName,
+		"--property=LoadState,ActiveState,SubState,UnitFileState,MainPID,NRestarts,ActiveEnterTimestamp")
+	if err != nil {
+		return state, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "LoadState":
+			state.LoadState = value
+		case "ActiveState":
+			state.ActiveState = value
+		case "SubState":
+			state.SubState = value
+		case "UnitFileState":
+			state.UnitFileState = value
+		case "MainPID":
+			state.MainPID, _ = strconv.Atoi(value)
+		case "NRestarts":
+			state.Restarts, _ = strconv.Atoi(value)
+		case "ActiveEnterTimestamp":
+			state.Since = value
+		}
+	}
+	return state, nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// UnitName is the name of the systemd user unit that runs the daemon
+const UnitName = "syncsh.service"
+
+// Unit describes the systemd user unit running the daemon
+type Unit struct {
+	Executable       string   // absolute path of the syncsh binary
+	Args             []string // arguments that start the daemon
+	WorkingDirectory string   // relative paths in config.yaml are resolved from here
+	ReadWritePaths   []string // paths the daemon writes to, everything else is read-only
+
+	// Passphrase is the credential file, encrypted with systemd-creds, that
+	// unlocks an encrypted key store. Empty for other key stores.
+	Passphrase string
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description=syncsh shell history synchronization
+Documentation=https://github.com/TheRealSibasishBehera/syncsh
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=simple
+ExecStart={{.ExecStart}}
+{{- if .WorkingDirectory}}
+WorkingDirectory={{.WorkingDirectory}}
+{{- end}}
+{{- if .Passphrase}}
+# The key store passphrase, decrypted by systemd into $CREDENTIALS_DIRECTORY
+LoadCredentialEncrypted={{.Credential}}:{{.Passphrase}}
+{{- end}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=5s
+
+# Hardening
+NoNewPrivileges=yes
+ProtectSystem=strict
+{{- range .ReadWritePaths}}
+ReadWritePaths=-{{.}}
+{{- end}}
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectKernelTunables=yes
+ProtectKernelModules=yes
+ProtectControlGroups=yes
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+SystemCallFilter=@system-service
+UMask=0077
+
+[Install]
+WantedBy=default.target
+`))
+
+// Render returns the unit file contents
+func (u Unit) Render() (string, error) {
+	if !filepath.IsAbs(u.Executable) {
+		return "", fmt.Errorf("executable must be an absolute path: %s", u.Executable)
+	}
+	words := []string{quote(u.Executable)}
+	for _, arg := range u.Args {
+		words = append(words, quote(arg))
+	}
+
+	var b strings.Builder
+	err := unitTemplate.Execute(&b, struct {
+		Unit
+		ExecStart  string
+		Credential string
+	}{u, strings.Join(words, " "), PassphraseCredential})
+	if err != nil {
+		return "", fmt.Errorf("render unit: %w", err)
+	}
+	return b.String(), nil
+}
+
+// quote escapes a word of ExecStart. Specifiers and variables are escaped
+// as %% and $$, and words with spaces, quotes or backslashes are double quoted.
+func quote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+	return `"` + s + `"`
+}
+
+// UnitPath returns where user units are installed:
+// $XDG_CONFIG_HOME/systemd/user, or ~/.config/systemd/user
+func UnitPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user", UnitName), nil
+}
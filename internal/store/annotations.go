@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+var ErrAnnotationNotFound = errors.New("annotation not found")
+
+// annotationColumns are the annotations columns scanAnnotation reads, in
+// order
+const annotationColumns = `a.hash, a.machine_id, a.tags, a.note, a.updated_at, a.signature`
+
+// GetAnnotation returns the annotation a machine attached to the entry with
+// the given hash
+func (s *Store) GetAnnotation(ctx context.Context, hash, machineID string) (parser.Annotation, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+annotationColumns+` FROM annotations a WHERE a.hash = ? AND a.machine_id = ?`,
+		hash, machineID)
+	a, err := scanAnnotation(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return parser.Annotation{}, ErrAnnotationNotFound
+		}
+		return parser.Annotation{}, dbError("scan annotation", err)
+	}
+	return a, nil
+}
+
+// PutAnnotation stores an annotation unless the machine's stored annotation
+// of the entry is at least as new or the entry was deleted, and reports
+// whether it was stored
+func (s *Store) PutAnnotation(ctx context.Context, a parser.Annotation) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `INSERT INTO annotations (hash, machine_id, tags, note, updated_at, signature)
+	          SELECT ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM tombstones WHERE hash = ?)
+	          ON CONFLICT (hash, machine_id) DO UPDATE SET
+	              tags = excluded.tags, note = excluded.note, updated_at = excluded.updated_at, signature = excluded.signature
+	          WHERE excluded.updated_at > annotations.updated_at`,
+		a.Hash, a.MachineID, strings.Join(a.Tags, " "), a.Note, a.UpdatedAt, a.Signature, a.Hash)
+	if err != nil {
+		return false, dbError("put annotation", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError("get rows affected", err)
+	}
+	return n > 0, nil
+}
+
+// ListAnnotations returns every machine's annotations of the entries with
+// the given hashes
+func (s *Store) ListAnnotations(ctx context.Context, hashes ...string) ([]parser.Annotation, error) {
+	if len(hashes) == 0 {
+		return nil, nil
+	}
+	args := make([]any, len(hashes))
+	for i, h := range hashes {
+		args[i] = h
+	}
+	return s.queryAnnotations(ctx, `SELECT `+annotationColumns+` FROM annotations a
+	          WHERE a.hash IN (?`+strings.Repeat(", ?", len(hashes)-1)+`) ORDER BY a.updated_at ASC`, args...)
+}
+
+// ListAnnotationsSince retrieves up to limit annotations of a machine past
+// since, in cursor order. Given namespaces, only annotations of stored
+// entries in one of them are listed.
+func (s *Store) ListAnnotationsSince(ctx context.Context, machineID string, since Cursor, limit int, namespaces ...string) ([]parser.Annotation, error) {
+	query := `SELECT ` + annotationColumns + ` FROM annotations a`
+	if len(namespaces) > 0 {
+		query += ` JOIN history_entries ON history_entries.hash = a.hash`
+	}
+	query += ` WHERE a.machine_id = ? AND (a.updated_at, a.hash) > (?, ?)`
+	query, args := inNamespaces(query, []any{machineID, since.Timestamp, since.Hash}, namespaces)
+	query += ` ORDER BY a.updated_at ASC, a.hash ASC LIMIT ?`
+	return s.queryAnnotations(ctx, query, append(args, limit)...)
+}
+
+// UpdateAnnotationsSyncCursor records how far a machine has acknowledged
+// the annotations of this machine
+func (s *Store) UpdateAnnotationsSyncCursor(ctx context.Context, machineID string, through Cursor) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sync_state (machine_id, last_sync_timestamp, annotations_through, annotations_through_hash)
+	          VALUES (?, 0, ?, ?)
+	          ON CONFLICT (machine_id) DO UPDATE SET annotations_through = excluded.annotations_through,
+	              annotations_through_hash = excluded.annotations_through_hash`,
+		machineID, through.Timestamp, through.Hash)
+	if err != nil {
+		return dbError("update annotations sync timestamp", err)
+	}
+	return nil
+}
+
+// AnnotationCursor returns the cursor just past a
+func AnnotationCursor(a *parser.Annotation) Cursor {
+	return Cursor{Timestamp: a.UpdatedAt, Hash: a.Hash}
+}
+
+func (s *Store) queryAnnotations(ctx context.Context, query string, args ...any) ([]parser.Annotation, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, dbError("query annotations", err)
+	}
+	defer rows.Close()
+
+	var annotations []parser.Annotation
+	for rows.Next() {
+		a, err := scanAnnotation(rows)
+		if err != nil {
+			return nil, dbError("scan annotation", err)
+		}
+		annotations = append(annotations, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("query annotations", err)
+	}
+	return annotations, nil
+}
+
+// scanAnnotation reads a row selected with annotationColumns
+func scanAnnotation(row interface{ Scan(dest ...any) error }) (parser.Annotation, error) {
+	var (
+		a    parser.Annotation
+		tags string
+	)
+	err := row.Scan(&a.Hash, &a.MachineID, &tags, &a.Note, &a.UpdatedAt, &a.Signature)
+	a.Tags = strings.Fields(tags)
+	return a, err
+}
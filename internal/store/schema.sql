@@ -1,21 +1,61 @@
-CREATE TABLE history_entries (
+CREATE TABLE IF NOT EXISTS history_entries (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     timestamp INTEGER NOT NULL,
     machine_id TEXT NOT NULL,
     command TEXT NOT NULL,
     duration INTEGER DEFAULT 0,
     exit_code INTEGER DEFAULT 0,
-    hash TEXT NOT NULL UNIQUE
+    hash TEXT NOT NULL UNIQUE,
+    signature BLOB, -- Ed25519 signature by the origin machine
+    namespace TEXT NOT NULL DEFAULT '' -- shared history the entry belongs to, empty for the machine's own
 );
 
 -- Sync state tracking
-CREATE TABLE sync_state (
+CREATE TABLE IF NOT EXISTS sync_state (
     machine_id TEXT PRIMARY KEY,
-    last_sync_timestamp INTEGER NOT NULL
+    last_sync_timestamp INTEGER NOT NULL,
+    synced_at INTEGER NOT NULL DEFAULT 0, -- when the machine last acknowledged entries
+    annotations_through INTEGER NOT NULL DEFAULT 0, -- update time of the newest annotation acknowledged
+    through_hash TEXT NOT NULL DEFAULT '', -- hash of the newest entry acknowledged, ordering entries of one second
+    annotations_through_hash TEXT NOT NULL DEFAULT '' -- hash of the entry of the newest annotation acknowledged
+);
+
+-- Tags and notes attached to history entries, one row per entry and annotating machine
+CREATE TABLE IF NOT EXISTS annotations (
+    hash TEXT NOT NULL, -- hash of the annotated history entry
+    machine_id TEXT NOT NULL, -- machine that annotated it
+    tags TEXT NOT NULL DEFAULT '', -- space separated
+    note TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL,
+    signature BLOB, -- Ed25519 signature by the annotating machine
+    PRIMARY KEY (hash, machine_id)
+);
+
+-- Deleted history entries, kept until every peer has acknowledged the deletion
+CREATE TABLE IF NOT EXISTS tombstones (
+    hash TEXT PRIMARY KEY, -- hash of the deleted history entry
+    deleted_at INTEGER NOT NULL,
+    machine_id TEXT NOT NULL, -- machine that deleted it
+    signature BLOB NOT NULL -- Ed25519 signature by the deleting machine
+);
+
+-- Tombstones each peer has acknowledged
+CREATE TABLE IF NOT EXISTS tombstone_acks (
+    hash TEXT NOT NULL,
+    machine_id TEXT NOT NULL,
+    PRIMARY KEY (hash, machine_id)
+);
+
+-- How far each history file has been read
+CREATE TABLE IF NOT EXISTS history_offsets (
+    path TEXT PRIMARY KEY,
+    offset INTEGER NOT NULL
 );
 
 -- Indexes for performance
-CREATE INDEX idx_history_timestamp ON history_entries(timestamp);
-CREATE INDEX idx_history_machine ON history_entries(machine_id);
-CREATE INDEX idx_history_hash ON history_entries(hash);
-CREATE INDEX idx_history_command ON history_entries(command);
+CREATE INDEX IF NOT EXISTS idx_history_timestamp ON history_entries(timestamp);
+CREATE INDEX IF NOT EXISTS idx_history_machine ON history_entries(machine_id);
+CREATE INDEX IF NOT EXISTS idx_history_hash ON history_entries(hash);
+CREATE INDEX IF NOT EXISTS idx_history_command ON history_entries(command);
+CREATE INDEX IF NOT EXISTS idx_history_namespace ON history_entries(namespace);
+CREATE INDEX IF NOT EXISTS idx_annotations_machine ON annotations(machine_id, updated_at);
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"strings"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+// Query selects history entries for search. Every condition given must
+// hold.
+type Query struct {
+	// Words must each appear in the command or in a note on the entry,
+	// ignoring case
+	Words []string
+	// Tags must each be attached to the entry by some machine
+	Tags []string
+	// Annotated only selects entries with tags or a note
+	Annotated bool
+	Limit     int // 0 for no limit
+}
+
+// Search returns the entries matching q, newest first
+func (s *Store) Search(ctx context.Context, q Query) ([]parser.HistoryEntry, error) {
+	query := `SELECT ` + entryColumns + ` FROM history_entries e WHERE 1=1`
+	var args []any
+
+	for _, word := range q.Words {
+		query += ` AND (instr(lower(e.command), ?) > 0 OR EXISTS (
+		               SELECT 1 FROM annotations a WHERE a.hash = e.hash AND instr(lower(a.note), ?) > 0))`
+		word = strings.ToLower(word)
+		args = append(args, word, word)
+	}
+	for _, tag := range q.Tags {
+		query += ` AND EXISTS (SELECT 1 FROM annotations a
+		               WHERE a.hash = e.hash AND instr(' ' || a.tags || ' ', ' ' || ? || ' ') > 0)`
+		args = append(args, tag)
+	}
+	if q.Annotated {
+		query += ` AND EXISTS (SELECT 1 FROM annotations a WHERE a.hash = e.hash AND (a.tags != '' OR a.note != ''))`
+	}
+
+	query += ` ORDER BY e.timestamp DESC, e.id DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+	return s.queryEntries(ctx, query, args...)
+}
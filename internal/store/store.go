@@ -8,20 +8,83 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
+	"github.com/TheRealSibasishBehera/syncsh/internal/metrics"
 	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var log = logging.For(logging.Store)
+
 var (
 	//go:embed schema.sql
 	Schema string
 
 	ErrEntryNotFound = errors.New("history entry not found")
 	ErrDuplicateHash = errors.New("duplicate hash - entry already exists")
+	ErrDeletedEntry  = errors.New("history entry was deleted")
+	ErrAmbiguousHash = errors.New("hash prefix matches more than one history entry")
 )
 
+// entryColumns are the history_entries columns scanEntry reads, in order
+const entryColumns = `id, timestamp, machine_id, command, duration, exit_code, hash, signature, namespace`
+
+// addedColumns are columns added to a table after it was first created.
+// Databases created by older versions get them when they are opened.
+var addedColumns = []struct{ table, column, definition string }{
+	{"history_entries", "signature", "BLOB"},
+	{"sync_state", "synced_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"history_entries", "namespace", "TEXT NOT NULL DEFAULT ''"},
+	{"sync_state", "annotations_through", "INTEGER NOT NULL DEFAULT 0"},
+	{"sync_state", "through_hash", "TEXT NOT NULL DEFAULT ''"},
+	{"sync_state", "annotations_through_hash", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// Cursor is a position in a machine's history: just past the entry with
+// Hash at Timestamp. Many entries can share a timestamp, so the hash orders
+// them. An empty Hash is before every entry at Timestamp. In a machine's
+// annotations, Timestamp is the update time and Hash the annotated entry's;
+// the machine and the entry identify an annotation.
+type Cursor struct {
+	Timestamp int64
+	Hash      string
+}
+
+// After reports whether c is past o
+func (c Cursor) After(o Cursor) bool {
+	return c.Timestamp > o.Timestamp || c.Timestamp == o.Timestamp && c.Hash > o.Hash
+}
+
+// Later returns whichever of c and o is further along
+func (c Cursor) Later(o Cursor) Cursor {
+	if o.After(c) {
+		return o
+	}
+	return c
+}
+
+// EntryCursor returns the cursor just past e
+func EntryCursor(e *parser.HistoryEntry) Cursor {
+	return Cursor{Timestamp: e.Timestamp, Hash: EntryHash(e)}
+}
+
+// SyncState is how far a machine has acknowledged this machine's history
+type SyncState struct {
+	Through  Cursor    // past the newest acknowledged entry
+	SyncedAt time.Time // when the last acknowledgement arrived
+
+	// AnnotationsThrough is past the newest acknowledged annotation
+	AnnotationsThrough Cursor
+}
+
 type Store struct {
 	db *sql.DB
 }
@@ -30,32 +93,106 @@ func New(db *sql.DB) *Store {
 	return &Store{db: db}
 }
 
-// InitSchema creates the database tables if they don't exist
+// dsnOptions let the daemon and the commands use the database at once:
+// readers never block the writer, and a writer waits for another to finish
+// instead of failing right away
+const dsnOptions = "?_busy_timeout=5000&_journal_mode=WAL"
+
+// Open opens the SQLite database at path, creating it and its schema if needed
+func Open(ctx context.Context, path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("create database directory: %w", err)
+	}
+	db, err := sql.Open("sqlite3", path+dsnOptions)
+	if err != nil {
+		return nil, fmt.Errorf("open database '%s': %w", path, err)
+	}
+	s := New(db)
+	if err := s.InitSchema(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("initialize database '%s': %w", path, err)
+	}
+	return s, nil
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// InitSchema creates the database tables if they don't exist and adds
+// columns missing from tables created by older versions
 func (s *Store) InitSchema(ctx context.Context) error {
+	for _, c := range addedColumns {
+		exists, err := s.tableExists(ctx, c.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		has, err := s.hasColumn(ctx, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
 	_, err := s.db.ExecContext(ctx, Schema)
 	return err
 }
 
+func (s *Store) tableExists(ctx context.Context, table string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("look up table %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+func (s *Store) hasColumn(ctx context.Context, table, column string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("look up column %s.%s: %w", table, column, err)
+	}
+	return n > 0, nil
+}
+
 // CreateEntry inserts a new history entry into the database
 func (s *Store) CreateEntry(ctx context.Context, entry *parser.HistoryEntry) error {
 	// Generate hash if not provided
 	if entry.Hash == "" {
-		entry.Hash = generateHash(entry.Timestamp, entry.MachineID, entry.Command)
+		entry.Hash = EntryHash(entry)
 	}
 
-	query := `INSERT INTO history_entries (timestamp, machine_id, command, duration, exit_code, hash) 
-	          VALUES (?, ?, ?, ?, ?, ?)`
+	// A deleted entry is refused while its tombstone is kept
+	query := `INSERT INTO history_entries (timestamp, machine_id, command, duration, exit_code, hash, signature, namespace) 
+	          SELECT ?, ?, ?, ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM tombstones WHERE hash = ?)`
 
-	_, err := s.db.ExecContext(ctx, query,
+	result, err := s.db.ExecContext(ctx, query,
 		entry.Timestamp, entry.MachineID, entry.Command,
-		entry.Duration, entry.ExitCode, entry.Hash)
+		entry.Duration, entry.ExitCode, entry.Hash, entry.Signature, entry.Namespace, entry.Hash)
 
 	if err != nil {
 		// Check for unique constraint violation on hash
 		if isUniqueConstraintError(err) {
 			return ErrDuplicateHash
 		}
-		return fmt.Errorf("insert history entry: %w", err)
+		return dbError("insert history entry", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return dbError("get rows affected", err)
+	}
+	if n == 0 {
+		return ErrDeletedEntry
 	}
 
 	return nil
@@ -63,29 +200,37 @@ func (s *Store) CreateEntry(ctx context.Context, entry *parser.HistoryEntry) err
 
 // GetEntryByHash retrieves a history entry by its hash
 func (s *Store) GetEntryByHash(ctx context.Context, hash string) (parser.HistoryEntry, error) {
-	query := `SELECT id, timestamp, machine_id, command, duration, exit_code, hash 
-	          FROM history_entries WHERE hash = ?`
-
-	row := s.db.QueryRowContext(ctx, query, hash)
+	row := s.db.QueryRowContext(ctx, `SELECT `+entryColumns+` FROM history_entries WHERE hash = ?`, hash)
+	return scanOneEntry(row)
+}
 
-	var entry parser.HistoryEntry
-	err := row.Scan(&entry.ID, &entry.Timestamp, &entry.MachineID,
-		&entry.Command, &entry.Duration, &entry.ExitCode, &entry.Hash)
+// GetEntryByID retrieves a history entry by its local ID
+func (s *Store) GetEntryByID(ctx context.Context, id int64) (parser.HistoryEntry, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+entryColumns+` FROM history_entries WHERE id = ?`, id)
+	return scanOneEntry(row)
+}
 
+// GetEntryByHashPrefix retrieves the one history entry whose hash starts
+// with prefix
+func (s *Store) GetEntryByHashPrefix(ctx context.Context, prefix string) (parser.HistoryEntry, error) {
+	entries, err := s.queryEntries(ctx, `SELECT `+entryColumns+` FROM history_entries WHERE substr(hash, 1, ?) = ? LIMIT 2`,
+		len(prefix), prefix)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return parser.HistoryEntry{}, ErrEntryNotFound
-		}
-		return parser.HistoryEntry{}, fmt.Errorf("scan history entry: %w", err)
+		return parser.HistoryEntry{}, err
+	}
+	switch len(entries) {
+	case 0:
+		return parser.HistoryEntry{}, ErrEntryNotFound
+	case 1:
+		return entries[0], nil
+	default:
+		return parser.HistoryEntry{}, ErrAmbiguousHash
 	}
-
-	return entry, nil
 }
 
 // ListEntries retrieves history entries with optional filtering
 func (s *Store) ListEntries(ctx context.Context, machineID string, since int64, limit int) ([]parser.HistoryEntry, error) {
-	query := `SELECT id, timestamp, machine_id, command, duration, exit_code, hash 
-	          FROM history_entries WHERE 1=1`
+	query := `SELECT ` + entryColumns + ` FROM history_entries WHERE 1=1`
 	args := []interface{}{}
 
 	if machineID != "" {
@@ -98,33 +243,137 @@ func (s *Store) ListEntries(ctx context.Context, machineID string, since int64,
 		args = append(args, since)
 	}
 
-	query += " ORDER BY timestamp DESC"
+	query += " ORDER BY timestamp DESC, id DESC"
 
 	if limit > 0 {
 		query += " LIMIT ?"
 		args = append(args, limit)
 	}
 
+	return s.queryEntries(ctx, query, args...)
+}
+
+// ListEntriesSince retrieves up to limit entries of a machine past since,
+// in cursor order. Given namespaces, only entries in one of them are
+// listed.
+func (s *Store) ListEntriesSince(ctx context.Context, machineID string, since Cursor, limit int, namespaces ...string) ([]parser.HistoryEntry, error) {
+	query := `SELECT ` + entryColumns + ` FROM history_entries WHERE machine_id = ? AND (timestamp, hash) > (?, ?)`
+	args := []any{machineID, since.Timestamp, since.Hash}
+	query, args = inNamespaces(query, args, namespaces)
+	query += " ORDER BY timestamp ASC, hash ASC LIMIT ?"
+	return s.queryEntries(ctx, query, append(args, limit)...)
+}
+
+func (s *Store) queryEntries(ctx context.Context, query string, args ...any) ([]parser.HistoryEntry, error) {
 	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("query history entries: %w", err)
+		return nil, dbError("query history entries", err)
 	}
 	defer rows.Close()
 
 	var entries []parser.HistoryEntry
 	for rows.Next() {
-		var entry parser.HistoryEntry
-		err := rows.Scan(&entry.ID, &entry.Timestamp, &entry.MachineID,
-			&entry.Command, &entry.Duration, &entry.ExitCode, &entry.Hash)
+		entry, err := scanEntry(rows)
 		if err != nil {
-			return nil, fmt.Errorf("scan history entry: %w", err)
+			return nil, dbError("scan history entry", err)
 		}
 		entries = append(entries, entry)
 	}
-
+	if err := rows.Err(); err != nil {
+		return nil, dbError("query history entries", err)
+	}
 	return entries, nil
 }
 
+// scanEntry reads a row selected with entryColumns
+func scanEntry(row interface{ Scan(dest ...any) error }) (parser.HistoryEntry, error) {
+	var entry parser.HistoryEntry
+	err := row.Scan(&entry.ID, &entry.Timestamp, &entry.MachineID,
+		&entry.Command, &entry.Duration, &entry.ExitCode, &entry.Hash, &entry.Signature, &entry.Namespace)
+	return entry, err
+}
+
+func scanOneEntry(row *sql.Row) (parser.HistoryEntry, error) {
+	entry, err := scanEntry(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return parser.HistoryEntry{}, ErrEntryNotFound
+		}
+		return parser.HistoryEntry{}, dbError("scan history entry", err)
+	}
+	return entry, nil
+}
+
+// inNamespaces restricts query to entries in one of namespaces, if any are
+// given
+func inNamespaces(query string, args []any, namespaces []string) (string, []any) {
+	if len(namespaces) == 0 {
+		return query, args
+	}
+	query += " AND namespace IN (?" + strings.Repeat(", ?", len(namespaces)-1) + ")"
+	for _, ns := range namespaces {
+		args = append(args, ns)
+	}
+	return query, args
+}
+
+// CountEntries returns the number of stored history entries
+func (s *Store) CountEntries(ctx context.Context) (int64, error) {
+	var n int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM history_entries`).Scan(&n); err != nil {
+		return 0, dbError("count history entries", err)
+	}
+	return n, nil
+}
+
+// CountEntriesSince returns the number of entries of a machine past since,
+// only counting those in one of namespaces if any are given
+func (s *Store) CountEntriesSince(ctx context.Context, machineID string, since Cursor, namespaces ...string) (int64, error) {
+	query, args := inNamespaces(`SELECT COUNT(*) FROM history_entries WHERE machine_id = ? AND (timestamp, hash) > (?, ?)`,
+		[]any{machineID, since.Timestamp, since.Hash}, namespaces)
+	var n int64
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&n)
+	if err != nil {
+		return 0, dbError("count history entries", err)
+	}
+	return n, nil
+}
+
+// Size returns the size of the database in bytes
+func (s *Store) Size(ctx context.Context) (int64, error) {
+	var size int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size)
+	if err != nil {
+		return 0, dbError("get database size", err)
+	}
+	return size, nil
+}
+
+// GetHistoryOffset returns how many bytes of the history file at path have
+// been ingested
+func (s *Store) GetHistoryOffset(ctx context.Context, path string) (int64, error) {
+	var offset int64
+	err := s.db.QueryRowContext(ctx, `SELECT offset FROM history_offsets WHERE path = ?`, path).Scan(&offset)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, dbError("get history offset", err)
+	}
+	return offset, nil
+}
+
+// SetHistoryOffset records how many bytes of the history file at path have
+// been ingested
+func (s *Store) SetHistoryOffset(ctx context.Context, path string, offset int64) error {
+	_, err := s.db.ExecContext(ctx, `INSERT OR REPLACE INTO history_offsets (path, offset) VALUES (?, ?)`, path, offset)
+	if err != nil {
+		return dbError("set history offset", err)
+	}
+	return nil
+}
+
 // GetLastSyncTimestamp retrieves the last sync timestamp for a machine
 func (s *Store) GetLastSyncTimestamp(ctx context.Context, machineID string) (int64, error) {
 	query := `SELECT last_sync_timestamp FROM sync_state WHERE machine_id = ?`
@@ -136,7 +385,7 @@ func (s *Store) GetLastSyncTimestamp(ctx context.Context, machineID string) (int
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, nil // No previous sync
 		}
-		return 0, fmt.Errorf("get last sync timestamp: %w", err)
+		return 0, dbError("get last sync timestamp", err)
 	}
 
 	return timestamp, nil
@@ -144,37 +393,99 @@ func (s *Store) GetLastSyncTimestamp(ctx context.Context, machineID string) (int
 
 // UpdateLastSyncTimestamp updates the last sync timestamp for a machine
 func (s *Store) UpdateLastSyncTimestamp(ctx context.Context, machineID string, timestamp int64) error {
-	query := `INSERT OR REPLACE INTO sync_state (machine_id, last_sync_timestamp) VALUES (?, ?)`
+	return s.UpdateSyncCursor(ctx, machineID, Cursor{Timestamp: timestamp})
+}
 
-	_, err := s.db.ExecContext(ctx, query, machineID, timestamp)
+// UpdateSyncCursor records how far a machine has acknowledged this
+// machine's history
+func (s *Store) UpdateSyncCursor(ctx context.Context, machineID string, through Cursor) error {
+	query := `INSERT INTO sync_state (machine_id, last_sync_timestamp, through_hash, synced_at) VALUES (?, ?, ?, ?)
+	          ON CONFLICT (machine_id) DO UPDATE SET last_sync_timestamp = excluded.last_sync_timestamp,
+	              through_hash = excluded.through_hash, synced_at = excluded.synced_at`
+
+	_, err := s.db.ExecContext(ctx, query, machineID, through.Timestamp, through.Hash, time.Now().Unix())
 	if err != nil {
-		return fmt.Errorf("update last sync timestamp: %w", err)
+		return dbError("update last sync timestamp", err)
 	}
 
 	return nil
 }
 
-// DeleteEntry removes a history entry by hash
-func (s *Store) DeleteEntry(ctx context.Context, hash string) error {
-	query := `DELETE FROM history_entries WHERE hash = ?`
-
-	result, err := s.db.ExecContext(ctx, query, hash)
+// GetSyncState returns how far a machine has acknowledged this machine's
+// history. The zero SyncState is returned for machines never synced with.
+func (s *Store) GetSyncState(ctx context.Context, machineID string) (SyncState, error) {
+	var (
+		state    SyncState
+		syncedAt int64
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT last_sync_timestamp, through_hash, synced_at, annotations_through, annotations_through_hash
+	          FROM sync_state WHERE machine_id = ?`, machineID).Scan(&state.Through.Timestamp, &state.Through.Hash, &syncedAt,
+		&state.AnnotationsThrough.Timestamp, &state.AnnotationsThrough.Hash)
 	if err != nil {
-		return fmt.Errorf("delete history entry: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return SyncState{}, nil
+		}
+		return SyncState{}, dbError("get sync state", err)
+	}
+	if syncedAt > 0 {
+		state.SyncedAt = time.Unix(syncedAt, 0)
 	}
+	return state, nil
+}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("get rows affected: %w", err)
+// DeleteEntry deletes a history entry by hash the way PutTombstone does,
+// with a tombstone signed as machineID so peers delete it too and never
+// send it back
+func (s *Store) DeleteEntry(ctx context.Context, hash, machineID string, signingKey keys.Key) error {
+	if _, err := s.GetEntryByHash(ctx, hash); err != nil {
+		return err
 	}
+	t := parser.Tombstone{Hash: hash, DeletedAt: time.Now().Unix(), MachineID: machineID}
+	provenance.SignTombstone(&t, signingKey)
+	_, err := s.PutTombstone(ctx, t)
+	return err
+}
 
-	if rowsAffected == 0 {
-		return ErrEntryNotFound
+// DeleteMachineEntries removes every history entry of a machine and returns
+// how many were removed
+func (s *Store) DeleteMachineEntries(ctx context.Context, machineID string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM history_entries WHERE machine_id = ?`, machineID)
+	if err != nil {
+		return 0, dbError("delete history entries", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, dbError("get rows affected", err)
 	}
+	return n, nil
+}
 
+// DeleteSyncState forgets how far a machine has acknowledged this machine's
+// history
+func (s *Store) DeleteSyncState(ctx context.Context, machineID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM sync_state WHERE machine_id = ?`, machineID); err != nil {
+		return dbError("delete sync state", err)
+	}
 	return nil
 }
 
+// dbError wraps a failed database operation and counts it
+func dbError(op string, err error) error {
+	metrics.SQLiteErrors.WithLabelValues(op).Inc()
+	log.Debug("Store operation failed.", "op", op, "error", err)
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+// EntryHash returns the hash a history entry is stored under. Entries of a shared
+// namespace also hash their namespace, so sharing a command never collides
+// with the machine's own entry for it. Shell commands cannot contain NUL.
+func EntryHash(e *parser.HistoryEntry) string {
+	if e.Namespace == "" {
+		return generateHash(e.Timestamp, e.MachineID, e.Command)
+	}
+	return generateHash(e.Timestamp, e.MachineID, e.Namespace+"\x00"+e.Command)
+}
+
 // generateHash creates a unique hash for a history entry
 func generateHash(timestamp int64, machineID, command string) string {
 	data := strconv.FormatInt(timestamp, 10) + machineID + command
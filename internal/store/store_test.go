@@ -3,13 +3,62 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
 	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+func TestOpenConcurrent(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "syncsh.db")
+	var stores []*Store
+	for range 2 {
+		s, err := Open(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		stores = append(stores, s)
+	}
+
+	var mode string
+	var timeout int
+	if err := stores[0].db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("journal_mode = %q, %v; want wal", mode, err)
+	}
+	if err := stores[1].db.QueryRowContext(ctx, "PRAGMA busy_timeout").Scan(&timeout); err != nil || timeout != 5000 {
+		t.Errorf("busy_timeout = %d, %v; want 5000", timeout, err)
+	}
+
+	// Both write while the other holds the database
+	errs := make(chan error, len(stores))
+	for i, s := range stores {
+		go func() {
+			for j := range 50 {
+				e := parser.HistoryEntry{Timestamp: int64(j), MachineID: "m", Command: fmt.Sprintf("echo %d", i)}
+				if err := s.CreateEntry(ctx, &e); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for range stores {
+		if err := <-errs; err != nil {
+			t.Errorf("Concurrent write failed: %v", err)
+		}
+	}
+}
+
 func setupTestDB(t *testing.T) *Store {
 	db, err := sql.Open("sqlite3", ":memory:")
 	if err != nil {
@@ -250,7 +299,11 @@ func TestDeleteEntry(t *testing.T) {
 	}
 	
 	// Delete the entry
-	err = store.DeleteEntry(ctx, entry.Hash)
+	seed, publicKey, err := keys.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.DeleteEntry(ctx, entry.Hash, "machine-2", seed)
 	if err != nil {
 		t.Fatalf("Failed to delete entry: %v", err)
 	}
@@ -260,9 +313,21 @@ func TestDeleteEntry(t *testing.T) {
 	if err != ErrEntryNotFound {
 		t.Errorf("Expected ErrEntryNotFound, got: %v", err)
 	}
+
+	// and replaced by a signed tombstone that keeps it from coming back
+	tombstones, err := store.ListTombstones(ctx, "machine-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tombstones) != 1 || provenance.VerifyTombstone(tombstones[0], publicKey) != nil {
+		t.Errorf("Expected one signed tombstone, got %+v", tombstones)
+	}
+	if err := store.CreateEntry(ctx, &entry); !errors.Is(err, ErrDeletedEntry) {
+		t.Errorf("Expected the deleted entry to be refused, got: %v", err)
+	}
 	
 	// Test deleting non-existent entry
-	err = store.DeleteEntry(ctx, "non-existent-hash")
+	err = store.DeleteEntry(ctx, "non-existent-hash", "machine-2", seed)
 	if err != ErrEntryNotFound {
 		t.Errorf("Expected ErrEntryNotFound for non-existent entry, got: %v", err)
 	}
@@ -292,4 +357,465 @@ func TestHashGeneration(t *testing.T) {
 	if hash1 == hash5 {
 		t.Error("Different timestamps should generate different hashes")
 	}
-}
\ No newline at end of file
+}
+func TestSignatureRoundTrip(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	entry := parser.HistoryEntry{
+		Timestamp: time.Now().Unix(),
+		MachineID: "test-machine-1",
+		Command:   "echo signed",
+		Signature: []byte{1, 2, 3},
+	}
+	if err := store.CreateEntry(ctx, &entry); err != nil {
+		t.Fatalf("Failed to create entry: %v", err)
+	}
+
+	retrieved, err := store.GetEntryByHash(ctx, entry.Hash)
+	if err != nil {
+		t.Fatalf("Failed to retrieve entry: %v", err)
+	}
+	if string(retrieved.Signature) != string(entry.Signature) {
+		t.Errorf("Signature mismatch: got %x, want %x", retrieved.Signature, entry.Signature)
+	}
+}
+
+func TestInitSchemaIdempotent(t *testing.T) {
+	store := setupTestDB(t)
+	if err := store.InitSchema(context.Background()); err != nil {
+		t.Errorf("Initializing an existing database failed: %v", err)
+	}
+}
+
+func TestListEntriesSince(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	for i, cmd := range []string{"one", "two", "three"} {
+		entry := parser.HistoryEntry{Timestamp: int64(100 + i), MachineID: "m1", Command: cmd}
+		if err := store.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := parser.HistoryEntry{Timestamp: 200, MachineID: "m2", Command: "other"}
+	if err := store.CreateEntry(ctx, &other); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := store.ListEntriesSince(ctx, "m1", Cursor{Timestamp: 100, Hash: "~"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Command != "two" || got[1].Command != "three" {
+		t.Errorf("Expected [two three] oldest first, got %+v", got)
+	}
+	if got, _ := store.ListEntriesSince(ctx, "m1", Cursor{}, 1); len(got) != 1 || got[0].Command != "one" {
+		t.Errorf("Expected limit to keep the oldest entry, got %+v", got)
+	}
+
+	n, err := store.CountEntries(ctx)
+	if err != nil || n != 4 {
+		t.Errorf("CountEntries = %d, %v; want 4", n, err)
+	}
+	if n, err := store.CountEntriesSince(ctx, "m1", Cursor{Timestamp: 100, Hash: "~"}); err != nil || n != 2 {
+		t.Errorf("CountEntriesSince = %d, %v; want 2", n, err)
+	}
+
+	// Paging through entries of the same second skips none of them
+	for _, cmd := range []string{"a", "b", "c", "d", "e"} {
+		entry := parser.HistoryEntry{Timestamp: 300, MachineID: "m1", Command: cmd}
+		if err := store.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[string]bool)
+	for since := (Cursor{Timestamp: 102, Hash: "~"}); ; {
+		page, err := store.ListEntriesSince(ctx, "m1", since, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, e := range page {
+			seen[e.Command] = true
+		}
+		since = EntryCursor(&page[len(page)-1])
+	}
+	if len(seen) != 5 {
+		t.Errorf("Paging listed %d of 5 entries of the same second", len(seen))
+	}
+	if size, err := store.Size(ctx); err != nil || size <= 0 {
+		t.Errorf("Size = %d, %v; want a positive size", size, err)
+	}
+}
+
+func TestGetSyncState(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	if state, err := store.GetSyncState(ctx, "peer"); err != nil || state != (SyncState{}) {
+		t.Errorf("Expected no sync state, got %+v, %v", state, err)
+	}
+	before := time.Now().Add(-time.Second)
+	if err := store.UpdateLastSyncTimestamp(ctx, "peer", 1700000000); err != nil {
+		t.Fatal(err)
+	}
+	state, err := store.GetSyncState(ctx, "peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Through != (Cursor{Timestamp: 1700000000}) || state.SyncedAt.Before(before) {
+		t.Errorf("Unexpected sync state %+v", state)
+	}
+	through := Cursor{Timestamp: 1700000000, Hash: "ab"}
+	if err := store.UpdateSyncCursor(ctx, "peer", through); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = store.GetSyncState(ctx, "peer"); err != nil || state.Through != through {
+		t.Errorf("Unexpected sync state %+v", state)
+	}
+}
+
+func TestInitSchemaAddsColumns(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+	ctx := context.Background()
+
+	// Tables as the first release created them
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE history_entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp INTEGER NOT NULL,
+			machine_id TEXT NOT NULL,
+			command TEXT NOT NULL,
+			duration INTEGER DEFAULT 0,
+			exit_code INTEGER DEFAULT 0,
+			hash TEXT NOT NULL UNIQUE
+		);
+		CREATE TABLE sync_state (machine_id TEXT PRIMARY KEY, last_sync_timestamp INTEGER NOT NULL);
+		INSERT INTO sync_state VALUES ('peer', 5);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := New(db)
+	if err := store.InitSchema(ctx); err != nil {
+		t.Fatalf("Failed to upgrade the schema: %v", err)
+	}
+	if state, err := store.GetSyncState(ctx, "peer"); err != nil || state.Through.Timestamp != 5 {
+		t.Errorf("Existing sync state was lost: %+v, %v", state, err)
+	}
+	entry := parser.HistoryEntry{Timestamp: 1, MachineID: "m", Command: "ls", Signature: []byte{1}}
+	if err := store.CreateEntry(ctx, &entry); err != nil {
+		t.Errorf("Failed to store a signed entry: %v", err)
+	}
+}
+
+func TestHistoryOffset(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	if offset, err := store.GetHistoryOffset(ctx, "/tmp/history"); err != nil || offset != 0 {
+		t.Errorf("Expected offset 0 for an unknown file, got %d, %v", offset, err)
+	}
+	if err := store.SetHistoryOffset(ctx, "/tmp/history", 42); err != nil {
+		t.Fatal(err)
+	}
+	if offset, _ := store.GetHistoryOffset(ctx, "/tmp/history"); offset != 42 {
+		t.Errorf("Expected offset 42, got %d", offset)
+	}
+}
+
+func TestDeleteMachine(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	for i, machineID := range []string{"machine-1", "machine-2", "machine-1"} {
+		entry := parser.HistoryEntry{Timestamp: int64(1700000000 + i), MachineID: machineID, Command: "ls"}
+		if err := store.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.UpdateLastSyncTimestamp(ctx, "machine-1", 1700000002); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := store.DeleteMachineEntries(ctx, "machine-1"); err != nil || n != 2 {
+		t.Fatalf("DeleteMachineEntries() = %d, %v, want 2", n, err)
+	}
+	if entries, err := store.ListEntries(ctx, "", 0, 0); err != nil || len(entries) != 1 || entries[0].MachineID != "machine-2" {
+		t.Errorf("Entries left = %+v (%v), want machine-2's", entries, err)
+	}
+
+	if err := store.DeleteSyncState(ctx, "machine-1"); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := store.GetSyncState(ctx, "machine-1"); err != nil || state != (SyncState{}) {
+		t.Errorf("Sync state after delete = %+v (%v)", state, err)
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	own := parser.HistoryEntry{Timestamp: 100, MachineID: "m1", Command: "make deploy"}
+	shared := own
+	shared.Namespace = "team"
+	for _, e := range []*parser.HistoryEntry{&own, &shared, {Timestamp: 101, MachineID: "m1", Command: "ls", Namespace: "ops"}} {
+		if err := store.CreateEntry(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if own.Hash == shared.Hash {
+		t.Error("Sharing a command must not reuse the hash of the own entry")
+	}
+	if got, err := store.GetEntryByHash(ctx, shared.Hash); err != nil || got.Namespace != "team" {
+		t.Errorf("GetEntryByHash() = %+v, %v", got, err)
+	}
+
+	got, err := store.ListEntriesSince(ctx, "m1", Cursor{}, 10, "", "team")
+	if err != nil || len(got) != 2 || got[0].Namespace != "" || got[1].Namespace != "team" {
+		t.Errorf("ListEntriesSince(personal, team) = %+v, %v", got, err)
+	}
+	if got, _ := store.ListEntriesSince(ctx, "m1", Cursor{}, 10); len(got) != 3 {
+		t.Errorf("ListEntriesSince() without namespaces = %d entries, want 3", len(got))
+	}
+	if n, err := store.CountEntriesSince(ctx, "m1", Cursor{}, "ops"); err != nil || n != 1 {
+		t.Errorf("CountEntriesSince(ops) = %d, %v; want 1", n, err)
+	}
+}
+
+func TestGetEntry(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	var entries []parser.HistoryEntry
+	for i := range 2 {
+		entry := parser.HistoryEntry{Timestamp: int64(i), MachineID: "m1", Command: "ls"}
+		if err := store.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	byHash, err := store.GetEntryByHash(ctx, entries[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := store.GetEntryByID(ctx, byHash.ID); err != nil || got.Hash != byHash.Hash {
+		t.Errorf("GetEntryByID() = %+v, %v", got, err)
+	}
+	if _, err := store.GetEntryByID(ctx, 1000); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("GetEntryByID() of a missing entry = %v", err)
+	}
+	if got, err := store.GetEntryByHashPrefix(ctx, byHash.Hash[:12]); err != nil || got.ID != byHash.ID {
+		t.Errorf("GetEntryByHashPrefix() = %+v, %v", got, err)
+	}
+	if _, err := store.GetEntryByHashPrefix(ctx, ""); !errors.Is(err, ErrAmbiguousHash) {
+		t.Errorf("GetEntryByHashPrefix() of an ambiguous prefix = %v", err)
+	}
+	if _, err := store.GetEntryByHashPrefix(ctx, "xyz"); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("GetEntryByHashPrefix() of an unknown prefix = %v", err)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	entry := parser.HistoryEntry{Timestamp: 100, MachineID: "m1", Command: "kubectl rollout undo deploy/api", Namespace: "ops"}
+	if err := store.CreateEntry(ctx, &entry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetAnnotation(ctx, entry.Hash, "m1"); !errors.Is(err, ErrAnnotationNotFound) {
+		t.Errorf("GetAnnotation() before annotating = %v", err)
+	}
+
+	a := parser.Annotation{Hash: entry.Hash, MachineID: "m2", Tags: []string{"deploy", "prod"}, Note: "Rollback", UpdatedAt: 10}
+	if stored, err := store.PutAnnotation(ctx, a); err != nil || !stored {
+		t.Fatalf("PutAnnotation() = %v, %v", stored, err)
+	}
+	older := a
+	older.Tags, older.UpdatedAt = nil, 10
+	if stored, err := store.PutAnnotation(ctx, older); err != nil || stored {
+		t.Errorf("PutAnnotation() of an annotation that is not newer = %v, %v", stored, err)
+	}
+	got, err := store.GetAnnotation(ctx, entry.Hash, "m2")
+	if err != nil || len(got.Tags) != 2 || got.Note != "Rollback" {
+		t.Errorf("GetAnnotation() = %+v, %v", got, err)
+	}
+
+	if got, err := store.ListAnnotationsSince(ctx, "m2", Cursor{}, 10, "ops"); err != nil || len(got) != 1 {
+		t.Errorf("ListAnnotationsSince(ops) = %+v, %v", got, err)
+	}
+	if got, _ := store.ListAnnotationsSince(ctx, "m2", Cursor{}, 10, ""); len(got) != 0 {
+		t.Errorf("ListAnnotationsSince(personal) = %+v, want none", got)
+	}
+	if got, _ := store.ListAnnotationsSince(ctx, "m2", AnnotationCursor(&a), 10); len(got) != 0 {
+		t.Errorf("ListAnnotationsSince(10) = %+v, want none", got)
+	}
+
+	// Paging through annotations of the same second skips none of them
+	for _, hash := range []string{"h1", "h2", "h3"} {
+		if _, err := store.PutAnnotation(ctx, parser.Annotation{Hash: hash, MachineID: "m3", Note: hash, UpdatedAt: 20}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[string]bool)
+	for since := (Cursor{}); ; {
+		page, err := store.ListAnnotationsSince(ctx, "m3", since, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, a := range page {
+			seen[a.Hash] = true
+		}
+		since = AnnotationCursor(&page[len(page)-1])
+	}
+	if len(seen) != 3 {
+		t.Errorf("Paging listed %d of 3 annotations of the same second", len(seen))
+	}
+
+	if err := store.UpdateLastSyncTimestamp(ctx, "m2", 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateAnnotationsSyncCursor(ctx, "m2", AnnotationCursor(&a)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.UpdateLastSyncTimestamp(ctx, "m2", 60); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := store.GetSyncState(ctx, "m2"); err != nil || state.Through.Timestamp != 60 || state.AnnotationsThrough != AnnotationCursor(&a) {
+		t.Errorf("GetSyncState() = %+v, %v", state, err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	var entries []parser.HistoryEntry
+	for i, command := range []string{"git push origin main", "kubectl get pods", "Git status"} {
+		entry := parser.HistoryEntry{Timestamp: int64(100 + i), MachineID: "m1", Command: command}
+		if err := store.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	for _, a := range []parser.Annotation{
+		{Hash: entries[1].Hash, MachineID: "m1", Tags: []string{"k8s"}, Note: "Check the cluster", UpdatedAt: 1},
+		{Hash: entries[0].Hash, MachineID: "m2", Tags: []string{"git", "release"}, UpdatedAt: 1},
+	} {
+		if _, err := store.PutAnnotation(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{name: "all", query: Query{}, want: []string{"Git status", "kubectl get pods", "git push origin main"}},
+		{name: "word ignores case", query: Query{Words: []string{"GIT"}}, want: []string{"Git status", "git push origin main"}},
+		{name: "every word", query: Query{Words: []string{"git", "push"}}, want: []string{"git push origin main"}},
+		{name: "note", query: Query{Words: []string{"cluster"}}, want: []string{"kubectl get pods"}},
+		{name: "tag", query: Query{Tags: []string{"release"}}, want: []string{"git push origin main"}},
+		{name: "tag is whole", query: Query{Tags: []string{"rel"}}},
+		{name: "tag and word", query: Query{Tags: []string{"git"}, Words: []string{"status"}}},
+		{name: "annotated", query: Query{Annotated: true}, want: []string{"kubectl get pods", "git push origin main"}},
+		{name: "limit", query: Query{Limit: 1}, want: []string{"Git status"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Search(ctx, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var commands []string
+			for _, e := range got {
+				commands = append(commands, e.Command)
+			}
+			if !slices.Equal(commands, tt.want) {
+				t.Errorf("Search() = %q, want %q", commands, tt.want)
+			}
+		})
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	store := setupTestDB(t)
+	ctx := context.Background()
+
+	entry := parser.HistoryEntry{Timestamp: 100, MachineID: "m1", Command: "export TOKEN=secret"}
+	if err := store.CreateEntry(ctx, &entry); err != nil {
+		t.Fatal(err)
+	}
+	note := parser.Annotation{Hash: entry.Hash, MachineID: "m2", Note: "the token", UpdatedAt: 1}
+	if _, err := store.PutAnnotation(ctx, note); err != nil {
+		t.Fatal(err)
+	}
+
+	tombstone := parser.Tombstone{Hash: entry.Hash, DeletedAt: 200, MachineID: "m1", Signature: []byte("sig")}
+	if isNew, err := store.PutTombstone(ctx, tombstone); err != nil || !isNew {
+		t.Fatalf("PutTombstone() = %v, %v", isNew, err)
+	}
+	if isNew, err := store.PutTombstone(ctx, tombstone); err != nil || isNew {
+		t.Errorf("PutTombstone() again = %v, %v", isNew, err)
+	}
+	if _, err := store.GetEntryByHash(ctx, entry.Hash); !errors.Is(err, ErrEntryNotFound) {
+		t.Errorf("GetEntryByHash() of a deleted entry = %v", err)
+	}
+	if got, _ := store.ListAnnotations(ctx, entry.Hash); len(got) != 0 {
+		t.Errorf("Annotations of a deleted entry were kept: %+v", got)
+	}
+
+	// The entry and its annotations are refused while the tombstone is kept
+	again := parser.HistoryEntry{Timestamp: 100, MachineID: "m1", Command: "export TOKEN=secret"}
+	if err := store.CreateEntry(ctx, &again); !errors.Is(err, ErrDeletedEntry) {
+		t.Errorf("CreateEntry() of a deleted entry = %v", err)
+	}
+	note.UpdatedAt++
+	if stored, err := store.PutAnnotation(ctx, note); err != nil || stored {
+		t.Errorf("PutAnnotation() of a deleted entry = %v, %v", stored, err)
+	}
+
+	if got, err := store.ListTombstones(ctx, "m2"); err != nil || len(got) != 1 || string(got[0].Signature) != "sig" {
+		t.Errorf("ListTombstones(m2) = %+v, %v", got, err)
+	}
+	if got, _ := store.ListTombstones(ctx, "m1"); len(got) != 0 {
+		t.Errorf("ListTombstones() of the deleting machine = %+v, want none", got)
+	}
+
+	if err := store.AckTombstones(ctx, "m2", entry.Hash, "unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := store.ListTombstones(ctx, "m2"); len(got) != 0 {
+		t.Errorf("ListTombstones() after the ack = %+v, want none", got)
+	}
+	if n, err := store.CollectTombstones(ctx, nil); err != nil || n != 0 {
+		t.Errorf("CollectTombstones() without machines = %d, %v; want 0", n, err)
+	}
+	if n, err := store.CollectTombstones(ctx, []string{"m1", "m2", "m3"}); err != nil || n != 0 {
+		t.Errorf("CollectTombstones() before m3 acknowledged = %d, %v; want 0", n, err)
+	}
+	if err := store.AckTombstones(ctx, "m3", entry.Hash); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.CollectTombstones(ctx, []string{"m1", "m2", "m3"}); err != nil || n != 1 {
+		t.Errorf("CollectTombstones() = %d, %v; want 1", n, err)
+	}
+	if err := store.CreateEntry(ctx, &again); err != nil {
+		t.Errorf("CreateEntry() after the tombstone was dropped = %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"strings"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+)
+
+// PutTombstone stores a tombstone and deletes the entry it names along with
+// the entry's annotations. It reports whether the tombstone was new.
+func (s *Store) PutTombstone(ctx context.Context, t parser.Tombstone) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, dbError("begin transaction", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `INSERT INTO tombstones (hash, deleted_at, machine_id, signature) VALUES (?, ?, ?, ?)
+	          ON CONFLICT (hash) DO NOTHING`,
+		t.Hash, t.DeletedAt, t.MachineID, t.Signature)
+	if err != nil {
+		return false, dbError("put tombstone", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, dbError("get rows affected", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM history_entries WHERE hash = ?`, t.Hash); err != nil {
+		return false, dbError("delete history entry", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM annotations WHERE hash = ?`, t.Hash); err != nil {
+		return false, dbError("delete annotations", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, dbError("commit tombstone", err)
+	}
+	return n > 0, nil
+}
+
+// ListTombstones returns the tombstones a machine has neither acknowledged
+// nor made itself, oldest first
+func (s *Store) ListTombstones(ctx context.Context, unackedBy string) ([]parser.Tombstone, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT t.hash, t.deleted_at, t.machine_id, t.signature FROM tombstones t
+	          WHERE t.machine_id != ? AND NOT EXISTS (
+	              SELECT 1 FROM tombstone_acks a WHERE a.hash = t.hash AND a.machine_id = ?)
+	          ORDER BY t.deleted_at ASC`, unackedBy, unackedBy)
+	if err != nil {
+		return nil, dbError("query tombstones", err)
+	}
+	defer rows.Close()
+
+	var tombstones []parser.Tombstone
+	for rows.Next() {
+		var t parser.Tombstone
+		if err := rows.Scan(&t.Hash, &t.DeletedAt, &t.MachineID, &t.Signature); err != nil {
+			return nil, dbError("scan tombstone", err)
+		}
+		tombstones = append(tombstones, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError("query tombstones", err)
+	}
+	return tombstones, nil
+}
+
+// AckTombstones records that a machine has acknowledged the tombstones with
+// the given hashes
+func (s *Store) AckTombstones(ctx context.Context, machineID string, hashes ...string) error {
+	for _, hash := range hashes {
+		_, err := s.db.ExecContext(ctx, `INSERT INTO tombstone_acks (hash, machine_id)
+		          SELECT hash, ? FROM tombstones WHERE hash = ?
+		          ON CONFLICT (hash, machine_id) DO NOTHING`, machineID, hash)
+		if err != nil {
+			return dbError("ack tombstone", err)
+		}
+	}
+	return nil
+}
+
+// CollectTombstones deletes the tombstones each of machineIDs has
+// acknowledged or made itself and returns how many were deleted. After that
+// an entry they named is accepted again. With no machines nothing is
+// deleted, since nobody has acknowledged anything.
+func (s *Store) CollectTombstones(ctx context.Context, machineIDs []string) (int64, error) {
+	if len(machineIDs) == 0 {
+		return 0, nil
+	}
+	query := `DELETE FROM tombstones WHERE NOT EXISTS (
+	              SELECT 1 FROM (VALUES (?)` + strings.Repeat(", (?)", len(machineIDs)-1) + `) p
+	              WHERE p.column1 != tombstones.machine_id AND NOT EXISTS (
+	                  SELECT 1 FROM tombstone_acks a WHERE a.hash = tombstones.hash AND a.machine_id = p.column1))`
+	args := make([]any, len(machineIDs))
+	for i, id := range machineIDs {
+		args[i] = id
+	}
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, dbError("collect tombstones", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, dbError("get rows affected", err)
+	}
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM tombstone_acks WHERE hash NOT IN (SELECT hash FROM tombstones)`); err != nil {
+		return n, dbError("collect tombstone acks", err)
+	}
+	return n, nil
+}
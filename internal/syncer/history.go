@@ -0,0 +1,564 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/envelope"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/metrics"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/protocol"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	"golang.org/x/time/rate"
+)
+
+// historyBatchSize caps the entries sealed into a single batch
+const historyBatchSize = 500
+
+var (
+	ErrUnknownOrigin = errors.New("history batch was sealed by an unknown machine")
+	ErrForgedEntries = errors.New("history batch claims a machine ID its sender does not own")
+)
+
+// SendHistory seals entries for the session's peer and sends them. The batch
+// is sealed with the key the peer knows us by, so it can authenticate us
+// even if the batch reaches it through another machine.
+func (s *Server) SendHistory(sess *Session, entries []parser.HistoryEntry) error {
+	return s.sendBatch(sess, envelope.Batch{Entries: entries})
+}
+
+// sendBatch is SendHistory for a batch that may carry annotations and
+// tombstones as well
+func (s *Server) sendBatch(sess *Session, batch envelope.Batch) error {
+	s.mu.Lock()
+	batch.MachineID = s.cfg.MachineID
+	recipient := sess.Peer.PublicKey
+	s.mu.Unlock()
+	entries := batch.Entries
+
+	env, err := envelope.Seal(batch, sess.LocalPrivateKey, recipient)
+	if err != nil {
+		return fmt.Errorf("seal history for %s: %w", sess.Peer.Name, err)
+	}
+
+	if len(entries) > 0 {
+		sent := sentBatch{through: newest(entries), at: time.Now()}
+		sess.inFlightMu.Lock()
+		sess.inFlight = append(sess.inFlight, sent)
+		sess.inFlightMu.Unlock()
+	}
+
+	if err := sess.Send(protocol.TypeHistoryBatch, protocol.HistoryBatch{Envelope: env}); err != nil {
+		return err
+	}
+	metrics.EntriesSent.WithLabelValues(sess.Peer.Name).Add(float64(len(entries)))
+	return nil
+}
+
+// PushHistory sends the peer every entry and annotation of this machine it
+// has not acknowledged yet and returns how many entries were sent. Only
+// entries in namespaces both sides exchange, and annotations of them, are
+// sent. Entries sent earlier in the session are not sent again while their
+// acknowledgement is outstanding. Nothing is sent if the peer's policy does
+// not allow it, and batches are paced to its rate limit. A policy with tags
+// sends entries along with the annotations tagging them instead. Tombstones
+// the peer has not acknowledged go first, whatever the namespaces and tags.
+func (s *Server) PushHistory(ctx context.Context, sess *Session) (sent int, err error) {
+	defer func() {
+		metrics.SyncRounds.WithLabelValues(sess.Peer.Name, metrics.RoundResult(err)).Inc()
+	}()
+
+	s.mu.Lock()
+	machineID, peerMachineID := s.cfg.MachineID, sess.Peer.MachineID
+	s.mu.Unlock()
+	// Deletions go to every peer, whatever it may be sent, since each has to
+	// acknowledge them before they are dropped
+	if err := s.pushTombstones(ctx, sess, peerMachineID); err != nil {
+		return 0, err
+	}
+	policy := s.policy(sess)
+	if !policy.CanSend() || !policy.AllowsOrigin(machineID) {
+		return 0, nil
+	}
+	if len(sess.namespaces) == 0 {
+		return 0, nil
+	}
+	batchSize := historyBatchSize
+	var limiter *rate.Limiter
+	if l := s.limiters(sess.Peer); l != nil {
+		limiter = l.send
+		batchSize = min(batchSize, limiter.Burst())
+	}
+
+	state, err := s.store.GetSyncState(ctx, peerMachineID)
+	if err != nil {
+		return 0, err
+	}
+	if len(policy.Tags) == 0 {
+		sent, err = s.pushEntries(ctx, sess, machineID, state.Through.Later(sess.sentThrough), batchSize, limiter)
+		if err != nil {
+			return sent, err
+		}
+	}
+	n, err := s.pushAnnotations(ctx, sess, machineID, state.AnnotationsThrough.Later(sess.annotationsSentThrough),
+		batchSize, limiter, policy.Tags)
+	return sent + n, err
+}
+
+// pushEntries sends the entries of this machine past since
+func (s *Server) pushEntries(ctx context.Context, sess *Session, machineID string, since store.Cursor, batchSize int, limiter *rate.Limiter) (sent int, err error) {
+	for {
+		entries, err := s.store.ListEntriesSince(ctx, machineID, since, batchSize, sess.namespaces...)
+		if err != nil {
+			return sent, err
+		}
+		if len(entries) == 0 {
+			return sent, nil
+		}
+		if limiter != nil {
+			if err := limiter.WaitN(ctx, len(entries)); err != nil {
+				return sent, err
+			}
+		}
+		if err := s.SendHistory(sess, entries); err != nil {
+			return sent, err
+		}
+		since = store.EntryCursor(&entries[len(entries)-1])
+		sess.sentThrough = since
+		sent += len(entries)
+		if len(entries) < batchSize {
+			return sent, nil
+		}
+	}
+}
+
+// pushAnnotations sends the annotations of this machine past since and
+// returns how many entries went along. With tags, only annotations
+// carrying one of them are sent, each with the entry it annotates if that
+// is this machine's.
+func (s *Server) pushAnnotations(ctx context.Context, sess *Session, machineID string, since store.Cursor, batchSize int, limiter *rate.Limiter, tags []string) (sent int, err error) {
+	for {
+		annotations, err := s.store.ListAnnotationsSince(ctx, machineID, since, batchSize, sess.namespaces...)
+		if err != nil {
+			return sent, err
+		}
+		if len(annotations) == 0 {
+			return sent, nil
+		}
+		listed := len(annotations)
+		since = store.AnnotationCursor(&annotations[listed-1])
+
+		var entries []parser.HistoryEntry
+		if len(tags) > 0 {
+			if annotations, entries, err = s.tagged(ctx, machineID, annotations, tags); err != nil {
+				return sent, err
+			}
+		}
+		if len(annotations) > 0 {
+			// Only entries count against the rate limit
+			if limiter != nil && len(entries) > 0 {
+				if err := limiter.WaitN(ctx, len(entries)); err != nil {
+					return sent, err
+				}
+			}
+			if err := s.sendBatch(sess, envelope.Batch{Entries: entries, Annotations: annotations}); err != nil {
+				return sent, err
+			}
+			sent += len(entries)
+		}
+		sess.annotationsSentThrough = since
+		if listed < batchSize {
+			return sent, nil
+		}
+	}
+}
+
+// pushTombstones sends the tombstones the peer has neither acknowledged nor
+// been sent in this session
+func (s *Server) pushTombstones(ctx context.Context, sess *Session, peerMachineID string) error {
+	tombstones, err := s.store.ListTombstones(ctx, peerMachineID)
+	if err != nil {
+		return err
+	}
+	tombstones = slices.DeleteFunc(tombstones, func(t parser.Tombstone) bool { return sess.tombstonesSent[t.Hash] })
+	for batch := range slices.Chunk(tombstones, historyBatchSize) {
+		if err := s.sendBatch(sess, envelope.Batch{Tombstones: batch}); err != nil {
+			return err
+		}
+		if sess.tombstonesSent == nil {
+			sess.tombstonesSent = make(map[string]bool)
+		}
+		for _, t := range batch {
+			sess.tombstonesSent[t.Hash] = true
+		}
+	}
+	return nil
+}
+
+// tagged returns the annotations carrying one of tags and the entries of
+// this machine they annotate
+func (s *Server) tagged(ctx context.Context, machineID string, annotations []parser.Annotation, tags []string) ([]parser.Annotation, []parser.HistoryEntry, error) {
+	var (
+		kept    []parser.Annotation
+		entries []parser.HistoryEntry
+	)
+	for _, a := range annotations {
+		if !a.HasTag(tags...) {
+			continue
+		}
+		kept = append(kept, a)
+		entry, err := s.store.GetEntryByHash(ctx, a.Hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if entry.MachineID == machineID {
+			entries = append(entries, entry)
+		}
+	}
+	return kept, entries, nil
+}
+
+// handleHistoryBatch opens a sealed batch and stores its entries. The origin
+// is identified by the envelope's sender key rather than by the session, and
+// the batch must carry the machine ID registered for that origin. Every entry
+// must be signed by the origin's signing key; a batch with a single bad
+// signature is rejected as a whole. So is a batch the policy of the session's
+// peer does not accept, or with entries of a namespace the session does not
+// exchange. Annotations in the batch are checked the same way and stored
+// after the entries, unless a newer annotation by the same machine is
+// stored already. Tombstones must be signed by the machine that made them
+// and are applied first; ones made by a machine this machine does not know,
+// or of entries the session may not delete, are skipped, but acknowledged
+// like the rest. With a tag policy only the
+// tagged entries and the annotations tagging them are kept; the rest are
+// acknowledged and dropped.
+func (s *Server) handleHistoryBatch(ctx context.Context, sess *Session, msg protocol.Message) error {
+	policy := s.policy(sess)
+	if !policy.CanReceive() {
+		return fmt.Errorf("%w: %s may not send history", ErrPolicyDenied, sess.Peer.Name)
+	}
+	var hb protocol.HistoryBatch
+	if err := msg.Decode(&hb); err != nil {
+		return err
+	}
+	env := hb.Envelope
+
+	s.mu.Lock()
+	privateKey, keyErr := machine.PrivateKeyByPublic(s.cfg, s.keys, env.Recipient)
+	origin, known := s.cfg.PeerByPublicKey(env.Sender, time.Now())
+	var (
+		originName, originMachineID string
+		originSigningKey            keys.Key
+	)
+	if known {
+		originName, originMachineID, originSigningKey = origin.Name, origin.MachineID, origin.SigningKey
+	}
+	s.mu.Unlock()
+
+	if keyErr != nil {
+		return fmt.Errorf("open history batch: %w", keyErr)
+	}
+	if !known {
+		return ErrUnknownOrigin
+	}
+	batch, err := env.Open(privateKey)
+	if err != nil {
+		return fmt.Errorf("open history batch from %s: %w", originName, err)
+	}
+	if originMachineID == "" || batch.MachineID != originMachineID {
+		return fmt.Errorf("%w: %s sent entries of %q", ErrForgedEntries, originName, batch.MachineID)
+	}
+	if !policy.AllowsOrigin(batch.MachineID) {
+		return fmt.Errorf("%w: history of %q is not shared with %s", ErrPolicyDenied, batch.MachineID, sess.Peer.Name)
+	}
+	for _, entry := range batch.Entries {
+		if !slices.Contains(sess.namespaces, entry.Namespace) {
+			return fmt.Errorf("%w: namespace %q is not shared with %s", ErrPolicyDenied, config.NamespaceName(entry.Namespace), sess.Peer.Name)
+		}
+		if err := provenance.Verify(entry, originSigningKey); err != nil {
+			return fmt.Errorf("history from %s: %w", originName, err)
+		}
+	}
+	for _, a := range batch.Annotations {
+		if err := s.checkAnnotation(ctx, sess, a, originSigningKey); err != nil {
+			return fmt.Errorf("annotation from %s: %w", originName, err)
+		}
+	}
+	tombstones, err := s.checkTombstones(batch.Tombstones)
+	if err != nil {
+		return fmt.Errorf("tombstone from %s: %w", originName, err)
+	}
+	if tombstones, err = s.scopeTombstones(ctx, sess, policy, tombstones); err != nil {
+		return err
+	}
+	through, annotationsThrough := newest(batch.Entries), newestAnnotation(batch.Annotations)
+	ack := protocol.HistoryAck{
+		Through:                through.Timestamp,
+		ThroughHash:            through.Hash,
+		AnnotationsThrough:     annotationsThrough.Timestamp,
+		AnnotationsThroughHash: annotationsThrough.Hash,
+	}
+	if len(policy.Tags) > 0 {
+		if err := s.keepTagged(ctx, &batch, policy.Tags); err != nil {
+			return err
+		}
+	}
+	if l := s.limiters(sess.Peer); l != nil && !l.receive.AllowN(time.Now(), len(batch.Entries)) {
+		return fmt.Errorf("%w: %d entries from %s", ErrRateLimited, len(batch.Entries), sess.Peer.Name)
+	}
+
+	deleted := 0
+	for _, t := range tombstones {
+		isNew, err := s.store.PutTombstone(ctx, t)
+		if err != nil {
+			return err
+		}
+		if isNew {
+			deleted++
+		}
+	}
+	stored := 0
+	for _, entry := range batch.Entries {
+		// The hash is derived from the entry, never trusted from the wire.
+		entry.ID, entry.Hash = 0, ""
+		if err := s.store.CreateEntry(ctx, &entry); err != nil {
+			if errors.Is(err, store.ErrDuplicateHash) || errors.Is(err, store.ErrDeletedEntry) {
+				continue
+			}
+			return err
+		}
+		stored++
+	}
+	for _, a := range batch.Annotations {
+		if _, err := s.store.PutAnnotation(ctx, a); err != nil {
+			return err
+		}
+	}
+
+	log.Debug("Received history.", "peer", sess.Peer.Name, "origin", originName,
+		"entries", len(batch.Entries), "new", stored, "annotations", len(batch.Annotations), "deleted", deleted)
+	metrics.EntriesReceived.WithLabelValues(originName).Add(float64(stored))
+
+	for _, t := range batch.Tombstones {
+		ack.Tombstones = append(ack.Tombstones, t.Hash)
+	}
+	return sess.Send(protocol.TypeHistoryAck, ack)
+}
+
+// keepTagged drops the annotations of batch carrying none of tags and the
+// entries no kept or stored annotation tags, the way tagged picks what is
+// sent
+func (s *Server) keepTagged(ctx context.Context, batch *envelope.Batch, tags []string) error {
+	batch.Annotations = slices.DeleteFunc(batch.Annotations, func(a parser.Annotation) bool { return !a.HasTag(tags...) })
+	tagged := make(map[string]bool, len(batch.Annotations))
+	for _, a := range batch.Annotations {
+		tagged[a.Hash] = true
+	}
+
+	kept := batch.Entries[:0]
+	for _, entry := range batch.Entries {
+		hash := store.EntryHash(&entry)
+		if !tagged[hash] {
+			stored, err := s.store.ListAnnotations(ctx, hash)
+			if err != nil {
+				return err
+			}
+			tagged[hash] = slices.ContainsFunc(stored, func(a parser.Annotation) bool { return a.HasTag(tags...) })
+		}
+		if tagged[hash] {
+			kept = append(kept, entry)
+		}
+	}
+	batch.Entries = kept
+	return nil
+}
+
+// checkTombstones verifies tombstones against the signing keys of the
+// machines that made them and returns the ones to apply
+func (s *Server) checkTombstones(tombstones []parser.Tombstone) ([]parser.Tombstone, error) {
+	if len(tombstones) == 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	signingKeys := machine.SigningKeys(s.cfg)
+	var (
+		verified []parser.Tombstone
+		err      error
+	)
+	for _, t := range tombstones {
+		key, known := signingKeys(t.MachineID)
+		if !known {
+			log.Warn("Skipping tombstone of an unknown machine.", "machine", t.MachineID, "hash", t.Hash)
+			continue
+		}
+		if err = provenance.VerifyTombstone(t, key); err != nil {
+			break
+		}
+		verified = append(verified, t)
+	}
+	s.mu.Unlock()
+	return verified, err
+}
+
+// scopeTombstones drops the tombstones of stored entries the session does
+// not exchange: entries of a namespace it does not share, of an origin or
+// without a tag its policy excludes. The machine that ran a command may
+// always delete it. Tombstones of entries not stored here are kept, so the
+// entries are refused if they arrive later.
+func (s *Server) scopeTombstones(ctx context.Context, sess *Session, policy config.Policy, tombstones []parser.Tombstone) ([]parser.Tombstone, error) {
+	var kept []parser.Tombstone
+	for _, t := range tombstones {
+		entry, err := s.store.GetEntryByHash(ctx, t.Hash)
+		if errors.Is(err, store.ErrEntryNotFound) {
+			kept = append(kept, t)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		shared := t.MachineID == entry.MachineID ||
+			slices.Contains(sess.namespaces, entry.Namespace) && policy.AllowsOrigin(entry.MachineID)
+		if shared && t.MachineID != entry.MachineID && len(policy.Tags) > 0 {
+			annotations, err := s.store.ListAnnotations(ctx, t.Hash)
+			if err != nil {
+				return nil, err
+			}
+			shared = slices.ContainsFunc(annotations, func(a parser.Annotation) bool { return a.HasTag(policy.Tags...) })
+		}
+		if !shared {
+			log.Warn("Skipping tombstone of an entry not shared with the peer.", "peer", sess.Peer.Name, "machine", t.MachineID, "hash", t.Hash)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return kept, nil
+}
+
+// checkAnnotation verifies an annotation's tags and signature. An
+// annotation of a stored entry must be of a namespace the session
+// exchanges; one of an entry not stored yet is kept for when it arrives.
+func (s *Server) checkAnnotation(ctx context.Context, sess *Session, a parser.Annotation, signingKey keys.Key) error {
+	if err := parser.ValidateTags(a.Tags); err != nil {
+		return err
+	}
+	if err := provenance.VerifyAnnotation(a, signingKey); err != nil {
+		return err
+	}
+	entry, err := s.store.GetEntryByHash(ctx, a.Hash)
+	if errors.Is(err, store.ErrEntryNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(sess.namespaces, entry.Namespace) {
+		return fmt.Errorf("%w: namespace %q is not shared with %s", ErrPolicyDenied, config.NamespaceName(entry.Namespace), sess.Peer.Name)
+	}
+	return nil
+}
+
+// handleHistoryAck records how far the peer has stored our history and
+// annotations, so the next session resumes from there. Entries sent along
+// with tags are not in timestamp order, so they do not move the entries
+// forward. Acknowledged tombstones are recorded, and then the ones every
+// peer has acknowledged are dropped.
+func (s *Server) handleHistoryAck(ctx context.Context, sess *Session, msg protocol.Message) error {
+	var ack protocol.HistoryAck
+	if err := msg.Decode(&ack); err != nil {
+		return err
+	}
+	through := store.Cursor{Timestamp: ack.Through, Hash: ack.ThroughHash}
+	observeRoundTrip(sess, through)
+
+	s.mu.Lock()
+	peerMachineID := sess.Peer.MachineID
+	s.mu.Unlock()
+
+	state, err := s.store.GetSyncState(ctx, peerMachineID)
+	if err != nil {
+		return err
+	}
+	if err := s.store.AckTombstones(ctx, peerMachineID, ack.Tombstones...); err != nil {
+		return err
+	}
+	if err := s.collectTombstones(ctx); err != nil {
+		return err
+	}
+	annotationsThrough := store.Cursor{Timestamp: ack.AnnotationsThrough, Hash: ack.AnnotationsThroughHash}
+	if annotationsThrough.After(state.AnnotationsThrough) {
+		if err := s.store.UpdateAnnotationsSyncCursor(ctx, peerMachineID, annotationsThrough); err != nil {
+			return err
+		}
+	}
+	if !through.After(state.Through) || len(s.policy(sess).Tags) > 0 {
+		return nil
+	}
+	return s.store.UpdateSyncCursor(ctx, peerMachineID, through)
+}
+
+// observeRoundTrip records the round trip of every in-flight batch the
+// acknowledgement covers
+func observeRoundTrip(sess *Session, through store.Cursor) {
+	sess.inFlightMu.Lock()
+	defer sess.inFlightMu.Unlock()
+	acked := 0
+	for _, b := range sess.inFlight {
+		if b.through.After(through) {
+			break
+		}
+		metrics.SyncRoundTrip.WithLabelValues(sess.Peer.Name).Observe(time.Since(b.at).Seconds())
+		acked++
+	}
+	sess.inFlight = sess.inFlight[acked:]
+}
+
+// collectTombstones drops the tombstones every configured peer has
+// acknowledged. Peers that never connected have no machine ID yet and could
+// still have the deleted entries, so nothing is dropped until all have one.
+func (s *Server) collectTombstones(ctx context.Context) error {
+	s.mu.Lock()
+	machineIDs := make([]string, 0, len(s.cfg.Peers))
+	for _, p := range s.cfg.Peers {
+		if p.MachineID == "" {
+			s.mu.Unlock()
+			return nil
+		}
+		machineIDs = append(machineIDs, p.MachineID)
+	}
+	s.mu.Unlock()
+
+	n, err := s.store.CollectTombstones(ctx, machineIDs)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Debug("Dropped acknowledged tombstones.", "tombstones", n)
+	}
+	return nil
+}
+
+// newestAnnotation returns the cursor past the newest of annotations
+func newestAnnotation(annotations []parser.Annotation) store.Cursor {
+	var c store.Cursor
+	for i := range annotations {
+		c = c.Later(store.AnnotationCursor(&annotations[i]))
+	}
+	return c
+}
+
+// newest returns the cursor past the newest of entries. The hashes are
+// derived from the entries rather than trusted from the wire.
+func newest(entries []parser.HistoryEntry) store.Cursor {
+	var c store.Cursor
+	for i := range entries {
+		c = c.Later(store.EntryCursor(&entries[i]))
+	}
+	return c
+}
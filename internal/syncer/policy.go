@@ -0,0 +1,55 @@
+package syncer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrPolicyDenied = errors.New("history refused by peer policy")
+	ErrRateLimited  = errors.New("peer exceeded its history rate limit")
+)
+
+// peerLimiters pace the history exchanged with one peer. They live on the
+// server so a peer cannot reset them by reconnecting.
+type peerLimiters struct {
+	perMinute     int
+	send, receive *rate.Limiter
+}
+
+// limiters returns the rate limiters for peer, or nil if its policy has no
+// rate limit. The burst is a minute's worth of entries.
+func (s *Server) limiters(peer *config.Peer) *peerLimiters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	perMinute := peer.Policy.RateLimit
+	if perMinute <= 0 {
+		delete(s.limits, peer.PublicKey)
+		return nil
+	}
+	l, ok := s.limits[peer.PublicKey]
+	if !ok || l.perMinute != perMinute {
+		every := rate.Every(time.Minute / time.Duration(perMinute))
+		l = &peerLimiters{
+			perMinute: perMinute,
+			send:      rate.NewLimiter(every, perMinute),
+			receive:   rate.NewLimiter(every, perMinute),
+		}
+		if s.limits == nil {
+			s.limits = make(map[keys.Key]*peerLimiters)
+		}
+		s.limits[peer.PublicKey] = l
+	}
+	return l
+}
+
+// policy returns the policy of the session's peer
+func (s *Server) policy(sess *Session) config.Policy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sess.Peer.Policy
+}
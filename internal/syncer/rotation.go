@@ -0,0 +1,128 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/protocol"
+)
+
+// Announce connects to peer over conn, sends any key rotations it has not
+// acknowledged and waits until it does. It returns immediately if the peer
+// already knows our current key.
+func (s *Server) Announce(ctx context.Context, conn net.Conn, peer *config.Peer, localPrivateKey keys.Key) error {
+	sess, err := s.Handshake(conn, peer, localPrivateKey)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer sess.Close()
+
+	if s.isUpToDate(peer) {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Run(ctx, sess)
+	}()
+
+	select {
+	case <-sess.acked:
+		return nil
+	case err := <-done:
+		if err == nil {
+			err = fmt.Errorf("connection to %s closed before it acknowledged the new key", peer.Name)
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) isUpToDate(peer *config.Peer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return peer.KnownPublicKey == s.cfg.PublicKey
+}
+
+// announceRotations sends the peer the rotation chain it is missing, so peers
+// that were offline during a rotation catch up on their next connection.
+func (s *Server) announceRotations(_ context.Context, sess *Session) error {
+	s.mu.Lock()
+	announcements, err := machine.Announcements(s.cfg, s.keys, sess.Peer)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if len(announcements) == 0 {
+		return nil
+	}
+	return sess.Send(protocol.TypeKeyRotation, protocol.KeyRotation{Announcements: announcements})
+}
+
+func (s *Server) handleKeyRotation(_ context.Context, sess *Session, msg protocol.Message) error {
+	var rotation protocol.KeyRotation
+	if err := msg.Decode(&rotation); err != nil {
+		return err
+	}
+	if len(rotation.Announcements) == 0 {
+		return nil
+	}
+
+	var newKey keys.Key
+	err := s.update(func(cfg *config.Config) error {
+		peer := sess.Peer
+		latest := rotation.Announcements[len(rotation.Announcements)-1]
+		if latest.NewPublicKey == peer.PublicKey {
+			// Already applied, the previous acknowledgement got lost.
+			newKey = peer.PublicKey
+			return nil
+		}
+
+		last, err := keys.VerifyChain(rotation.Announcements, peer.PublicKey, sess.LocalPrivateKey)
+		if err != nil {
+			return fmt.Errorf("verify key rotation: %w", err)
+		}
+		peer.PreviousPublicKey = peer.PublicKey
+		peer.PreviousKeyExpiresAt = last.GraceUntil
+		peer.PublicKey = last.NewPublicKey
+		newKey = last.NewPublicKey
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("Peer rotated its key.", "peer", sess.Peer.Name)
+	return sess.Send(protocol.TypeKeyRotationAck, protocol.KeyRotationAck{PublicKey: newKey})
+}
+
+func (s *Server) handleKeyRotationAck(_ context.Context, sess *Session, msg protocol.Message) error {
+	var ack protocol.KeyRotationAck
+	if err := msg.Decode(&ack); err != nil {
+		return err
+	}
+
+	err := s.update(func(cfg *config.Config) error {
+		_, retired := cfg.GetRetiredKey(ack.PublicKey, time.Now())
+		if !retired && ack.PublicKey != cfg.PublicKey {
+			return fmt.Errorf("peer acknowledged a key that is not ours")
+		}
+		sess.Peer.KnownPublicKey = ack.PublicKey
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if s.isUpToDate(sess.Peer) {
+		sess.ackedOnce.Do(func() { close(sess.acked) })
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/machine"
+	"github.com/TheRealSibasishBehera/syncsh/internal/network"
+	"github.com/TheRealSibasishBehera/syncsh/internal/protocol"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+type testMachine struct {
+	cfg *config.Config
+	ks  keys.KeyStore
+	st  *store.Store // nil unless the test exchanges history
+}
+
+func newTestMachine(t *testing.T, name string) *testMachine {
+	priv, pub, err := network.NewMachineKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	ks := &keys.FileStore{Dir: filepath.Join(dir, "keys")}
+	if err := ks.Put("wireguard", priv); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		MachineID:     name,
+		PrivateKeyRef: "wireguard",
+		PublicKey:     pub,
+	}
+	cfg.SetPath(filepath.Join(dir, name+".yaml"))
+	if _, err := machine.EnsureSigningKey(cfg, ks); err != nil {
+		t.Fatal(err)
+	}
+	return &testMachine{cfg: cfg, ks: ks}
+}
+
+func (m *testMachine) privateKeyFor(t *testing.T, peer *config.Peer) keys.Key {
+	key, err := machine.PrivateKeyFor(m.cfg, m.ks, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// pair makes a and b peers of each other
+func pair(ma, mb *testMachine) {
+	a, b := ma.cfg, mb.cfg
+	a.Peers = append(a.Peers, config.Peer{Name: "b", PublicKey: b.PublicKey, KnownPublicKey: a.PublicKey})
+	b.Peers = append(b.Peers, config.Peer{Name: "a", PublicKey: a.PublicKey, KnownPublicKey: b.PublicKey})
+}
+
+// serveOnce accepts a single connection for m's first peer
+func serveOnce(t *testing.T, ctx context.Context, m *testMachine) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	srv := NewServer(m.cfg, m.ks, m.st)
+	peer := &m.cfg.Peers[0]
+	key := m.privateKeyFor(t, peer)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		_ = srv.Serve(ctx, conn, peer, key)
+	}()
+	return ln.Addr().String()
+}
+
+func TestAnnounceRotation(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	pair(a, b)
+	oldKey := a.cfg.PublicKey
+
+	if _, err := machine.RotateKeys(a.cfg, a.ks, time.Hour); err != nil {
+		t.Fatalf("Failed to rotate keys: %v", err)
+	}
+	newKey := a.cfg.PublicKey
+
+	addr := serveOnce(t, ctx, b)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := &a.cfg.Peers[0]
+	if err := NewServer(a.cfg, a.ks, nil).Announce(ctx, conn, peer, a.privateKeyFor(t, peer)); err != nil {
+		t.Fatalf("Failed to announce rotation: %v", err)
+	}
+
+	if peer.KnownPublicKey != newKey {
+		t.Errorf("Peer did not acknowledge the new key")
+	}
+	if b.cfg.Peers[0].PublicKey != newKey {
+		t.Errorf("Receiver did not switch to the new key")
+	}
+	if !b.cfg.Peers[0].HasPublicKey(oldKey, time.Now()) {
+		t.Errorf("Receiver should still accept the old key during the grace window")
+	}
+	if b.cfg.Peers[0].HasPublicKey(oldKey, time.Now().Add(2*time.Hour)) {
+		t.Errorf("Receiver should not accept the old key after the grace window")
+	}
+
+	// The receiver must have persisted the new key.
+	saved, err := config.NewFromFile(b.cfg.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Peers[0].PublicKey != newKey {
+		t.Errorf("Saved config has key %s, want %s", saved.Peers[0].PublicKey, newKey)
+	}
+}
+
+func TestAnnounceRotationCatchUp(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	pair(a, b)
+
+	// b was offline for two rotations in a row.
+	for i := 0; i < 2; i++ {
+		if _, err := machine.RotateKeys(a.cfg, a.ks, time.Hour); err != nil {
+			t.Fatalf("Failed to rotate keys: %v", err)
+		}
+	}
+
+	addr := serveOnce(t, ctx, b)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := &a.cfg.Peers[0]
+	if err := NewServer(a.cfg, a.ks, nil).Announce(ctx, conn, peer, a.privateKeyFor(t, peer)); err != nil {
+		t.Fatalf("Failed to announce rotation: %v", err)
+	}
+	if b.cfg.Peers[0].PublicKey != a.cfg.PublicKey {
+		t.Errorf("Receiver did not catch up to the latest key")
+	}
+}
+
+func TestHandshakeRejectsUnknownKey(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	stranger := newTestMachine(t, "stranger")
+	pair(a, b)
+
+	addr := serveOnce(t, ctx, b)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peer := &a.cfg.Peers[0]
+	strangerKey, err := machine.PrivateKey(stranger.cfg, stranger.ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewServer(a.cfg, a.ks, nil).Handshake(conn, peer, strangerKey)
+	if err == nil {
+		// The server side rejects us; we only notice once the connection drops.
+		_, err = conn.Read(make([]byte, 1))
+	}
+	if err == nil {
+		t.Errorf("Expected handshake with an unknown key to fail")
+	}
+}
+
+func TestHandshakeRejectsBlockedKey(t *testing.T) {
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	pair(a, b)
+	// b is still listed as a peer, e.g. by a daemon that has not reloaded
+	a.cfg.Block(b.cfg.PublicKey)
+
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	defer cb.Close()
+	go NewServer(b.cfg, b.ks, nil).Handshake(cb, &b.cfg.Peers[0], b.privateKeyFor(t, &b.cfg.Peers[0]))
+
+	_, err := NewServer(a.cfg, a.ks, nil).Handshake(ca, &a.cfg.Peers[0], a.privateKeyFor(t, &a.cfg.Peers[0]))
+	if !errors.Is(err, config.ErrBlockedKey) {
+		t.Errorf("Handshake with a blocked key = %v, want %v", err, config.ErrBlockedKey)
+	}
+}
+
+func TestHandshakeRejectsImpostor(t *testing.T) {
+	a := newTestMachine(t, "a")
+	b := newTestMachine(t, "b")
+	stranger := newTestMachine(t, "stranger")
+	pair(a, b)
+
+	ca, cb := net.Pipe()
+	defer ca.Close()
+	defer cb.Close()
+	// The stranger claims b's public key, but only holds its own private key
+	go func() {
+		strangerKey, err := machine.PrivateKey(stranger.cfg, stranger.ks)
+		if err != nil {
+			return
+		}
+		claim := protocol.Hello{
+			Version:    protocol.Version,
+			PublicKey:  b.cfg.PublicKey,
+			MachineID:  "b",
+			SigningKey: stranger.cfg.SigningPublicKey,
+			Nonce:      []byte("nonce"),
+		}
+		pc := protocol.NewConn(cb)
+		var hello protocol.Hello
+		if exchange(pc, protocol.TypeHello, claim, &hello) != nil {
+			return
+		}
+		proof, err := protocol.ProveHello(claim, hello, strangerKey)
+		if err != nil {
+			return
+		}
+		_ = exchange(pc, protocol.TypeHelloProof, proof, &protocol.HelloProof{})
+	}()
+
+	_, err := NewServer(a.cfg, a.ks, nil).Handshake(ca, &a.cfg.Peers[0], a.privateKeyFor(t, &a.cfg.Peers[0]))
+	if !errors.Is(err, protocol.ErrInvalidProof) {
+		t.Errorf("Handshake with an impostor = %v, want %v", err, protocol.ErrInvalidProof)
+	}
+	if a.cfg.Peers[0].MachineID != "" || !a.cfg.Peers[0].SigningKey.IsZero() {
+		t.Errorf("The impostor's identity was recorded: %+v", a.cfg.Peers[0])
+	}
+}
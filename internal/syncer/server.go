@@ -0,0 +1,309 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/TheRealSibasishBehera/syncsh/internal/config"
+	"github.com/TheRealSibasishBehera/syncsh/internal/keys"
+	"github.com/TheRealSibasishBehera/syncsh/internal/logging"
+	"github.com/TheRealSibasishBehera/syncsh/internal/protocol"
+	"github.com/TheRealSibasishBehera/syncsh/internal/secret"
+	"github.com/TheRealSibasishBehera/syncsh/internal/store"
+)
+
+var log = logging.For(logging.Sync)
+
+var (
+	ErrUnknownPeer        = errors.New("remote public key does not belong to the expected peer")
+	ErrMachineIDMismatch  = errors.New("peer presented a different machine ID than on first contact")
+	ErrSigningKeyMismatch = errors.New("peer presented a different signing key than on first contact")
+)
+
+// Handler processes one message received during a session
+type Handler func(ctx context.Context, s *Session, msg protocol.Message) error
+
+// Hook runs once at the start of every session, before messages are read
+type Hook func(ctx context.Context, s *Session) error
+
+// Session is a connection to a single peer after the Hello exchange
+type Session struct {
+	Peer *config.Peer
+	// LocalPrivateKey is the key this machine uses on the tunnel to Peer
+	LocalPrivateKey keys.Key
+
+	conn      *protocol.Conn
+	acked     chan struct{}
+	ackedOnce sync.Once
+
+	// namespaces are the history namespaces both sides exchange
+	namespaces []string
+
+	// sentThrough is past the newest entry sent in this session, and
+	// annotationsSentThrough past the newest annotation
+	sentThrough            store.Cursor
+	annotationsSentThrough store.Cursor
+	// tombstonesSent are the hashes of tombstones sent in this session
+	tombstonesSent map[string]bool
+
+	// inFlight are the batches sent in this session awaiting acknowledgement
+	inFlightMu sync.Mutex
+	inFlight   []sentBatch
+}
+
+// sentBatch records when a batch was sent, to measure the round trip
+type sentBatch struct {
+	through store.Cursor
+	at      time.Time
+}
+
+// Send writes a message to the peer
+func (s *Session) Send(t protocol.MessageType, payload any) error {
+	return s.conn.Send(t, payload)
+}
+
+// Close closes the underlying connection
+func (s *Session) Close() error {
+	return s.conn.Close()
+}
+
+// Server runs sessions with peers and dispatches their messages to handlers.
+// Changes handlers make to the configuration are saved under a single lock.
+type Server struct {
+	cfg      *config.Config
+	keys     keys.KeyStore
+	store    *store.Store
+	mu       sync.Mutex
+	handlers map[protocol.MessageType]Handler
+	hooks    []Hook
+	limits   map[keys.Key]*peerLimiters // guarded by mu
+}
+
+// NewServer returns a server for the machine described by cfg. History
+// batches are only accepted when st is not nil.
+func NewServer(cfg *config.Config, ks keys.KeyStore, st *store.Store) *Server {
+	s := &Server{
+		cfg:      cfg,
+		keys:     ks,
+		store:    st,
+		handlers: make(map[protocol.MessageType]Handler),
+	}
+	s.Handle(protocol.TypeError, handleError)
+	s.Handle(protocol.TypeKeyRotation, s.handleKeyRotation)
+	s.Handle(protocol.TypeKeyRotationAck, s.handleKeyRotationAck)
+	if st != nil {
+		s.Handle(protocol.TypeHistoryBatch, s.handleHistoryBatch)
+		s.Handle(protocol.TypeHistoryAck, s.handleHistoryAck)
+	}
+	s.OnSession(s.announceRotations)
+	return s
+}
+
+// Handle registers h for messages of type t, replacing any previous handler
+func (s *Server) Handle(t protocol.MessageType, h Handler) {
+	s.handlers[t] = h
+}
+
+// OnSession registers a hook that runs at the start of every session
+func (s *Server) OnSession(h Hook) {
+	s.hooks = append(s.hooks, h)
+}
+
+// Handshake exchanges Hello messages over conn and checks that the remote
+// side presents a public key belonging to peer, then that it holds the
+// private key through a HelloProof. The peer's machine ID and signing key
+// are recorded on first contact and must not change afterwards.
+func (s *Server) Handshake(conn net.Conn, peer *config.Peer, localPrivateKey keys.Key) (*Session, error) {
+	localPublicKey, err := localPrivateKey.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := secret.New(32)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	local := protocol.Hello{
+		Version:    protocol.Version,
+		PublicKey:  localPublicKey,
+		MachineID:  s.cfg.MachineID,
+		SigningKey: s.cfg.SigningPublicKey,
+		Namespaces: peer.EntryNamespaces(),
+		Nonce:      nonce,
+	}
+	s.mu.Unlock()
+
+	pc := protocol.NewConn(conn)
+	var hello protocol.Hello
+	if err := exchange(pc, protocol.TypeHello, local, &hello); err != nil {
+		return nil, fmt.Errorf("handshake with %s: %w", peer.Name, err)
+	}
+	if hello.Version != protocol.Version {
+		return nil, fmt.Errorf("handshake with %s: unsupported protocol version %d", peer.Name, hello.Version)
+	}
+	if err := peer.Policy.Validate(); err != nil {
+		return nil, fmt.Errorf("handshake with %s: policy: %w", peer.Name, err)
+	}
+	s.mu.Lock()
+	blocked := s.cfg.IsBlocked(hello.PublicKey)
+	s.mu.Unlock()
+	if blocked {
+		return nil, fmt.Errorf("handshake with %s: %w", peer.Name, config.ErrBlockedKey)
+	}
+	if !peer.HasPublicKey(hello.PublicKey, time.Now()) {
+		return nil, fmt.Errorf("handshake with %s: %w", peer.Name, ErrUnknownPeer)
+	}
+	proof, err := protocol.ProveHello(local, hello, localPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("handshake with %s: %w", peer.Name, err)
+	}
+	var remoteProof protocol.HelloProof
+	if err := exchange(pc, protocol.TypeHelloProof, proof, &remoteProof); err != nil {
+		return nil, fmt.Errorf("handshake with %s: %w", peer.Name, err)
+	}
+	if err := remoteProof.Verify(local, hello, localPrivateKey); err != nil {
+		return nil, fmt.Errorf("handshake with %s: %w", peer.Name, err)
+	}
+	if err := s.learnIdentity(peer, hello); err != nil {
+		return nil, fmt.Errorf("handshake with %s: %w", peer.Name, err)
+	}
+
+	return &Session{
+		Peer:            peer,
+		LocalPrivateKey: localPrivateKey,
+		conn:            pc,
+		acked:           make(chan struct{}),
+		namespaces:      commonNamespaces(local.Namespaces, hello.Namespaces),
+	}, nil
+}
+
+// exchange sends out and receives a message of the same type into in at
+// once, since both sides of a handshake send before they receive
+func exchange(pc *protocol.Conn, t protocol.MessageType, out, in any) error {
+	sent := make(chan error, 1)
+	go func() {
+		sent <- pc.Send(t, out)
+	}()
+	if err := pc.Expect(t, in); err != nil {
+		return err
+	}
+	return <-sent
+}
+
+// commonNamespaces returns the namespaces of local the remote side also
+// announced. A remote side that announced none only has its own history.
+func commonNamespaces(local, remote []string) []string {
+	if remote == nil {
+		remote = []string{""}
+	}
+	var common []string
+	for _, ns := range local {
+		if slices.Contains(remote, ns) {
+			common = append(common, ns)
+		}
+	}
+	return common
+}
+
+// Run runs the session hooks and then dispatches incoming messages until the
+// connection is closed or ctx is cancelled.
+func (s *Server) Run(ctx context.Context, sess *Session) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = sess.Close()
+	}()
+
+	for _, hook := range s.hooks {
+		if err := hook(ctx, sess); err != nil {
+			return err
+		}
+	}
+
+	for {
+		msg, err := sess.conn.Receive()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("receive from %s: %w", sess.Peer.Name, err)
+		}
+
+		handler, ok := s.handlers[msg.Type]
+		if !ok {
+			log.Warn("Ignoring unknown message.", "type", msg.Type, "peer", sess.Peer.Name)
+			continue
+		}
+		if err := handler(ctx, sess, msg); err != nil {
+			_ = sess.Send(protocol.TypeError, protocol.Error{Message: err.Error()})
+			return fmt.Errorf("handle %s from %s: %w", msg.Type, sess.Peer.Name, err)
+		}
+	}
+}
+
+// Serve performs the handshake on conn and runs the resulting session
+func (s *Server) Serve(ctx context.Context, conn net.Conn, peer *config.Peer, localPrivateKey keys.Key) error {
+	sess, err := s.Handshake(conn, peer, localPrivateKey)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	return s.Run(ctx, sess)
+}
+
+func (s *Server) learnIdentity(peer *config.Peer, hello protocol.Hello) error {
+	if hello.MachineID == "" || hello.SigningKey.IsZero() {
+		return errors.New("peer did not send its machine ID and signing key")
+	}
+	s.mu.Lock()
+	knownID, knownKey := peer.MachineID, peer.SigningKey
+	s.mu.Unlock()
+	if knownID != "" && knownID != hello.MachineID {
+		return ErrMachineIDMismatch
+	}
+	if !knownKey.IsZero() && knownKey != hello.SigningKey {
+		return ErrSigningKeyMismatch
+	}
+	if knownID != "" && !knownKey.IsZero() {
+		return nil
+	}
+	return s.update(func(cfg *config.Config) error {
+		peer.MachineID = hello.MachineID
+		peer.SigningKey = hello.SigningKey
+		return nil
+	})
+}
+
+// View runs fn with the configuration locked against changes made by handlers
+func (s *Server) View(fn func(cfg *config.Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.cfg)
+}
+
+// update applies fn to the configuration and saves it
+func (s *Server) update(fn func(cfg *config.Config) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := fn(s.cfg); err != nil {
+		return err
+	}
+	return s.cfg.Save()
+}
+
+func handleError(_ context.Context, sess *Session, msg protocol.Message) error {
+	var e protocol.Error
+	if err := msg.Decode(&e); err != nil {
+		return err
+	}
+	log.Error("Peer reported an error.", "peer", sess.Peer.Name, "error", e.Message)
+	return nil
+}
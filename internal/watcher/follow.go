@@ -0,0 +1,53 @@
+package watcher
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Follower reads the lines appended to a file since the previous read
+type Follower struct {
+	Path   string
+	Offset int64 // bytes of the file already read
+}
+
+// ReadLines returns the complete lines written since Offset and advances it.
+// A line still being written is left for the next call. If the file shrank
+// it was truncated or rewritten, as zsh does when it trims its history, and
+// is read again from the start; entries already stored are deduplicated.
+func (f *Follower) ReadLines() ([]string, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("open history file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat history file: %w", err)
+	}
+	if info.Size() < f.Offset {
+		f.Offset = 0
+	}
+	if info.Size() == f.Offset {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(file, f.Offset, info.Size()-f.Offset))
+	if err != nil {
+		return nil, fmt.Errorf("read history file: %w", err)
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil, nil
+	}
+	f.Offset += int64(end + 1)
+
+	return strings.Split(string(data[:end]), "\n"), nil
+}
@@ -0,0 +1,55 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFollowerReadLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	f := &Follower{Path: path}
+
+	if lines, err := f.ReadLines(); err != nil || lines != nil {
+		t.Fatalf("Expected a missing file to yield nothing, got %v, %v", lines, err)
+	}
+
+	appendTo := func(s string) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	appendTo("ls\ngit sta")
+	lines, err := f.ReadLines()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"ls"}) {
+		t.Errorf("Expected only the complete line, got %q", lines)
+	}
+
+	appendTo("tus\nmake\n")
+	lines, _ = f.ReadLines()
+	if !reflect.DeepEqual(lines, []string{"git status", "make"}) {
+		t.Errorf("Expected the rest of the file, got %q", lines)
+	}
+	if lines, _ := f.ReadLines(); lines != nil {
+		t.Errorf("Expected nothing new, got %q", lines)
+	}
+
+	// Rewritten shorter, like a trimmed zsh history.
+	if err := os.WriteFile(path, []byte("make\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lines, _ = f.ReadLines()
+	if !reflect.DeepEqual(lines, []string{"make"}) {
+		t.Errorf("Expected the rewritten file to be read from the start, got %q", lines)
+	}
+}